@@ -0,0 +1,215 @@
+// Package meqatest runs meqa test plans from Go code, typically from go test against an
+// http.Handler:
+//
+//	func TestAPI(t *testing.T) {
+//		meqatest.RunT(t, &meqatest.Options{
+//			Spec:    "testdata/petstore_meqa.yml",
+//			Plan:    "testdata/simple.yml",
+//			Handler: newServer(),
+//		})
+//	}
+//
+// Every Run uses its own plan, DB and HTTP client, so runs don't share state.
+package meqatest
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqplan"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+)
+
+// The host requests are sent to when running against a Handler without a BaseURL.
+const handlerHost = "http://meqatest.local"
+
+// Options describes what to run and where.
+type Options struct {
+	Spec string // the meqa tagged OpenAPI spec file
+	Plan string // the test plan file
+
+	// Where the requests go. If Handler is set the requests are served in-process by it. If
+	// BaseURL is empty the first server in the spec is used.
+	BaseURL string
+	Handler http.Handler
+	Client  *mqplan.ClientConfig // the client settings, Handler overrides Client.Handler
+
+	Suites   []string // the suites to run, all of them if empty
+	Workers  int      // the number of suites to run in parallel, 1 if 0
+	Examples bool     // prefer the examples in the spec to random values
+	FuzzType string   // the type of fuzzing, mqutil.FuzzDataType for instance, none if empty
+
+	// Validate responses with a JSON Schema validator and fail the tests that don't match.
+	StrictSchema bool
+
+	Username string
+	Password string
+	ApiToken string
+
+	// The credentials of the security schemes in the spec, sent according to the security
+	// requirements of each operation.
+	Credentials mqplan.Credentials
+
+	// The identities tests can run as with as:, in addition to the ones in the plan's meqa_init.
+	Identities mqplan.Identities
+
+	// If set, the suites are run once for every role of the policy, and the result has the
+	// permission matrix.
+	Permissions mqplan.Permissions
+
+	Out     io.Writer   // the console output of the run, discarded if nil
+	Logger  *log.Logger // the log of the run, discarded if nil
+	Verbose bool        // print the request parameters and the schema mismatches to Out
+}
+
+// Result is the outcome of a run.
+type Result struct {
+	Tests       []*mqplan.TestResult
+	Counts      map[string]int
+	Coverage    *mqplan.Coverage
+	Permissions *mqplan.PermissionMatrix // set if Options.Permissions was
+}
+
+// Failed returns the tests that failed.
+func (r *Result) Failed() []*mqplan.TestResult {
+	var failed []*mqplan.TestResult
+	for _, t := range r.Tests {
+		if t.Result == mqutil.Failed {
+			failed = append(failed, t)
+		}
+	}
+	return failed
+}
+
+// Load reads the spec and test plan in opts and returns a plan ready to run.
+func Load(opts *Options) (*mqplan.TestPlan, error) {
+	tmpDir, err := ioutil.TempDir("", "meqatest")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	swagger, err := mqswag.CreateSwaggerFromURL(opts.Spec, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	// The suites copy the credentials when they are loaded.
+	plan := &mqplan.TestPlan{}
+	plan.Username = opts.Username
+	plan.Password = opts.Password
+	plan.ApiToken = opts.ApiToken
+	plan.Credentials = opts.Credentials
+	// The plan adds the identities in meqa_init, so it gets a copy.
+	plan.Identities = make(mqplan.Identities)
+	for name, id := range opts.Identities {
+		plan.Identities[name] = id
+	}
+	plan.Examples = opts.Examples
+	plan.StrictSchema = opts.StrictSchema
+	plan.FuzzType = opts.FuzzType
+	ctx := mqplan.NewContext(swagger, opts.Logger)
+	ctx.Verbose = opts.Verbose
+	err = plan.InitFromFile(opts.Plan, ctx)
+	if err != nil {
+		return nil, err
+	}
+	plan.Out = opts.Out
+	if plan.Out == nil {
+		plan.Out = ioutil.Discard
+	}
+
+	config := mqplan.ClientConfig{}
+	if opts.Client != nil {
+		config = *opts.Client
+	}
+	if opts.Handler != nil {
+		config.Handler = opts.Handler
+	}
+	plan.SetClientConfig(&config)
+
+	plan.BaseURL = opts.BaseURL
+	if len(plan.BaseURL) == 0 {
+		if len(swagger.Servers) > 0 {
+			plan.BaseURL = swagger.Servers[0].URL
+		}
+		if u, err := url.Parse(plan.BaseURL); config.Handler != nil && (err != nil || len(u.Host) == 0) {
+			plan.BaseURL = handlerHost + "/" + strings.TrimLeft(plan.BaseURL, "/")
+		}
+	}
+	if len(plan.BaseURL) == 0 {
+		return nil, fmt.Errorf("no base URL given and no server found in %s", opts.Spec)
+	}
+	return plan, nil
+}
+
+// Run loads the spec and the test plan in opts and runs the plan.
+func Run(opts *Options) (*Result, error) {
+	plan, err := Load(opts)
+	if err != nil {
+		return nil, err
+	}
+	suites := opts.Suites
+	if len(suites) == 0 {
+		for _, s := range plan.SuiteList {
+			suites = append(suites, s.Name)
+		}
+	}
+	plan.ResultCounts = make(map[string]int)
+	if opts.Permissions != nil {
+		if err := plan.RunPermissions(suites, opts.Workers, opts.Permissions); err != nil {
+			return nil, err
+		}
+	} else {
+		plan.RunAll(suites, opts.Workers)
+	}
+	result := &Result{
+		Tests:    plan.Results(),
+		Counts:   plan.ResultCounts,
+		Coverage: plan.Coverage(),
+	}
+	if opts.Permissions != nil {
+		result.Permissions = plan.PermissionMatrix(opts.Permissions)
+	}
+	return result, nil
+}
+
+// RunT runs the plan like Run and reports every failed test and fuzz failure as an error on t.
+// With Options.Permissions it reports the over- and under-permissive cells of the permission
+// matrix, and the ones the calls didn't verify, instead of the failed tests. It stops the test if the plan can't be loaded.
+func RunT(t testing.TB, opts *Options) *Result {
+	t.Helper()
+	result, err := Run(opts)
+	if err != nil {
+		t.Fatalf("meqatest: %s", err.Error())
+	}
+	if result.Permissions != nil {
+		for _, row := range result.Permissions.Operations {
+			for _, cell := range row.Cells {
+				switch cell.Verdict {
+				case mqplan.PermissionOverPermissive, mqplan.PermissionUnderPermissive:
+					t.Errorf("%s %s is %s for %s: expected %s, got %v", strings.ToUpper(row.Method), row.Path, cell.Verdict, cell.Role, cell.Expected, cell.Statuses)
+				case mqplan.PermissionUnknown:
+					t.Errorf("%s %s isn't verified for %s: expected %s, got %v", strings.ToUpper(row.Method), row.Path, cell.Role, cell.Expected, cell.Statuses)
+				}
+			}
+		}
+		return result
+	}
+	for _, r := range result.Tests {
+		name := fmt.Sprintf("%s/%s (%s %s)", r.Suite, r.Name, strings.ToUpper(r.Method), r.Path)
+		if r.Result == mqutil.Failed {
+			t.Errorf("%s failed with status %d: %s", name, r.Status, r.Error())
+		}
+		for _, p := range r.FuzzFailures {
+			t.Errorf("%s fuzz failure on %s=%v (%s): expected %s got %s", name, p.Field, p.Value, p.FuzzType, p.Expected, p.Actual)
+		}
+	}
+	return result
+}
@@ -0,0 +1,1313 @@
+package meqatest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqplan"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+)
+
+// petstoreSpec is the meqa tagged petstore spec of the repo's testdata.
+const petstoreSpec = "../../testdata/petstore_meqa.yml"
+
+// tempDir creates a directory for the files of a test, the caller removes it.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "meqatest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// writeFile writes a spec or plan for a test.
+func writeFile(t *testing.T, path string, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const petPlan = `
+pet suite:
+- name: post_addPet_1
+  path: /pet
+  method: post
+- name: get_getPetById_2
+  path: /pet/{petId}
+  method: get
+  pathParams:
+    petId: '{{post_addPet_1.outputs.id}}'
+`
+
+// petServer serves POST /api/v3/pet and GET /api/v3/pet/{petId} from memory.
+func petServer() http.Handler {
+	var mutex sync.Mutex
+	pets := make(map[string]json.RawMessage)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		path := strings.TrimPrefix(r.URL.Path, "/api/v3")
+		switch {
+		case r.Method == http.MethodPost && path == "/pet":
+			body, _ := ioutil.ReadAll(r.Body)
+			var pet struct {
+				Id json.Number `json:"id"`
+			}
+			if json.Unmarshal(body, &pet) != nil {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			pets[pet.Id.String()] = body
+			w.Write(body)
+		case r.Method == http.MethodGet && strings.HasPrefix(path, "/pet/"):
+			pet, ok := pets[strings.TrimPrefix(path, "/pet/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(pet)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func TestRunHandler(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	planPath := filepath.Join(dir, "pet.yml")
+	writeFile(t, planPath, petPlan)
+	opts := &Options{
+		Spec:    petstoreSpec,
+		Plan:    planPath,
+		Handler: petServer(),
+	}
+
+	// Two plans running at the same time against separate servers must not see each other.
+	var wg sync.WaitGroup
+	results := make([]*Result, 2)
+	errs := make([]error, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			o := *opts
+			o.Handler = petServer()
+			results[i], errs[i] = Run(&o)
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if len(result.Tests) != 2 || result.Counts[mqutil.Passed] != 2 || len(result.Failed()) != 0 {
+			t.Errorf("expected 2 passed tests, got %v", result.Counts)
+		}
+		if result.Tests[1].Status != http.StatusOK {
+			t.Errorf("expected the created pet to be found, got status %d", result.Tests[1].Status)
+		}
+	}
+
+	RunT(t, opts)
+}
+
+func TestRunCredentials(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	planPath := filepath.Join(dir, "pet.yml")
+	writeFile(t, planPath, petPlan)
+	server := petServer()
+	var auth []string
+	RunT(t, &Options{
+		Spec:     petstoreSpec,
+		Plan:     planPath,
+		ApiToken: "secret",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth = append(auth, r.Header.Get("Authorization"))
+			server.ServeHTTP(w, r)
+		}),
+	})
+	if len(auth) != 2 || auth[0] != "Bearer secret" || auth[1] != "Bearer secret" {
+		t.Errorf("expected the api token on every request, got %v", auth)
+	}
+}
+
+// Plans run at the same time in one process each have their own context: the history the
+// templates resolve against, the DB, the logger and the output.
+func TestRunContexts(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	const plans = 6
+	var wg sync.WaitGroup
+	logs := make([]bytes.Buffer, plans)
+	outs := make([]bytes.Buffer, plans)
+	results := make([]*Result, plans)
+	errs := make([]error, plans)
+	for i := 0; i < plans; i++ {
+		planPath := filepath.Join(dir, fmt.Sprintf("pet%d.yml", i))
+		writeFile(t, planPath, strings.Replace(petPlan, "pet suite:", fmt.Sprintf("pet suite %d:", i), 1))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Each plan has its own server, so an id from another plan isn't found.
+			results[i], errs[i] = Run(&Options{
+				Spec:    petstoreSpec,
+				Plan:    planPath,
+				Handler: petServer(),
+				Out:     &outs[i],
+				Logger:  log.New(&logs[i], "", 0),
+			})
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < plans; i++ {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if failed := results[i].Failed(); len(results[i].Tests) != 2 || len(failed) > 0 {
+			t.Errorf("expected plan %d to pass, got %v", i, results[i].Counts)
+		}
+		suite := fmt.Sprintf("pet suite %d", i)
+		for name, buf := range map[string]*bytes.Buffer{"log": &logs[i], "output": &outs[i]} {
+			if n := strings.Count(buf.String(), "Test suite: "); n != 1 || !strings.Contains(buf.String(), "Test suite: "+suite) {
+				t.Errorf("expected the %s of plan %d to have only %s, got %d suites", name, i, suite, n)
+			}
+		}
+	}
+}
+
+const bodyPlan = `
+body suite:
+- name: post_xml
+  path: /pet
+  method: post
+  headerParams:
+    Content-Type: application/xml
+- name: post_form
+  path: /pet
+  method: post
+  headerParams:
+    Content-Type: application/x-www-form-urlencoded
+- name: post_uploadFile
+  path: /pet/{petId}/uploadImage
+  method: post
+  pathParams:
+    petId: 1
+`
+
+func TestRunRequestBodies(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	planPath := filepath.Join(dir, "body.yml")
+	writeFile(t, planPath, bodyPlan)
+	var contentTypes []string
+	var bodies []string
+	RunT(t, &Options{
+		Spec: petstoreSpec,
+		Plan: planPath,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+			bodies = append(bodies, string(body))
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	})
+	expected := []string{"application/xml", "application/x-www-form-urlencoded", "application/octet-stream"}
+	if len(contentTypes) != len(expected) {
+		t.Fatalf("expected %d requests, got %d", len(expected), len(contentTypes))
+	}
+	for i, contentType := range expected {
+		if contentTypes[i] != contentType {
+			t.Errorf("expected Content-Type %s, got %s", contentType, contentTypes[i])
+		}
+	}
+	if !strings.Contains(bodies[0], "<pet>") || !strings.Contains(bodies[0], "<photoUrls><photoUrl>") {
+		t.Errorf("expected a pet element with wrapped photo urls, got %s", bodies[0])
+	}
+	if form, err := url.ParseQuery(bodies[1]); err != nil || len(form.Get("name")) == 0 {
+		t.Errorf("expected a form with the pet name, got %s", bodies[1])
+	}
+	if len(bodies[2]) == 0 {
+		t.Error("expected a generated file")
+	}
+}
+
+func TestRunResponseContent(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	planPath := filepath.Join(dir, "pet.yml")
+	writeFile(t, planPath, petPlan)
+	server := petServer()
+	result := RunT(t, &Options{
+		Spec: petstoreSpec,
+		Plan: planPath,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				server.ServeHTTP(w, r)
+				return
+			}
+			// Serve the stored pet as xml.
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, r)
+			var pet interface{}
+			d := json.NewDecoder(rec.Body)
+			d.UseNumber()
+			d.Decode(&pet)
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(petXml("pet", pet)))
+		}),
+	})
+	if get := result.Tests[1]; get.ContentError != nil || get.SchemaError != nil {
+		t.Errorf("expected the xml response to match, got %v %v", get.ContentError, get.SchemaError)
+	}
+}
+
+// petXml writes v as an xml element, with the items of arrays wrapped in elements named after the
+// singular of the array name.
+func petXml(name string, v interface{}) string {
+	var b strings.Builder
+	b.WriteString("<" + name + ">")
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, c := range v {
+			b.WriteString(petXml(k, c))
+		}
+	case []interface{}:
+		for _, c := range v {
+			b.WriteString(petXml(strings.TrimSuffix(name, "s"), c))
+		}
+	default:
+		b.WriteString(fmt.Sprint(v))
+	}
+	b.WriteString("</" + name + ">")
+	return b.String()
+}
+
+const polymorphicSpec = `
+openapi: 3.0.0
+info:
+  title: pets
+  version: "1"
+servers:
+- url: /api
+paths:
+  /pets:
+    post:
+      description: <meqa Pet..post>
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Pet'
+      responses:
+        '200':
+          description: the created pet
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Pet'
+components:
+  schemas:
+    Pet:
+      oneOf:
+      - $ref: '#/components/schemas/Cat'
+      - $ref: '#/components/schemas/Dog'
+      discriminator:
+        propertyName: petType
+        mapping:
+          cat: '#/components/schemas/Cat'
+          dog: Dog
+    Cat:
+      type: object
+      required: [petType, name, secret]
+      properties:
+        id:
+          type: integer
+          readOnly: true
+        petType:
+          type: string
+        name:
+          type: string
+        secret:
+          type: string
+          writeOnly: true
+        nickname:
+          type: string
+          nullable: true
+    Dog:
+      type: object
+      required: [petType, name]
+      properties:
+        id:
+          type: integer
+          readOnly: true
+        petType:
+          type: string
+        name:
+          type: string
+        tag:
+          not:
+            type: string
+`
+
+func TestRunPolymorphicBodies(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	specPath := filepath.Join(dir, "pets.yml")
+	planPath := filepath.Join(dir, "pets_plan.yml")
+	plan := "pets suite:\n"
+	for i := 0; i < 30; i++ {
+		plan += fmt.Sprintf("- name: post_pet_%d\n  path: /pets\n  method: post\n", i)
+	}
+	writeFile(t, specPath, polymorphicSpec)
+	writeFile(t, planPath, plan)
+
+	petTypes := make(map[string]int)
+	result := RunT(t, &Options{
+		Spec: specPath,
+		Plan: planPath,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var pet map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&pet)
+			petType, _ := pet["petType"].(string)
+			petTypes[petType]++
+			if _, ok := pet["id"]; ok {
+				t.Errorf("readOnly id sent in %v", pet)
+			}
+			if _, ok := pet["tag"].(string); ok {
+				t.Errorf("tag matches the not schema in %v", pet)
+			}
+			if petType == "cat" && pet["secret"] == nil {
+				t.Errorf("writeOnly secret not sent in %v", pet)
+			}
+			pet["id"] = petTypes["cat"] + petTypes["dog"]
+			delete(pet, "secret")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(pet)
+		}),
+	})
+	if len(petTypes) != 2 || petTypes["cat"] == 0 || petTypes["dog"] == 0 {
+		t.Errorf("expected both discriminator values, got %v", petTypes)
+	}
+	if result.Counts[mqutil.SchemaMismatch] != 0 {
+		t.Errorf("expected the responses to match the schema, got %v", result.Counts)
+	}
+}
+
+func TestRunExamples(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	planPath := filepath.Join(dir, "pet.yml")
+	writeFile(t, planPath, petPlan)
+	server := petServer()
+	var pet map[string]interface{}
+	RunT(t, &Options{
+		Spec:     petstoreSpec,
+		Plan:     planPath,
+		Examples: true,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				body, _ := ioutil.ReadAll(r.Body)
+				json.Unmarshal(body, &pet)
+				r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+			}
+			server.ServeHTTP(w, r)
+		}),
+	})
+	category, _ := pet["category"].(map[string]interface{})
+	if pet["name"] != "doggie" || pet["id"] != float64(10) || category["name"] != "Dogs" {
+		t.Errorf("expected the examples to be sent, got %v", pet)
+	}
+}
+
+const thingSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+servers:
+- url: /api
+paths:
+  /things:
+    post:
+      description: <meqa Thing..post>
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Thing'
+      responses:
+        '200':
+          description: the created thing
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Thing'
+components:
+  schemas:
+    Thing:
+      type: object
+      additionalProperties: false
+      required: [name]
+      properties:
+        id:
+          type: integer
+          format: int32
+          readOnly: true
+        name:
+          type: string
+        size:
+          type: integer
+`
+
+func TestRunStrictSchema(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	specPath := filepath.Join(dir, "things.yml")
+	planPath := filepath.Join(dir, "things_plan.yml")
+	writeFile(t, specPath, thingSpec)
+	plan := "things suite:\n- name: post_thing\n  path: /things\n  method: post\n"
+	writeFile(t, planPath, plan)
+	// The response has a property the schema doesn't allow.
+	result, err := Run(&Options{
+		Spec:         specPath,
+		Plan:         planPath,
+		StrictSchema: true,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var thing map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&thing)
+			thing["id"] = 1
+			thing["extra"] = true
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(thing)
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failed()) != 1 || result.Counts[mqutil.SchemaMismatch] != 1 {
+		t.Fatalf("expected the strict check to fail the test, got %v", result.Counts)
+	}
+	if msg := result.Tests[0].SchemaError.Error(); !strings.Contains(msg, "extra") {
+		t.Errorf("expected the error to name the extra property, got %s", msg)
+	}
+}
+
+const headerSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+servers:
+- url: /api
+paths:
+  /things:
+    post:
+      description: <meqa Thing..post>
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Thing'
+      responses:
+        '200':
+          description: the created thing
+          headers:
+            X-Rate-Limit:
+              required: true
+              schema:
+                type: integer
+            ETag:
+              schema:
+                type: string
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Thing'
+components:
+  schemas:
+    Thing:
+      type: object
+      required: [name]
+      properties:
+        name:
+          type: string
+`
+
+func TestRunResponseHeaders(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	specPath := filepath.Join(dir, "things.yml")
+	planPath := filepath.Join(dir, "things_plan.yml")
+	writeFile(t, specPath, headerSpec)
+	plan := `things suite:
+- name: post_thing
+  path: /things
+  method: post
+  expect:
+    headers:
+      ETag:
+        regex: '^"v[0-9]+"$'
+      X-Debug: null
+`
+	writeFile(t, planPath, plan)
+	RunT(t, &Options{
+		Spec: specPath,
+		Plan: planPath,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var thing map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&thing)
+			w.Header().Set("X-Rate-Limit", "100")
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(thing)
+		}),
+	})
+}
+
+const assertionPlan = `
+pet suite:
+- name: post_addPet_1
+  path: /pet
+  method: post
+- name: get_getPetById_2
+  path: /pet/{petId}
+  method: get
+  pathParams:
+    petId: '{{post_addPet_1.outputs.id}}'
+  expect:
+    jsonpath:
+      $.id: '{{post_addPet_1.outputs.id}}'
+      $.photoUrls.length(): {$gte: 1}
+      $.photoUrls[*]: {$type: string}
+      $.name: {$type: string, $ne: ""}
+    body_matches:
+      id: '@integer'
+      category: {name: '@string'}
+    latency_ms: {$lt: 5000}
+`
+
+func TestRunAssertions(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	planPath := filepath.Join(dir, "pet.yml")
+	writeFile(t, planPath, assertionPlan)
+	RunT(t, &Options{
+		Spec:    petstoreSpec,
+		Plan:    planPath,
+		Handler: petServer(),
+	})
+}
+
+func TestRunStatusExpectations(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	planPath := filepath.Join(dir, "pet.yml")
+	writeFile(t, planPath, "pet suite:\n- name: get_getPetById_1\n  path: /pet/{petId}\n  method: get\n  pathParams:\n    petId: -1\n"+
+		"  expect:\n    status: [400, 4xx]\n")
+	RunT(t, &Options{
+		Spec:    petstoreSpec,
+		Plan:    planPath,
+		Handler: petServer(),
+	})
+}
+
+const templatePlan = `
+pet suite:
+- name: post_addPet_1
+  path: /pet
+  method: post
+  bodyParams:
+    id: '{{randInt(1000, 2000)}}'
+    name: pet
+- name: get_getPetById_2
+  path: /pet/{petId}
+  method: get
+  pathParams:
+    petId: '{{post_addPet_1.outputs.id}}'
+`
+
+func TestRunTemplates(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	planPath := filepath.Join(dir, "pet.yml")
+	writeFile(t, planPath, templatePlan)
+	server := petServer()
+	var paths []string
+	result := RunT(t, &Options{
+		Spec: petstoreSpec,
+		Plan: planPath,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.Path)
+			server.ServeHTTP(w, r)
+		}),
+	})
+	if len(paths) != 2 || len(result.Tests) != 2 {
+		t.Fatalf("expected 2 tests, got %v", result.Counts)
+	}
+	// The pet is got by the id it was created with.
+	if id, err := strconv.Atoi(path.Base(paths[1])); err != nil || id < 1000 || id > 2000 {
+		t.Errorf("expected to get the pet created with a random id, got %s", paths[1])
+	}
+}
+
+const captureSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+servers:
+- url: /api
+paths:
+  /things:
+    post:
+      description: <meqa Thing..post>
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Thing'
+      responses:
+        '201':
+          description: created, the body is empty
+  /things/{id}:
+    get:
+      parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+      - name: X-Session
+        in: header
+        schema:
+          type: string
+      responses:
+        '200':
+          description: the thing
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Thing'
+components:
+  schemas:
+    Thing:
+      type: object
+      required: [name]
+      properties:
+        name:
+          type: string
+`
+
+const capturePlan = `
+things suite:
+- name: post_thing
+  path: /things
+  method: post
+  capture:
+    location: responseHeaders.location
+    session: cookies.session
+    created: '{{post_thing.status}}'
+- name: get_thing
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: '{{post_thing.responseHeaders.X-Thing-Id}}'
+  headerParams:
+    X-Session: '{{vars.session}}-{{vars.created}}'
+  capture:
+    url: '{{vars.location}}?name={{get_thing.outputs.name}}'
+- name: get_thing_again
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 7
+  headerParams:
+    X-Session: '{{vars.url}}'
+`
+
+func TestRunCapture(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	specPath := filepath.Join(dir, "things.yml")
+	planPath := filepath.Join(dir, "things_plan.yml")
+	writeFile(t, specPath, captureSpec)
+	writeFile(t, planPath, capturePlan)
+	var name string
+	var sessions []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var thing map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&thing)
+			name, _ = thing["name"].(string)
+			w.Header().Set("Location", "/api/things/7")
+			w.Header().Set("X-Thing-Id", "7")
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		if r.URL.Path != "/api/things/7" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sessions = append(sessions, r.Header.Get("X-Session"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": name})
+	})
+
+	RunT(t, &Options{Spec: specPath, Plan: planPath, Handler: handler})
+	if len(sessions) != 2 || sessions[0] != "abc-201" || sessions[1] != "/api/things/7?name="+name {
+		t.Errorf("expected the captured values to be sent, got %v", sessions)
+	}
+}
+
+const securitySpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+servers:
+- url: /api
+security:
+- bearerAuth: []
+paths:
+  /status:
+    get:
+      security: []
+      responses:
+        '200':
+          description: the service is up
+  /things:
+    post:
+      description: <meqa Thing..post>
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Thing'
+      responses:
+        '201':
+          description: created
+  /things/{id}:
+    parameters:
+    - name: id
+      in: path
+      required: true
+      schema:
+        type: integer
+    get:
+      security:
+      - headerKey: []
+      - basicAuth: []
+      responses:
+        '200':
+          description: the thing
+    delete:
+      security:
+      - queryKey: []
+        cookieKey: []
+      responses:
+        '204':
+          description: deleted
+components:
+  securitySchemes:
+    bearerAuth:
+      type: http
+      scheme: bearer
+    basicAuth:
+      type: http
+      scheme: basic
+    headerKey:
+      type: apiKey
+      in: header
+      name: X-Api-Key
+    queryKey:
+      type: apiKey
+      in: query
+      name: key
+    cookieKey:
+      type: apiKey
+      in: cookie
+      name: session
+  schemas:
+    Thing:
+      type: object
+      properties:
+        name:
+          type: string
+`
+
+const securityPlan = `
+things suite:
+- name: get_status
+  path: /status
+  method: get
+- name: post_thing
+  path: /things
+  method: post
+- name: get_thing
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 1
+- name: delete_thing
+  path: /things/{id}
+  method: delete
+  pathParams:
+    id: 1
+`
+
+func TestRunSecuritySchemes(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	specPath := filepath.Join(dir, "things.yml")
+	planPath := filepath.Join(dir, "things_plan.yml")
+	writeFile(t, specPath, securitySpec)
+	writeFile(t, planPath, securityPlan)
+	sent := make(map[string]string)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var creds []string
+		if auth := r.Header.Get("Authorization"); len(auth) > 0 {
+			creds = append(creds, auth)
+		}
+		if key := r.Header.Get("X-Api-Key"); len(key) > 0 {
+			creds = append(creds, "X-Api-Key "+key)
+		}
+		if key := r.URL.Query().Get("key"); len(key) > 0 {
+			creds = append(creds, "key="+key)
+		}
+		if c, err := r.Cookie("session"); err == nil {
+			creds = append(creds, "session="+c.Value)
+		}
+		sent[r.Method+" "+r.URL.Path] = strings.Join(creds, " ")
+		switch r.Method {
+		case http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:pw"))
+
+	RunT(t, &Options{
+		Spec:     specPath,
+		Plan:     planPath,
+		Handler:  handler,
+		ApiToken: "fallback",
+		Credentials: mqplan.Credentials{
+			"bearerAuth": {Token: "tok"},
+			"basicAuth":  {Username: "alice", Password: "pw"},
+			"queryKey":   {Value: "q"},
+			"cookieKey":  {Value: "c"},
+		},
+	})
+	want := map[string]string{
+		"GET /api/status":      "",
+		"POST /api/things":     "Bearer tok",
+		"GET /api/things/1":    basic,
+		"DELETE /api/things/1": "key=q session=c",
+	}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("expected the credentials of the security requirements, got %v", sent)
+	}
+}
+
+const oauthSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+servers:
+- url: /api
+security:
+- oauth: [read]
+paths:
+  /things/{id}:
+    get:
+      parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+      responses:
+        '200':
+          description: the thing
+components:
+  securitySchemes:
+    oauth:
+      type: oauth2
+      flows:
+        clientCredentials:
+          tokenUrl: /oauth/token
+          scopes:
+            read: read things
+`
+
+const oauthPlan = `
+things suite:
+- name: get_1
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 1
+- name: get_2
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 2
+- name: get_3
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 3
+`
+
+func TestRunOAuth2(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	specPath := filepath.Join(dir, "things.yml")
+	planPath := filepath.Join(dir, "things_plan.yml")
+	writeFile(t, specPath, oauthSpec)
+	writeFile(t, planPath, oauthPlan)
+	// A stub token server: every grant issues a new token, and the API accepts only the latest.
+	var grants []string
+	var valid string
+	calls := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/oauth/") {
+			r.ParseForm()
+			if id, secret, _ := r.BasicAuth(); id != "meqa" || secret != "s3cret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			grants = append(grants, r.Form.Get("grant_type"))
+			valid = fmt.Sprintf("token%d", len(grants))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  valid,
+				"token_type":    "Bearer",
+				"expires_in":    3600,
+				"refresh_token": "refresh",
+			})
+			return
+		}
+		// The second call has the token revoked.
+		calls++
+		if calls == 2 {
+			valid = ""
+		}
+		if r.Header.Get("Authorization") != "Bearer "+valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	})
+
+	// The token is cached, and replaced when it's rejected.
+	RunT(t, &Options{
+		Spec:        specPath,
+		Plan:        planPath,
+		Handler:     handler,
+		Credentials: mqplan.Credentials{"oauth": {ClientId: "meqa", ClientSecret: "s3cret"}},
+	})
+	if want := []string{"client_credentials", "refresh_token"}; !reflect.DeepEqual(grants, want) {
+		t.Errorf("expected a token and a refresh after the 401, got %v", grants)
+	}
+}
+
+const identityPlan = `
+meqa_init:
+- name: meqa_init
+  identities:
+    admin:
+      credentials:
+        bearerAuth:
+          token: admin
+---
+things suite:
+- name: meqa_init
+  as: admin
+- name: post_thing
+  path: /things
+  method: post
+- name: get_thing
+  path: /things/{id}
+  method: get
+  as: user
+  pathParams:
+    id: 1
+`
+
+func TestRunIdentities(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	specPath := filepath.Join(dir, "things.yml")
+	planPath := filepath.Join(dir, "things_plan.yml")
+	writeFile(t, specPath, securitySpec)
+	writeFile(t, planPath, identityPlan)
+	sent := make(map[string]string)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get("X-Api-Key"); len(key) > 0 {
+			sent[r.Method] = "X-Api-Key " + key
+		} else {
+			sent[r.Method] = r.Header.Get("Authorization")
+		}
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+	})
+	result := RunT(t, &Options{
+		Spec:       specPath,
+		Plan:       planPath,
+		Handler:    handler,
+		Identities: mqplan.Identities{"user": {Credentials: mqplan.Credentials{"headerKey": {Value: "user"}}}},
+	})
+	if sent["POST"] != "Bearer admin" || sent["GET"] != "X-Api-Key user" {
+		t.Errorf("expected the requests to be made as admin and user, got %v", sent)
+	}
+	if result.Tests[0].Identity != "admin" || result.Tests[1].Identity != "user" {
+		t.Errorf("expected the results to show the identities, got %q and %q", result.Tests[0].Identity, result.Tests[1].Identity)
+	}
+}
+
+const bolaSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+servers:
+- url: /api
+security:
+- bearerAuth: []
+paths:
+  /things:
+    post:
+      operationId: addThing
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Thing'
+      responses:
+        '200':
+          description: the thing created
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Thing'
+  /things/{thingId}:
+    parameters:
+    - name: thingId
+      in: path
+      required: true
+      schema:
+        type: integer
+    get:
+      operationId: getThing
+      responses:
+        '200':
+          description: the thing
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Thing'
+    put:
+      operationId: updateThing
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Thing'
+      responses:
+        '200':
+          description: the thing updated
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Thing'
+    delete:
+      operationId: deleteThing
+      responses:
+        '204':
+          description: deleted
+components:
+  securitySchemes:
+    bearerAuth:
+      type: http
+      scheme: bearer
+  schemas:
+    Thing:
+      type: object
+      properties:
+        id:
+          type: integer
+          readOnly: true
+        name:
+          type: string
+`
+
+// thingServer serves the things of bolaSpec. Only the owner of a thing can access it.
+func thingServer() http.Handler {
+	var mutex sync.Mutex
+	owners := make(map[int]string)
+	names := make(map[int]string)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		user := r.Header.Get("Authorization")
+		var thing map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&thing)
+		if r.URL.Path == "/api/things" {
+			id := len(owners) + 1
+			owners[id] = user
+			names[id], _ = thing["name"].(string)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "name": names[id]})
+			return
+		}
+		var id int
+		fmt.Sscanf(r.URL.Path, "/api/things/%d", &id)
+		if owner, ok := owners[id]; !ok || owner != user {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodDelete:
+			delete(owners, id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case http.MethodPut:
+			names[id], _ = thing["name"].(string)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "name": names[id]})
+	})
+}
+
+func TestRunBola(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	specPath := filepath.Join(dir, "things.yml")
+	planPath := filepath.Join(dir, "bola.yml")
+	writeFile(t, specPath, bolaSpec)
+	swagger, err := mqswag.CreateSwaggerFromURL(specPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dag := mqswag.NewDAG()
+	if err := swagger.AddToDAG(dag, mqutil.NewLogger(ioutil.Discard)); err != nil {
+		t.Fatal(err)
+	}
+	dag.Sort()
+	dag.CheckWeight()
+	plan, err := mqplan.GenerateBolaTestPlan(mqplan.NewContext(swagger, nil), dag, nil, nil, mqplan.BolaOwner, mqplan.BolaAttacker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.DumpToFile(planPath); err != nil {
+		t.Fatal(err)
+	}
+	identities := mqplan.Identities{
+		mqplan.BolaOwner:    {ApiToken: "alice"},
+		mqplan.BolaAttacker: {ApiToken: "mallory"},
+	}
+
+	result := RunT(t, &Options{Spec: specPath, Plan: planPath, Handler: thingServer(), Identities: identities})
+	var attacks []string
+	for _, r := range result.Tests {
+		if r.Identity == mqplan.BolaAttacker {
+			attacks = append(attacks, r.Method)
+		}
+	}
+	if want := []string{"get", "put", "delete"}; !reflect.DeepEqual(attacks, want) {
+		t.Errorf("expected the attacker to try %v, got %v", want, attacks)
+	}
+}
+
+const permissionsPlan = `
+things suite:
+- name: post_thing
+  path: /things
+  method: post
+- name: get_thing
+  path: /things/{thingId}
+  method: get
+  pathParams:
+    thingId: 1
+- name: delete_thing
+  path: /things/{thingId}
+  method: delete
+  pathParams:
+    thingId: 1
+`
+
+func TestRunPermissions(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	specPath := filepath.Join(dir, "things.yml")
+	planPath := filepath.Join(dir, "things_plan.yml")
+	writeFile(t, specPath, bolaSpec)
+	writeFile(t, planPath, permissionsPlan)
+	// The server lets users delete things but not read them.
+	allowed := map[string]map[string]bool{
+		"Bearer admin": {http.MethodPost: true, http.MethodGet: true, http.MethodDelete: true},
+		"Bearer user":  {http.MethodPost: true, http.MethodDelete: true},
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods, ok := allowed[r.Header.Get("Authorization")]
+		switch {
+		case !ok:
+			w.WriteHeader(http.StatusUnauthorized)
+		case !methods[r.Method]:
+			w.WriteHeader(http.StatusForbidden)
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id": 1, "name": "thing"}`)
+		}
+	})
+	result, err := Run(&Options{
+		Spec:    specPath,
+		Plan:    planPath,
+		Handler: handler,
+		Identities: mqplan.Identities{
+			"admin": {ApiToken: "admin"},
+			"user":  {ApiToken: "user"},
+			"guest": {ApiToken: "guest"},
+		},
+		Permissions: mqplan.Permissions{
+			"admin": {"*"},
+			"user":  {"addThing", "GET /things/{thingId}"},
+			"guest": {},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := result.Permissions
+	if want := []string{"admin", "guest", "user"}; !reflect.DeepEqual(m.Roles, want) {
+		t.Fatalf("expected the roles %v, got %v", want, m.Roles)
+	}
+	if m.OverPermissive != 1 || m.UnderPermissive != 1 || m.Unknown != 0 {
+		t.Errorf("expected one over- and one under-permissive cell, got %d and %d and %d unknown", m.OverPermissive, m.UnderPermissive, m.Unknown)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 
 	"os"
 	"path/filepath"
@@ -19,29 +20,26 @@ const (
 	algoSimple  = "simple"
 	algoObject  = "object"
 	algoPath    = "path"
+	algoBola    = "bola" // not in all, it needs identities to run
 	algoAll     = "all"
 )
 
 var algoList []string = []string{algoSimple, algoObject, algoPath}
 
 func main() {
-	mqutil.Logger = mqutil.NewStdLogger()
-
 	swaggerJSONFile := filepath.Join(meqaDataDir, "swagger.yml")
 	meqaPath := flag.String("d", meqaDataDir, "the directory where we put the generated files")
 	swaggerFile := flag.String("s", swaggerJSONFile, "the swagger.yml file location")
-	algorithm := flag.String("a", "all", "the algorithm - simple, object, path, all")
+	algorithm := flag.String("a", "all", "the algorithm - simple, object, path, bola, all")
 	verbose := flag.Bool("v", false, "turn on verbose mode")
 	allowedAPIsFile := flag.String("w", "", "name of the file (that lists out all fuzzable APIs) along with its relative path. Example testdata/allowedAPIs.cfg")
 	ignoredPathsFile := flag.String("i", "", "name of the file (that lists out all ignored paths in APIs) along with its relative path. Example testdata/ignorePaths.cfg")
 
 	flag.Parse()
-	run(meqaPath, swaggerFile, algorithm, verbose, allowedAPIsFile, ignoredPathsFile)
+	run(mqutil.NewStdLogger(), meqaPath, swaggerFile, algorithm, verbose, allowedAPIsFile, ignoredPathsFile)
 }
 
-func run(meqaPath *string, swaggerFile *string, algorithm *string, verbose *bool, allowedAPIsFile *string, ignoredPathsFile *string) {
-	mqutil.Verbose = *verbose
-
+func run(logger *log.Logger, meqaPath *string, swaggerFile *string, algorithm *string, verbose *bool, allowedAPIsFile *string, ignoredPathsFile *string) {
 	swaggerJsonPath := *swaggerFile
 	if fi, err := os.Stat(swaggerJsonPath); os.IsNotExist(err) || fi.Mode().IsDir() {
 		fmt.Printf("Can't load swagger file at the following location %s", swaggerJsonPath)
@@ -64,13 +62,16 @@ func run(meqaPath *string, swaggerFile *string, algorithm *string, verbose *bool
 	// loading swagger.json
 	swagger, err := mqswag.CreateSwaggerFromURL(swaggerJsonPath, *meqaPath)
 	if err != nil {
-		mqutil.Logger.Printf("Error: %s", err.Error())
+		logger.Printf("Error: %s", err.Error())
 		os.Exit(1)
 	}
+	ctx := mqplan.NewContext(swagger, logger)
+	ctx.Verbose = *verbose
 	dag := mqswag.NewDAG()
-	err = swagger.AddToDAG(dag)
+	dag.Verbose = *verbose
+	err = swagger.AddToDAG(dag, logger)
 	if err != nil {
-		mqutil.Logger.Printf("Error: %s", err.Error())
+		logger.Printf("Error: %s", err.Error())
 		os.Exit(1)
 	}
 
@@ -88,20 +89,22 @@ func run(meqaPath *string, swaggerFile *string, algorithm *string, verbose *bool
 		var testPlan *mqplan.TestPlan
 		switch algo {
 		case algoPath:
-			testPlan, err = mqplan.GeneratePathTestPlan(swagger, dag, allowedAPIs, ignoredPaths)
+			testPlan, err = mqplan.GeneratePathTestPlan(ctx, dag, allowedAPIs, ignoredPaths)
 		case algoObject:
-			testPlan, err = mqplan.GenerateTestPlan(swagger, dag)
+			testPlan, err = mqplan.GenerateTestPlan(ctx, dag)
+		case algoBola:
+			testPlan, err = mqplan.GenerateBolaTestPlan(ctx, dag, allowedAPIs, ignoredPaths, mqplan.BolaOwner, mqplan.BolaAttacker)
 		default:
-			testPlan, err = mqplan.GenerateSimpleTestPlan(swagger, dag)
+			testPlan, err = mqplan.GenerateSimpleTestPlan(ctx, dag)
 		}
 		if err != nil {
-			mqutil.Logger.Printf("Error: %s", err.Error())
+			logger.Printf("Error: %s", err.Error())
 			os.Exit(1)
 		}
 		testPlanFile := filepath.Join(testPlanPath, algo+".yml")
 		err = testPlan.DumpToFile(testPlanFile)
 		if err != nil {
-			mqutil.Logger.Printf("Error: %s", err.Error())
+			logger.Printf("Error: %s", err.Error())
 			os.Exit(1)
 		}
 		fmt.Println("Test plans generated at:", testPlanFile)
@@ -9,7 +9,7 @@ import (
 )
 
 func TestMqgen(t *testing.T) {
-	mqutil.Logger = mqutil.NewStdLogger()
+	logger := mqutil.NewStdLogger()
 	wd, _ := os.Getwd()
 	meqaPath := filepath.Join(wd, "../../testdata")
 	swaggerPath := filepath.Join(meqaPath, "petstore_meqa.yml")
@@ -17,7 +17,7 @@ func TestMqgen(t *testing.T) {
 	verbose := false
 	allowedAPIsPath := ""
 	ignoredPathsPath := ""
-	run(&meqaPath, &swaggerPath, &algorithm, &verbose, &allowedAPIsPath, &ignoredPathsPath)
+	run(logger, &meqaPath, &swaggerPath, &algorithm, &verbose, &allowedAPIsPath, &ignoredPathsPath)
 }
 
 func TestMain(m *testing.M) {
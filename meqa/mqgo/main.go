@@ -2,13 +2,13 @@ package main
 
 import (
 	"crypto/tls"
-	"crypto/x509"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"strings"
+	"time"
 
 	"path/filepath"
 
@@ -18,193 +18,101 @@ import (
 
 	"github.com/AdityaVallabh/swagger_meqa/meqa/mqplan"
 
-	uuid "github.com/gofrs/uuid"
-	"gopkg.in/resty.v1"
 	"gopkg.in/yaml.v2"
 )
 
 const (
 	meqaDataDir = "meqa_data"
-	configFile  = ".config.yml"
 	resultFile  = "result.yml"
-	serverURL   = "https://api.meqa.io"
 )
 
 const (
-	configAPIKey       = "api_key"
-	configAcceptedTerm = "terms_accepted"
+	algoSimple = "simple"
+	algoObject = "object"
+	algoPath   = "path"
+	algoBola   = "bola" // not in all, it needs identities to run
+	algoAll    = "all"
 )
 
+var algoList []string = []string{algoSimple, algoObject, algoPath}
+
 const (
 	SupportedFuzzTypes = "Supported fuzz types: none, positive, datatype, negative or all"
 )
 
-func writeConfigFile(configPath string, configMap map[string]interface{}) error {
-	configBytes, err := yaml.Marshal(configMap)
-	if err != nil {
-		return err
-	}
-	err = ioutil.WriteFile(configPath, configBytes, 0644)
+// generateMeqa tags the spec at swaggerPath with what can be inferred from its structure and
+// writes it to meqaPath, along with the test plans for algorithm if it isn't empty. With extension
+// the tags are written as x-meqa extensions rather than into the descriptions.
+func generateMeqa(logger *log.Logger, meqaPath string, swaggerPath string, algorithm string, extension bool) error {
+	inputBytes, err := ioutil.ReadFile(swaggerPath)
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-func getConfigs(meqaPath string) (map[string]interface{}, error) {
-	configMap := make(map[string]interface{})
-	configPath := filepath.Join(meqaPath, configFile)
-
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		u, _ := uuid.NewV4()
-		configMap[configAPIKey] = u.String()
-		err = writeConfigFile(configPath, configMap)
-		if err != nil {
-			return nil, err
-		}
-		return configMap, nil
-	}
-	configBytes, err := ioutil.ReadFile(configPath)
+	doc, err := mqswag.ReadSpecDoc(inputBytes)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("can't read the spec %s: %s", swaggerPath, err.Error())
 	}
-	err = yaml.Unmarshal(configBytes, &configMap)
+	doc, count, err := mqswag.TagSpecDoc(doc, extension, logger)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("can't tag the spec %s: %s", swaggerPath, err.Error())
 	}
-	return configMap, nil
-}
-
-func generateMeqa(meqaPath string, swaggerPath string) error {
-	caPool := x509.NewCertPool()
-	permCert := `-----BEGIN CERTIFICATE-----
-MIIDVzCCAj+gAwIBAgIJAJOCmHT8l8H6MA0GCSqGSIb3DQEBCwUAMEIxCzAJBgNV
-BAYTAlVTMQswCQYDVQQIDAJDQTEQMA4GA1UECgwHbWVxYS5pbzEUMBIGA1UEAwwL
-YXBpLm1lcWEuaW8wHhcNMTcxMDEwMDQzMTQ0WhcNMjcxMDA4MDQzMTQ0WjBCMQsw
-CQYDVQQGEwJVUzELMAkGA1UECAwCQ0ExEDAOBgNVBAoMB21lcWEuaW8xFDASBgNV
-BAMMC2FwaS5tZXFhLmlvMIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA
-zrqFruzqLoQDDo+RnjmiBQRTz5P2thD57UCKZXASoTbGL/RppR1bgq+87WTGyqij
-5+NSNbwzl+0wfLzQJ3n5klvjihDHGxsKf/cyHxuTiUtxt7IK+R5lMahLQuSReHi9
-74KEDJqfUQVkR29AR7Tnay1jM/qDl1zwM2MzZJFYN/3Fb6oTCKCL07T6Ai0Ct5E3
-R+Top8rD8QNK7VWivF78Pxyqi9D6OARF/t0PjQWD6PippGzwVArNbdniZw9Fybgi
-6XMa7BD+5XX9kz/Yr8YbyiEMuwiIgp7Qiy9YUfdad1rlnClp79AffNt+FcPWFUAX
-HOO1SfpEJsKeFsIm2gZbDQIDAQABo1AwTjAdBgNVHQ4EFgQUDBBLDmUc6ELMjI+b
-4MXf5EnsbGAwHwYDVR0jBBgwFoAUDBBLDmUc6ELMjI+b4MXf5EnsbGAwDAYDVR0T
-BAUwAwEB/zANBgkqhkiG9w0BAQsFAAOCAQEAbTVTBURITrhDcXvAZTaYyknyH9He
-hIixE4SFAtZ5i6NWhiA9veKfcSTtGVxzwbpEx5Rhqxx9OoYA6gD/BTtyX8GSFAbu
-tQelpNZSBOnDCEMRCNUc1+ccULvJdXN0MGkjtNeCgv6S3gjyhFe+xRHB8nFOiq7Z
-0qCFziwr2nK5sBoISMyERlQHwTaSbqm/AvvZioDkgTwcubfP9GIa6zkc6RxBJW+S
-I7nKRgKm9r+E6Yi7Kahf1bCWCmFUVZKd+Y1zyWlYZA43v9gFcy8ZHOWg5+GAIhyO
-UDqHH0wRogFg9n/9p69s/RcDdn6dW6Psdtvmxug28ExUQxYTkj/6ORmoiw==
------END CERTIFICATE-----
-`
-	caPool.AppendCertsFromPEM([]byte(permCert))
-	config := tls.Config{RootCAs: caPool}
-
-	resty.SetTLSClientConfig(&config)
-	resty.SetRedirectPolicy(resty.FlexibleRedirectPolicy(15))
-
-	// Get the API key, if it doesn't exist, generate one.
-	configMap, err := getConfigs(meqaPath)
+	outBytes, err := yaml.Marshal(doc)
 	if err != nil {
 		return err
 	}
-	if configMap[configAPIKey] == nil {
-		return fmt.Errorf("api_key not found in %s", filepath.Join(meqaPath, configFile))
-	}
-	acceptedTerm := configMap[configAcceptedTerm]
-	if acceptedTerm != nil {
-		acceptedTermBool, ok := acceptedTerm.(bool)
-		if !ok || !acceptedTermBool {
-			acceptedTerm = nil
-		}
-	}
-	warning := `The "generate" command will send your Swagger spec to
-https://api.meqa.io to be processed. This service is provided as a convenience.
-You can also follow https://github.com/meqaio/swagger_meqa/tree/master/docs
-to do Swagger spec processing on your local computer. By continuing and using
-the https://api.meqa.io service you are agreeing to our Terms and Conditions
-located at: https://github.com/meqaio/swagger_meqa/blob/master/TERMS.md.
-
-Do you wish to proceed? y/n: `
-	if acceptedTerm == nil {
-		fmt.Print(warning)
-		var answer string
-		fmt.Scanln(&answer)
-		if answer != "y" {
-			os.Exit(1)
-		}
-		configMap[configAcceptedTerm] = true
-		err = writeConfigFile(filepath.Join(meqaPath, configFile), configMap)
-		if err != nil {
-			return err
-		}
-	}
 
-	inputBytes, err := ioutil.ReadFile(swaggerPath)
+	// output file name is the input swagger spec name + _meqa.yml, if there isn't a _meqa already
+	_, inputFile := filepath.Split(swaggerPath)
+	swaggerMeqaPath := filepath.Join(meqaPath, strings.TrimSuffix(strings.Split(inputFile, ".")[0], "_meqa")+"_meqa.yml")
+	fmt.Printf("Writing tagged swagger spec with %d new tags to: %s\n", count, swaggerMeqaPath)
+	err = ioutil.WriteFile(swaggerMeqaPath, outBytes, 0644)
 	if err != nil {
 		return err
 	}
-	var swaggerMap map[string]interface{}
-	err = json.Unmarshal(inputBytes, &swaggerMap)
-	if err == nil {
-		// Convert to yaml because the server python requires it
-		inputBytes, err = yaml.Marshal(swaggerMap)
-		if err != nil {
-			fmt.Printf("Unexpected error: %s\n", err)
-			os.Exit(1)
-		}
-	} else {
-		err = yaml.Unmarshal(inputBytes, &swaggerMap)
-		if err != nil {
-			fmt.Printf("Failed to unmarshal file %s as yaml - error: %s\n", swaggerPath, err.Error())
-			os.Exit(1)
-		}
-	}
-	if sv := swaggerMap["swagger"]; sv != "2.0" {
-		fmt.Printf("We only support swagger/openapi spec 2.0 right now. Your version is %s\n", sv)
-		os.Exit(1)
+	if len(algorithm) == 0 {
+		return nil
 	}
 
-	bodyMap := make(map[string]interface{})
-	bodyMap["api_key"] = configMap[configAPIKey]
-	bodyMap["swagger"] = string(inputBytes)
-
-	req := resty.R()
-	req.SetBody(bodyMap)
-	resp, err := req.Post(serverURL + "/specs")
-
-	if status := resp.StatusCode(); status >= 300 {
-		return fmt.Errorf("server call failed, status %d, body:\n%s", status, string(resp.Body()))
+	plansToGenerate := []string{algorithm}
+	if algorithm == algoAll {
+		plansToGenerate = algoList
 	}
-
-	respMap := make(map[string]interface{})
-	err = json.Unmarshal(resp.Body(), &respMap)
+	swagger, err := mqswag.CreateSwaggerFromURL(swaggerMeqaPath, meqaPath)
 	if err != nil {
 		return err
 	}
-
-	if respMap["swagger_meqa"] == nil {
-		return fmt.Errorf("server call failed, status %d, body:\n%s", resp.StatusCode(), string(resp.Body()))
-	}
-
-	// output file name is the input swagger spec name + _meqa.yml, if there isn't a _meqa already
-	_, inputFile := filepath.Split(swaggerPath)
-	swaggerMeqaPath := filepath.Join(meqaPath, strings.TrimSuffix(strings.Split(inputFile, ".")[0], "_meqa")+"_meqa.yml")
-	fmt.Printf("Writing tagged swagger spec to: %s\n", swaggerMeqaPath)
-	err = ioutil.WriteFile(swaggerMeqaPath, []byte(respMap["swagger_meqa"].(string)), 0644)
+	ctx := mqplan.NewContext(swagger, logger)
+	dag := mqswag.NewDAG()
+	err = swagger.AddToDAG(dag, logger)
 	if err != nil {
 		return err
 	}
-	for planName, planBody := range respMap["test_plans"].(map[string]interface{}) {
-		planPath := filepath.Join(meqaPath, planName+".yml")
+	dag.Sort()
+	dag.CheckWeight()
+	for _, algo := range plansToGenerate {
+		var testPlan *mqplan.TestPlan
+		switch algo {
+		case algoPath:
+			testPlan, err = mqplan.GeneratePathTestPlan(ctx, dag, nil, nil)
+		case algoObject:
+			testPlan, err = mqplan.GenerateTestPlan(ctx, dag)
+		case algoBola:
+			testPlan, err = mqplan.GenerateBolaTestPlan(ctx, dag, nil, nil, mqplan.BolaOwner, mqplan.BolaAttacker)
+		case algoSimple:
+			testPlan, err = mqplan.GenerateSimpleTestPlan(ctx, dag)
+		default:
+			return fmt.Errorf("unknown algorithm %s", algo)
+		}
+		if err != nil {
+			return err
+		}
+		planPath := filepath.Join(meqaPath, algo+".yml")
 		fmt.Printf("Writing test suites file to: %s\n", planPath)
-		err = ioutil.WriteFile(planPath, []byte(planBody.(string)), 0644)
+		err = testPlan.DumpToFile(planPath)
 		if err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
@@ -215,22 +123,41 @@ func main() {
 	runCommand.SetOutput(os.Stdout)
 
 	genMeqaPath := genCommand.String("d", meqaDataDir, "the directory where meqa config, log and output files reside")
-	genSwaggerFile := genCommand.String("s", "", "the OpenAPI (Swagger) spec file path")
-
-	runMeqaPath := runCommand.String("d", meqaDataDir, "the directory where meqa config, log and output files reside")
-	runSwaggerFile := runCommand.String("s", "", "the meqa generated OpenAPI (Swagger) spec file path")
-	testPlanFile := runCommand.String("p", "", "the test plan file name")
-	resultPath := runCommand.String("r", "", "the test result file name (default result.yml in meqa_data dir)")
-	testToRun := runCommand.String("t", "all", "the test to run")
-	username := runCommand.String("u", "", "the username for basic HTTP authentication")
-	password := runCommand.String("w", "", "the password for basic HTTP authentication")
-	apitoken := runCommand.String("a", "", "the api token for bearer HTTP authentication")
-	baseURL := runCommand.String("h", "", "the host's base url")
-	fuzzType := runCommand.String("f", "", SupportedFuzzTypes)
-	batchSize := runCommand.Int("b", 10, "batch size")
-	repro := runCommand.Bool("re", false, "reproduce failures")
-	datasetPath := runCommand.String("l", "", "the dataset path")
-	verbose := runCommand.Bool("v", false, "turn on verbose mode")
+	genSwaggerFile := genCommand.String("s", "", "the OpenAPI 3 or Swagger 2.0 spec file path")
+	genAlgorithm := genCommand.String("a", "", "the test plans to generate - simple, object, path, bola, all (default none)")
+	genExtension := genCommand.Bool("x", false, "write the tags as x-meqa extensions instead of into the descriptions")
+
+	var opts runOptions
+	runCommand.StringVar(&opts.meqaPath, "d", meqaDataDir, "the directory where meqa config, log and output files reside")
+	runCommand.StringVar(&opts.swaggerFile, "s", "", "the meqa generated OpenAPI (Swagger) spec file path")
+	runCommand.StringVar(&opts.testPlanFile, "p", "", "the test plan file name")
+	runCommand.StringVar(&opts.resultPath, "r", "", "the test result file name (default result.yml in meqa_data dir)")
+	runCommand.StringVar(&opts.testToRun, "t", "all", "the test to run")
+	runCommand.StringVar(&opts.username, "u", "", "the username for basic HTTP authentication")
+	runCommand.StringVar(&opts.password, "w", "", "the password for basic HTTP authentication")
+	runCommand.StringVar(&opts.apitoken, "a", "", "the api token for bearer HTTP authentication")
+	runCommand.StringVar(&opts.credentialsPath, "credentials", "", "the file with the credentials of the security schemes in the spec")
+	runCommand.StringVar(&opts.identitiesPath, "identities", "", "the file with the identities tests can run as")
+	runCommand.StringVar(&opts.permissionsPath, "permissions", "", "the file with the operations each role may call, runs the tests as every role and checks the policy")
+	runCommand.StringVar(&opts.permissionsJsonPath, "permissions-json", "", "the JSON permission matrix report file name")
+	runCommand.StringVar(&opts.baseURL, "h", "", "the host's base url")
+	runCommand.StringVar(&opts.fuzzType, "f", "", SupportedFuzzTypes)
+	runCommand.IntVar(&opts.batchSize, "b", 10, "batch size")
+	runCommand.BoolVar(&opts.repro, "re", false, "reproduce failures")
+	runCommand.StringVar(&opts.datasetPath, "l", "", "the dataset path")
+	runCommand.BoolVar(&opts.verbose, "v", false, "turn on verbose mode")
+	runCommand.IntVar(&opts.workers, "j", 1, "the number of test suites to run in parallel")
+	runCommand.StringVar(&opts.junitPath, "junit", "", "the JUnit XML report file name")
+	runCommand.StringVar(&opts.htmlPath, "html", "", "the HTML report file name")
+	runCommand.StringVar(&opts.eventsPath, "events", "", "the file to stream a JSON result per test to")
+	runCommand.BoolVar(&opts.coverage, "coverage", false, "print the API coverage report")
+	runCommand.StringVar(&opts.coveragePath, "coverage-json", "", "the JSON API coverage report file name")
+	runCommand.DurationVar(&opts.timeout, "timeout", 0, "the timeout of each request, e.g. 30s (default no timeout)")
+	runCommand.StringVar(&opts.proxy, "proxy", "", "the proxy URL to send requests through")
+	runCommand.BoolVar(&opts.tlsVerify, "tls-verify", false, "verify the server's TLS certificate (by default it isn't, so test servers can use self-signed ones)")
+	runCommand.BoolVar(&opts.examples, "examples", false, "prefer the examples in the spec to random values when generating parameters")
+	runCommand.BoolVar(&opts.strictSchema, "strict-schema", false, "validate responses with a JSON Schema validator and fail the tests that don't match")
+	runCommand.Float64Var(&opts.minCoverage, "min-coverage", 0, "the minimum percentage of operations that must be called, the run fails below it")
 
 	flag.Usage = func() {
 		fmt.Println("Usage: mqgo {generate|run} [options]")
@@ -255,8 +182,8 @@ func main() {
 		swaggerFile = genSwaggerFile
 	case "run":
 		runCommand.Parse(os.Args[2:])
-		meqaPath = runMeqaPath
-		swaggerFile = runSwaggerFile
+		meqaPath = &opts.meqaPath
+		swaggerFile = &opts.swaggerFile
 	default:
 		flag.Usage()
 		os.Exit(1)
@@ -277,14 +204,13 @@ func main() {
 	}
 
 	if os.Args[1] == "run" {
-		if len(*resultPath) == 0 {
-			rf := filepath.Join(*meqaPath, resultFile)
-			resultPath = &rf
+		if len(opts.resultPath) == 0 {
+			opts.resultPath = filepath.Join(*meqaPath, resultFile)
 		}
 	}
 
-	mqutil.Logger = mqutil.NewFileLogger(filepath.Join(*meqaPath, "mqgo.log"))
-	mqutil.Logger.Println(os.Args)
+	logger := mqutil.NewFileLogger(filepath.Join(*meqaPath, "mqgo.log"))
+	logger.Println(os.Args)
 
 	if _, err := os.Stat(*swaggerFile); os.IsNotExist(err) {
 		fmt.Printf("can't load swagger file at the following location %s", *swaggerFile)
@@ -292,7 +218,7 @@ func main() {
 	}
 
 	if genCommand.Parsed() {
-		err = generateMeqa(*meqaPath, *swaggerFile)
+		err = generateMeqa(logger, *meqaPath, *swaggerFile, *genAlgorithm, *genExtension)
 		if err != nil {
 			fmt.Printf("got an err:\n%s", err.Error())
 			os.Exit(1)
@@ -300,56 +226,91 @@ func main() {
 		return
 	}
 
-	runMeqa(meqaPath, swaggerFile, testPlanFile, resultPath, testToRun, username, password, apitoken, baseURL, datasetPath, fuzzType, batchSize, repro, verbose)
+	runMeqa(logger, &opts)
 }
 
-func runMeqa(meqaPath, swaggerFile, testPlanFile, resultPath,
-	testToRun, username, password, apitoken, baseURL, datasetPath, fuzzType *string, batchSize *int, repro, verbose *bool) {
+// runOptions are the flags of the run command.
+type runOptions struct {
+	meqaPath            string
+	swaggerFile         string
+	testPlanFile        string
+	resultPath          string
+	testToRun           string
+	username            string
+	password            string
+	apitoken            string
+	credentialsPath     string
+	identitiesPath      string
+	permissionsPath     string
+	permissionsJsonPath string
+	baseURL             string
+	datasetPath         string
+	junitPath           string
+	htmlPath            string
+	eventsPath          string
+	coveragePath        string
+	proxy               string
+	fuzzType            string
+	batchSize           int
+	workers             int
+	repro               bool
+	verbose             bool
+	coverage            bool
+	examples            bool
+	strictSchema        bool
+	tlsVerify           bool
+	minCoverage         float64
+	timeout             time.Duration
+}
 
-	mqutil.Verbose = *verbose
+func runMeqa(logger *log.Logger, opts *runOptions) {
 
-	if len(*testPlanFile) == 0 {
+	if len(opts.testPlanFile) == 0 {
 		fmt.Println("You must use -p to specify a test plan file. Use -h to see more options.")
 		os.Exit(1)
 	}
 
-	if _, err := os.Stat(*testPlanFile); os.IsNotExist(err) {
-		fmt.Printf("can't load test plan file at the following location %s", *testPlanFile)
+	if _, err := os.Stat(opts.testPlanFile); os.IsNotExist(err) {
+		fmt.Printf("can't load test plan file at the following location %s", opts.testPlanFile)
 		os.Exit(1)
 	}
 
 	var fuzzMode string
-	switch strings.ToLower(*fuzzType) {
+	switch strings.ToLower(opts.fuzzType) {
 	case "none": // Accept 'none' as valid fuzzType and leave fuzzMode empty
 	case mqutil.FuzzPositive, mqutil.FuzzNegative, mqutil.FuzzDataType, mqutil.FuzzAll:
-		fuzzMode = *fuzzType
+		fuzzMode = opts.fuzzType
 	default:
-		fmt.Println("Unknown fuzzType:", *fuzzType)
+		fmt.Println("Unknown fuzzType:", opts.fuzzType)
 		fmt.Println(SupportedFuzzTypes)
 		os.Exit(1)
 	}
 
 	// load swagger.yml
-	swagger, err := mqswag.CreateSwaggerFromURL(*swaggerFile, *meqaPath)
+	swagger, err := mqswag.CreateSwaggerFromURL(opts.swaggerFile, opts.meqaPath)
 	if err != nil {
-		mqutil.Logger.Printf("Error: %s", err.Error())
-	}
-	mqswag.ObjDB.Init(swagger)
-	mqplan.Current.FuzzType = fuzzMode
-	mqplan.Current.Repro = *repro
+		logger.Printf("Error: %s", err.Error())
+	}
+	ctx := mqplan.NewContext(swagger, logger)
+	ctx.Verbose = opts.verbose
+	plan := &mqplan.TestPlan{}
+	plan.FuzzType = fuzzMode
+	plan.Repro = opts.repro
+	plan.Examples = opts.examples
+	plan.StrictSchema = opts.strictSchema
 	if len(fuzzMode) > 0 {
-		err := mqswag.ReadUniqueKeys(*meqaPath)
+		ctx.UniqueKeys, err = mqswag.ReadUniqueKeys(opts.meqaPath)
 		if err != nil {
 			fmt.Printf("Error reading %s - %s\n", mqswag.UniqueKeysFile, err.Error())
 			os.Exit(1)
 		}
-		err = mqplan.Current.ReadFails(*meqaPath)
+		err = ctx.ReadFails(opts.meqaPath, fuzzMode)
 		if err != nil {
 			fmt.Printf("Error reading %s - %s\n", mqplan.MeqaFails, err.Error())
 			os.Exit(1)
 		}
-		if !*repro {
-			err := mqswag.ReadDataset(*datasetPath, *meqaPath, fuzzMode, *batchSize)
+		if !opts.repro {
+			ctx.Dataset, ctx.DoneData, err = mqswag.ReadDataset(opts.datasetPath, opts.meqaPath, fuzzMode, opts.batchSize)
 			if err != nil {
 				fmt.Println("Error reading datasets -", err.Error())
 				os.Exit(1)
@@ -358,62 +319,138 @@ func runMeqa(meqaPath, swaggerFile, testPlanFile, resultPath,
 	}
 
 	// load test plan
-	mqplan.Current.Username = *username
-	mqplan.Current.Password = *password
-	mqplan.Current.ApiToken = *apitoken
-	if *baseURL == "" {
-		*baseURL = swagger.Servers[0].URL
-	}
-	mqplan.Current.BaseURL = *baseURL
-	err = mqplan.Current.InitFromFile(*testPlanFile, &mqswag.ObjDB)
+	plan.Username = opts.username
+	plan.Password = opts.password
+	plan.ApiToken = opts.apitoken
+	if len(opts.credentialsPath) > 0 {
+		plan.Credentials, err = mqplan.ReadCredentials(opts.credentialsPath)
+		if err != nil {
+			fmt.Printf("Error reading %s - %s\n", opts.credentialsPath, err.Error())
+			os.Exit(1)
+		}
+	}
+	if len(opts.identitiesPath) > 0 {
+		plan.Identities, err = mqplan.ReadIdentities(opts.identitiesPath)
+		if err != nil {
+			fmt.Printf("Error reading %s - %s\n", opts.identitiesPath, err.Error())
+			os.Exit(1)
+		}
+	}
+	if opts.baseURL == "" {
+		opts.baseURL = swagger.Servers[0].URL
+	}
+	plan.BaseURL = opts.baseURL
+	err = plan.InitFromFile(opts.testPlanFile, ctx)
 	if err != nil {
-		mqutil.Logger.Printf("Error loading test plan: %s", err.Error())
-	}
-
-	// for testing, set the config to skip verifying https certificates
-	resty.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
-	resty.SetRedirectPolicy(resty.FlexibleRedirectPolicy(15))
-
-	mqplan.Current.ResultCounts = make(map[string]int)
-	if *testToRun == "all" {
-		for _, testSuite := range mqplan.Current.SuiteList {
-			mqutil.Logger.Printf("\n---\nTest suite: %s\n", testSuite.Name)
-			fmt.Printf("\n---\nTest suite: %s\n", testSuite.Name)
-			counts, err := mqplan.Current.Run(testSuite.Name, nil)
-			mqutil.Logger.Printf("err:\n%v", err)
-			for k := range counts {
-				mqplan.Current.ResultCounts[k] += counts[k]
-			}
+		logger.Printf("Error loading test plan: %s", err.Error())
+	}
+
+	// for testing, set the config to skip verifying https certificates unless asked to
+	plan.SetClientConfig(&mqplan.ClientConfig{
+		Timeout: opts.timeout,
+		Proxy:   opts.proxy,
+		TLS:     &tls.Config{InsecureSkipVerify: !opts.tlsVerify},
+	})
+
+	plan.ResultCounts = make(map[string]int)
+	if len(opts.eventsPath) > 0 {
+		eventsFile, err := os.Create(opts.eventsPath)
+		if err != nil {
+			fmt.Printf("Error creating %s - %s\n", opts.eventsPath, err.Error())
+			os.Exit(1)
+		}
+		defer eventsFile.Close()
+		plan.Events = eventsFile
+	}
+	var suitesToRun []string
+	if opts.testToRun == "all" {
+		for _, testSuite := range plan.SuiteList {
+			suitesToRun = append(suitesToRun, testSuite.Name)
+		}
+	} else {
+		suitesToRun = append(suitesToRun, opts.testToRun)
+	}
+	var permissions mqplan.Permissions
+	if len(opts.permissionsPath) > 0 {
+		permissions, err = mqplan.ReadPermissions(opts.permissionsPath)
+		if err != nil {
+			fmt.Printf("Error reading %s - %s\n", opts.permissionsPath, err.Error())
+			os.Exit(1)
+		}
+		err = plan.RunPermissions(suitesToRun, opts.workers, permissions)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
 		}
 	} else {
-		mqutil.Logger.Printf("\n---\nTest suite: %s\n", *testToRun)
-		fmt.Printf("\n---\nTest suite: %s\n", *testToRun)
-		counts, err := mqplan.Current.Run(*testToRun, nil)
-		mqutil.Logger.Printf("err:\n%v", err)
-		for k := range counts {
-			mqplan.Current.ResultCounts[k] += counts[k]
+		plan.RunAll(suitesToRun, opts.workers)
+	}
+	plan.LogErrors()
+	plan.PrintSummary()
+	var matrix *mqplan.PermissionMatrix
+	if permissions != nil {
+		matrix = plan.PermissionMatrix(permissions)
+		matrix.Print(os.Stdout)
+	}
+	cov := plan.Coverage()
+	if opts.coverage {
+		cov.Print(os.Stdout)
+	}
+	os.Remove(opts.resultPath)
+	plan.WriteResultToFile(opts.resultPath)
+	if len(opts.junitPath) > 0 {
+		err := plan.WriteJUnit(opts.junitPath)
+		if err != nil {
+			fmt.Printf("Error writing JUnit report to %s - %s\n", opts.junitPath, err.Error())
+			os.Exit(1)
+		}
+	}
+	if len(opts.htmlPath) > 0 {
+		err := plan.WriteHTML(opts.htmlPath)
+		if err != nil {
+			fmt.Printf("Error writing HTML report to %s - %s\n", opts.htmlPath, err.Error())
+			os.Exit(1)
+		}
+	}
+	if len(opts.coveragePath) > 0 {
+		err := cov.WriteToFile(opts.coveragePath)
+		if err != nil {
+			fmt.Printf("Error writing coverage report to %s - %s\n", opts.coveragePath, err.Error())
+			os.Exit(1)
+		}
+	}
+	if matrix != nil && len(opts.permissionsJsonPath) > 0 {
+		err := matrix.WriteToFile(opts.permissionsJsonPath)
+		if err != nil {
+			fmt.Printf("Error writing permission matrix to %s - %s\n", opts.permissionsJsonPath, err.Error())
+			os.Exit(1)
 		}
 	}
-	mqplan.Current.LogErrors()
-	mqplan.Current.PrintSummary()
-	os.Remove(*resultPath)
-	mqplan.Current.WriteResultToFile(*resultPath)
 	if len(fuzzMode) > 0 {
-		err := mqplan.Current.WriteFailures(*meqaPath)
+		err := ctx.WriteFailures(opts.meqaPath, opts.repro)
 		if err != nil {
 			fmt.Printf("Error writing fuzz failures to file - %s\n", err.Error())
 			os.Exit(1)
 		}
-		if !*repro {
-			err := mqswag.WriteDoneData(*meqaPath)
+		if !opts.repro {
+			err := mqswag.WriteDoneData(opts.meqaPath, ctx.DoneData)
 			if err != nil {
 				fmt.Printf("Error writing to %s - %s\n", mqswag.DoneDataFile, err.Error())
 				os.Exit(1)
 			}
 		}
 	}
-	// Exit with non-zero code only for functional failures
-	if mqplan.Current.ResultCounts[mqutil.Failed] > 0 {
+	// With a policy the roles are expected to fail some tests, only the policy violations count.
+	if matrix != nil {
+		if matrix.Violations() > 0 {
+			os.Exit(5)
+		}
+	} else if plan.ResultCounts[mqutil.Failed] > 0 {
+		// Exit with non-zero code only for functional failures
 		os.Exit(3)
 	}
+	if cov.Percent < opts.minCoverage {
+		fmt.Printf("Coverage %.1f%% is below the minimum of %.1f%%\n", cov.Percent, opts.minCoverage)
+		os.Exit(4)
+	}
 }
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
 	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+	spec "github.com/getkin/kin-openapi/openapi3"
+	"gopkg.in/yaml.v2"
 )
 
 func TestInterfaceEqual(t *testing.T) {
@@ -33,6 +43,259 @@ func TestInterfaceEqual(t *testing.T) {
 	}
 }
 
+func TestGenerateMeqa(t *testing.T) {
+	logger := mqutil.NewStdLogger()
+	for _, extension := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "mqgo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		err = generateMeqa(logger, dir, "../../testdata/petstore_meqa.yml", algoPath, extension)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, algoPath+".yml")); err != nil {
+			t.Error(err)
+		}
+		swagger, err := mqswag.CreateSwaggerFromURL(filepath.Join(dir, "petstore_meqa.yml"), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags := []struct {
+			tag      *mqswag.MeqaTag
+			expected string
+		}{
+			{mqswag.GetOperationTag(swagger.Paths["/pet/{petId}"].Get, logger), "<meqa Pet..get>"},
+			{mqswag.GetParameterTag(swagger.Paths["/pet/{petId}"].Get.Parameters[0].Value, logger), "<meqa Pet.id>"},
+			{mqswag.GetParameterTag(swagger.Paths["/user/{username}"].Get.Parameters[0].Value, logger), "<meqa User.username>"},
+			{mqswag.GetOperationTag(swagger.Paths["/pet"].Post, logger), "<meqa Pet..post>"},
+			{mqswag.GetOperationTag(swagger.Paths["/pet/{petId}"].Post, logger), "<meqa Pet..put>"},
+		}
+		for _, tag := range tags {
+			if tag.tag == nil || tag.tag.ToString() != tag.expected {
+				t.Errorf("expected %s, got %v (extension %t)", tag.expected, tag.tag, extension)
+			}
+		}
+		if tag := mqswag.GetOperationTag(swagger.Paths["/pet/{petId}/uploadImage"].Post, logger); tag != nil {
+			t.Errorf("expected no tag on an action, got %s", tag.ToString())
+		}
+	}
+}
+
+func TestMeqaExtension(t *testing.T) {
+	logger := mqutil.NewStdLogger()
+	op := &spec.Operation{Description: "Find a pet <meqa Pet..get>"}
+	op.Extensions = map[string]interface{}{
+		mqswag.MeqaExtension: json.RawMessage(`{"class": "Owner", "property": "id", "operation": "put", "flags": ["weak", "fail"]}`),
+	}
+	tag := mqswag.GetOperationTag(op, logger)
+	if tag == nil || tag.ToString() != "<meqa Owner.id.put>" || tag.Flags != mqswag.FlagWeak|mqswag.FlagFail {
+		t.Errorf("expected the extension to take precedence, got %v", tag)
+	}
+	op.Extensions[mqswag.MeqaExtension] = json.RawMessage(`"Owner.name"`)
+	if tag := mqswag.GetOperationTag(op, logger); tag == nil || tag.ToString() != "<meqa Owner.name>" {
+		t.Errorf("expected the short form to be read, got %v", tag)
+	}
+	delete(op.Extensions, mqswag.MeqaExtension)
+	if tag := mqswag.GetOperationTag(op, logger); tag == nil || tag.ToString() != "<meqa Pet..get>" {
+		t.Errorf("expected the description tag without an extension, got %v", tag)
+	}
+}
+
+func TestTagSpecDocClass(t *testing.T) {
+	doc := yaml.MapSlice{}
+	err := yaml.Unmarshal([]byte(`
+openapi: 3.0.0
+info:
+  title: pets
+  version: "1"
+paths:
+  /pet-items/{id}:
+    get:
+      responses:
+        '204':
+          description: the pet item
+  /petitems/{id}:
+    get:
+      responses:
+        '204':
+          description: the pet item
+components:
+  schemas:
+    pet_item:
+      type: object
+    PetItem:
+      type: object
+    Pet_Item:
+      type: object
+    pet-item:
+      type: object
+    petItem:
+      type: object
+    PET_ITEM:
+      type: object
+`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The schema named like the path is the class, otherwise the first in order that matches, every time.
+	for i := 0; i < 20; i++ {
+		tagged, _, err := mqswag.TagSpecDoc(doc, false, mqutil.NewStdLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := yaml.Marshal(tagged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tag := range []string{"<meqa pet-item..get>", "<meqa PET_ITEM..get>"} {
+			if !strings.Contains(string(data), tag) {
+				t.Fatalf("expected %s, got %s", tag, data)
+			}
+		}
+	}
+}
+
+const swagger2Spec = `
+swagger: "2.0"
+info:
+  title: things
+  version: "1"
+paths:
+  /things:
+    get:
+      responses:
+        '200':
+          description: the things
+          schema:
+            type: array
+            items:
+              $ref: '#/definitions/Thing'
+definitions:
+  Thing:
+    type: object
+    properties:
+      name:
+        type: string
+      nickname:
+        type: string
+        x-nullable: true
+`
+
+func TestGenerateSwagger2Nullable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	specPath := filepath.Join(dir, "things.yml")
+	if err := ioutil.WriteFile(specPath, []byte(swagger2Spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logger := mqutil.NewStdLogger()
+	if err := generateMeqa(logger, dir, specPath, "", false); err != nil {
+		t.Fatal(err)
+	}
+	swagger, err := mqswag.CreateSwaggerFromURL(filepath.Join(dir, "things_meqa.yml"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thing := swagger.Components.Schemas["Thing"]
+	if !thing.Value.Properties["nickname"].Value.Nullable {
+		t.Errorf("expected x-nullable to be converted to nullable")
+	}
+	// Swagger 2.0 has no null, so a null isn't a mismatch there, declared or not.
+	things := mqswag.SchemaRef(*swagger.Paths["/things"].Get.Responses["200"].Value.Content["application/json"].Schema)
+	if !things.Matches([]interface{}{map[string]interface{}{"name": nil, "nickname": nil}}, swagger, logger) {
+		t.Errorf("expected the null fields of a Swagger 2.0 spec to match")
+	}
+	delete(swagger.Extensions, mqswag.SwaggerVersionExtension)
+	if things.Matches([]interface{}{map[string]interface{}{"name": nil}}, swagger, logger) {
+		t.Errorf("expected a null field that isn't nullable not to match in OpenAPI 3")
+	}
+}
+
+const coverageSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths:
+  /things/{id}:
+    get:
+      parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+      responses:
+        '200':
+          description: the thing
+    delete:
+      parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+      responses:
+        '204':
+          description: the thing is deleted
+`
+
+func TestMinCoverage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	specPath := filepath.Join(dir, "things.yml")
+	planPath := filepath.Join(dir, "things_plan.yml")
+	if err := ioutil.WriteFile(specPath, []byte(coverageSpec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan := "things suite:\n- name: get_thing\n  path: /things/{id}\n  method: get\n  pathParams:\n    id: 1\n"
+	if err := ioutil.WriteFile(planPath, []byte(plan), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Half the operations are called.
+	for _, c := range []struct {
+		minCoverage string
+		exitCode    int
+	}{
+		{"0", 0},
+		{"50", 0},
+		{"60", 4},
+	} {
+		cmd := exec.Command(os.Args[0], "run", "-d", dir, "-s", specPath, "-p", planPath, "-h", server.URL, "-f", "none",
+			"-min-coverage", c.minCoverage)
+		cmd.Env = append(os.Environ(), "MQGO_MAIN=1")
+		out, err := cmd.CombinedOutput()
+		exitCode := 0
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if exitCode != c.exitCode {
+			t.Errorf("expected exit code %d with -min-coverage %s, got %d: %s", c.exitCode, c.minCoverage, exitCode, out)
+		}
+		if below := strings.Contains(string(out), "Coverage 50.0% is below the minimum of "+c.minCoverage+".0%"); below != (c.exitCode == 4) {
+			t.Errorf("expected the coverage to be reported below the minimum: %t, got %s", c.exitCode == 4, out)
+		}
+	}
+}
+
 func TestMain(m *testing.M) {
+	// The tests run mqgo as the test binary with MQGO_MAIN set.
+	if os.Getenv("MQGO_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
 	os.Exit(m.Run())
 }
@@ -0,0 +1,488 @@
+package mqplan
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// The matchers that can be used in the jsonpath, body_matches and latency_ms sections of expect, as
+// in {$gte: 1}. They start with $ so they can't be mistaken for the properties of an object to match.
+var matchers = map[string]bool{
+	"$eq":       true,
+	"$ne":       true,
+	"$gt":       true,
+	"$gte":      true,
+	"$lt":       true,
+	"$lte":      true,
+	"$in":       true,
+	"$contains": true,
+	"$regex":    true,
+	"$type":     true,
+	"$exists":   true,
+}
+
+// The types the type matcher takes.
+var jsonTypes = map[string]bool{
+	gojsonschema.TYPE_NULL:    true,
+	gojsonschema.TYPE_BOOLEAN: true,
+	gojsonschema.TYPE_INTEGER: true,
+	gojsonschema.TYPE_NUMBER:  true,
+	gojsonschema.TYPE_STRING:  true,
+	gojsonschema.TYPE_ARRAY:   true,
+	gojsonschema.TYPE_OBJECT:  true,
+}
+
+var (
+	statusClass = regexp.MustCompile(`^([1-5])[xX][xX]$`)                           // e.g. 4xx
+	statusRange = regexp.MustCompile(`^([1-5][0-9][0-9])\s*-\s*([1-5][0-9][0-9])$`) // e.g. 400-499
+)
+
+// statusBounds returns the lowest and highest status code that a single status expectation allows:
+// a status code, possibly a string as in "404", a class like 4xx, or a range like 400-499.
+func statusBounds(expected interface{}) (int, int, error) {
+	if n, ok := toNumber(expected); ok && n == math.Trunc(n) {
+		return int(n), int(n), nil
+	}
+	if s, ok := expected.(string); ok {
+		s = strings.TrimSpace(s)
+		if m := statusClass.FindStringSubmatch(s); m != nil {
+			c, _ := strconv.Atoi(m[1])
+			return c * 100, c*100 + 99, nil
+		}
+		if m := statusRange.FindStringSubmatch(s); m != nil {
+			min, _ := strconv.Atoi(m[1])
+			max, _ := strconv.Atoi(m[2])
+			if min <= max {
+				return min, max, nil
+			}
+		}
+		if n, err := strconv.Atoi(s); err == nil {
+			return n, n, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid status %v, expecting %s, %s, a status code like 404, a class like 4xx, "+
+		"a range like 400-499, or a list of these", jsonString(expected), StatusSuccess, StatusFail)
+}
+
+// statusMatches reports whether the response status matches expect.status. Besides what statusBounds
+// takes, the expectation can be success, fail, or a list of any of these, as in [400, 422].
+func statusMatches(expected interface{}, status int, success bool) (bool, error) {
+	switch e := expected.(type) {
+	case []interface{}:
+		if len(e) == 0 {
+			return false, fmt.Errorf("invalid status [], expecting at least one status")
+		}
+		var matches bool
+		for _, item := range e {
+			m, err := statusMatches(item, status, success)
+			if err != nil {
+				return false, err
+			}
+			matches = matches || m
+		}
+		return matches, nil
+	case string:
+		switch strings.TrimSpace(e) {
+		case StatusSuccess:
+			return success, nil
+		case StatusFail:
+			return !success, nil
+		}
+	}
+	min, max, err := statusBounds(expected)
+	if err != nil {
+		return false, err
+	}
+	return status >= min && status <= max, nil
+}
+
+// statusString formats expect.status for the fuzz failures.
+func statusString(expected interface{}) string {
+	if l, ok := expected.([]interface{}); ok {
+		var items []string
+		for _, item := range l {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprint(expected)
+}
+
+// isMatcher reports whether m is a set of matchers like {$gte: 1}, rather than an object to match.
+// That's the case when all its keys start with $.
+func isMatcher(m map[string]interface{}) bool {
+	if len(m) == 0 {
+		return false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// checkMatcher returns an error if op isn't a matcher or v isn't a value it takes.
+func checkMatcher(op string, v interface{}) error {
+	if !matchers[op] {
+		return fmt.Errorf("unknown matcher %s", op)
+	}
+	var ok bool
+	switch op {
+	case "$gt", "$gte", "$lt", "$lte":
+		_, ok = toNumber(v)
+	case "$in":
+		_, ok = v.([]interface{})
+	case "$exists":
+		_, ok = v.(bool)
+	case "$regex":
+		_, ok = v.(string)
+	case "$type":
+		ok = jsonTypes[fmt.Sprint(v)]
+	case "$contains":
+		switch v.(type) {
+		case map[string]interface{}, []interface{}, nil:
+		default:
+			ok = true
+		}
+	default:
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("invalid value %s for %s", jsonString(v), op)
+	}
+	return nil
+}
+
+// toNumber converts the numbers json and yaml decode to into a float64.
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// jsonType returns the JSON Schema type of v.
+func jsonType(v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+	if n, ok := toNumber(v); ok {
+		if n == math.Trunc(n) {
+			return gojsonschema.TYPE_INTEGER
+		}
+		return gojsonschema.TYPE_NUMBER
+	}
+	switch v.(type) {
+	case bool:
+		return gojsonschema.TYPE_BOOLEAN
+	case string:
+		return gojsonschema.TYPE_STRING
+	case []interface{}:
+		return gojsonschema.TYPE_ARRAY
+	case map[string]interface{}:
+		return gojsonschema.TYPE_OBJECT
+	}
+	return reflect.TypeOf(v).String()
+}
+
+// valuesEqual compares an expected value to the actual one. Numbers are compared by value, objects
+// and arrays the way expect.body is.
+func valuesEqual(expected, actual interface{}) bool {
+	e, eok := toNumber(expected)
+	a, aok := toNumber(actual)
+	if eok || aok {
+		return eok && aok && e == a
+	}
+	if es, ok := expected.(string); ok {
+		as, ok := actual.(string)
+		return ok && (es == as || mqutil.TimeCompare(es, as))
+	}
+	return mqutil.InterfaceEquals(expected, actual)
+}
+
+func jsonString(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// matchFormat checks actual against an @ matcher like @uuid. The name is either a JSON Schema type,
+// notnull, or a string format known to the JSON Schema validator, e.g. uuid, date-time or email.
+func matchFormat(name string, actual interface{}) error {
+	switch name {
+	case "any":
+		return nil
+	case "notnull":
+		if actual == nil {
+			return fmt.Errorf("expecting a value, got null")
+		}
+		return nil
+	case gojsonschema.TYPE_NUMBER:
+		if _, ok := toNumber(actual); !ok {
+			return fmt.Errorf("expecting a number, got %s", jsonString(actual))
+		}
+		return nil
+	case gojsonschema.TYPE_INTEGER, "null", gojsonschema.TYPE_BOOLEAN, gojsonschema.TYPE_STRING,
+		gojsonschema.TYPE_ARRAY, gojsonschema.TYPE_OBJECT:
+		if jsonType(actual) != name {
+			return fmt.Errorf("expecting a value of type %s, got %s", name, jsonString(actual))
+		}
+		return nil
+	case "datetime":
+		name = "date-time"
+	}
+	if !gojsonschema.FormatCheckers.Has(name) {
+		return fmt.Errorf("unknown matcher @%s", name)
+	}
+	if _, ok := actual.(string); !ok || !gojsonschema.FormatCheckers.IsFormat(name, actual) {
+		return fmt.Errorf("expecting a %s, got %s", name, jsonString(actual))
+	}
+	return nil
+}
+
+// match checks the value found at path against the expected one, and returns a message for each
+// failed assertion. The expected value is a set of matchers, an @ matcher, or a value to compare to.
+func match(path string, expected, actual interface{}, found bool) []string {
+	if m, ok := expected.(map[string]interface{}); ok && isMatcher(m) {
+		return matchAll(path, m, actual, found)
+	}
+	if !found {
+		return []string{fmt.Sprintf("%s: not found, expecting %s", path, jsonString(expected))}
+	}
+	if e, ok := expected.(string); ok && strings.HasPrefix(e, "@") {
+		if err := matchFormat(e[1:], actual); err != nil {
+			return []string{fmt.Sprintf("%s: %s", path, err.Error())}
+		}
+		return nil
+	}
+	if !valuesEqual(expected, actual) {
+		return []string{fmt.Sprintf("%s: expecting %s, got %s", path, jsonString(expected), jsonString(actual))}
+	}
+	return nil
+}
+
+// matchAll checks the value found at path against each of the matchers.
+func matchAll(path string, matchMap map[string]interface{}, actual interface{}, found bool) []string {
+	if exists, ok := matchMap["$exists"]; ok {
+		if b, _ := exists.(bool); b != found {
+			if found {
+				return []string{fmt.Sprintf("%s: expecting nothing, got %s", path, jsonString(actual))}
+			}
+			return []string{fmt.Sprintf("%s: not found", path)}
+		}
+	}
+	if !found {
+		if len(matchMap) == 1 && matchMap["$exists"] != nil {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: not found, expecting %s", path, jsonString(matchMap))}
+	}
+	var ops []string
+	for op := range matchMap {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	var msgs []string
+	for _, op := range ops {
+		v := matchMap[op]
+		if err := checkMatcher(op, v); err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", path, err.Error()))
+			continue
+		}
+		fail := func() {
+			msgs = append(msgs, fmt.Sprintf("%s: expecting %s %s, got %s", path, op, jsonString(v), jsonString(actual)))
+		}
+		switch op {
+		case "$eq":
+			msgs = append(msgs, match(path, v, actual, true)...)
+		case "$ne":
+			if valuesEqual(v, actual) {
+				fail()
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			e, _ := toNumber(v)
+			a, aok := toNumber(actual)
+			if !aok ||
+				(op == "$gt" && !(a > e)) || (op == "$gte" && !(a >= e)) ||
+				(op == "$lt" && !(a < e)) || (op == "$lte" && !(a <= e)) {
+				fail()
+			}
+		case "$in":
+			var in bool
+			for _, e := range v.([]interface{}) {
+				in = in || valuesEqual(e, actual)
+			}
+			if !in {
+				fail()
+			}
+		case "$contains":
+			var contains bool
+			switch a := actual.(type) {
+			case string:
+				contains = strings.Contains(a, fmt.Sprint(v))
+			case []interface{}:
+				for _, item := range a {
+					contains = contains || valuesEqual(v, item)
+				}
+			case map[string]interface{}:
+				_, contains = a[fmt.Sprint(v)]
+			}
+			if !contains {
+				fail()
+			}
+		case "$regex":
+			re, err := regexp.Compile(fmt.Sprint(v))
+			if err != nil {
+				msgs = append(msgs, fmt.Sprintf("%s: invalid regex %s: %s", path, jsonString(v), err.Error()))
+			} else if s, ok := actual.(string); !ok || !re.MatchString(s) {
+				fail()
+			}
+		case "$type":
+			typ := jsonType(actual)
+			if !(typ == v || (v == gojsonschema.TYPE_NUMBER && typ == gojsonschema.TYPE_INTEGER)) {
+				fail()
+			}
+		}
+	}
+	return msgs
+}
+
+// matchBody checks the value at path against the pattern of body_matches. Objects in the pattern
+// match the listed properties and arrays match item by item; anything else is matched by match.
+func matchBody(path string, pattern, actual interface{}, found bool) []string {
+	switch p := pattern.(type) {
+	case map[string]interface{}:
+		if isMatcher(p) {
+			break
+		}
+		if !found {
+			return []string{fmt.Sprintf("%s: not found, expecting an object", path)}
+		}
+		obj, ok := actual.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expecting an object, got %s", path, jsonString(actual))}
+		}
+		var keys []string
+		for k := range p {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var msgs []string
+		for _, k := range keys {
+			v, ok := obj[k]
+			msgs = append(msgs, matchBody(mqutil.JsonPathChild(path, k), p[k], v, ok)...)
+		}
+		return msgs
+	case []interface{}:
+		if !found {
+			return []string{fmt.Sprintf("%s: not found, expecting an array", path)}
+		}
+		ar, ok := actual.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expecting an array, got %s", path, jsonString(actual))}
+		}
+		if len(ar) < len(p) {
+			return []string{fmt.Sprintf("%s: expecting at least %d items, got %d", path, len(p), len(ar))}
+		}
+		var msgs []string
+		for i := range p {
+			msgs = append(msgs, matchBody(fmt.Sprintf("%s[%d]", path, i), p[i], ar[i], true)...)
+		}
+		return msgs
+	}
+	return match(path, pattern, actual, found)
+}
+
+// latencyMs returns how long the call took in milliseconds.
+func (t *Test) latencyMs() float64 {
+	return float64(t.stopTime.Sub(t.startTime)) / float64(time.Millisecond)
+}
+
+// checkAssertions checks the response body and the latency against the jsonpath, body_matches and
+// latency_ms sections of the test's expect. It returns a message for each failed assertion, starting
+// with the path it failed on.
+func (t *Test) checkAssertions(resultObj interface{}) []string {
+	var msgs []string
+	if paths, ok := t.Expect[ExpectJsonPath].(map[string]interface{}); ok {
+		var keys []string
+		for k := range paths {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, path := range keys {
+			values, definite, err := mqutil.JsonPath(resultObj, path)
+			if err != nil {
+				msgs = append(msgs, err.Error())
+			} else if len(values) == 0 {
+				msgs = append(msgs, match(path, paths[path], nil, false)...)
+			} else if definite {
+				msgs = append(msgs, match(path, paths[path], values[0].Value, true)...)
+			} else {
+				// Every value a wildcard finds must match.
+				for _, v := range values {
+					msgs = append(msgs, match(v.Path, paths[path], v.Value, true)...)
+				}
+			}
+		}
+	}
+	if pattern, ok := t.Expect[ExpectBodyMatches]; ok && pattern != nil {
+		msgs = append(msgs, matchBody("$", pattern, resultObj, resultObj != nil)...)
+	}
+	if expected, ok := t.Expect[ExpectLatency]; ok && expected != nil {
+		if _, isNumber := toNumber(expected); isNumber {
+			// A number is the most the call may take.
+			expected = map[string]interface{}{"$lte": expected}
+		}
+		msgs = append(msgs, match(ExpectLatency, expected, math.Round(t.latencyMs()*1000)/1000, true)...)
+	}
+	return msgs
+}
+
+// actualJsonPaths returns the values found at the paths of the jsonpath section of expect, for the
+// result file. Paths with wildcards get the list of values found.
+func actualJsonPaths(resultObj interface{}, expected interface{}) map[string]interface{} {
+	paths, _ := expected.(map[string]interface{})
+	actual := make(map[string]interface{})
+	for path := range paths {
+		values, definite, err := mqutil.JsonPath(resultObj, path)
+		if err != nil || len(values) == 0 {
+			actual[path] = nil
+		} else if definite {
+			actual[path] = values[0].Value
+		} else {
+			var l []interface{}
+			for _, v := range values {
+				l = append(l, v.Value)
+			}
+			actual[path] = l
+		}
+	}
+	return actual
+}
@@ -0,0 +1,290 @@
+package mqplan
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+)
+
+func TestIsMatcher(t *testing.T) {
+	for _, c := range []struct {
+		m        map[string]interface{}
+		expected bool
+	}{
+		{map[string]interface{}{"$gte": 1}, true},
+		{map[string]interface{}{"$type": "string", "$ne": ""}, true},
+		{map[string]interface{}{"$dog": 1}, true},
+		{map[string]interface{}{}, false},
+		{map[string]interface{}{"name": "a"}, false},
+		{map[string]interface{}{"type": "string"}, false},
+		{map[string]interface{}{"gte": 1}, false},
+		{map[string]interface{}{"$gte": 1, "name": "a"}, false},
+	} {
+		if got := isMatcher(c.m); got != c.expected {
+			t.Errorf("isMatcher(%v): expected %t, got %t", c.m, c.expected, got)
+		}
+	}
+}
+
+func TestCheckMatcher(t *testing.T) {
+	for _, c := range []struct {
+		op    string
+		v     interface{}
+		valid bool
+	}{
+		{"$gte", 1, true},
+		{"$in", []interface{}{"a", "b"}, true},
+		{"$exists", false, true},
+		{"$contains", "a", true},
+		{"$regex", "^a", true},
+		{"$type", "string", true},
+		{"$dog", 1, false},
+		{"$type", "dog", false},
+		{"$gt", "a", false},
+		{"$in", "a", false},
+		{"$exists", "yes", false},
+		{"$contains", map[string]interface{}{"a": 1}, false},
+	} {
+		if err := checkMatcher(c.op, c.v); (err == nil) != c.valid {
+			t.Errorf("checkMatcher(%s, %v): expected valid %t, got %v", c.op, c.v, c.valid, err)
+		}
+	}
+}
+
+func TestMatchBody(t *testing.T) {
+	dog := map[string]interface{}{"type": "dog", "age": 3.0}
+	for _, c := range []struct {
+		pattern  interface{}
+		expected []string
+	}{
+		{map[string]interface{}{"type": "dog"}, nil},
+		{map[string]interface{}{"type": "@string"}, nil},
+		{map[string]interface{}{"type": "cat"}, []string{`$.type: expecting "cat", got "dog"`}},
+		{map[string]interface{}{"type": "@integer"}, []string{`$.type: expecting a value of type integer, got "dog"`}},
+		// Without the $ type is a property, even with a JSON Schema type as its value.
+		{map[string]interface{}{"type": "object"}, []string{`$.type: expecting "object", got "dog"`}},
+		{map[string]interface{}{"$type": "object"}, nil},
+		{map[string]interface{}{"$type": "array"}, []string{`$: expecting $type "array", got {"age":3,"type":"dog"}`}},
+		{map[string]interface{}{"age": map[string]interface{}{"$gt": 1, "$lt": 5}}, nil},
+		{map[string]interface{}{"name": map[string]interface{}{"$exists": false}}, nil},
+		{map[string]interface{}{"name": "@string"}, []string{`$.name: not found, expecting "@string"`}},
+	} {
+		if got := matchBody("$", c.pattern, dog, true); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("matchBody(%v): expected %q, got %q", c.pattern, c.expected, got)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		expected interface{}
+		actual   interface{}
+		found    bool
+		msgs     []string
+	}{
+		{"a", "a", true, nil},
+		{1.0, json.Number("1"), true, nil},
+		{"2019-01-01T00:00:00Z", "2019-01-01T00:00:00.000Z", true, nil},
+		{"a", "b", true, []string{`$.a: expecting "a", got "b"`}},
+		{"a", nil, false, []string{`$.a: not found, expecting "a"`}},
+		{"@uuid", "123e4567-e89b-12d3-a456-426614174000", true, nil},
+		{"@uuid", "123", true, []string{`$.a: expecting a uuid, got "123"`}},
+		{"@datetime", "2019-01-01T00:00:00Z", true, nil},
+		{"@number", 1.5, true, nil},
+		{"@notnull", nil, true, []string{"$.a: expecting a value, got null"}},
+		{"@dog", "a", true, []string{"$.a: unknown matcher @dog"}},
+		{map[string]interface{}{"$eq": "a", "$ne": "b", "$in": []interface{}{"a", "c"}}, "a", true, nil},
+		{map[string]interface{}{"$gt": 1, "$lte": 2}, 2.0, true, nil},
+		{map[string]interface{}{"$gt": 1}, 1.0, true, []string{"$.a: expecting $gt 1, got 1"}},
+		{map[string]interface{}{"$lt": 1}, "a", true, []string{`$.a: expecting $lt 1, got "a"`}},
+		{map[string]interface{}{"$contains": "b"}, "abc", true, nil},
+		{map[string]interface{}{"$contains": "b"}, []interface{}{"a", "b"}, true, nil},
+		{map[string]interface{}{"$contains": "b"}, map[string]interface{}{"a": 1.0}, true, []string{`$.a: expecting $contains "b", got {"a":1}`}},
+		{map[string]interface{}{"$regex": "^a"}, "abc", true, nil},
+		{map[string]interface{}{"$regex": "("}, "abc", true, []string{"$.a: invalid regex \"(\": error parsing regexp: missing closing ): `(`"}},
+		{map[string]interface{}{"$type": "number"}, 1.0, true, nil},
+		{map[string]interface{}{"$type": "integer"}, 1.5, true, []string{`$.a: expecting $type "integer", got 1.5`}},
+		{map[string]interface{}{"$exists": false}, nil, false, nil},
+		{map[string]interface{}{"$exists": false}, "a", true, []string{`$.a: expecting nothing, got "a"`}},
+		{map[string]interface{}{"$exists": true}, nil, false, []string{"$.a: not found"}},
+		{map[string]interface{}{"$gte": 1}, nil, false, []string{`$.a: not found, expecting {"$gte":1}`}},
+		{map[string]interface{}{"$gte": "a", "$dog": 1}, 1.0, true, []string{"$.a: unknown matcher $dog", `$.a: invalid value "a" for $gte`}},
+	} {
+		if got := match("$.a", c.expected, c.actual, c.found); !reflect.DeepEqual(got, c.msgs) {
+			t.Errorf("match(%v, %v): expected %q, got %q", c.expected, c.actual, c.msgs, got)
+		}
+	}
+}
+
+func TestCheckAssertions(t *testing.T) {
+	pet := map[string]interface{}{
+		"id":        json.Number("7"),
+		"name":      "doggie",
+		"photoUrls": []interface{}{"a", 1.0},
+	}
+	test := &Test{
+		Expect: map[string]interface{}{
+			ExpectJsonPath: map[string]interface{}{
+				"$.id":                 7.0,
+				"$.photoUrls.length()": map[string]interface{}{"$gte": 1},
+				"$.photoUrls[*]":       map[string]interface{}{"$type": "string"},
+				"$.owner":              map[string]interface{}{"$exists": true},
+			},
+			ExpectBodyMatches: map[string]interface{}{"id": "@integer", "name": "@uuid"},
+			ExpectLatency:     100,
+		},
+		startTime: time.Unix(0, 0),
+		stopTime:  time.Unix(0, int64(150*time.Millisecond)),
+	}
+	// Sorted by path, every wildcard match on its own.
+	expected := []string{
+		"$.owner: not found",
+		`$.photoUrls[1]: expecting $type "string", got 1`,
+		`$.name: expecting a uuid, got "doggie"`,
+		"latency_ms: expecting $lte 100, got 150",
+	}
+	if got := test.checkAssertions(pet); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if actual := actualJsonPaths(pet, test.Expect[ExpectJsonPath]); !reflect.DeepEqual(actual, map[string]interface{}{
+		"$.id": json.Number("7"), "$.photoUrls.length()": 2, "$.photoUrls[*]": []interface{}{"a", 1.0}, "$.owner": nil,
+	}) {
+		t.Errorf("expected the values found at the paths, got %v", actual)
+	}
+}
+
+func TestStatusBounds(t *testing.T) {
+	for _, c := range []struct {
+		expected interface{}
+		min, max int
+		valid    bool
+	}{
+		{404, 404, 404, true},
+		{404.0, 404, 404, true},
+		{"404", 404, 404, true},
+		{" 4xx ", 400, 499, true},
+		{"2XX", 200, 299, true},
+		{"400-422", 400, 422, true},
+		{"400 - 499", 400, 499, true},
+		{404.5, 0, 0, false},
+		{"6xx", 0, 0, false},
+		{"499-400", 0, 0, false},
+		{"4xx-5xx", 0, 0, false},
+		{"success", 0, 0, false},
+		{nil, 0, 0, false},
+	} {
+		min, max, err := statusBounds(c.expected)
+		if (err == nil) != c.valid || min != c.min || max != c.max {
+			t.Errorf("%#v: expected %d-%d (valid %t), got %d-%d, %v", c.expected, c.min, c.max, c.valid, min, max, err)
+		}
+	}
+}
+
+func TestStatusMatches(t *testing.T) {
+	for _, c := range []struct {
+		expected interface{}
+		status   int
+		success  bool
+		matches  bool
+		valid    bool
+	}{
+		{"success", 201, true, true, true},
+		{"success", 404, false, false, true},
+		{"fail", 404, false, true, true},
+		{"4xx", 404, false, true, true},
+		{"4xx", 500, false, false, true},
+		{[]interface{}{400, "422"}, 422, false, true, true},
+		{[]interface{}{400, "5xx"}, 404, false, false, true},
+		{[]interface{}{"success", 404}, 404, false, true, true},
+		{[]interface{}{}, 404, false, false, false},
+		{[]interface{}{400, "bad"}, 400, false, false, false},
+		{"bad", 400, false, false, false},
+	} {
+		matches, err := statusMatches(c.expected, c.status, c.success)
+		if (err == nil) != c.valid || matches != c.matches {
+			t.Errorf("%#v with %d: expected %t (valid %t), got %t, %v", c.expected, c.status, c.matches, c.valid, matches, err)
+		}
+	}
+}
+
+// An expected status the plan gets wrong fails the test instead of the run.
+func TestRunInvalidStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, thingsSpec, "things suite:\n- name: get_thing\n  path: /things/{id}\n  method: get\n"+
+		"  pathParams:\n    id: 1\n  expect:\n    status: 4oo\n- name: get_other\n  path: /things/{id}\n  method: get\n"+
+		"  pathParams:\n    id: 2\n  expect:\n    status: 4xx\n", server.URL)
+	plan.RunAll([]string{"things suite"}, 1)
+
+	if len(plan.resultList) != 2 || plan.ResultCounts[mqutil.Passed] != 1 {
+		t.Fatalf("expected one test to pass, got %v", plan.ResultCounts)
+	}
+	if err := plan.resultList[0].err; err == nil || !strings.Contains(err.Error(), "invalid status") {
+		t.Errorf("expected an invalid status to be reported, got %v", err)
+	}
+}
+
+// The fuzzed requests expect fuzzStatus, 400 by default.
+func TestRunFuzzStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The first post is the test itself, the server rejects the fuzzed ones that follow.
+	var mutex sync.Mutex
+	var posts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		posts++
+		first := posts == 1
+		mutex.Unlock()
+		if !first {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	for _, c := range []struct {
+		init     string
+		failures bool
+	}{
+		{"", true},
+		{"meqa_init:\n- fuzzStatus: [400, 422]\n", false},
+		{"meqa_init:\n- fuzzStatus: 4xx\n", false},
+		{"things suite:\n- name: meqa_init\n  fuzzStatus: 422\n", false},
+	} {
+		posts = 0
+		suite := "things suite:\n"
+		if strings.HasPrefix(c.init, suite) {
+			suite = ""
+		}
+		plan := loadPlan(t, dir, rawBodySpec, c.init+suite+"- name: post_thing\n  path: /things\n  method: post\n", server.URL)
+		plan.FuzzType = mqutil.FuzzDataType
+		plan.RunAll([]string{"things suite"}, 1)
+
+		if posts < 2 {
+			t.Fatalf("expected the body to be fuzzed, got %d posts", posts)
+		}
+		if failures := len(plan.resultList[0].fuzzFailures) > 0; failures != c.failures {
+			t.Errorf("expected fuzz failures with %q: %v, got %d", c.init, c.failures, len(plan.resultList[0].fuzzFailures))
+		}
+	}
+}
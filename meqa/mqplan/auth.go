@@ -0,0 +1,273 @@
+package mqplan
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gopkg.in/resty.v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+// The types and locations of the security schemes.
+const (
+	SecurityApiKey        = "apiKey"
+	SecurityHttp          = "http"
+	SecurityOAuth2        = "oauth2"
+	SecurityOpenIdConnect = "openIdConnect"
+
+	SecurityInHeader = "header"
+	SecurityInQuery  = "query"
+	SecurityInCookie = "cookie"
+)
+
+// Credential is what is sent for one security scheme of the spec. An apiKey scheme sends Value,
+// an http bearer, oauth2 or openIdConnect scheme sends Token and an http basic scheme sends
+// Username and Password.
+//
+// Without a Token, oauth2 and openIdConnect schemes get one from the token endpoint, with the
+// password grant if there is a Username and the client credentials grant otherwise. The endpoint is
+// TokenURL, or the tokenUrl of the scheme's flow for the grant.
+type Credential struct {
+	Value    string `yaml:"value,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	ClientId     string   `yaml:"clientId,omitempty"`
+	ClientSecret string   `yaml:"clientSecret,omitempty"`
+	TokenURL     string   `yaml:"tokenUrl,omitempty"`
+	Scopes       []string `yaml:"scopes,omitempty"` // the scopes to ask for, the ones of the requirement if empty
+	Grant        string   `yaml:"grant,omitempty"`  // GrantClientCredentials or GrantPassword, see grant()
+}
+
+// Credentials maps the names of the security schemes in the spec to their credentials.
+type Credentials map[string]*Credential
+
+// ReadCredentials reads a credentials file.
+func ReadCredentials(path string) (Credentials, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var credentials Credentials
+	err = yaml.Unmarshal(data, &credentials)
+	if err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// Identity is someone the requests can be made as, selected with as: on suites and tests. The
+// requests made as an identity only send its credentials, never the plan's.
+type Identity struct {
+	Username    string      `yaml:"username,omitempty"`
+	Password    string      `yaml:"password,omitempty"`
+	ApiToken    string      `yaml:"apiToken,omitempty"`
+	Credentials Credentials `yaml:"credentials,omitempty"` // the credentials of the security schemes in the spec
+}
+
+// Identities maps the names of the identities to them.
+type Identities map[string]*Identity
+
+// ReadIdentities reads an identities file.
+func ReadIdentities(path string) (Identities, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var identities Identities
+	err = yaml.Unmarshal(data, &identities)
+	if err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// identity returns the identity the test runs as. Without as: it's the plan's credentials with the
+// suite's username, password and api token.
+func (t *Test) identity() (*Identity, error) {
+	tc := t.suite
+	if len(t.As) == 0 {
+		return &Identity{tc.Username, tc.Password, tc.ApiToken, tc.plan.Credentials}, nil
+	}
+	if id := tc.plan.Identities[t.As]; id != nil {
+		return id, nil
+	}
+	return nil, mqutil.NewError(mqutil.ErrNotFound, fmt.Sprintf("identity %s of test %s not found", t.As, t.Name))
+}
+
+// securityRequirements returns the security requirements of the test's operation, or the ones
+// of the spec if the operation doesn't have its own. It returns nil if neither declares any, and
+// an empty list if the operation is public.
+func (t *Test) securityRequirements() *spec.SecurityRequirements {
+	if t.op != nil && t.op.Security != nil {
+		return t.op.Security
+	}
+	if t.ctx.Swagger != nil && t.ctx.Swagger.Security != nil {
+		return &t.ctx.Swagger.Security
+	}
+	return nil
+}
+
+// securityScheme returns the named security scheme of the spec, nil if there is none.
+func (t *Test) securityScheme(name string) *spec.SecurityScheme {
+	if t.ctx.Swagger == nil || t.ctx.Swagger.Components.SecuritySchemes == nil {
+		return nil
+	}
+	if ref := t.ctx.Swagger.Components.SecuritySchemes[name]; ref != nil {
+		return ref.Value
+	}
+	return nil
+}
+
+// canApply returns whether the credential has what the scheme needs.
+func (c *Credential) canApply(scheme *spec.SecurityScheme) bool {
+	if c == nil || scheme == nil {
+		return false
+	}
+	switch scheme.Type {
+	case SecurityApiKey:
+		return len(c.Value) > 0
+	case SecurityHttp:
+		if strings.EqualFold(scheme.Scheme, "basic") {
+			return len(c.Username) > 0
+		}
+		return len(c.Token) > 0
+	case SecurityOAuth2, SecurityOpenIdConnect:
+		return len(c.Token) > 0 || c.acquiresToken(scheme)
+	}
+	return false
+}
+
+// apply sets the credential on the request the way the scheme says. Schemes that send a token send
+// token.
+func (c *Credential) apply(req *resty.Request, scheme *spec.SecurityScheme, token string) {
+	switch scheme.Type {
+	case SecurityApiKey:
+		switch scheme.In {
+		case SecurityInQuery:
+			req.SetQueryParam(scheme.Name, c.Value)
+		case SecurityInCookie:
+			req.Header.Add("Cookie", scheme.Name+"="+c.Value)
+		default:
+			req.SetHeader(scheme.Name, c.Value)
+		}
+	case SecurityHttp:
+		switch {
+		case strings.EqualFold(scheme.Scheme, "basic"):
+			req.SetBasicAuth(c.Username, c.Password)
+		case strings.EqualFold(scheme.Scheme, "bearer"):
+			req.SetAuthToken(token)
+		default:
+			req.SetHeader("Authorization", scheme.Scheme+" "+token)
+		}
+	default:
+		req.SetAuthToken(token)
+	}
+}
+
+// setAuth sets the credentials of the test's identity on the request. When the operation has
+// security requirements and the identity has credentials, the first requirement all of whose
+// schemes have a credential is applied. Public operations get no credentials. Otherwise the
+// identity's username and password or api token are sent.
+func (t *Test) setAuth(req *resty.Request) error {
+	t.authRequirement, t.authTokens = nil, nil
+	id, err := t.identity()
+	if err != nil {
+		return err
+	}
+	requirements := t.securityRequirements()
+	if requirements != nil && len(*requirements) == 0 {
+		fmt.Fprintf(t.out(), "... public operation, sending no credentials\n")
+		return nil
+	}
+	if requirements != nil && id.Credentials != nil {
+		for _, requirement := range *requirements {
+			if t.applyRequirement(req, requirement, id.Credentials) {
+				return nil
+			}
+		}
+		t.ctx.Logger.Printf("no credentials for the security requirements of %s %s", t.Method, t.Path)
+	}
+	if len(id.ApiToken) > 0 {
+		req.SetAuthToken(id.ApiToken)
+	} else if len(id.Username) > 0 {
+		req.SetBasicAuth(id.Username, id.Password)
+	}
+	return nil
+}
+
+// applyRequirement applies the credentials of all the schemes of the requirement. It returns false
+// without touching the request if any of them is missing or can't get a token.
+func (t *Test) applyRequirement(req *resty.Request, requirement spec.SecurityRequirement, credentials Credentials) bool {
+	var names []string
+	tokens := make(map[string]string)
+	for name := range requirement {
+		c, scheme := credentials[name], t.securityScheme(name)
+		if !c.canApply(scheme) {
+			return false
+		}
+		tokens[name] = c.Token
+		if c.acquiresToken(scheme) {
+			token, err := t.suite.plan.oauthToken(scheme, c, requirement[name], "")
+			if err != nil {
+				fmt.Fprintf(t.out(), "... %s\n", err.Error())
+				t.ctx.Logger.Print(err)
+				return false
+			}
+			tokens[name] = token
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		credentials[name].apply(req, t.securityScheme(name), tokens[name])
+	}
+	t.authRequirement, t.authTokens = requirement, tokens
+	if len(names) > 0 {
+		t.ctx.Logger.Printf("using the credentials of %s for %s %s", strings.Join(names, ", "), t.Method, t.Path)
+	}
+	return true
+}
+
+// expectsUnauthorized reports whether expect.status allows a 401, which then isn't retried with a new
+// token.
+func (t *Test) expectsUnauthorized() bool {
+	if t.Expect == nil || t.Expect[ExpectStatus] == nil {
+		return false
+	}
+	matches, err := statusMatches(t.Expect[ExpectStatus], http.StatusUnauthorized, false)
+	return err == nil && matches
+}
+
+// refreshAuth gets new tokens for the schemes of the applied requirement that got theirs from a
+// token endpoint, and sets them on the request. It returns whether there were any.
+func (t *Test) refreshAuth(req *resty.Request) bool {
+	id, err := t.identity()
+	if err != nil {
+		return false
+	}
+	refreshed := false
+	for name, scopes := range t.authRequirement {
+		c, scheme := id.Credentials[name], t.securityScheme(name)
+		if !c.canApply(scheme) || !c.acquiresToken(scheme) {
+			continue
+		}
+		token, err := t.suite.plan.oauthToken(scheme, c, scopes, t.authTokens[name])
+		if err != nil {
+			fmt.Fprintf(t.out(), "... %s\n", err.Error())
+			t.ctx.Logger.Print(err)
+			return false
+		}
+		c.apply(req, scheme, token)
+		t.authTokens[name] = token
+		refreshed = true
+	}
+	return refreshed
+}
@@ -0,0 +1,411 @@
+package mqplan
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+const securitySpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+security:
+- bearerAuth: []
+paths:
+  /status:
+    get:
+      security: []
+      responses:
+        '200':
+          description: the service is up
+  /things:
+    post:
+      responses:
+        '201':
+          description: created
+  /things/{id}:
+    parameters:
+    - name: id
+      in: path
+      required: true
+      schema:
+        type: integer
+    get:
+      security:
+      - headerKey: []
+      - basicAuth: []
+      responses:
+        '200':
+          description: the thing
+    delete:
+      security:
+      - queryKey: []
+        cookieKey: []
+      responses:
+        '204':
+          description: deleted
+components:
+  securitySchemes:
+    bearerAuth:
+      type: http
+      scheme: bearer
+    basicAuth:
+      type: http
+      scheme: basic
+    headerKey:
+      type: apiKey
+      in: header
+      name: X-Api-Key
+    queryKey:
+      type: apiKey
+      in: query
+      name: key
+    cookieKey:
+      type: apiKey
+      in: cookie
+      name: session
+    tokenAuth:
+      type: oauth2
+      flows:
+        clientCredentials:
+          tokenUrl: http://example.com/token
+          scopes: {}
+`
+
+const securityPlan = `
+things suite:
+- name: get_status
+  path: /status
+  method: get
+- name: post_thing
+  path: /things
+  method: post
+- name: get_thing
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 1
+- name: delete_thing
+  path: /things/{id}
+  method: delete
+  pathParams:
+    id: 1
+`
+
+func TestCanApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	schemes := loadPlan(t, dir, securitySpec, "", "").ctx.Swagger.Components.SecuritySchemes
+	for _, c := range []struct {
+		scheme     string
+		credential *Credential
+		expected   bool
+	}{
+		{"headerKey", &Credential{Value: "k"}, true},
+		{"headerKey", &Credential{Token: "t"}, false},
+		{"bearerAuth", &Credential{Token: "t"}, true},
+		{"bearerAuth", &Credential{Username: "alice"}, false},
+		{"basicAuth", &Credential{Username: "alice"}, true},
+		{"basicAuth", &Credential{Token: "t"}, false},
+		{"tokenAuth", &Credential{Token: "t"}, true},
+		{"tokenAuth", &Credential{Value: "k"}, false},
+		{"bearerAuth", nil, false},
+		{"unknown", &Credential{Token: "t"}, false},
+	} {
+		var scheme *spec.SecurityScheme
+		if ref := schemes[c.scheme]; ref != nil {
+			scheme = ref.Value
+		}
+		if got := c.credential.canApply(scheme); got != c.expected {
+			t.Errorf("expected %+v to apply to %s %v, got %v", c.credential, c.scheme, c.expected, got)
+		}
+	}
+}
+
+func TestRunSecuritySchemes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var sent map[string]string
+	limited := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete && !limited {
+			limited = true
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		var creds []string
+		if auth := r.Header.Get("Authorization"); len(auth) > 0 {
+			creds = append(creds, auth)
+		}
+		if key := r.Header.Get("X-Api-Key"); len(key) > 0 {
+			creds = append(creds, "X-Api-Key "+key)
+		}
+		if key := r.URL.Query().Get("key"); len(key) > 0 {
+			creds = append(creds, "key="+key)
+		}
+		if c, err := r.Cookie("session"); err == nil {
+			creds = append(creds, "session="+c.Value)
+		}
+		sent[r.Method+" "+r.URL.Path] = strings.Join(creds, " ")
+		switch r.Method {
+		case http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:pw"))
+	for _, c := range []struct {
+		credentials Credentials
+		limited     bool
+		expected    map[string]string
+	}{
+		{Credentials{
+			"bearerAuth": {Token: "tok"},
+			"basicAuth":  {Username: "alice", Password: "pw"},
+			"queryKey":   {Value: "q"},
+			"cookieKey":  {Value: "c"},
+		}, false, map[string]string{
+			"GET /status":      "",
+			"POST /things":     "Bearer tok",
+			"GET /things/1":    basic,
+			"DELETE /things/1": "key=q session=c",
+		}},
+		// Without credentials for the requirement the api token is sent.
+		{Credentials{"headerKey": {Value: "h"}}, false, map[string]string{
+			"GET /status":      "",
+			"POST /things":     "Bearer fallback",
+			"GET /things/1":    "X-Api-Key h",
+			"DELETE /things/1": "Bearer fallback",
+		}},
+		// A request retried after a 429 still sends the cookie of its credentials.
+		{Credentials{"queryKey": {Value: "q"}, "cookieKey": {Value: "c"}}, true, map[string]string{
+			"GET /status":      "",
+			"POST /things":     "Bearer fallback",
+			"GET /things/1":    "Bearer fallback",
+			"DELETE /things/1": "key=q session=c",
+		}},
+	} {
+		sent = make(map[string]string)
+		limited = !c.limited
+		plan := loadPlan(t, dir, securitySpec, securityPlan, server.URL)
+		plan.Credentials = c.credentials
+		plan.SuiteMap["things suite"].ApiToken = "fallback"
+		plan.RunAll([]string{"things suite"}, 1)
+		if plan.ResultCounts[mqutil.Passed] != 4 || !limited {
+			t.Fatalf("expected 4 passed tests, got %v", plan.ResultCounts)
+		}
+		if !reflect.DeepEqual(sent, c.expected) {
+			t.Errorf("expected the credentials %v, got %v", c.expected, sent)
+		}
+	}
+}
+
+const identityPlan = `
+meqa_init:
+- name: meqa_init
+  identities:
+    admin:
+      credentials:
+        bearerAuth:
+          token: admin
+---
+things suite:
+- name: meqa_init
+  as: admin
+- name: post_thing
+  path: /things
+  method: post
+- name: get_thing
+  path: /things/{id}
+  method: get
+  as: user
+  pathParams:
+    id: 1
+---
+default suite:
+- name: get_default
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 1
+---
+ref suite:
+- name: things
+  ref: things suite
+  as: other
+---
+init ref suite:
+- name: meqa_init
+  as: other
+- name: things
+  ref: things suite
+`
+
+func TestRunIdentities(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var sent []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get("X-Api-Key"); len(key) > 0 {
+			sent = append(sent, r.Method+" X-Api-Key "+key)
+		} else {
+			sent = append(sent, r.Method+" "+r.Header.Get("Authorization"))
+		}
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+	for _, c := range []struct {
+		suite      string
+		sent       []string
+		identities []string
+	}{
+		{"things suite", []string{"POST Bearer admin", "GET X-Api-Key user"}, []string{"admin", "user"}},
+		// The identity of a ref test doesn't replace the ones the referenced tests set.
+		{"ref suite", []string{"POST Bearer other", "GET X-Api-Key user"}, []string{"other", "user"}},
+		// Without as: the ref test runs as the identity of its suite.
+		{"init ref suite", []string{"POST Bearer other", "GET X-Api-Key user"}, []string{"other", "user"}},
+		// Without as: the plan's credentials are sent.
+		{"default suite", []string{"GET Bearer default"}, []string{""}},
+	} {
+		sent = nil
+		plan := loadPlan(t, dir, securitySpec, identityPlan, server.URL)
+		plan.SuiteMap[c.suite].ApiToken = "default"
+		// Added to the ones of meqa_init.
+		plan.Identities["user"] = &Identity{Credentials: Credentials{"headerKey": {Value: "user"}}}
+		plan.Identities["other"] = &Identity{Credentials: Credentials{"bearerAuth": {Token: "other"}}}
+		plan.RunAll([]string{c.suite}, 1)
+		if !reflect.DeepEqual(sent, c.sent) {
+			t.Errorf("expected %s to send %v, got %v", c.suite, c.sent, sent)
+		}
+		var identities []string
+		for _, r := range plan.Results() {
+			if r.Result != mqutil.Passed {
+				t.Errorf("expected %s to pass, got %s", r.Name, r.Error())
+			}
+			identities = append(identities, r.Identity)
+		}
+		if !reflect.DeepEqual(identities, c.identities) {
+			t.Errorf("expected the results of %s to show the identities %v, got %v", c.suite, c.identities, identities)
+		}
+	}
+}
+
+func TestUnknownIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, thingsSpec, "things suite:\n- name: get_thing\n  path: /things/{id}\n  method: get\n  as: nobody\n  pathParams:\n    id: 1\n", server.URL)
+	var out bytes.Buffer
+	plan.Out = &out
+	plan.RunAll([]string{"things suite"}, 1)
+
+	if len(plan.resultList) != 1 || plan.resultList[0].err == nil {
+		t.Fatalf("expected the test to fail, got %v", plan.ResultCounts)
+	}
+	if msg := plan.resultList[0].err.Error(); !strings.Contains(msg, "identity nobody of test get_thing not found") {
+		t.Errorf("expected the unknown identity to be reported, got %s", msg)
+	}
+	// It's reported before the test is run.
+	console := out.String()
+	if requests != 0 || strings.Count(console, "identity nobody") != 1 || strings.Contains(console, "Executing tests") {
+		t.Errorf("expected the error once and no request, got %d requests\n%s", requests, console)
+	}
+}
+
+// Each identity keeps its own cookies: the session cookie set for one of them is sent with its
+// later requests, not with the requests of another.
+func TestIdentityCookies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var cookies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			// The caller logs in.
+			session := "anonymous"
+			if auth := r.Header.Get("Authorization"); len(auth) > 0 {
+				session = strings.TrimPrefix(auth, "Bearer ")
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: session, Path: "/"})
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		cookies = append(cookies, r.Header.Get("Cookie"))
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, thingsSpec, `things suite:
+- name: login
+  path: /things
+  method: post
+  as: owner
+- name: get_thing
+  path: /things/{id}
+  method: get
+  as: attacker
+  pathParams:
+    id: 1
+- name: get_thing
+  path: /things/{id}
+  method: get
+  as: owner
+  pathParams:
+    id: 1
+- name: get_thing
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 1
+- name: login
+  path: /things
+  method: post
+- name: get_thing
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 1
+`, server.URL)
+	plan.Identities = Identities{"owner": {ApiToken: "owner"}, "attacker": {ApiToken: "attacker"}}
+	plan.RunAll([]string{"things suite"}, 1)
+
+	if plan.ResultCounts[mqutil.Passed] != 6 {
+		t.Fatalf("expected 6 passed tests, got %v", plan.ResultCounts)
+	}
+	// The attacker, the owner, and no identity before and after logging in.
+	expected := []string{"", "session=owner", "", "session=anonymous"}
+	if !reflect.DeepEqual(cookies, expected) {
+		t.Errorf("expected the cookies %q, got %q", expected, cookies)
+	}
+}
@@ -0,0 +1,365 @@
+package mqplan
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+	spec "github.com/getkin/kin-openapi/openapi3"
+	"gopkg.in/resty.v1"
+)
+
+const contentTypeHeader = "Content-Type"
+
+// The request body media types we prefer, in order, when the spec allows several.
+var preferredMediaTypes = []string{
+	mqswag.JsonResponse,
+	mqswag.FormMedia,
+	mqswag.MultipartMedia,
+	mqswag.XmlMedia,
+}
+
+// requestMediaType returns the media type to send the request body of t in, and its spec. The
+// Content-Type header of the test wins if the spec allows it. It returns "" if the operation has
+// no request body.
+func (t *Test) requestMediaType() (string, *spec.MediaType) {
+	if t.op == nil || t.op.RequestBody == nil || t.op.RequestBody.Value == nil {
+		return "", nil
+	}
+	content := t.op.RequestBody.Value.Content
+	for k, v := range t.HeaderParams {
+		if strings.EqualFold(k, contentTypeHeader) {
+			mediaType, _, _ := mime.ParseMediaType(fmt.Sprint(v))
+			if media := content[mediaType]; media != nil {
+				return mediaType, media
+			}
+		}
+	}
+	for _, mediaType := range preferredMediaTypes {
+		if media := content[mediaType]; media != nil {
+			return mediaType, media
+		}
+	}
+	var mediaTypes []string
+	for mediaType := range content {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	sort.Strings(mediaTypes)
+	// Other json types such as application/merge-patch+json first.
+	for _, mediaType := range mediaTypes {
+		if isJsonMedia(mediaType) {
+			return mediaType, content[mediaType]
+		}
+	}
+	if len(mediaTypes) > 0 {
+		return mediaTypes[0], content[mediaTypes[0]]
+	}
+	return "", nil
+}
+
+// requestBodySchema returns the schema of the request body in the media type it will be sent in.
+func (t *Test) requestBodySchema() mqswag.SchemaRef {
+	_, media := t.requestMediaType()
+	if media == nil || media.Schema == nil {
+		return mqswag.SchemaRef{}
+	}
+	return (mqswag.SchemaRef)(*media.Schema)
+}
+
+// setBody sets the request body of req, encoded in the media type from the spec.
+func (t *Test) setBody(req *resty.Request) error {
+	mediaType, media := t.requestMediaType()
+	if len(mediaType) == 0 || isJsonMedia(mediaType) {
+		if len(mediaType) > 0 {
+			req.SetHeader(contentTypeHeader, mediaType)
+		}
+		req.SetBody(t.BodyParams)
+		return nil
+	}
+	var schema mqswag.SchemaRef
+	if media.Schema != nil {
+		schema = (mqswag.SchemaRef)(*media.Schema)
+	}
+	body, contentType, err := encodeBody(mediaType, media, schema, t.db.Swagger, t.BodyParams)
+	if err != nil {
+		return err
+	}
+	req.SetHeader(contentTypeHeader, contentType)
+	req.SetBody(body)
+	return nil
+}
+
+func isJsonMedia(mediaType string) bool {
+	return mediaType == mqswag.JsonResponse || strings.HasSuffix(mediaType, "+json")
+}
+
+func isXmlMedia(mediaType string) bool {
+	return mediaType == mqswag.XmlMedia || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// encodeBody encodes body in mediaType. It returns the encoded body and the Content-Type header.
+func encodeBody(mediaType string, media *spec.MediaType, schema mqswag.SchemaRef, swagger *mqswag.Swagger, body interface{}) ([]byte, string, error) {
+	switch {
+	case mediaType == mqswag.FormMedia:
+		values := url.Values{}
+		if bodyMap, ok := body.(map[string]interface{}); ok {
+			for k, v := range bodyMap {
+				if ar, ok := v.([]interface{}); ok {
+					for _, entry := range ar {
+						values.Add(k, formValue(entry))
+					}
+				} else {
+					values.Set(k, formValue(v))
+				}
+			}
+		}
+		return []byte(values.Encode()), mediaType, nil
+	case mediaType == mqswag.MultipartMedia:
+		return encodeMultipart(media, schema, swagger, body)
+	case isXmlMedia(mediaType):
+		name, _ := xmlName(schema.Value, "")
+		if len(name) == 0 {
+			name, _, _ = swagger.GetReferredSchema(schema)
+		}
+		if len(name) == 0 {
+			name = "root"
+		}
+		buf := &bytes.Buffer{}
+		buf.WriteString(xml.Header)
+		enc := xml.NewEncoder(buf)
+		err := encodeXml(enc, name, body, schema, swagger)
+		if err == nil {
+			err = enc.Flush()
+		}
+		return buf.Bytes(), mediaType, err
+	}
+	// Everything else, e.g. text/plain or application/octet-stream, is sent as is.
+	switch b := body.(type) {
+	case nil:
+		return nil, mediaType, nil
+	case string:
+		return []byte(b), mediaType, nil
+	case []byte:
+		return b, mediaType, nil
+	}
+	data, err := json.Marshal(body)
+	return data, mediaType, err
+}
+
+// formValue returns the string form of a form field. Objects are sent as json.
+func formValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return mqutil.InterfaceToJsonString(v)
+}
+
+// isBinarySchema tells whether the schema describes file content.
+func isBinarySchema(schema *spec.SchemaRef) bool {
+	return schema != nil && schema.Value != nil && (schema.Value.Type == "file" ||
+		(schema.Value.Type == "string" && (schema.Value.Format == "binary" || schema.Value.Format == "base64")))
+}
+
+// encodeMultipart encodes body as multipart/form-data. Binary properties are sent as files: if
+// the value is the path of an existing file the file is sent, otherwise the value is the content
+// of a generated file.
+func encodeMultipart(media *spec.MediaType, schema mqswag.SchemaRef, swagger *mqswag.Swagger, body interface{}) ([]byte, string, error) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	bodyMap, _ := body.(map[string]interface{})
+	var properties map[string]*spec.SchemaRef
+	if schema.Value != nil {
+		properties = schema.GetProperties(swagger)
+	}
+	var keys []string
+	for k := range bodyMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	writePart := func(name string, fileName string, contentType string, data []byte) error {
+		h := make(textproto.MIMEHeader)
+		disposition := fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(name))
+		if len(fileName) > 0 {
+			disposition += fmt.Sprintf(`; filename="%s"`, escapeQuotes(fileName))
+		}
+		h.Set("Content-Disposition", disposition)
+		if len(contentType) > 0 {
+			h.Set(contentTypeHeader, contentType)
+		}
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		_, err = part.Write(data)
+		return err
+	}
+	for _, k := range keys {
+		var partType string
+		if media != nil && media.Encoding[k] != nil {
+			partType = media.Encoding[k].ContentType
+		}
+		values, isArray := bodyMap[k].([]interface{})
+		propSchema := properties[k]
+		if isArray && propSchema != nil && propSchema.Value != nil && propSchema.Value.Items != nil {
+			propSchema = propSchema.Value.Items
+		}
+		if !isArray {
+			values = []interface{}{bodyMap[k]}
+		}
+		for _, v := range values {
+			var err error
+			if isBinarySchema(propSchema) {
+				fileName := k + ".bin"
+				data := []byte(fmt.Sprint(v))
+				if path, ok := v.(string); ok {
+					if fi, statErr := os.Stat(path); statErr == nil && fi.Mode().IsRegular() {
+						fileName = filepath.Base(path)
+						if data, err = ioutil.ReadFile(path); err != nil {
+							return nil, "", err
+						}
+					}
+				}
+				if len(partType) == 0 {
+					partType = "application/octet-stream"
+				}
+				err = writePart(k, fileName, partType, data)
+			} else if _, isObject := v.(map[string]interface{}); isObject {
+				err = writePart(k, "", mqswag.JsonResponse, []byte(mqutil.InterfaceToJsonString(v)))
+			} else {
+				err = writePart(k, "", partType, []byte(formValue(v)))
+			}
+			if err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	err := w.Close()
+	return buf.Bytes(), w.FormDataContentType(), err
+}
+
+func escapeQuotes(s string) string {
+	return strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace(s)
+}
+
+// xmlName returns the name of the element from the xml object of the schema, or name if there is
+// none. It also tells whether the value is an attribute.
+func xmlName(schema *spec.Schema, name string) (string, bool) {
+	if schema == nil {
+		return name, false
+	}
+	x, _ := schema.XML.(map[string]interface{})
+	if n, ok := x["name"].(string); ok && len(n) > 0 {
+		name = n
+	}
+	attribute, _ := x["attribute"].(bool)
+	return name, attribute
+}
+
+// xmlWrapped tells whether the array is wrapped in an element of its own.
+func xmlWrapped(schema *spec.Schema) bool {
+	if schema == nil {
+		return false
+	}
+	x, _ := schema.XML.(map[string]interface{})
+	wrapped, _ := x["wrapped"].(bool)
+	return wrapped
+}
+
+// encodeXml writes value as the element name, following the xml objects of the schema.
+func encodeXml(enc *xml.Encoder, name string, value interface{}, schema mqswag.SchemaRef, swagger *mqswag.Swagger) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		var properties map[string]*spec.SchemaRef
+		if schema.Value != nil {
+			properties = schema.GetProperties(swagger)
+		}
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		start := xml.StartElement{Name: xml.Name{Local: name}}
+		var children []string
+		for _, k := range keys {
+			var propSchema *spec.Schema
+			if properties[k] != nil {
+				propSchema = properties[k].Value
+			}
+			if n, attribute := xmlName(propSchema, k); attribute {
+				start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: n}, Value: formValue(v[k])})
+			} else {
+				children = append(children, k)
+			}
+		}
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, k := range children {
+			var propSchema mqswag.SchemaRef
+			if properties[k] != nil {
+				propSchema = (mqswag.SchemaRef)(*properties[k])
+			}
+			if err := encodeXmlProperty(enc, k, v[k], propSchema, swagger); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	case []interface{}:
+		// A top level array is always wrapped.
+		return encodeXmlProperty(enc, name, v, mqswag.SchemaRef{Value: &spec.Schema{XML: map[string]interface{}{"wrapped": true}, Items: schemaItems(schema)}}, swagger)
+	case nil:
+		return nil
+	}
+	return enc.EncodeElement(formValue(value), xml.StartElement{Name: xml.Name{Local: name}})
+}
+
+func schemaItems(schema mqswag.SchemaRef) *spec.SchemaRef {
+	if schema.Value == nil {
+		return nil
+	}
+	return schema.Value.Items
+}
+
+// encodeXmlProperty writes the property key of an object. Arrays are a list of elements named after
+// the items, wrapped in an element named after the property if the schema says so.
+func encodeXmlProperty(enc *xml.Encoder, key string, value interface{}, schema mqswag.SchemaRef, swagger *mqswag.Swagger) error {
+	name, _ := xmlName(schema.Value, key)
+	ar, ok := value.([]interface{})
+	if !ok {
+		return encodeXml(enc, name, value, schema, swagger)
+	}
+	var itemSchema mqswag.SchemaRef
+	if items := schemaItems(schema); items != nil {
+		itemSchema = (mqswag.SchemaRef)(*items)
+	}
+	itemName, _ := xmlName(itemSchema.Value, name)
+	wrapped := xmlWrapped(schema.Value)
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if wrapped {
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+	}
+	for _, item := range ar {
+		if err := encodeXml(enc, itemName, item, itemSchema, swagger); err != nil {
+			return err
+		}
+	}
+	if wrapped {
+		return enc.EncodeToken(start.End())
+	}
+	return nil
+}
@@ -0,0 +1,627 @@
+package mqplan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+const bodySpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths:
+  /things:
+    post:
+      requestBody:
+        content:
+          application/xml:
+            schema:
+              $ref: '#/components/schemas/Thing'
+          application/x-www-form-urlencoded:
+            schema:
+              $ref: '#/components/schemas/Thing'
+          text/plain:
+            schema:
+              type: string
+      responses:
+        '204':
+          description: the thing is created
+  /files:
+    post:
+      requestBody:
+        content:
+          multipart/form-data:
+            schema:
+              type: object
+              properties:
+                name:
+                  type: string
+                file:
+                  type: string
+                  format: binary
+          application/merge-patch+json:
+            schema:
+              type: object
+      responses:
+        '204':
+          description: the file is uploaded
+components:
+  schemas:
+    Thing:
+      type: object
+      xml:
+        name: thing
+      properties:
+        id:
+          type: integer
+          xml:
+            attribute: true
+        name:
+          type: string
+        tags:
+          type: array
+          xml:
+            wrapped: true
+          items:
+            type: string
+            xml:
+              name: tag
+`
+
+func TestRequestMediaType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	swagger := loadPlan(t, dir, bodySpec, "", "").ctx.Swagger
+	for _, c := range []struct {
+		path        string
+		contentType string
+		expected    string
+	}{
+		{"/things", "", mqswag.FormMedia},
+		{"/things", "application/xml; charset=utf-8", mqswag.XmlMedia},
+		{"/things", "text/plain", "text/plain"},
+		// A Content-Type the spec doesn't allow is ignored.
+		{"/things", "image/png", mqswag.FormMedia},
+		{"/files", "", mqswag.MultipartMedia},
+		{"/files", "application/merge-patch+json", "application/merge-patch+json"},
+	} {
+		test := &Test{op: swagger.Paths[c.path].Post}
+		if len(c.contentType) > 0 {
+			test.HeaderParams = map[string]interface{}{"content-type": c.contentType}
+		}
+		if mediaType, _ := test.requestMediaType(); mediaType != c.expected {
+			t.Errorf("expected %s for %s with %q, got %s", c.expected, c.path, c.contentType, mediaType)
+		}
+	}
+}
+
+func TestEncodeBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	swagger := loadPlan(t, dir, bodySpec, "", "").ctx.Swagger
+	thing := map[string]interface{}{"id": 1, "name": "a & b", "tags": []interface{}{"x", "y"}}
+	for _, c := range []struct {
+		path      string
+		mediaType string
+		body      interface{}
+		expected  string
+	}{
+		{"/things", mqswag.XmlMedia, thing, `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+			`<thing id="1"><name>a &amp; b</name><tags><tag>x</tag><tag>y</tag></tags></thing>`},
+		{"/things", mqswag.FormMedia, thing, "id=1&name=a+%26+b&tags=x&tags=y"},
+		{"/things", "text/plain", "a & b", "a & b"},
+		{"/things", "text/plain", nil, ""},
+	} {
+		media := swagger.Paths[c.path].Post.RequestBody.Value.Content[c.mediaType]
+		body, contentType, err := encodeBody(c.mediaType, media, (mqswag.SchemaRef)(*media.Schema), swagger, c.body)
+		if err != nil {
+			t.Errorf("expected %v to be encoded in %s, got %s", c.body, c.mediaType, err.Error())
+			continue
+		}
+		if string(body) != c.expected || contentType != c.mediaType {
+			t.Errorf("expected %s in %s, got %s in %s", c.expected, c.mediaType, body, contentType)
+		}
+	}
+
+	// An element needs a name.
+	media := swagger.Paths["/things"].Post.RequestBody.Value.Content[mqswag.XmlMedia]
+	if _, _, err := encodeBody(mqswag.XmlMedia, media, (mqswag.SchemaRef)(*media.Schema), swagger, map[string]interface{}{"": "a"}); err == nil {
+		t.Error("expected an error for an element without a name")
+	}
+}
+
+func TestEncodeMultipart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	swagger := loadPlan(t, dir, bodySpec, "", "").ctx.Swagger
+	media := swagger.Paths["/files"].Post.RequestBody.Value.Content[mqswag.MultipartMedia]
+	body, contentType, err := encodeBody(mqswag.MultipartMedia, media, (mqswag.SchemaRef)(*media.Schema), swagger,
+		map[string]interface{}{"name": "a", "file": "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != mqswag.MultipartMedia {
+		t.Fatalf("expected a multipart Content-Type, got %s", contentType)
+	}
+	form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(form.Value, map[string][]string{"name": {"a"}}) {
+		t.Errorf("expected the name as a value, got %v", form.Value)
+	}
+	// The binary property is sent as a file.
+	files := form.File["file"]
+	if len(files) != 1 || files[0].Filename != "file.bin" || files[0].Header.Get("Content-Type") != "application/octet-stream" {
+		t.Fatalf("expected a generated file, got %v", files)
+	}
+	f, err := files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if data, _ := ioutil.ReadAll(f); string(data) != "content" {
+		t.Errorf("expected the value as the content of the file, got %s", data)
+	}
+}
+
+// A multipart Content-Type in the header parameters keeps the boundary of the body.
+func TestRunMultipartContentType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var files []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for name := range r.MultipartForm.File {
+			files = append(files, name)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, bodySpec, "files suite:\n- name: post_file\n  path: /files\n  method: post\n"+
+		"  headerParams:\n    Content-Type: multipart/form-data\n", server.URL)
+	plan.RunAll([]string{"files suite"}, 1)
+
+	if plan.ResultCounts[mqutil.Passed] != 1 {
+		t.Fatalf("expected the test to pass, got %v", plan.ResultCounts)
+	}
+	if !reflect.DeepEqual(files, []string{"file"}) {
+		t.Errorf("expected the file to be uploaded, got %v", files)
+	}
+}
+
+// A body that can't be encoded fails the test without sending it.
+func TestRunUnencodableBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, bodySpec, "things suite:\n- name: post_thing\n  path: /things\n  method: post\n"+
+		"  headerParams:\n    Content-Type: application/xml\n  bodyParams:\n    name:\n      \"\": empty\n", server.URL)
+	plan.RunAll([]string{"things suite"}, 1)
+
+	if len(plan.resultList) != 1 || plan.resultList[0].err == nil {
+		t.Fatalf("expected the test to fail, got %v", plan.ResultCounts)
+	}
+	if msg := plan.resultList[0].err.Error(); requests != 0 || !strings.Contains(msg, "can't encode the request body") {
+		t.Errorf("expected the test to fail before sending the body, got %d requests and %s", requests, msg)
+	}
+}
+
+const rawBodySpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths:
+  /things:
+    post:
+      requestBody:
+        content:
+          application/x-thing:
+            schema:
+              type: object
+              required: [name, size]
+              properties:
+                name:
+                  type: string
+                size:
+                  type: number
+      responses:
+        '201':
+          description: the thing is created
+`
+
+// A fuzzed body that can't be encoded fails the fuzz request without sending it.
+func TestFuzzUnencodableBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var mutex sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, rawBodySpec, "things suite:\n- name: post_thing\n  path: /things\n  method: post\n", server.URL)
+	// The bodies are sent as json, which has no NaN.
+	plan.Repro = true
+	plan.ctx.OldFailuresMap = map[string]map[string]map[string]map[mqutil.FuzzValue]bool{
+		"/things": {"post": {"size": {{Value: math.NaN(), FuzzType: mqutil.FuzzDataType}: true}}},
+	}
+	plan.RunAll([]string{"things suite"}, 1)
+
+	if len(plan.resultList) != 1 || requests != 1 {
+		t.Fatalf("expected only the test itself to be sent, got %d requests", requests)
+	}
+	test := plan.resultList[0]
+	if test.err != nil {
+		t.Errorf("expected the test to pass, got %s", test.err.Error())
+	}
+	if len(test.fuzzFailures) != 1 {
+		t.Fatalf("expected a fuzz failure, got %d", len(test.fuzzFailures))
+	}
+	if p := test.fuzzFailures[0]; p.Field != "size" || len(p.Actual) > 0 || !strings.Contains(p.Message, "can't encode the request body") {
+		t.Errorf("expected the encoding error in the fuzz failure, got %+v", p)
+	}
+}
+
+const petSpec = `
+openapi: 3.0.0
+info:
+  title: pets
+  version: "1"
+paths: {}
+components:
+  schemas:
+    Pet:
+      oneOf:
+      - $ref: '#/components/schemas/Cat'
+      - $ref: '#/components/schemas/Dog'
+      discriminator:
+        propertyName: petType
+        mapping:
+          cat: '#/components/schemas/Cat'
+          dog: Dog
+    Cat:
+      type: object
+      required: [petType, name, secret]
+      properties:
+        id:
+          type: integer
+          readOnly: true
+        petType:
+          type: string
+        name:
+          type: string
+        secret:
+          type: string
+          writeOnly: true
+    Dog:
+      type: object
+      required: [petType, name]
+      properties:
+        petType:
+          type: string
+        name:
+          type: string
+        tag:
+          not:
+            type: string
+`
+
+func TestGenerateAlternatives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	plan := loadPlan(t, dir, petSpec, "", "")
+	test := &Test{Name: "post_pet", ctx: plan.ctx, suite: CreateTestSuite("pets suite", nil, plan)}
+	test.suite.out, test.suite.db = ioutil.Discard, plan.ctx.DB.CloneSchema()
+	test.db = test.suite.db
+	pet := mqswag.SchemaRef{Ref: "#/components/schemas/Pet", Value: plan.ctx.Swagger.Components.Schemas["Pet"].Value}
+
+	petTypes := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		o, err := test.GenerateSchema("pet", nil, pet, nil, plan.ctx.DB, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := o.(map[string]interface{})
+		petType, _ := obj["petType"].(string)
+		petTypes[petType]++
+		if _, ok := obj["id"]; ok {
+			t.Errorf("expected no readOnly id, got %v", obj)
+		}
+		if _, ok := obj["tag"].(string); ok {
+			t.Errorf("expected the tag not to match its not schema, got %v", obj)
+		}
+		if petType == "cat" && obj["secret"] == nil {
+			t.Errorf("expected the writeOnly secret, got %v", obj)
+		}
+	}
+	if len(petTypes) != 2 || petTypes["cat"] == 0 || petTypes["dog"] == 0 {
+		t.Errorf("expected both discriminator values, got %v", petTypes)
+	}
+
+}
+
+const accountSpec = `
+openapi: 3.0.0
+info:
+  title: accounts
+  version: "1"
+paths:
+  /accounts:
+    post:
+      requestBody:
+        content:
+          application/json:
+            schema:
+              oneOf:
+              - $ref: '#/components/schemas/User'
+              - $ref: '#/components/schemas/Bot'
+              discriminator:
+                propertyName: kind
+            example:
+              kind: User
+              name: alice
+              password: s3cret
+      responses:
+        '200':
+          description: the created account
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/User'
+components:
+  schemas:
+    User:
+      type: object
+      required: [kind, name, password]
+      properties:
+        kind:
+          type: string
+        name:
+          type: string
+        password:
+          type: string
+          writeOnly: true
+    Bot:
+      type: object
+      required: [kind, name, token]
+      properties:
+        kind:
+          type: string
+        name:
+          type: string
+        token:
+          type: string
+`
+
+// A request may have writeOnly properties, even in its example, only a response may not.
+func TestRunWriteOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, echoPassword := range []bool{false, true} {
+		var account map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&account)
+			response := map[string]interface{}{"kind": account["kind"], "name": account["name"]}
+			if echoPassword {
+				response["password"] = account["password"]
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+		}))
+		plan := loadPlan(t, dir, accountSpec, "accounts suite:\n- name: post_account\n  path: /accounts\n  method: post\n  examples: true\n", server.URL)
+		plan.RunAll([]string{"accounts suite"}, 1)
+		server.Close()
+
+		if account["name"] != "alice" || account["password"] != "s3cret" {
+			t.Errorf("expected the example to be sent, got %v", account)
+		}
+		if mismatch := plan.ResultCounts[mqutil.SchemaMismatch] == 1; mismatch != echoPassword {
+			t.Errorf("expected a schema mismatch only for a returned password, got %v returning it %v", plan.ResultCounts, echoPassword)
+		}
+	}
+}
+
+const exampleSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths:
+  /things/{id}:
+    put:
+      parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+        example: 42
+      requestBody:
+        content:
+          application/json:
+            schema:
+              type: object
+              properties:
+                name:
+                  type: string
+                  example: doggie
+                tags:
+                  type: array
+                  items:
+                    type: string
+                  example: [a, b]
+      responses:
+        '204':
+          description: the thing is updated
+`
+
+// The documented examples are only sent when asked for.
+func TestRunExamples(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var path string
+	var thing map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&thing)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	for _, examples := range []bool{false, true} {
+		plan := loadPlan(t, dir, exampleSpec, fmt.Sprintf("things suite:\n- name: put_thing\n  path: /things/{id}\n  method: put\n  examples: %v\n", examples), server.URL)
+		plan.RunAll([]string{"things suite"}, 1)
+
+		if plan.ResultCounts[mqutil.Passed] != 1 {
+			t.Fatalf("expected the test to pass, got %v", plan.ResultCounts)
+		}
+		usedExamples := path == "/things/42" && thing["name"] == "doggie" && reflect.DeepEqual(thing["tags"], []interface{}{"a", "b"})
+		if usedExamples != examples {
+			t.Errorf("expected the examples to be used only when asked for, got %s %v", path, thing)
+		}
+	}
+}
+
+const examplesSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths:
+  /things/{id}:
+    put:
+      parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+        examples:
+          one:
+            value: 1
+          two:
+            value: 2
+      requestBody:
+        content:
+          application/json:
+            schema:
+              type: object
+              properties:
+                name:
+                  type: string
+                tags:
+                  type: array
+                  items:
+                    type: string
+            examples:
+              small:
+                value:
+                  name: ann
+                  tags: [a]
+              big:
+                value:
+                  name: bob
+                  tags: [b, c]
+      responses:
+        '204':
+          description: the thing is updated
+`
+
+// Every example is a positive fuzz value, not only the one that's generated.
+func TestExampleSamples(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	plan := loadPlan(t, dir, examplesSpec, "", "")
+	plan.FuzzType = mqutil.FuzzPositive
+	test := &Test{Name: "put_thing", ctx: plan.ctx, suite: CreateTestSuite("things suite", nil, plan)}
+	test.suite.out, test.suite.db = ioutil.Discard, plan.ctx.DB.CloneSchema()
+	test.db = test.suite.db
+	test.sampleSpace = make(map[string][]mqutil.FuzzValue)
+	op := plan.ctx.Swagger.Paths["/things/{id}"].Put
+	media := op.RequestBody.Value.Content["application/json"]
+	for _, param := range []*spec.Parameter{
+		op.Parameters[0].Value,
+		{Schema: media.Schema, Example: media.Example, Examples: media.Examples},
+	} {
+		if _, err := test.GenerateParameter(param, plan.ctx.DB); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	samples := make(map[string][]interface{})
+	for name, values := range test.sampleSpace {
+		for _, v := range values {
+			samples[name] = append(samples[name], v.Value)
+		}
+		sort.Slice(samples[name], func(i, j int) bool { return fmt.Sprint(samples[name][i]) < fmt.Sprint(samples[name][j]) })
+	}
+	want := map[string][]interface{}{
+		"id":   {1.0, 2.0},
+		"name": {"ann", "bob"},
+		"tags": {"a", "b", "c"},
+	}
+	if !reflect.DeepEqual(samples, want) {
+		t.Errorf("expected the values of every example, got %v", samples)
+	}
+}
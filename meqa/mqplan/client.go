@@ -0,0 +1,141 @@
+package mqplan
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"gopkg.in/resty.v1"
+)
+
+// DefaultMaxRedirects is the number of redirects followed when ClientConfig.MaxRedirects is 0.
+const DefaultMaxRedirects = 15
+
+// ClientConfig configures the HTTP client a test plan sends its requests with.
+type ClientConfig struct {
+	Timeout      time.Duration // the timeout of each request, 0 means no timeout
+	Proxy        string        // the proxy URL, empty means no proxy
+	TLS          *tls.Config   // the TLS settings, nil means the defaults
+	MaxRedirects int           // the number of redirects to follow, 0 means DefaultMaxRedirects, negative means none
+
+	// If set, requests are served in-process by Handler instead of going out on the network.
+	// Proxy and TLS are ignored in that case.
+	Handler http.Handler
+}
+
+// HandlerTransport is an http.RoundTripper that serves the requests with an http.Handler.
+type HandlerTransport struct {
+	Handler http.Handler
+}
+
+// RoundTrip implements http.RoundTripper. The handler gets the request the way a server would,
+// with RequestURI and RemoteAddr set like httptest.NewRequest sets them.
+func (t *HandlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	serverReq := new(http.Request)
+	*serverReq = *req
+	if serverReq.Body == nil {
+		serverReq.Body = http.NoBody
+	}
+	serverReq.RequestURI = req.URL.RequestURI()
+	serverReq.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+	t.Handler.ServeHTTP(rec, serverReq)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// NewClient creates a resty client from the config. Like the default resty client, it keeps the
+// cookies the server sets and sends them back.
+func NewClient(config *ClientConfig) *resty.Client {
+	client := resty.New()
+	if config.Handler != nil {
+		client.SetTransport(&HandlerTransport{config.Handler})
+	} else {
+		transport := &http.Transport{TLSClientConfig: config.TLS}
+		client.SetTransport(transport)
+		if len(config.Proxy) > 0 {
+			client.SetProxy(config.Proxy)
+		}
+	}
+	client.SetTimeout(config.Timeout)
+	// The flexible policy counts the first request too.
+	switch {
+	case config.MaxRedirects < 0:
+		client.SetRedirectPolicy(resty.NoRedirectPolicy())
+	case config.MaxRedirects == 0:
+		client.SetRedirectPolicy(resty.FlexibleRedirectPolicy(DefaultMaxRedirects + 1))
+	default:
+		client.SetRedirectPolicy(resty.FlexibleRedirectPolicy(config.MaxRedirects + 1))
+	}
+	return client
+}
+
+// SetClient sets the client the plan sends its requests with.
+func (plan *TestPlan) SetClient(client *resty.Client) {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	plan.client = client
+	plan.clientConfig = nil
+	plan.clients = nil
+}
+
+// SetClientConfig makes the plan send its requests with a client created from config. Unlike a
+// client given to SetClient, the plan can create a new one to start over, see RunPermissions.
+func (plan *TestPlan) SetClientConfig(config *ClientConfig) {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	plan.client = NewClient(config)
+	plan.clientConfig = config
+	plan.clients = nil
+}
+
+// Client returns the client the plan sends its requests with. A client with the default
+// config is created if none was set.
+func (plan *TestPlan) Client() *resty.Client {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	if plan.client == nil {
+		if plan.clientConfig == nil {
+			plan.clientConfig = &ClientConfig{}
+		}
+		plan.client = NewClient(plan.clientConfig)
+	}
+	return plan.client
+}
+
+// clientAs returns the client the requests of the identity are sent with, the plan's client if
+// identity is empty. Each identity gets its own client created from the config, so it doesn't
+// send the cookies set for another one. A client given to SetClient is shared by all of them.
+func (plan *TestPlan) clientAs(identity string) *resty.Client {
+	client := plan.Client()
+	if len(identity) == 0 {
+		return client
+	}
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	if plan.clientConfig == nil {
+		return client
+	}
+	if c, ok := plan.clients[identity]; ok {
+		return c
+	}
+	if plan.clients == nil {
+		plan.clients = make(map[string]*resty.Client)
+	}
+	c := NewClient(plan.clientConfig)
+	plan.clients[identity] = c
+	return c
+}
+
+// newClient replaces the clients with new ones created from the config, which forgets the
+// cookies. A client given to SetClient is kept.
+func (plan *TestPlan) newClient() {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	if plan.clientConfig != nil {
+		plan.client = NewClient(plan.clientConfig)
+		plan.clients = nil
+	}
+}
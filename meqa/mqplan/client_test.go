@@ -0,0 +1,108 @@
+package mqplan
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerTransport(t *testing.T) {
+	var got *http.Request
+	var body string
+	client := &http.Client{Transport: &HandlerTransport{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.Header().Set("X-Served", "yes")
+		w.WriteHeader(http.StatusCreated)
+	})}}
+
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/pets?name=a%20b", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("X-Served") != "yes" || resp.Request != req {
+		t.Errorf("expected the handler's response to the request, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if got.RequestURI != "/pets?name=a%20b" || len(got.RemoteAddr) == 0 || got.Host != "example.com" || body != "{}" {
+		t.Errorf("expected the handler to get a server request, got %q %q %q %q", got.RequestURI, got.RemoteAddr, got.Host, body)
+	}
+	if len(req.RequestURI) > 0 {
+		t.Errorf("expected the client's request to be left alone, got %q", req.RequestURI)
+	}
+
+	// A request without a body gets an empty one, like on a server.
+	if _, err := client.Get("http://example.com/pets"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Body == nil || body != "" {
+		t.Errorf("expected an empty body, got %v", got.Body)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	config := &ClientConfig{Timeout: 5 * time.Second, TLS: &tls.Config{InsecureSkipVerify: true}}
+	client := NewClient(config)
+	if client.GetClient().Timeout != config.Timeout {
+		t.Errorf("expected the timeout %v, got %v", config.Timeout, client.GetClient().Timeout)
+	}
+	if transport, ok := client.GetClient().Transport.(*http.Transport); !ok || transport.TLSClientConfig != config.TLS {
+		t.Errorf("expected the TLS config on the transport, got %v", client.GetClient().Transport)
+	}
+
+	// The redirects followed depend on MaxRedirects.
+	redirects := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/loop" {
+			redirects++
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		}
+	})
+	for _, c := range []struct {
+		maxRedirects int
+		redirects    int
+	}{
+		{-1, 1},
+		{0, DefaultMaxRedirects + 1},
+		{3, 4},
+	} {
+		redirects = 0
+		client := NewClient(&ClientConfig{Handler: handler, MaxRedirects: c.maxRedirects})
+		if _, ok := client.GetClient().Transport.(*HandlerTransport); !ok {
+			t.Fatalf("expected the handler transport, got %v", client.GetClient().Transport)
+		}
+		client.R().Get("http://example.com/loop")
+		if redirects != c.redirects {
+			t.Errorf("expected %d requests with MaxRedirects %d, got %d", c.redirects, c.maxRedirects, redirects)
+		}
+	}
+}
+
+func TestPlanClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The requests of each plan go to the handler of its client.
+	for _, name := range []string{"a", "b"} {
+		var served []string
+		plan := loadPlan(t, dir, thingsSpec, "things suite:\n- name: get_thing\n  path: /things/{id}\n  method: get\n  pathParams:\n    id: 1\n",
+			"http://"+name+".local")
+		plan.SetClient(NewClient(&ClientConfig{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = append(served, r.Host+r.URL.Path)
+		})}))
+		plan.RunAll([]string{"things suite"}, 1)
+		if len(served) != 1 || served[0] != name+".local/things/1" {
+			t.Errorf("expected plan %s to send its request to its handler, got %v", name, served)
+		}
+	}
+}
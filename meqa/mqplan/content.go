@@ -0,0 +1,267 @@
+package mqplan
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	spec "github.com/getkin/kin-openapi/openapi3"
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/resty.v1"
+)
+
+// responseMediaType returns the documented media type in content that matches the Content-Type
+// of resp, and its spec. It returns an error if the response has a body in a media type that isn't
+// documented. If nothing is documented any media type is accepted.
+func responseMediaType(resp *resty.Response, content spec.Content) (string, *spec.MediaType, error) {
+	contentType := resp.Header().Get(contentTypeHeader)
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if len(contentType) > 0 && err != nil {
+		return "", nil, fmt.Errorf("invalid response Content-Type %q: %s", contentType, err.Error())
+	}
+	if len(content) == 0 {
+		return mediaType, nil, nil
+	}
+	var documented []string
+	for k := range content {
+		documented = append(documented, k)
+	}
+	sort.Strings(documented)
+	if len(mediaType) == 0 {
+		if len(resp.Body()) == 0 {
+			return "", nil, nil
+		}
+		return "", nil, fmt.Errorf("the response has no Content-Type, expecting one of %s", strings.Join(documented, ", "))
+	}
+	if media := content[mediaType]; media != nil {
+		return mediaType, media, nil
+	}
+	// Ranges like image/* and */*.
+	for _, k := range documented {
+		if k == "*/*" || (strings.HasSuffix(k, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(k, "*"))) {
+			return mediaType, content[k], nil
+		}
+	}
+	if len(resp.Body()) == 0 {
+		// Nothing to decode, the Content-Type doesn't matter.
+		return mediaType, nil, nil
+	}
+	return mediaType, nil, fmt.Errorf("the response Content-Type %s is not one of the documented %s", mediaType, strings.Join(documented, ", "))
+}
+
+// decodeResponse decodes body according to mediaType. JSON and XML bodies are decoded into maps and
+// arrays that can be checked against the schema, text bodies into a string and binary bodies are not
+// decoded. It returns an error if the body isn't in the media type.
+func decodeResponse(mediaType string, body []byte, schema mqswag.SchemaRef, swagger *mqswag.Swagger) (interface{}, error) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+	switch {
+	case len(mediaType) == 0 || isJsonMedia(mediaType):
+		var obj interface{}
+		d := json.NewDecoder(bytes.NewReader(body))
+		d.UseNumber()
+		err := d.Decode(&obj)
+		if err != nil && len(mediaType) > 0 {
+			return nil, fmt.Errorf("the response is not valid %s: %s", mediaType, err.Error())
+		}
+		return obj, nil
+	case isXmlMedia(mediaType):
+		root, err := parseXml(body)
+		if err != nil {
+			return nil, fmt.Errorf("the response is not valid %s: %s", mediaType, err.Error())
+		}
+		return root.decode(schema, swagger), nil
+	case strings.HasPrefix(mediaType, "text/"):
+		return string(body), nil
+	}
+	return nil, nil
+}
+
+// checkLength checks the length of a text or binary body against the Content-Length header and the
+// length limits of the schema.
+func checkLength(resp *resty.Response, schema mqswag.SchemaRef, isText bool) error {
+	body := resp.Body()
+	if l := resp.Header().Get("Content-Length"); len(l) > 0 && resp.RawResponse != nil && resp.RawResponse.Request.Method != "HEAD" {
+		if n, err := strconv.Atoi(l); err == nil && n != len(body) {
+			return fmt.Errorf("the response Content-Length is %d but the body has %d bytes", n, len(body))
+		}
+	}
+	if schema.Value == nil || !strings.Contains(schema.Value.Type, gojsonschema.TYPE_STRING) {
+		return nil
+	}
+	length := uint64(len(body))
+	if isText {
+		length = uint64(len([]rune(string(body))))
+	}
+	if length < schema.Value.MinLength {
+		return fmt.Errorf("the response has length %d, less than the minLength %d", length, schema.Value.MinLength)
+	}
+	if schema.Value.MaxLength != nil && length > *schema.Value.MaxLength {
+		return fmt.Errorf("the response has length %d, more than the maxLength %d", length, *schema.Value.MaxLength)
+	}
+	return nil
+}
+
+// xmlNode is an element of a parsed XML document.
+type xmlNode struct {
+	name     string
+	attrs    map[string]string
+	children []*xmlNode
+	text     string
+}
+
+func parseXml(data []byte) (*xmlNode, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var stack []*xmlNode
+	var root *xmlNode
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch tok := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: tok.Name.Local, attrs: make(map[string]string)}
+			for _, a := range tok.Attr {
+				node.attrs[a.Name.Local] = a.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else if root == nil {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(tok)
+			}
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no root element")
+	}
+	return root, nil
+}
+
+func (n *xmlNode) childrenNamed(name string) []*xmlNode {
+	var nodes []*xmlNode
+	for _, c := range n.children {
+		if c.name == name {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+// decode converts the element to the form json would be decoded to, using the types and the xml
+// objects of the schema.
+func (n *xmlNode) decode(schema mqswag.SchemaRef, swagger *mqswag.Swagger) interface{} {
+	if schema.Value == nil {
+		if len(n.children) == 0 && len(n.attrs) == 0 {
+			return n.text
+		}
+		obj := make(map[string]interface{})
+		for k, v := range n.attrs {
+			obj[k] = v
+		}
+		for _, c := range n.children {
+			obj[c.name] = c.decode(mqswag.SchemaRef{}, swagger)
+		}
+		return obj
+	}
+	if strings.Contains(schema.Value.Type, gojsonschema.TYPE_ARRAY) {
+		var itemSchema mqswag.SchemaRef
+		if schema.Value.Items != nil {
+			itemSchema = (mqswag.SchemaRef)(*schema.Value.Items)
+		}
+		ar := []interface{}{}
+		for _, c := range n.children {
+			ar = append(ar, c.decode(itemSchema, swagger))
+		}
+		return ar
+	}
+	properties := schema.GetProperties(swagger)
+	if len(properties) == 0 && !strings.Contains(schema.Value.Type, gojsonschema.TYPE_OBJECT) {
+		return xmlScalar(n.text, schema.Value.Type)
+	}
+	obj := make(map[string]interface{})
+	known := make(map[string]bool)
+	for p, ps := range properties {
+		if ps == nil || ps.Value == nil {
+			continue
+		}
+		propSchema := (mqswag.SchemaRef)(*ps)
+		name, attribute := xmlName(ps.Value, p)
+		if attribute {
+			if v, ok := n.attrs[name]; ok {
+				obj[p] = xmlScalar(v, ps.Value.Type)
+			}
+			continue
+		}
+		if !strings.Contains(ps.Value.Type, gojsonschema.TYPE_ARRAY) {
+			known[name] = true
+			if nodes := n.childrenNamed(name); len(nodes) > 0 {
+				obj[p] = nodes[0].decode(propSchema, swagger)
+			}
+			continue
+		}
+		var itemSchema mqswag.SchemaRef
+		if ps.Value.Items != nil {
+			itemSchema = (mqswag.SchemaRef)(*ps.Value.Items)
+		}
+		itemName, _ := xmlName(itemSchema.Value, name)
+		items := n.childrenNamed(itemName)
+		known[itemName] = true
+		if xmlWrapped(ps.Value) {
+			known[name] = true
+			wrappers := n.childrenNamed(name)
+			if len(wrappers) == 0 {
+				continue
+			}
+			items = wrappers[0].childrenNamed(itemName)
+		} else if len(items) == 0 {
+			continue
+		}
+		ar := []interface{}{}
+		for _, item := range items {
+			ar = append(ar, item.decode(itemSchema, swagger))
+		}
+		obj[p] = ar
+	}
+	// Keep what the schema doesn't know about so that it can be reported.
+	for _, c := range n.children {
+		if !known[c.name] {
+			obj[c.name] = c.decode(mqswag.SchemaRef{}, swagger)
+		}
+	}
+	return obj
+}
+
+// xmlScalar converts the text of an element or attribute to the json type.
+func xmlScalar(text string, schemaType string) interface{} {
+	text = strings.TrimSpace(text)
+	switch schemaType {
+	case gojsonschema.TYPE_INTEGER, gojsonschema.TYPE_NUMBER:
+		if _, err := strconv.ParseFloat(text, 64); err == nil {
+			return json.Number(text)
+		}
+	case gojsonschema.TYPE_BOOLEAN:
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+	}
+	return text
+}
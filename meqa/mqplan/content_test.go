@@ -0,0 +1,153 @@
+package mqplan
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+	spec "github.com/getkin/kin-openapi/openapi3"
+	"gopkg.in/resty.v1"
+)
+
+// response returns the response of a handler that serves body in contentType.
+func response(t *testing.T, contentType string, body string) *resty.Response {
+	client := NewClient(&ClientConfig{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Without a Content-Type, not even a sniffed one.
+		w.Header()["Content-Type"] = nil
+		if len(contentType) > 0 {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Write([]byte(body))
+	})})
+	resp, err := client.R().Get("http://example.com/things/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestResponseMediaType(t *testing.T) {
+	content := spec.Content{
+		mqswag.JsonResponse: &spec.MediaType{},
+		"image/*":           &spec.MediaType{},
+	}
+	for _, c := range []struct {
+		contentType string
+		body        string
+		content     spec.Content
+		expected    string
+		documented  bool
+		fails       bool
+	}{
+		{"application/json; charset=utf-8", "{}", content, mqswag.JsonResponse, true, false},
+		{"image/png", "png", content, "image/png", true, false},
+		{"text/html", "<p>", content, "text/html", false, true},
+		// Without a body the Content-Type doesn't matter.
+		{"text/html", "", content, "text/html", false, false},
+		{"", "", content, "", false, false},
+		{"", "{}", content, "", false, true},
+		{"text/html; =", "<p>", content, "", false, true},
+		// Nothing documented accepts everything.
+		{"text/html", "<p>", nil, "text/html", false, false},
+	} {
+		mediaType, media, err := responseMediaType(response(t, c.contentType, c.body), c.content)
+		if (err != nil) != c.fails || mediaType != c.expected || (media != nil) != c.documented {
+			t.Errorf("expected %q documented %v for %q with %q, got %q %v %v", c.expected, c.documented, c.contentType, c.body, mediaType, media != nil, err)
+		}
+	}
+}
+
+func TestDecodeResponse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	swagger := loadPlan(t, dir, bodySpec, "", "").ctx.Swagger
+	thing := (mqswag.SchemaRef)(*swagger.Paths["/things"].Post.RequestBody.Value.Content[mqswag.XmlMedia].Schema)
+	for _, c := range []struct {
+		mediaType string
+		body      string
+		expected  interface{}
+	}{
+		{mqswag.XmlMedia, `<thing id="1"><name>a</name><tags><tag>x</tag><tag>y</tag></tags><extra>z</extra></thing>`,
+			map[string]interface{}{"id": json.Number("1"), "name": "a", "tags": []interface{}{"x", "y"}, "extra": "z"}},
+		{"application/merge-patch+json", `{"id": 1}`, map[string]interface{}{"id": json.Number("1")}},
+		// Without a Content-Type it's json if it can be.
+		{"", `{"id": 1}`, map[string]interface{}{"id": json.Number("1")}},
+		{"", "not json", nil},
+		{"text/plain", "a & b", "a & b"},
+		{"image/png", "png", nil},
+		{mqswag.JsonResponse, "", nil},
+	} {
+		obj, err := decodeResponse(c.mediaType, []byte(c.body), thing, swagger)
+		if err != nil || !reflect.DeepEqual(obj, c.expected) {
+			t.Errorf("expected %v from %s in %q, got %v %v", c.expected, c.body, c.mediaType, obj, err)
+		}
+	}
+	for mediaType, body := range map[string]string{mqswag.JsonResponse: "{", mqswag.XmlMedia: "<thing>", "text/xml": "no element"} {
+		if _, err := decodeResponse(mediaType, []byte(body), thing, swagger); err == nil || !strings.Contains(err.Error(), "not valid "+mediaType) {
+			t.Errorf("expected %s not to be valid %s, got %v", body, mediaType, err)
+		}
+	}
+}
+
+func TestCheckLength(t *testing.T) {
+	max := uint64(5)
+	schema := mqswag.SchemaRef{Value: &spec.Schema{Type: "string", MinLength: 5, MaxLength: &max}}
+	for _, c := range []struct {
+		body   string
+		isText bool
+		fails  bool
+	}{
+		{"hello", true, false},
+		{"hell", true, true},
+		{"hello!", true, true},
+		// Text is measured in characters, binary in bytes.
+		{"héllo", true, false},
+		{"héllo", false, true},
+	} {
+		if err := checkLength(response(t, "text/plain", c.body), schema, c.isText); (err != nil) != c.fails {
+			t.Errorf("expected %q text %v to fail %v, got %v", c.body, c.isText, c.fails, err)
+		}
+	}
+	if err := checkLength(response(t, "text/plain", "hell"), mqswag.SchemaRef{}, true); err != nil {
+		t.Errorf("expected no limits without a schema, got %s", err.Error())
+	}
+}
+
+// A response in a media type that isn't documented is a content mismatch, its body is still made
+// sense of.
+func TestRunContentMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 1, "name": "thing"}`))
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, mismatchSpec, "things suite:\n- name: post_thing\n  path: /things\n  method: post\n", server.URL)
+	plan.RunAll([]string{"things suite"}, 1)
+
+	if len(plan.resultList) != 1 || plan.ResultCounts[mqutil.ContentMismatch] != 1 {
+		t.Fatalf("expected a content mismatch, got %v", plan.ResultCounts)
+	}
+	test := plan.resultList[0]
+	if test.contentError == nil || !strings.Contains(test.contentError.Error(), "text/html is not one of the documented application/json") {
+		t.Errorf("expected the undocumented Content-Type in the error, got %v", test.contentError)
+	}
+	if body, _ := test.Expect[ExpectBody].(map[string]interface{}); body["name"] != "thing" {
+		t.Errorf("expected the body to be decoded anyway, got %v", test.Expect[ExpectBody])
+	}
+}
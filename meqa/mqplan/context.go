@@ -0,0 +1,134 @@
+package mqplan
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+)
+
+// Context holds the state of a run. Every plan has its own, so any number of plans can be
+// loaded and run in the same process.
+type Context struct {
+	Swagger   *mqswag.Swagger
+	DB        *mqswag.DB            // the objects known to exist on the server
+	Validator *mqswag.JSONValidator // validates the responses against the schemas of Swagger
+	History   TestHistory           // the tests run by all suites of the plan
+	Logger    *log.Logger
+	Verbose   bool // print the request parameters and the schema mismatches to the output
+
+	// Fuzzing data.
+	Dataset    mqswag.DatasetType // the values to fuzz with in this run
+	DoneData   mqswag.DatasetType // the values fuzzed with so far, including Dataset
+	UniqueKeys map[string]bool    // the fields that need a unique value in every request
+
+	// Fuzz failures.
+	OldFailuresMap map[string]map[string]map[string]map[mqutil.FuzzValue]bool // endpoint->method->field->(value,fuzzType)->bool
+	NewFailures    []*mqswag.Payload
+	OtherFailures  []*mqswag.Payload // Failures where fuzzType != currFuzzType
+}
+
+// NewContext creates a context for running plans against swagger. A nil logger discards the log.
+func NewContext(swagger *mqswag.Swagger, logger *log.Logger) *Context {
+	if logger == nil {
+		logger = mqutil.NewLogger(ioutil.Discard)
+	}
+	ctx := &Context{Swagger: swagger, Logger: logger}
+	if swagger != nil {
+		ctx.DB = &mqswag.DB{}
+		ctx.DB.Init(swagger, logger)
+		ctx.Validator = mqswag.NewJSONValidator(swagger)
+	}
+	return ctx
+}
+
+// ReadFails reads the .mqfails file and stores previous failures in OldFailuresMap and OtherFailures
+func (ctx *Context) ReadFails(path string, fuzzType string) error {
+	f, err := os.Open(filepath.Join(path, MeqaFails))
+	defer f.Close()
+	if err != nil {
+		return err
+	}
+	failures := make(map[string]map[string]map[string]map[mqutil.FuzzValue]bool)
+	d := json.NewDecoder(f)
+	for {
+		var v mqswag.Payload
+		if err := d.Decode(&v); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		// Initialize the maps if they don't exist
+		if failures[v.Endpoint] == nil {
+			failures[v.Endpoint] = make(map[string]map[string]map[mqutil.FuzzValue]bool)
+		}
+		if failures[v.Endpoint][v.Method] == nil {
+			failures[v.Endpoint][v.Method] = make(map[string]map[mqutil.FuzzValue]bool)
+		}
+		if failures[v.Endpoint][v.Method][v.Field] == nil {
+			failures[v.Endpoint][v.Method][v.Field] = make(map[mqutil.FuzzValue]bool)
+		}
+		// Add failures matching current fuzzType to OldFailuresMap and rest to OtherFailuresMap
+		if fuzzType == v.FuzzType || fuzzType == mqutil.FuzzAll {
+			fuzzValue := mqutil.FuzzValue{Value: v.Value, FuzzType: v.FuzzType}
+			failures[v.Endpoint][v.Method][v.Field][fuzzValue] = true
+		} else {
+			ctx.OtherFailures = append(ctx.OtherFailures, &v)
+		}
+	}
+	ctx.OldFailuresMap = failures
+	return nil
+}
+
+// WriteFailures writes new failures to mqfails file
+func (ctx *Context) WriteFailures(path string, repro bool) error {
+	flags := os.O_CREATE | os.O_WRONLY
+	var perms os.FileMode
+	if repro {
+		// Overwrite the file
+		flags |= os.O_TRUNC
+		perms = 0755
+	} else {
+		// Append new failures at the end
+		flags |= os.O_APPEND
+		perms = 0644
+	}
+	// Write/Append to mqfails
+	mqFails, err := os.OpenFile(filepath.Join(path, MeqaFails), flags, perms)
+	defer mqFails.Close()
+	if err != nil {
+		return err
+	}
+	// Write only new failures to new newFails
+	newFails, err := os.OpenFile(filepath.Join(path, NewFails), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	defer newFails.Close()
+	if err != nil {
+		return err
+	}
+	d1 := json.NewEncoder(mqFails)
+	d2 := json.NewEncoder(newFails)
+	meta := ReadMetadata(path, ctx.Logger)
+	if repro {
+		// Write the previous failures which we haven't tested (in this run) as it is
+		for _, v := range ctx.OtherFailures {
+			if err := d1.Encode(v); err != nil {
+				return err
+			}
+		}
+	}
+	for _, v := range ctx.NewFailures {
+		v.Meta = meta
+		if err := d1.Encode(v); err != nil {
+			return err
+		}
+		if err := d2.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
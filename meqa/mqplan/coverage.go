@@ -0,0 +1,233 @@
+package mqplan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+// Coverage describes how much of the spec was exercised by a run.
+type Coverage struct {
+	Operations        []*OperationCoverage `json:"operations"`
+	Called            int                  `json:"called"`
+	Total             int                  `json:"total"`
+	Percent           float64              `json:"percent"`
+	ResponsesObserved int                  `json:"responsesObserved"`
+	ResponsesTotal    int                  `json:"responsesTotal"`
+}
+
+// OperationCoverage is the coverage of a single path and method.
+type OperationCoverage struct {
+	Path                 string              `json:"path"`
+	Method               string              `json:"method"`
+	Calls                int                 `json:"calls"`
+	Responses            []*ResponseCoverage `json:"responses"`
+	UndocumentedStatuses []int               `json:"undocumentedStatuses,omitempty"`
+	OptionalParams       []*ParamCoverage    `json:"optionalParams,omitempty"`
+}
+
+// ResponseCoverage tells whether a documented response code was returned by the server.
+type ResponseCoverage struct {
+	Code     string `json:"code"`
+	Observed bool   `json:"observed"`
+}
+
+// ParamCoverage tells whether an optional query or header parameter was ever sent.
+type ParamCoverage struct {
+	Name string `json:"name"`
+	In   string `json:"in"`
+	Sent bool   `json:"sent"`
+}
+
+// responseKey returns the key in responses that documents status, or "" if there is none.
+func responseKey(responses spec.Responses, status int) string {
+	code := strconv.Itoa(status)
+	if _, ok := responses[code]; ok {
+		return code
+	}
+	rangeCode := code[:1] + "XX"
+	for k := range responses {
+		if strings.ToUpper(k) == rangeCode {
+			return k
+		}
+	}
+	if _, ok := responses["default"]; ok {
+		return "default"
+	}
+	return ""
+}
+
+func newOperationCoverage(path string, method string, pathItem *spec.PathItem, op *spec.Operation) *OperationCoverage {
+	oc := &OperationCoverage{Path: path, Method: method}
+	var codes []string
+	for code := range op.Responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		oc.Responses = append(oc.Responses, &ResponseCoverage{Code: code})
+	}
+	seen := make(map[string]bool)
+	for _, params := range []spec.Parameters{op.Parameters, pathItem.Parameters} {
+		for _, p := range params {
+			if p.Value == nil || p.Value.Required || (p.Value.In != "query" && p.Value.In != "header") {
+				continue
+			}
+			if key := p.Value.In + mqswag.FieldSeparator + p.Value.Name; !seen[key] {
+				seen[key] = true
+				oc.OptionalParams = append(oc.OptionalParams, &ParamCoverage{Name: p.Value.Name, In: p.Value.In})
+			}
+		}
+	}
+	return oc
+}
+
+// record adds what the executed test exercised to the coverage. Tests that got no response, like
+// the ones whose parameters couldn't be resolved, don't count as calls.
+func (oc *OperationCoverage) record(t *Test, responses spec.Responses) {
+	if t.resp == nil || t.resp.StatusCode() == 0 {
+		return
+	}
+	oc.Calls++
+	status := t.resp.StatusCode()
+	key := responseKey(responses, status)
+	if len(key) == 0 {
+		found := false
+		for _, s := range oc.UndocumentedStatuses {
+			found = found || s == status
+		}
+		if !found {
+			oc.UndocumentedStatuses = append(oc.UndocumentedStatuses, status)
+			sort.Ints(oc.UndocumentedStatuses)
+		}
+	}
+	for _, r := range oc.Responses {
+		if r.Code == key {
+			r.Observed = true
+		}
+	}
+	for _, p := range oc.OptionalParams {
+		params := t.QueryParams
+		if p.In == "header" {
+			params = t.HeaderParams
+		}
+		if _, ok := params[p.Name]; ok {
+			p.Sent = true
+		}
+	}
+}
+
+// Coverage computes the spec coverage of the tests run so far.
+func (plan *TestPlan) Coverage() *Coverage {
+	c := &Coverage{}
+	if plan.ctx == nil || plan.ctx.Swagger == nil {
+		return c
+	}
+	swagger := plan.ctx.Swagger
+	var paths []string
+	for path := range swagger.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	opMap := make(map[string]*OperationCoverage)
+	for _, path := range paths {
+		pathItem := swagger.Paths[path]
+		for _, method := range mqswag.MethodAll {
+			op := GetOperationByMethod(pathItem, method)
+			if op == nil {
+				continue
+			}
+			oc := newOperationCoverage(path, method, pathItem, op)
+			opMap[mqswag.GetDAGName(mqswag.TypeOp, path, method)] = oc
+			c.Operations = append(c.Operations, oc)
+		}
+	}
+	for _, t := range plan.resultList {
+		oc := opMap[mqswag.GetDAGName(mqswag.TypeOp, t.Path, t.Method)]
+		if oc == nil {
+			continue
+		}
+		oc.record(t, GetOperationByMethod(swagger.Paths[t.Path], t.Method).Responses)
+	}
+	for _, oc := range c.Operations {
+		c.Total++
+		if oc.Calls > 0 {
+			c.Called++
+		}
+		for _, r := range oc.Responses {
+			c.ResponsesTotal++
+			if r.Observed {
+				c.ResponsesObserved++
+			}
+		}
+	}
+	if c.Total > 0 {
+		c.Percent = float64(c.Called) * 100 / float64(c.Total)
+	}
+	return c
+}
+
+// Print writes the coverage report in text to out.
+func (c *Coverage) Print(out io.Writer) {
+	fmt.Fprint(out, mqutil.AQUA)
+	fmt.Fprintf(out, "-----------------------------Coverage--------------------------------\n")
+	fmt.Fprint(out, mqutil.END)
+	for _, oc := range c.Operations {
+		if oc.Calls > 0 {
+			fmt.Fprintf(out, "%s%-7s%s %s (%d calls)\n", mqutil.GREEN, strings.ToUpper(oc.Method), mqutil.END, oc.Path, oc.Calls)
+		} else {
+			fmt.Fprintf(out, "%s%-7s%s %s (not called)\n", mqutil.RED, strings.ToUpper(oc.Method), mqutil.END, oc.Path)
+			continue
+		}
+		var observed, missing []string
+		for _, r := range oc.Responses {
+			if r.Observed {
+				observed = append(observed, r.Code)
+			} else {
+				missing = append(missing, r.Code)
+			}
+		}
+		fmt.Fprintf(out, "        responses observed: %s, not observed: %s\n", joinOrNone(observed), joinOrNone(missing))
+		if len(oc.UndocumentedStatuses) > 0 {
+			fmt.Fprintf(out, "        %sundocumented statuses: %v%s\n", mqutil.YELLOW, oc.UndocumentedStatuses, mqutil.END)
+		}
+		var unsent []string
+		for _, p := range oc.OptionalParams {
+			if !p.Sent {
+				unsent = append(unsent, p.Name+" (in "+p.In+")")
+			}
+		}
+		if len(unsent) > 0 {
+			fmt.Fprintf(out, "        optional params never sent: %s\n", strings.Join(unsent, ", "))
+		}
+	}
+	fmt.Fprint(out, mqutil.AQUA)
+	fmt.Fprintf(out, "Operations called: %d/%d (%.1f%%)\n", c.Called, c.Total, c.Percent)
+	fmt.Fprintf(out, "Documented responses observed: %d/%d\n", c.ResponsesObserved, c.ResponsesTotal)
+	fmt.Fprint(out, mqutil.END)
+}
+
+func joinOrNone(ar []string) string {
+	if len(ar) == 0 {
+		return "none"
+	}
+	return strings.Join(ar, ", ")
+}
+
+// WriteToFile writes the coverage report in JSON to path.
+func (c *Coverage) WriteToFile(path string) error {
+	b, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,158 @@
+package mqplan
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+const coverageSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths:
+  /things/{id}:
+    parameters:
+    - name: X-Trace
+      in: header
+      schema:
+        type: string
+    get:
+      parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+      - name: verbose
+        in: query
+        schema:
+          type: boolean
+      responses:
+        '200':
+          description: the thing
+        '404':
+          description: no such thing
+        5XX:
+          description: the server failed
+    delete:
+      parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+      responses:
+        '204':
+          description: the thing is deleted
+`
+
+const coveragePlan = `
+things suite:
+- name: get_1
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 1
+- name: get_2
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 418
+  expect:
+    status: 418
+- name: get_3
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 503
+  expect:
+    status: 503
+- name: delete_1
+  path: /things/{id}
+  method: delete
+  as: nobody
+  pathParams:
+    id: 1
+`
+
+func TestCoverage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status, err := strconv.Atoi(path.Base(r.URL.Path)); err == nil && status > 100 {
+			w.WriteHeader(status)
+		}
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, coverageSpec, coveragePlan, server.URL)
+	if c := plan.Coverage(); c.Called != 0 || c.Total != 2 || c.Percent != 0 {
+		t.Errorf("expected nothing to be covered before the run, got %+v", c)
+	}
+	plan.RunAll([]string{"things suite"}, 1)
+
+	c := plan.Coverage()
+	if c.Called != 1 || c.Total != 2 || c.Percent != 50 || c.ResponsesObserved != 2 || c.ResponsesTotal != 4 {
+		t.Errorf("expected 1 of 2 operations and 2 of 4 responses, got %+v", c)
+	}
+	if len(c.Operations) != 2 {
+		t.Fatalf("expected 2 operations, got %d", len(c.Operations))
+	}
+	get, del := c.Operations[0], c.Operations[1]
+	if get.Method != "get" || get.Calls != 3 || !reflect.DeepEqual(get.UndocumentedStatuses, []int{418}) {
+		t.Errorf("expected 3 calls to get with an undocumented 418, got %+v", get)
+	}
+	responses := make(map[string]bool)
+	for _, r := range get.Responses {
+		responses[r.Code] = r.Observed
+	}
+	if want := map[string]bool{"200": true, "404": false, "5XX": true}; !reflect.DeepEqual(responses, want) {
+		t.Errorf("expected the 200 and the 5XX to be observed, got %v", responses)
+	}
+	params := make(map[string]bool)
+	for _, p := range get.OptionalParams {
+		params[p.In+" "+p.Name] = p.Sent
+	}
+	// The optional parameters are generated like the others.
+	if want := map[string]bool{"query verbose": true, "header X-Trace": true}; !reflect.DeepEqual(params, want) {
+		t.Errorf("expected the optional parameters to be sent, got %v", params)
+	}
+	// The delete as an unknown identity is never sent.
+	if del.Method != "delete" || del.Calls != 0 || del.Responses[0].Observed {
+		t.Errorf("expected delete not to be called, got %+v", del)
+	}
+}
+
+func TestResponseKey(t *testing.T) {
+	responses := map[string]bool{"200": true, "4XX": true, "default": true}
+	for _, c := range []struct {
+		responses map[string]bool
+		status    int
+		expected  string
+	}{
+		{responses, 200, "200"},
+		{responses, 201, "default"},
+		{responses, 404, "4XX"},
+		{map[string]bool{"200": true, "4xx": true}, 404, "4xx"},
+		{map[string]bool{"200": true}, 500, ""},
+	} {
+		r := make(spec.Responses)
+		for code := range c.responses {
+			r[code] = &spec.ResponseRef{Value: &spec.Response{}}
+		}
+		if key := responseKey(r, c.status); key != c.expected {
+			t.Errorf("expected %d to be documented by %q in %v, got %q", c.status, c.expected, c.responses, key)
+		}
+	}
+}
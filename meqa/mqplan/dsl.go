@@ -1,13 +1,16 @@
 package mqplan
 
 import (
-	"bytes"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
+	"net/http"
+	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -29,12 +32,25 @@ import (
 )
 
 const (
-	ExpectStatus = "status"
-	ExpectBody   = "body"
+	ExpectStatus      = "status"
+	ExpectBody        = "body"
+	ExpectHeaders     = "headers"
+	ExpectRegex       = "regex"        // matches a header value against a regular expression
+	ExpectJsonPath    = "jsonpath"     // JSONPaths in the response body and what they must match
+	ExpectBodyMatches = "body_matches" // a pattern the response body must match
+	ExpectLatency     = "latency_ms"   // how long the call may take
+
+	// The sections of a test's results that templates can refer to besides the parameters and outputs.
+	ParamResponseHeaders = "responseHeaders"
+	ParamStatus          = "status"
+	ParamCookies         = "cookies"
+	ParamVars            = "vars" // {{vars.name}} refers to a variable captured by an earlier test
 
 	MaxRetries = 10
+	NullChance = 5 // one in NullChance nullable properties is generated as null
 
 	StatusSuccess             = "success" // 2XX
+	StatusFail                = "fail"    // not 2XX
 	StatusCodeOk              = 200       // Create success
 	StatusCodeNoResponse      = 204       // Delete success
 	StatusCodeBadRequest      = 400       // Due to incorrect body parameters
@@ -108,6 +124,11 @@ type Test struct {
 	Ref        string                 `yaml:"ref,omitempty"`
 	Expect     map[string]interface{} `yaml:"expect,omitempty"`
 	Strict     bool                   `yaml:"strict,omitempty"`
+	Examples   bool                   `yaml:"examples,omitempty"`
+	FuzzStatus interface{}            `yaml:"fuzzStatus,omitempty"` // what negative fuzz requests expect, as in expect.status
+	Capture    map[string]string      `yaml:"capture,omitempty"`    // variables to set from the results, {{vars.name}}
+	As         string                 `yaml:"as,omitempty"`         // the identity the request is made as
+	Identities Identities             `yaml:"identities,omitempty"` // only in meqa_init, the identities of the plan
 	TestParams `yaml:",inline,omitempty" json:",inline,omitempty"`
 
 	startTime time.Time
@@ -118,21 +139,31 @@ type Test struct {
 	comparisons map[string]([]*Comparison)
 	sampleSpace map[string][]mqutil.FuzzValue
 
-	tag   *mqswag.MeqaTag // The tag at the top level that describes the test
-	db    *mqswag.DB
-	suite *TestSuite
-	op    *spec.Operation
-	resp  *resty.Response
-	err   error
-
-	responseError interface{}
-	schemaError   error
+	tag    *mqswag.MeqaTag // The tag at the top level that describes the test
+	ctx    *Context
+	db     *mqswag.DB
+	suite  *TestSuite
+	op     *spec.Operation
+	params spec.Parameters // the parameters of op and of its path
+	resp   *resty.Response
+	err    error
+
+	responseError  interface{}
+	schemaError    error
+	contentError   error // set if the response body isn't in a documented media type
+	headerError    error // set if the response headers don't match the spec or the expect
+	fuzzFailures   []*mqswag.Payload
+	expectedStatus interface{} // the expect.status before it's overwritten with the actual result
+
+	authRequirement spec.SecurityRequirement // the security requirement whose credentials were sent
+	authTokens      map[string]string        // the tokens sent for the schemes of authRequirement
 }
 
-func (t *Test) Init(suite *TestSuite) {
+func (t *Test) Init(ctx *Context, suite *TestSuite) {
+	t.ctx = ctx
 	t.suite = suite
 	if suite != nil {
-		t.db = suite.plan.db
+		t.db = ctx.DB
 	}
 	if len(t.Method) != 0 {
 		t.Method = strings.ToLower(t.Method)
@@ -142,17 +173,27 @@ func (t *Test) Init(suite *TestSuite) {
 	if t.BodyParams != nil {
 		t.BodyParams, err = mqutil.YamlObjToJsonObj(t.BodyParams)
 		if err != nil {
-			mqutil.Logger.Print(err)
+			t.ctx.Logger.Print(err)
 		}
 	}
-	if len(t.Expect) > 0 && t.Expect[ExpectBody] != nil {
-		t.Expect[ExpectBody], err = mqutil.YamlObjToJsonObj(t.Expect[ExpectBody])
-		if err != nil {
-			mqutil.Logger.Print(err)
+	for _, section := range []string{ExpectBody, ExpectHeaders, ExpectJsonPath, ExpectBodyMatches, ExpectLatency} {
+		if len(t.Expect) > 0 && t.Expect[section] != nil {
+			t.Expect[section], err = mqutil.YamlObjToJsonObj(t.Expect[section])
+			if err != nil {
+				t.ctx.Logger.Print(err)
+			}
 		}
 	}
 }
 
+// out returns the console writer of the suite the test runs in.
+func (t *Test) out() io.Writer {
+	if t.suite != nil && t.suite.out != nil {
+		return t.suite.out
+	}
+	return os.Stdout
+}
+
 // Duplicate the schema with empty values
 func (t *Test) SchemaDuplicate() *Test {
 	test := *t
@@ -173,6 +214,8 @@ func (t *Test) SchemaDuplicate() *Test {
 	test.comparisons = make(map[string]([]*Comparison))
 	test.sampleSpace = make(map[string][]mqutil.FuzzValue)
 	test.err = nil
+	test.fuzzFailures = nil
+	test.expectedStatus = nil
 	test.db = test.suite.db
 
 	return &test
@@ -257,7 +300,7 @@ func (t *Test) AddObjectComparison(tag *mqswag.MeqaTag, obj map[string]interface
 	if len(class) == 0 {
 		cl, s := t.db.FindMatchingSchema(obj)
 		if s.Value == nil {
-			mqutil.Logger.Printf("Can't find a known schema for obj %v", obj)
+			t.ctx.Logger.Printf("Can't find a known schema for obj %v", obj)
 			return
 		}
 		class = cl
@@ -278,7 +321,7 @@ func (t *Test) AddObjectComparison(tag *mqswag.MeqaTag, obj map[string]interface
 		}
 		t.comparisons[class] = append(t.comparisons[class], &Comparison{nil, nil, obj, schema})
 	} else {
-		mqutil.Logger.Printf("unexpected: generating object %s for GET method.", class)
+		t.ctx.Logger.Printf("unexpected: generating object %s for GET method.", class)
 	}
 }
 
@@ -291,13 +334,13 @@ func (t *Test) GetClientDB(className string, associations map[string]map[string]
 	} else {
 		dbArray = t.db.Find(className, nil, associations, mqutil.InterfaceEquals, -1)
 	}
-	mqutil.Logger.Printf("got %d entries from db", len(dbArray))
+	t.ctx.Logger.Printf("got %d entries from db", len(dbArray))
 	return dbArray
 }
 
 // Every object in the response must be in the client db
 func (t *Test) ResponseInDb(className string, associations map[string]map[string]interface{}, resultArray []interface{}) error {
-	fmt.Printf("... checking GET result against client. ")
+	fmt.Fprintf(t.out(), "... checking GET result against client. ")
 	dbArray := t.GetClientDB(className, associations)
 	numMiss := 0
 	var missing string
@@ -330,17 +373,17 @@ func (t *Test) ResponseInDb(className string, associations map[string]map[string
 			b, _ := json.Marshal(dbArray[0])
 			found = string(b)
 		}
-		fmt.Printf("Result not found on client. Fail\n")
+		fmt.Fprintf(t.out(), "Result not found on client. Fail\n")
 		t.responseError = fmt.Sprintf("%v remote objects missing in client\nMissing:%v\nFound %v like:%v", numMiss, missing, len(dbArray), found)
 		return mqutil.NewError(mqutil.ErrHttp, fmt.Sprintf("remote object not found in client\n"))
 	}
-	fmt.Printf("Success\n")
+	fmt.Fprintf(t.out(), "Success\n")
 	return nil
 }
 
 // Every object in the client db must be in the response
 func (t *Test) DbInResponse(className string, associations map[string]map[string]interface{}, resultArray []interface{}) error {
-	fmt.Printf("... checking client objects against GET result. ")
+	fmt.Fprintf(t.out(), "... checking client objects against GET result. ")
 	dbArray := t.GetClientDB(className, associations)
 	numMiss := 0
 	var missing string
@@ -366,11 +409,11 @@ func (t *Test) DbInResponse(className string, associations map[string]map[string
 		}
 	}
 	if numMiss > 0 {
-		fmt.Printf("Result not found on remote. Fail\n")
+		fmt.Fprintf(t.out(), "Result not found on remote. Fail\n")
 		t.responseError = fmt.Sprintf("%v local objects missing from a list of %v on remote\nMissing: %s\n", numMiss, len(resultArray), missing)
 		return mqutil.NewError(mqutil.ErrHttp, fmt.Sprintf("client object not found in results returned\n"))
 	}
-	fmt.Printf("Success\n")
+	fmt.Fprintf(t.out(), "Success\n")
 	return nil
 }
 
@@ -380,30 +423,46 @@ func (t *Test) ProcessOneComparison(className string, method string, comp *Compa
 	associations map[string]map[string]interface{}, collection map[string][]interface{}) error {
 
 	if method == mqswag.MethodDelete {
-		mqutil.Logger.Printf("... deleting entry from client DB. Success\n")
+		t.ctx.Logger.Printf("... deleting entry from client DB. Success\n")
 		t.suite.db.Delete(className, comp.oldUsed, associations, mqutil.InterfaceEquals, 1)
 		t.db.Delete(className, comp.oldUsed, associations, mqutil.InterfaceEquals, 1)
 	} else if method == mqswag.MethodPost && comp.new != nil {
-		mqutil.Logger.Printf("... adding entry to client DB. Success\n")
+		t.ctx.Logger.Printf("... adding entry to client DB. Success\n")
 		t.suite.db.Insert(className, comp.new, associations)
 		return t.db.Insert(className, comp.new, associations)
 	} else if (method == mqswag.MethodPatch || method == mqswag.MethodPut) && comp.new != nil {
-		mqutil.Logger.Printf("... updating entry in client DB. Success\n")
+		t.ctx.Logger.Printf("... updating entry in client DB. Success\n")
 		t.suite.db.Update(className, comp.oldUsed, associations, mqutil.InterfaceEquals, comp.new, 1, method == mqswag.MethodPatch)
 		count := t.db.Update(className, comp.oldUsed, associations, mqutil.InterfaceEquals, comp.new, 1, method == mqswag.MethodPatch)
 		if count != 1 {
-			mqutil.Logger.Printf("Failed to find any entry to update")
+			t.ctx.Logger.Printf("Failed to find any entry to update")
 		}
 	}
 	return nil
 }
 
 func (t *Test) GetParam(path []string) interface{} {
+	if len(path) == 1 && path[0] == ParamStatus && t.resp != nil {
+		return t.resp.StatusCode()
+	}
 	if len(path) < 2 {
 		return nil
 	}
 	var section interface{}
-	if path[0] == "pathParams" {
+	if path[0] == ParamResponseHeaders && t.resp != nil {
+		headers := make(map[string]interface{})
+		for k, v := range t.resp.Header() {
+			headers[k] = strings.Join(v, ", ")
+		}
+		section = headers
+		path = append([]string{path[0], http.CanonicalHeaderKey(path[1])}, path[2:]...)
+	} else if path[0] == ParamCookies && t.resp != nil {
+		cookies := make(map[string]interface{})
+		for _, c := range t.resp.Cookies() {
+			cookies[c.Name] = c.Value
+		}
+		section = cookies
+	} else if path[0] == "pathParams" {
 		section = t.PathParams
 	} else if path[0] == "queryParams" {
 		section = t.QueryParams
@@ -418,18 +477,12 @@ func (t *Test) GetParam(path []string) interface{} {
 	}
 
 	topSection := section
-	// First try the exact search. This only works if there is no
-	// array on the search path.
+	// First try the exact search. Arrays on the search path need an index, as in items[0].
 	for _, field := range path[1:] {
 		if section == nil {
 			break
 		}
-		paramMap, ok := section.(map[string]interface{})
-		if !ok {
-			section = nil
-			break
-		}
-		section = paramMap[field]
+		section = lookupField(section, field)
 	}
 	if section != nil {
 		return section
@@ -456,7 +509,7 @@ func (t *Test) GetParam(path []string) interface{} {
 // ProcessResult decodes the response from the server into a result array
 func (t *Test) ProcessResult(resp *resty.Response) error {
 	if t.err != nil {
-		fmt.Printf("REST call hit the following error: %s\n", t.err.Error())
+		fmt.Fprintf(t.out(), "REST call hit the following error: %s\n", t.err.Error())
 		return t.err
 	}
 
@@ -480,15 +533,34 @@ func (t *Test) ProcessResult(resp *resty.Response) error {
 
 	respBody := resp.Body()
 	var respSchema mqswag.SchemaRef
-	if respSpec.Content != nil && respSpec.Content[mqswag.JsonResponse] != nil && respSpec.Content[mqswag.JsonResponse].Schema != nil {
-		respSchema = (mqswag.SchemaRef)(*(respSpec.Content[mqswag.JsonResponse].Schema))
+	mediaType, media, contentErr := responseMediaType(resp, respSpec.Content)
+	if media == nil && len(mediaType) == 0 {
+		// Without a Content-Type we assume json.
+		media = respSpec.Content[mqswag.JsonResponse]
+	}
+	if media != nil && media.Schema != nil {
+		respSchema = (mqswag.SchemaRef)(*media.Schema)
 	}
 	var resultObj interface{}
-	if len(respBody) > 0 {
-		d := json.NewDecoder(bytes.NewReader(respBody))
-		d.UseNumber()
-		d.Decode(&resultObj)
+	if contentErr == nil {
+		resultObj, contentErr = decodeResponse(mediaType, respBody, respSchema, t.db.Swagger)
+	}
+	if contentErr == nil && len(mediaType) > 0 && !isJsonMedia(mediaType) && !isXmlMedia(mediaType) {
+		contentErr = checkLength(resp, respSchema, strings.HasPrefix(mediaType, "text/"))
+	}
+	if contentErr != nil {
+		// Still try to make sense of the body.
+		resultObj, _ = decodeResponse("", respBody, mqswag.SchemaRef{}, t.db.Swagger)
+		t.contentError = contentErr
+		fmt.Fprintf(t.out(), "... checking response content. %vFail%v %s\n", mqutil.YELLOW, mqutil.END, contentErr.Error())
+	}
+	if err := checkDocumentedHeaders(resp.Header(), respSpec.Headers, t.ctx.Validator); err != nil {
+		t.headerError = err
+		fmt.Fprintf(t.out(), "... checking response headers. %vFail%v %s\n", mqutil.YELLOW, mqutil.END, err.Error())
 	}
+	expectHeaders := t.Expect[ExpectHeaders]
+	expectJsonPath := t.Expect[ExpectJsonPath]
+	expectLatency := t.Expect[ExpectLatency]
 
 	// Before returning from this function, we should set the test's expect value to that
 	// of actual result. This allows us to print out a result report that is the same format
@@ -499,14 +571,23 @@ func (t *Test) ProcessResult(resp *resty.Response) error {
 		if resultObj != nil {
 			t.Expect[ExpectBody] = resultObj
 		}
+		if expectHeaders != nil {
+			t.Expect[ExpectHeaders] = actualHeaders(resp.Header(), expectHeaders)
+		}
+		if expectJsonPath != nil {
+			t.Expect[ExpectJsonPath] = actualJsonPaths(resultObj, expectJsonPath)
+		}
+		if expectLatency != nil {
+			t.Expect[ExpectLatency] = math.Round(t.latencyMs())
+		}
 	}
 
-	if mqutil.Verbose {
-		fmt.Println("Verifying REST response")
+	if t.ctx.Verbose {
+		fmt.Fprintln(t.out(), "Verifying REST response")
 	}
 	// success based on return status
 	success := (status >= 200 && status < 300)
-	tag := mqswag.GetMeqaTag(respSpec.Description)
+	tag := mqswag.GetResponseTag(respSpec, t.ctx.Logger)
 	if tag != nil && tag.Flags&mqswag.FlagFail != 0 {
 		success = false
 	}
@@ -515,35 +596,61 @@ func (t *Test) ProcessResult(resp *resty.Response) error {
 	var expectedStatus interface{} = StatusSuccess
 	if t.Expect != nil && t.Expect[ExpectStatus] != nil {
 		expectedStatus = t.Expect[ExpectStatus]
-		if expectedStatus == "fail" {
-			testSuccess = !success
-		} else if expectedStatusNum, ok := expectedStatus.(int); ok {
-			testSuccess = (expectedStatusNum == status)
+		var err error
+		testSuccess, err = statusMatches(expectedStatus, status, success)
+		if err != nil {
+			t.expectedStatus = expectedStatus
+			fmt.Fprintf(t.out(), "... %s\n", err.Error())
+			setExpect()
+			return mqutil.NewError(mqutil.ErrInvalid, fmt.Sprintf("invalid expect.status in test %s: %s", t.Name, err.Error()))
 		}
 	}
+	t.expectedStatus = expectedStatus
 
 	greenSuccess := fmt.Sprintf("%vSuccess%v", mqutil.GREEN, mqutil.END)
 	redFail := fmt.Sprintf("%vFail%v", mqutil.RED, mqutil.END)
 	yellowFail := fmt.Sprintf("%vFail%v", mqutil.YELLOW, mqutil.END)
 	if testSuccess {
-		fmt.Printf("... expecting status: %v got status: %d. %v API=%v Method=%v\n", expectedStatus, status, greenSuccess, t.Path, t.Method)
+		fmt.Fprintf(t.out(), "... expecting status: %v got status: %d. %v API=%v Method=%v\n", expectedStatus, status, greenSuccess, t.Path, t.Method)
 		if t.Expect != nil && t.Expect[ExpectBody] != nil {
 			testSuccess = mqutil.InterfaceEquals(t.Expect[ExpectBody], resultObj)
 			if testSuccess {
-				fmt.Printf("... checking body against test's expect value. Success\n")
+				fmt.Fprintf(t.out(), "... checking body against test's expect value. Success\n")
 			} else {
-				mqutil.InterfacePrint(map[string]interface{}{"... expecting body": t.Expect[ExpectBody]}, true)
-				fmt.Printf("... actual response body: %s\n", respBody)
-				fmt.Printf("... checking body against test's expect value. Fail\n")
+				mqutil.InterfaceFprint(t.ctx.Logger, t.out(), map[string]interface{}{"... expecting body": t.Expect[ExpectBody]}, true)
+				fmt.Fprintf(t.out(), "... actual response body: %s\n", respBody)
+				fmt.Fprintf(t.out(), "... checking body against test's expect value. Fail\n")
 				ejson, _ := json.Marshal(t.Expect[ExpectBody])
 				setExpect()
 				return mqutil.NewError(mqutil.ErrExpect, fmt.Sprintf(
 					"=== test failed, expecting body: \n%s\ngot body:\n%s\n===", string(ejson), respBody))
 			}
 		}
+		if expectHeaders != nil {
+			if err := checkExpectedHeaders(resp.Header(), expectHeaders); err != nil {
+				fmt.Fprintf(t.out(), "... checking headers against test's expect value. Fail\n%s\n", err.Error())
+				if t.headerError != nil {
+					err = fmt.Errorf("%s\n%s", t.headerError.Error(), err.Error())
+				}
+				t.headerError = err
+				setExpect()
+				return mqutil.NewError(mqutil.ErrExpect, fmt.Sprintf("=== test failed, response headers don't match ===\n%s", err.Error()))
+			}
+			fmt.Fprintf(t.out(), "... checking headers against test's expect value. Success\n")
+		}
+		if msgs := t.checkAssertions(resultObj); len(msgs) > 0 {
+			fmt.Fprintf(t.out(), "... checking test's assertions. %v\n", redFail)
+			for _, msg := range msgs {
+				fmt.Fprintf(t.out(), "...... %s\n", msg)
+			}
+			setExpect()
+			return mqutil.NewError(mqutil.ErrExpect, fmt.Sprintf("=== test failed, %d assertions failed ===\n%s", len(msgs), strings.Join(msgs, "\n")))
+		} else if expectJsonPath != nil || expectLatency != nil || t.Expect[ExpectBodyMatches] != nil {
+			fmt.Fprintf(t.out(), "... checking test's assertions. Success\n")
+		}
 	} else {
 		t.responseError = resp
-		fmt.Printf("... expecting status: %v got status: %d. %v\n", expectedStatus, status, redFail)
+		fmt.Fprintf(t.out(), "... expecting status: %v got status: %d. %v\n", expectedStatus, status, redFail)
 		setExpect()
 		return mqutil.NewError(mqutil.ErrExpect, fmt.Sprintf("=== test failed, response code %d ===", status))
 	}
@@ -551,19 +658,34 @@ func (t *Test) ProcessResult(resp *resty.Response) error {
 	// Check if the response obj and respSchema match
 	collection := make(map[string][]interface{})
 	objMatchesSchema := false
-	if resultObj != nil && respSchema.Value != nil {
-		fmt.Printf("... verifying response against openapi schema. ")
-		err := respSchema.Parses("", resultObj, collection, true, t.db.Swagger)
+	if resultObj != nil && respSchema.Value != nil && t.suite.plan.StrictSchema {
+		fmt.Fprintf(t.out(), "... verifying response against the JSON schema. ")
+		err := t.ctx.Validator.Validate(respSchema, resultObj)
+		if err != nil {
+			fmt.Fprintf(t.out(), "%v\n", redFail)
+			t.schemaError = err
+			if t.ctx.Verbose {
+				fmt.Fprintln(t.out(), err.Error())
+			}
+			setExpect()
+			return mqutil.NewError(mqutil.ErrExpect, fmt.Sprintf("=== test failed, response doesn't match the schema ===\n%s", err.Error()))
+		}
+		fmt.Fprintf(t.out(), "%v API=%v Method=%v\n", greenSuccess, t.Path, t.Method)
+		// The objects in the response are still collected the usual way.
+		respSchema.ParsesResponse("", resultObj, collection, true, t.db.Swagger, t.ctx.Logger)
+	} else if resultObj != nil && respSchema.Value != nil {
+		fmt.Fprintf(t.out(), "... verifying response against openapi schema. ")
+		err := respSchema.ParsesResponse("", resultObj, collection, true, t.db.Swagger, t.ctx.Logger)
 		if err != nil {
-			fmt.Printf("%v\n", yellowFail)
+			fmt.Fprintf(t.out(), "%v\n", yellowFail)
 			objMatchesSchema = true
 			specBytes, _ := json.MarshalIndent(respSpec, "", "    ")
-			mqutil.Logger.Printf("server response doesn't match swagger spec: \n%s", string(specBytes))
+			t.ctx.Logger.Printf("server response doesn't match swagger spec: \n%s", string(specBytes))
 			t.schemaError = err
-			if mqutil.Verbose {
-				// fmt.Printf("... openapi response schema: %s\n", string(specBytes))
-				// fmt.Printf("... response body: %s\n", string(respBody))
-				fmt.Println(err.Error())
+			if t.ctx.Verbose {
+				// fmt.Fprintf(t.out(), "... openapi response schema: %s\n", string(specBytes))
+				// fmt.Fprintf(t.out(), "... response body: %s\n", string(respBody))
+				fmt.Fprintln(t.out(), err.Error())
 			}
 			// schemaError is already set. No need to treat it as a hard failure
 			setExpect()
@@ -578,18 +700,18 @@ func (t *Test) ProcessResult(resp *resty.Response) error {
 			}
 			*/
 		} else {
-			fmt.Printf("%v API=%v Method=%v\n", greenSuccess, t.Path, t.Method)
+			fmt.Fprintf(t.out(), "%v API=%v Method=%v\n", greenSuccess, t.Path, t.Method)
 		}
 	}
 	if resultObj != nil && len(collection) == 0 && t.tag != nil && len(t.tag.Class) > 0 {
 		// try to resolve collection from the hint on the operation's description field.
 		classSchema := t.db.GetSchema(t.tag.Class)
 		if classSchema.Value != nil {
-			if classSchema.Matches(resultObj, t.db.Swagger) {
+			if classSchema.Matches(resultObj, t.db.Swagger, t.ctx.Logger) {
 				collection[t.tag.Class] = append(collection[t.tag.Class], resultObj)
 			} else {
 				callback := func(value map[string]interface{}) error {
-					if classSchema.Matches(value, t.db.Swagger) {
+					if classSchema.Matches(value, t.db.Swagger, t.ctx.Logger) {
 						collection[t.tag.Class] = append(collection[t.tag.Class], value)
 					}
 					return nil
@@ -604,12 +726,12 @@ func (t *Test) ProcessResult(resp *resty.Response) error {
 		if len(respBody) > 0 {
 			if resultObj == nil && !strings.Contains(respSchema.Value.Type, gojsonschema.TYPE_STRING) {
 				specBytes, _ := json.MarshalIndent(respSpec, "", "    ")
-				mqutil.Logger.Printf("server response doesn't match swagger spec: \n%s", string(specBytes))
+				t.ctx.Logger.Printf("server response doesn't match swagger spec: \n%s", string(specBytes))
 			}
 		} else {
 			// If schema is an array, then not having a body is OK
 			if !strings.Contains(respSchema.Value.Type, gojsonschema.TYPE_ARRAY) {
-				mqutil.Logger.Printf("swagger.spec expects a non-empty response, but response body is actually empty")
+				t.ctx.Logger.Printf("swagger.spec expects a non-empty response, but response body is actually empty")
 			}
 		}
 	}
@@ -633,7 +755,7 @@ func (t *Test) ProcessResult(resp *resty.Response) error {
 		var propertyCollection map[string][]interface{}
 		if objMatchesSchema {
 			propertyCollection = make(map[string][]interface{})
-			respSchema.Parses("", resultObj, propertyCollection, false, t.db.Swagger)
+			respSchema.Parses("", resultObj, propertyCollection, false, t.db.Swagger, t.ctx.Logger)
 		}
 
 		for className, compList := range t.comparisons {
@@ -661,7 +783,7 @@ func (t *Test) ProcessResult(resp *resty.Response) error {
 								setExpect()
 								b, _ := json.Marshal(comp.new)
 								c, _ := json.Marshal(classList[0].(map[string]interface{}))
-								fmt.Printf("... checking GET result against client DB. Result not found on client. Fail\n")
+								fmt.Fprintf(t.out(), "... checking GET result against client DB. Result not found on client. Fail\n")
 								t.responseError = fmt.Sprintf("Expected:\n%v\nFound:\n%v\n", string(b), string(c))
 								if len(classList) > 1 {
 									t.responseError = t.responseError.(string) + fmt.Sprintf("... and %v other objects.\n", len(classList)-1)
@@ -686,7 +808,7 @@ func (t *Test) ProcessResult(resp *resty.Response) error {
 		}
 		// Add all fields in the response (including extra ones like metadata) to comparisons list
 		for className, resultArray := range collection {
-			objTag := mqswag.MeqaTag{className, "", "", 0}
+			objTag := mqswag.MeqaTag{Class: className}
 			for _, c := range resultArray {
 				t.AddObjectComparison(&objTag, c.(map[string]interface{}), t.db.GetSchema(className))
 			}
@@ -747,9 +869,9 @@ func (t *Test) ProcessResult(resp *resty.Response) error {
 }
 
 // SetRequestParameters sets the parameters. Returns the new request path.
-func (t *Test) SetRequestParameters(req *resty.Request) string {
+func (t *Test) SetRequestParameters(req *resty.Request) (string, error) {
 	files := make(map[string]string)
-	for _, p := range t.op.Parameters {
+	for _, p := range t.params {
 		if p.Value.Schema.Value.Type == "file" && t.FormParams[p.Value.Name] != nil {
 			// for swagger 2 file type can only be in formData
 			if fname, ok := t.FormParams[p.Value.Name].(string); ok {
@@ -763,7 +885,7 @@ func (t *Test) SetRequestParameters(req *resty.Request) string {
 	}
 	if len(t.FormParams) > 0 {
 		req.SetFormData(mqutil.MapInterfaceToMapString(t.FormParams))
-		mqutil.InterfacePrint(map[string]interface{}{"formParams": t.FormParams}, mqutil.Verbose)
+		mqutil.InterfaceFprint(t.ctx.Logger, t.out(), map[string]interface{}{"formParams": t.FormParams}, t.ctx.Verbose)
 	}
 	for k, v := range files {
 		t.FormParams[k] = v
@@ -771,15 +893,19 @@ func (t *Test) SetRequestParameters(req *resty.Request) string {
 
 	if len(t.QueryParams) > 0 {
 		req.SetQueryParams(mqutil.MapInterfaceToMapString(t.QueryParams))
-		mqutil.InterfacePrint(map[string]interface{}{"queryParams": t.QueryParams}, mqutil.Verbose)
-	}
-	if t.BodyParams != nil {
-		req.SetBody(t.BodyParams)
-		mqutil.InterfacePrint(map[string]interface{}{"bodyParams": t.BodyParams}, mqutil.Verbose)
+		mqutil.InterfaceFprint(t.ctx.Logger, t.out(), map[string]interface{}{"queryParams": t.QueryParams}, t.ctx.Verbose)
 	}
 	if len(t.HeaderParams) > 0 {
 		req.SetHeaders(mqutil.MapInterfaceToMapString(t.HeaderParams))
-		mqutil.InterfacePrint(map[string]interface{}{"headerParams": t.HeaderParams}, mqutil.Verbose)
+		mqutil.InterfaceFprint(t.ctx.Logger, t.out(), map[string]interface{}{"headerParams": t.HeaderParams}, t.ctx.Verbose)
+	}
+	if t.BodyParams != nil {
+		// The body's Content-Type replaces the one of the header parameters, as it can carry
+		// parameters like the multipart boundary.
+		if err := t.setBody(req); err != nil {
+			return "", mqutil.NewError(mqutil.ErrInvalid, fmt.Sprintf("can't encode the request body of test %s: %s", t.Name, err.Error()))
+		}
+		mqutil.InterfaceFprint(t.ctx.Logger, t.out(), map[string]interface{}{"bodyParams": t.BodyParams}, t.ctx.Verbose)
 	}
 	path := t.Path
 	if len(t.PathParams) > 0 {
@@ -787,14 +913,21 @@ func (t *Test) SetRequestParameters(req *resty.Request) string {
 		for k, v := range PathParamsStr {
 			path = strings.Replace(path, "{"+k+"}", v, -1)
 		}
-		mqutil.InterfacePrint(map[string]interface{}{"pathParams": t.PathParams}, mqutil.Verbose)
+		mqutil.InterfaceFprint(t.ctx.Logger, t.out(), map[string]interface{}{"pathParams": t.PathParams}, t.ctx.Verbose)
 	}
-	return path
+	return path, nil
 }
 
 func (t *Test) CopyParent(parentTest *Test) {
 	if parentTest != nil {
 		t.Strict = parentTest.Strict
+		t.Examples = parentTest.Examples
+		if parentTest.FuzzStatus != nil {
+			t.FuzzStatus = parentTest.FuzzStatus
+		}
+		if len(t.As) == 0 {
+			t.As = parentTest.As
+		}
 		t.Expect = mqutil.MapCopy(parentTest.Expect)
 		t.QueryParams = mqutil.MapAdd(t.QueryParams, parentTest.QueryParams)
 		t.PathParams = mqutil.MapAdd(t.PathParams, parentTest.PathParams)
@@ -823,9 +956,12 @@ func (t *Test) CopyParent(parentTest *Test) {
 
 // Often, requests require a unique field. Here, we generate and assign a new one randomly
 func (t *Test) generateUniqueKeys(bodyMap map[string]interface{}) {
-	bodySchema := (mqswag.SchemaRef)(*t.op.RequestBody.Value.Content[mqswag.JsonResponse].Schema)
+	bodySchema := t.requestBodySchema()
+	if bodySchema.Value == nil {
+		return
+	}
 	propSchemas := bodySchema.GetProperties(t.db.Swagger)
-	for uniqueKey := range mqswag.UniqueKeys {
+	for uniqueKey := range t.ctx.UniqueKeys {
 		if _, ok := propSchemas[uniqueKey]; ok {
 			prop := (mqswag.SchemaRef)(*propSchemas[uniqueKey])
 			bodyMap[uniqueKey], _ = generateString(prop, uniqueKey+"_")
@@ -849,6 +985,7 @@ func deleteResource(t *Test) {
 		}
 		if dTest != nil {
 			dTest = dTest.Duplicate()
+			dTest.suite, dTest.db = t.suite, t.suite.db
 			dTest.PathParams["id"] = id
 			dTest.op = spec.NewOperation()
 			// The object was added to db only if there was no error
@@ -875,14 +1012,18 @@ func fuzzRequest(t *Test, copyMap map[string]interface{}, fkey, fuzzType string,
 	}
 	t.BodyParams = mqutil.MapCombine(t.BodyParams.(map[string]interface{}), copyMap)
 	expectStatus := StatusSuccess
-	// If request is expected to fail, set the expectation to BadRequest
+	// If request is expected to fail, set the expectation to the fuzz status, BadRequest by default
 	if fuzzType == mqutil.FuzzDataType || fuzzType == mqutil.FuzzNegative {
 		if t.Expect == nil {
 			t.Expect = make(map[string]interface{})
 		}
-		t.Expect[ExpectStatus] = StatusCodeBadRequest
+		t.Expect[ExpectStatus] = t.fuzzStatus()
 		t.Expect[ExpectBody] = nil
-		expectStatus = fmt.Sprint(StatusCodeBadRequest)
+		// The rest are about the successful response.
+		delete(t.Expect, ExpectHeaders)
+		delete(t.Expect, ExpectJsonPath)
+		delete(t.Expect, ExpectBodyMatches)
+		expectStatus = statusString(t.Expect[ExpectStatus])
 	}
 	err := t.Do()
 	// If there were any errors, capture them in a payload object and send them over the failures channel
@@ -894,19 +1035,28 @@ func fuzzRequest(t *Test, copyMap map[string]interface{}, fkey, fuzzType string,
 			Value:    copyMap[fkey],
 			FuzzType: fuzzType,
 			Expected: expectStatus,
-			Actual:   t.resp.Status(),
-			Message:  t.resp.String(),
+		}
+		// Without a response the request wasn't sent, or got no answer.
+		if t.resp != nil {
+			payload.Actual = t.resp.Status()
+			payload.Message = t.resp.String()
+		} else {
+			payload.Message = err.Error()
 		}
 		failChan <- payload
 		b, err := json.Marshal(t.BodyParams)
 		if err != nil {
-			fmt.Println(err.Error())
+			fmt.Fprintln(t.out(), err.Error())
 			return
 		}
-		fmt.Printf("Expecting %v; Got %v: %v\nRequest Body: %v\n", expectStatus, t.resp.StatusCode(), t.resp.String(), string(b))
+		if t.resp != nil {
+			fmt.Fprintf(t.out(), "Expecting %v; Got %v: %v\nRequest Body: %v\n", expectStatus, t.resp.StatusCode(), t.resp.String(), string(b))
+		} else {
+			fmt.Fprintf(t.out(), "Expecting %v; Got %v\nRequest Body: %v\n", expectStatus, payload.Message, string(b))
+		}
 	}
 	// If the object was created, delete it
-	if t.Method == mqswag.MethodPost && t.resp.StatusCode() == StatusCodeOk {
+	if t.Method == mqswag.MethodPost && t.resp != nil && t.resp.StatusCode() == StatusCodeOk {
 		deleteResource(t)
 	}
 }
@@ -914,8 +1064,9 @@ func fuzzRequest(t *Test, copyMap map[string]interface{}, fkey, fuzzType string,
 // Returns a list of values to be fuzzed for each field in the request body
 func (t *Test) getSamples() (map[string][]mqutil.FuzzValue, int) {
 	samples, totalTests := make(map[string][]mqutil.FuzzValue), 1
-	if t.BodyParams != nil {
-		history := t.suite.plan.OldFailuresMap[t.Path][t.Method]
+	// Only the fields of object bodies are fuzzed.
+	if _, ok := t.BodyParams.(map[string]interface{}); ok {
+		history := t.ctx.OldFailuresMap[t.Path][t.Method]
 		if t.suite.plan.Repro {
 			// Return values from previous failures
 			for key, choices := range history {
@@ -945,8 +1096,8 @@ func (t *Test) getSamples() (map[string][]mqutil.FuzzValue, int) {
 func fuzzTest(baseTest *Test) ([]*mqswag.Payload, error) {
 	samples, totalTests := baseTest.getSamples()
 	inParallel := baseTest.Method != mqswag.MethodPut
-	fmt.Printf("Executing tests: %v\nIn parallel: %v\n", totalTests, inParallel)
-	baseTest.suite.plan.ResultCounts[mqutil.FuzzTotal] += totalTests - 1 // Excluding baseTest
+	fmt.Fprintf(baseTest.out(), "Executing tests: %v\nIn parallel: %v\n", totalTests, inParallel)
+	baseTest.suite.plan.AddResultCounts(map[string]int{mqutil.FuzzTotal: totalTests - 1}) // Excluding baseTest
 	baseCopy := baseTest.Duplicate()
 	errPositive := baseTest.Do()
 	failChan := make(chan *mqswag.Payload, totalTests)
@@ -983,50 +1134,65 @@ func fuzzTest(baseTest *Test) ([]*mqswag.Payload, error) {
 
 func (t *Test) Do() error {
 	tc := t.suite
-	req := resty.R()
-	if len(tc.ApiToken) > 0 {
-		req.SetAuthToken(tc.ApiToken)
-	} else if len(tc.Username) > 0 {
-		req.SetBasicAuth(tc.Username, tc.Password)
+	req := tc.plan.clientAs(t.As).R()
+	if err := t.setAuth(req); err != nil {
+		return err
 	}
+	// The cookies of the credentials are sent again when the request is retried.
+	authCookies := append([]string(nil), req.Header["Cookie"]...)
 
-	path := tc.plan.BaseURL + t.SetRequestParameters(req)
+	path, err := t.SetRequestParameters(req)
+	if err != nil {
+		fmt.Fprintf(t.out(), "... Fail\n... %s\n", err.Error())
+		return err
+	}
+	path = tc.plan.BaseURL + path
 	var resp *resty.Response
-	var err error
-	fmt.Printf("calling API=%v Method=%v\n", t.Path, t.Method)
-	for retries := 1; retries <= MaxRetries; retries++ {
-		t.startTime = time.Now()
-		switch t.Method {
-		case mqswag.MethodGet:
-			resp, err = req.Get(path)
-		case mqswag.MethodPost:
-			resp, err = req.Post(path)
-		case mqswag.MethodPut:
-			resp, err = req.Put(path)
-		case mqswag.MethodDelete:
-			resp, err = req.Delete(path)
-		case mqswag.MethodPatch:
-			resp, err = req.Patch(path)
-		case mqswag.MethodHead:
-			resp, err = req.Head(path)
-		case mqswag.MethodOptions:
-			resp, err = req.Options(path)
-		default:
-			return mqutil.NewError(mqutil.ErrInvalid, fmt.Sprintf("Unknown method in test %s: %v", t.Name, t.Method))
-		}
-		t.stopTime = time.Now()
-		fmt.Printf("... call completed: %f seconds. Status=%v, API=%v Method=%v\n", t.stopTime.Sub(t.startTime).Seconds(), resp.StatusCode(), t.Path, t.Method)
-		if err == nil && resp.StatusCode() != StatusCodeTooManyRequests {
+	if len(t.As) > 0 {
+		fmt.Fprintf(t.out(), "calling API=%v Method=%v As=%v\n", t.Path, t.Method, t.As)
+	} else {
+		fmt.Fprintf(t.out(), "calling API=%v Method=%v\n", t.Path, t.Method)
+	}
+	for attempt := 1; attempt <= 2; attempt++ {
+		for retries := 1; retries <= MaxRetries; retries++ {
+			t.startTime = time.Now()
+			switch t.Method {
+			case mqswag.MethodGet:
+				resp, err = req.Get(path)
+			case mqswag.MethodPost:
+				resp, err = req.Post(path)
+			case mqswag.MethodPut:
+				resp, err = req.Put(path)
+			case mqswag.MethodDelete:
+				resp, err = req.Delete(path)
+			case mqswag.MethodPatch:
+				resp, err = req.Patch(path)
+			case mqswag.MethodHead:
+				resp, err = req.Head(path)
+			case mqswag.MethodOptions:
+				resp, err = req.Options(path)
+			default:
+				return mqutil.NewError(mqutil.ErrInvalid, fmt.Sprintf("Unknown method in test %s: %v", t.Name, t.Method))
+			}
+			t.stopTime = time.Now()
+			fmt.Fprintf(t.out(), "... call completed: %f seconds. Status=%v, API=%v Method=%v\n", t.stopTime.Sub(t.startTime).Seconds(), resp.StatusCode(), t.Path, t.Method)
+			if err == nil && resp.StatusCode() != StatusCodeTooManyRequests {
+				break
+			}
+			req.Header["Cookie"] = authCookies
+			time.Sleep(time.Millisecond * (time.Duration)(1000+rand.Intn(3000*retries)))
+		}
+		// A token that expired is replaced and the request sent once more, unless a 401 is expected.
+		if attempt > 1 || err != nil || resp.StatusCode() != http.StatusUnauthorized || t.expectsUnauthorized() || !t.refreshAuth(req) {
 			break
 		}
-		req.Header["Cookie"] = nil
-		time.Sleep(time.Millisecond * (time.Duration)(1000+rand.Intn(3000*retries)))
+		fmt.Fprintf(t.out(), "... unauthorized, retrying with a new token\n")
 	}
 	if err != nil {
 		t.err = mqutil.NewError(mqutil.ErrHttp, err.Error())
 	} else {
-		mqutil.Logger.Print(resp.Status())
-		mqutil.Logger.Println(string(resp.Body()))
+		t.ctx.Logger.Print(resp.Status())
+		t.ctx.Logger.Println(string(resp.Body()))
 	}
 	err = t.ProcessResult(resp)
 	return err
@@ -1035,71 +1201,18 @@ func (t *Test) Do() error {
 // Run runs the test. Returns the test result.
 func (t *Test) Run(tc *TestSuite) ([]*mqswag.Payload, error) {
 
-	mqutil.Logger.Print("\n--- " + t.Name)
-	fmt.Printf("\nRunning test case: %s\n", t.Name)
+	t.ctx.Logger.Print("\n--- " + t.Name)
+	fmt.Fprintf(t.out(), "\nRunning test case: %s\n", t.Name)
 	err := t.ResolveParameters(tc)
 	if err != nil {
-		fmt.Printf("... Fail\n... %s\n", err.Error())
+		fmt.Fprintf(t.out(), "... Fail\n... %s\n", err.Error())
 		return nil, err
 	}
-	return fuzzTest(t)
-}
-
-func StringParamsResolveWithHistory(str string, h *TestHistory) interface{} {
-	begin := strings.Index(str, "{{")
-	end := strings.Index(str, "}}")
-	if end > begin {
-		ar := strings.Split(strings.Trim(str[begin+2:end], " "), ".")
-		if len(ar) < 3 {
-			mqutil.Logger.Printf("invalid parameter: {{%s}}, the format is {{testName.paramSection.paramName}}, e.g. {{test1.output.id}}",
-				str[begin+2:end])
-			return nil
-		}
-		t := h.GetTest(ar[0])
-		if t != nil {
-			return t.GetParam(ar[1:])
-		}
-	}
-	return nil
-}
-
-func MapParamsResolveWithHistory(paramMap map[string]interface{}, h *TestHistory) {
-	for k, v := range paramMap {
-		if str, ok := v.(string); ok {
-			if result := StringParamsResolveWithHistory(str, h); result != nil {
-				paramMap[k] = result
-			}
-		}
-	}
-}
-
-func ArrayParamsResolveWithHistory(paramArray []interface{}, h *TestHistory) {
-	for i, param := range paramArray {
-		if paramMap, ok := param.(map[string]interface{}); ok {
-			MapParamsResolveWithHistory(paramMap, h)
-		} else if str, ok := param.(string); ok {
-			if result := StringParamsResolveWithHistory(str, h); result != nil {
-				paramArray[i] = result
-			}
-		}
-	}
-}
-
-func (t *Test) ResolveHistoryParameters(h *TestHistory) {
-	MapParamsResolveWithHistory(t.PathParams, h)
-	MapParamsResolveWithHistory(t.FormParams, h)
-	MapParamsResolveWithHistory(t.HeaderParams, h)
-	MapParamsResolveWithHistory(t.QueryParams, h)
-	if bodyMap, ok := t.BodyParams.(map[string]interface{}); ok {
-		MapParamsResolveWithHistory(bodyMap, h)
-	} else if bodyArray, ok := t.BodyParams.([]interface{}); ok {
-		ArrayParamsResolveWithHistory(bodyArray, h)
-	} else if bodyStr, ok := t.BodyParams.(string); ok {
-		result := StringParamsResolveWithHistory(bodyStr, h)
-		if result != nil {
-			t.BodyParams = result
-		}
+	payloads, err := fuzzTest(t)
+	if err == nil {
+		err = t.capture(tc.history)
 	}
+	return payloads, err
 }
 
 // ParamsAdd adds the parameters from src to dst if the param doesn't already exist on dst.
@@ -1131,12 +1244,17 @@ func (t *Test) ResolveParameters(tc *TestSuite) error {
 	if t.op == nil {
 		return mqutil.NewError(mqutil.ErrNotFound, fmt.Sprintf("Path %s not found in swagger file", t.Path))
 	}
-	fmt.Printf("... resolving parameters.\n")
+	// An unknown identity is a mistake in the plan, reported once instead of for every request.
+	if _, err := t.identity(); err != nil {
+		return err
+	}
+	fmt.Fprintf(t.out(), "... resolving parameters.\n")
 
-	// There can be parameters at the path level. We merge these with the operation parameters.
-	t.op.Parameters = ParamsAdd(t.op.Parameters, pathItem.Parameters)
+	// There can be parameters at the path level. We merge these with the operation parameters, on a
+	// copy as the operation is shared by the suites running in parallel.
+	t.params = ParamsAdd(append(spec.Parameters(nil), t.op.Parameters...), pathItem.Parameters)
 
-	t.tag = mqswag.GetMeqaTag(t.op.Description)
+	t.tag = mqswag.GetOperationTag(t.op, t.ctx.Logger)
 
 	var paramsMap map[string]interface{}
 	var globalParamsMap map[string]interface{}
@@ -1150,8 +1268,12 @@ func (t *Test) ResolveParameters(tc *TestSuite) error {
 		}
 		if t.BodyParams != nil && !bodyIsMap {
 			// Body is not map, we use it directly.
-			bodySchema := (mqswag.SchemaRef)(*t.op.RequestBody.Value.Content[mqswag.JsonResponse].Schema)
-			paramTag, schema := t.db.Swagger.GetSchemaRootType(bodySchema, mqswag.GetMeqaTag(bodySchema.Value.Description))
+			bodySchema := t.requestBodySchema()
+			var paramTag *mqswag.MeqaTag
+			var schema mqswag.SchemaRef
+			if bodySchema.Value != nil {
+				paramTag, schema = t.db.Swagger.GetSchemaRootType(bodySchema, mqswag.GetSchemaTag(bodySchema.Value, t.ctx.Logger), t.ctx.Logger)
+			}
 			if schema.Value != nil && paramTag != nil {
 				objarray, _ := t.BodyParams.([]interface{})
 				for _, obj := range objarray {
@@ -1161,12 +1283,13 @@ func (t *Test) ResolveParameters(tc *TestSuite) error {
 					}
 				}
 			}
-			fmt.Print("provided\n")
+			fmt.Fprint(t.out(), "provided\n")
 		} else {
-			if _, ok := t.op.RequestBody.Value.Content[mqswag.JsonResponse]; !ok {
-				return mqutil.NewError(mqutil.ErrInvalid, "Unsupported type")
+			mediaType, media := t.requestMediaType()
+			if media == nil || media.Schema == nil {
+				return mqutil.NewError(mqutil.ErrInvalid, fmt.Sprintf("No schema for the request body in %s", mediaType))
 			}
-			bodyParam := &spec.Parameter{Schema: t.op.RequestBody.Value.Content[mqswag.JsonResponse].Schema}
+			bodyParam := &spec.Parameter{Schema: media.Schema, Example: media.Example, Examples: media.Examples}
 			genParam, err = t.GenerateParameter(bodyParam, t.db)
 			if err != nil {
 				return err
@@ -1182,8 +1305,8 @@ func (t *Test) ResolveParameters(tc *TestSuite) error {
 			}
 		}
 	}
-	for _, params := range t.op.Parameters {
-		fmt.Printf("        %s (in %s): ", params.Value.Name, params.Value.In)
+	for _, params := range t.params {
+		fmt.Fprintf(t.out(), "        %s (in %s): ", params.Value.Name, params.Value.In)
 		switch params.Value.In {
 		case "path":
 			if t.PathParams == nil {
@@ -1219,11 +1342,11 @@ func (t *Test) ResolveParameters(tc *TestSuite) error {
 		}
 		if o, ok := paramsMap[params.Value.Name]; ok {
 			if o != nil {
-				t.AddBasicComparison(mqswag.GetMeqaTag(params.Value.Description), params.Value, paramsMap[params.Value.Name])
-				fmt.Print("provided\n")
+				t.AddBasicComparison(mqswag.GetParameterTag(params.Value, t.ctx.Logger), params.Value, paramsMap[params.Value.Name])
+				fmt.Fprint(t.out(), "provided\n")
 			} else {
 				delete(paramsMap, params.Value.Name)
-				fmt.Print("skipping\n")
+				fmt.Fprint(t.out(), "skipping\n")
 			}
 			continue
 		}
@@ -1258,12 +1381,26 @@ func GetOperationByMethod(item *spec.PathItem, method string) *spec.Operation {
 
 // GenerateParameter generates paramter value based on the spec.
 func (t *Test) GenerateParameter(paramSpec *spec.Parameter, db *mqswag.DB) (interface{}, error) {
-	tag := mqswag.GetMeqaTag(paramSpec.Description)
+	tag := mqswag.GetParameterTag(paramSpec, t.ctx.Logger)
+	// The examples of the parameter take precedence over the ones of its schema. One of them is
+	// generated, all of them are positive fuzz values.
+	var example interface{}
+	if examples := mqswag.GetExamples(paramSpec.Example, paramSpec.Examples); len(examples) > 0 {
+		pick := rand.Intn(len(examples))
+		example = examples[pick]
+		if paramSpec.Schema != nil && (t.suite.plan.FuzzType == mqutil.FuzzPositive || t.suite.plan.FuzzType == mqutil.FuzzAll) {
+			for i, e := range examples {
+				if i != pick {
+					t.addExampleSamples(paramSpec.Name, (mqswag.SchemaRef)(*paramSpec.Schema), e, db.Swagger)
+				}
+			}
+		}
+	}
 	if paramSpec.Schema != nil {
-		return t.GenerateSchema(paramSpec.Name, tag, (mqswag.SchemaRef)(*paramSpec.Schema), db, 3)
+		return t.GenerateSchema(paramSpec.Name, tag, (mqswag.SchemaRef)(*paramSpec.Schema), example, db, 3)
 	}
 	if len(paramSpec.Schema.Value.Enum) != 0 {
-		fmt.Print("enum\n")
+		fmt.Fprint(t.out(), "enum\n")
 		return generateEnum(paramSpec.Schema.Value.Enum)
 	}
 	if len(paramSpec.Schema.Value.Type) == 0 {
@@ -1273,20 +1410,85 @@ func (t *Test) GenerateParameter(paramSpec *spec.Parameter, db *mqswag.DB) (inte
 	// construct a full schema from simple ones
 	schema := (mqswag.SchemaRef)(*paramSpec.Schema)
 	if paramSpec.Schema.Value.Type == gojsonschema.TYPE_OBJECT {
-		return t.generateObject("", tag, schema, db, 3)
+		return t.generateObject("", tag, schema, example, db, 3)
 	}
 	if paramSpec.Schema.Value.Type == gojsonschema.TYPE_ARRAY {
-		return t.generateArray("", tag, schema, db, 3)
+		return t.generateArray("", tag, schema, example, db, 3)
+	}
+
+	return t.generateByType(schema, paramSpec.Name, tag, paramSpec, example, true)
+}
+
+// addExampleSamples adds the values of an example that isn't generated to the positive fuzz values
+// of the fields they are for, under the names generateByType gives them.
+func (t *Test) addExampleSamples(prefix string, schema mqswag.SchemaRef, example interface{}, swagger *mqswag.Swagger) {
+	if example == nil || schema.Value == nil {
+		return
+	}
+	if _, referredSchema, err := swagger.GetReferredSchema(schema); err == nil && referredSchema.Value != nil {
+		t.addExampleSamples(prefix, referredSchema, example, swagger)
+		return
+	}
+	for _, s := range schema.Value.AllOf {
+		t.addExampleSamples(prefix, (mqswag.SchemaRef)(*s), example, swagger)
+	}
+	for _, s := range append(append([]*spec.SchemaRef{}, schema.Value.OneOf...), schema.Value.AnyOf...) {
+		if (mqswag.SchemaRef)(*s).Matches(example, swagger, t.ctx.Logger) {
+			t.addExampleSamples(prefix, (mqswag.SchemaRef)(*s), example, swagger)
+		}
+	}
+	switch schema.Value.Type {
+	case "", gojsonschema.TYPE_OBJECT:
+		exampleMap, _ := example.(map[string]interface{})
+		for k, v := range schema.Value.Properties {
+			t.addExampleSamples(k+"_", (mqswag.SchemaRef)(*v), exampleMap[k], swagger)
+		}
+	case gojsonschema.TYPE_ARRAY:
+		exampleItems, _ := example.([]interface{})
+		for _, item := range exampleItems {
+			if schema.Value.Items != nil {
+				t.addExampleSamples(prefix, (mqswag.SchemaRef)(*schema.Value.Items), item, swagger)
+			}
+		}
+	default:
+		if len(schema.Value.Enum) != 0 || !mqswag.Validate(schema, example) {
+			return
+		}
+		t.addPositiveSample(strings.ReplaceAll(prefix, "_", ""), example)
+	}
+}
+
+// addPositiveSample adds an example to the positive fuzz values of the field name, once.
+func (t *Test) addPositiveSample(name string, example interface{}) {
+	for _, v := range t.sampleSpace[name] {
+		if v.FuzzType == mqutil.FuzzPositive && reflect.DeepEqual(v.Value, example) {
+			return
+		}
 	}
+	t.sampleSpace[name] = append(t.sampleSpace[name], mqutil.FuzzValue{Value: example, FuzzType: mqutil.FuzzPositive})
+}
 
-	return t.generateByType(schema, paramSpec.Name, tag, paramSpec, true)
+// fuzzStatus returns the status expectation of the negative fuzz requests.
+func (t *Test) fuzzStatus() interface{} {
+	if t.FuzzStatus != nil {
+		return t.FuzzStatus
+	}
+	if t.suite != nil && t.suite.FuzzStatus != nil {
+		return t.suite.FuzzStatus
+	}
+	return StatusCodeBadRequest
+}
+
+// useExamples returns whether documented examples are preferred over random values.
+func (t *Test) useExamples() bool {
+	return t.Examples || (t.suite != nil && t.suite.Examples)
 }
 
 // Two ways to get to generateByType
 // 1) directly called from GenerateParameter, now we know the type is a parameter, and we want to add to comparison
 // 2) called at bottom level, here we know the object will be added to comparison and not the type primitives.
-func (t *Test) generateByType(s mqswag.SchemaRef, prefix string, parentTag *mqswag.MeqaTag, paramSpec *spec.Parameter, print bool) (interface{}, error) {
-	tag := mqswag.GetMeqaTag(s.Value.Description)
+func (t *Test) generateByType(s mqswag.SchemaRef, prefix string, parentTag *mqswag.MeqaTag, paramSpec *spec.Parameter, example interface{}, print bool) (interface{}, error) {
+	tag := mqswag.GetSchemaTag(s.Value, t.ctx.Logger)
 	if tag == nil {
 		tag = parentTag
 	}
@@ -1297,7 +1499,7 @@ func (t *Test) generateByType(s mqswag.SchemaRef, prefix string, parentTag *mqsw
 				if c.old != nil {
 					c.oldUsed[tag.Property] = c.old[tag.Property]
 					if print {
-						fmt.Printf("found %s.%s\n", tag.Class, tag.Property)
+						fmt.Fprintf(t.out(), "found %s.%s\n", tag.Class, tag.Property)
 					}
 					return c.old[tag.Property], nil
 				}
@@ -1313,7 +1515,7 @@ func (t *Test) generateByType(s mqswag.SchemaRef, prefix string, parentTag *mqsw
 				comp.oldUsed[tag.Property] = comp.old[tag.Property]
 				t.comparisons[tag.Class] = append(t.comparisons[tag.Class], comp)
 				if print {
-					fmt.Printf("found %s.%s\n", tag.Class, tag.Property)
+					fmt.Fprintf(t.out(), "found %s.%s\n", tag.Class, tag.Property)
 				}
 				return obj[tag.Property], nil
 			}
@@ -1321,17 +1523,29 @@ func (t *Test) generateByType(s mqswag.SchemaRef, prefix string, parentTag *mqsw
 	}
 
 	if len(s.Value.Type) != 0 {
-		if print {
-			fmt.Print("random\n")
+		var result interface{}
+		var err error
+		if example != nil && t.useExamples() {
+			if print {
+				fmt.Fprint(t.out(), "example\n")
+			}
+			result = example
+		} else {
+			if print {
+				fmt.Fprint(t.out(), "random\n")
+			}
+			result, err = generateValue(s.Value.Type, s, prefix)
 		}
-		result, err := generateValue(s.Value.Type, s, prefix)
 		name := strings.ReplaceAll(prefix, "_", "")
 		if result != nil && err == nil {
 			t.AddBasicComparison(tag, paramSpec, result)
 		}
 		// Add positive cases to list possible values for the field
 		if t.suite.plan.FuzzType == mqutil.FuzzPositive || t.suite.plan.FuzzType == mqutil.FuzzAll {
-			for _, c := range mqswag.Dataset.Positive[s.Value.Type] {
+			if example != nil && mqswag.Validate(s, example) {
+				t.addPositiveSample(name, example)
+			}
+			for _, c := range t.ctx.Dataset.Positive[s.Value.Type] {
 				if mqswag.Validate(s, c) {
 					fuzzValue := mqutil.FuzzValue{Value: c, FuzzType: mqutil.FuzzPositive}
 					t.sampleSpace[name] = append(t.sampleSpace[name], fuzzValue)
@@ -1340,10 +1554,12 @@ func (t *Test) generateByType(s mqswag.SchemaRef, prefix string, parentTag *mqsw
 		}
 		// Add values of a different datatype than what's expected in the field
 		if (t.suite.plan.FuzzType == mqutil.FuzzDataType || t.suite.plan.FuzzType == mqutil.FuzzAll) && s.Value.Type != gojsonschema.TYPE_STRING {
-			s.Value.Format = ""
+			// The other types are generated without the format, on a copy of the shared schema.
+			noFormat := *s.Value
+			noFormat.Format = ""
 			for _, valueType := range dataTypes {
 				if valueType != s.Value.Type {
-					res, err := generateValue(valueType, s, prefix)
+					res, err := generateValue(valueType, mqswag.SchemaRef{Ref: s.Ref, Value: &noFormat}, prefix)
 					fuzzValue := mqutil.FuzzValue{Value: res, FuzzType: mqutil.FuzzDataType}
 					if res != nil && err == nil {
 						t.sampleSpace[name] = append(t.sampleSpace[name], fuzzValue)
@@ -1353,7 +1569,7 @@ func (t *Test) generateByType(s mqswag.SchemaRef, prefix string, parentTag *mqsw
 		}
 		// Add negative cases to the list of fuzzable values for the field
 		if t.suite.plan.FuzzType == mqutil.FuzzNegative || t.suite.plan.FuzzType == mqutil.FuzzAll {
-			for _, c := range mqswag.Dataset.Negative[s.Value.Type] {
+			for _, c := range t.ctx.Dataset.Negative[s.Value.Type] {
 				if !mqswag.Validate(s, c) {
 					fuzzValue := mqutil.FuzzValue{Value: c, FuzzType: mqutil.FuzzNegative}
 					t.sampleSpace[name] = append(t.sampleSpace[name], fuzzValue)
@@ -1412,8 +1628,9 @@ func RandomTime(t time.Time, r time.Duration) time.Time {
 // date ranges. Prefix is a prefix to use when generating strings. It's only used when there is
 // no specified pattern in the swagger.json
 func generateString(s mqswag.SchemaRef, prefix string) (string, error) {
-	if len(s.Value.Pattern) == 0 {
-		s.Value.Pattern = generatePattern(s.Value.Format)
+	pattern := s.Value.Pattern
+	if len(pattern) == 0 {
+		pattern = generatePattern(s.Value.Format)
 	}
 	if s.Value.Format == "date-time" {
 		t := RandomTime(time.Now().UTC(), time.Hour*24*30)
@@ -1429,11 +1646,9 @@ func generateString(s mqswag.SchemaRef, prefix string) (string, error) {
 	}
 
 	// If no pattern is specified, we use the field name + some numbers as pattern
-	var pattern string
 	length := 0
-	if len(s.Value.Pattern) != 0 {
-		pattern = s.Value.Pattern
-		length = len(s.Value.Pattern) * 2
+	if len(pattern) != 0 {
+		length = len(pattern) * 2
 	} else {
 		pattern = prefix + "\\d{6,}"
 		length = len(prefix) * 3
@@ -1507,10 +1722,12 @@ func generateFloat(s mqswag.SchemaRef) (float64, error) {
 }
 
 func generateInt(s mqswag.SchemaRef) (int64, error) {
-	// Give a default range if there isn't one
+	// Give a default range if there isn't one, on a copy of the shared schema
 	if s.Value.Max == nil && s.Value.Min == nil {
 		maxf := 1000000.0
-		s.Value.Max = &maxf
+		bounded := *s.Value
+		bounded.Max = &maxf
+		s = mqswag.SchemaRef{Ref: s.Ref, Value: &bounded}
 	}
 	f, err := generateFloat(s)
 	if err != nil {
@@ -1523,7 +1740,7 @@ func generateInt(s mqswag.SchemaRef) (int64, error) {
 	return i, nil
 }
 
-func (t *Test) generateArray(name string, parentTag *mqswag.MeqaTag, schema mqswag.SchemaRef, db *mqswag.DB, level int) (interface{}, error) {
+func (t *Test) generateArray(name string, parentTag *mqswag.MeqaTag, schema mqswag.SchemaRef, example interface{}, db *mqswag.DB, level int) (interface{}, error) {
 	var numItems int
 	if schema.Value.MaxItems != nil || schema.Value.MinItems > 0 {
 		var maxItems int = 10
@@ -1549,8 +1766,19 @@ func (t *Test) generateArray(name string, parentTag *mqswag.MeqaTag, schema mqsw
 	if numItems <= 0 {
 		numItems = 1
 	}
+	// Each item gets its example from the array's example.
+	exampleItems, _ := example.([]interface{})
+	itemExample := func(i int) interface{} {
+		if len(exampleItems) == 0 {
+			return nil
+		}
+		return exampleItems[i%len(exampleItems)]
+	}
+	if len(exampleItems) > 0 && t.useExamples() {
+		numItems = len(exampleItems) - 1 // one more entry is generated below
+	}
 	itemSchema := (mqswag.SchemaRef)(*schema.Value.Items)
-	tag := mqswag.GetMeqaTag(schema.Value.Description)
+	tag := mqswag.GetSchemaTag(schema.Value, t.ctx.Logger)
 	if tag == nil {
 		tag = parentTag
 	}
@@ -1561,8 +1789,8 @@ func (t *Test) generateArray(name string, parentTag *mqswag.MeqaTag, schema mqsw
 		hash = make(map[interface{}]interface{})
 	}
 
-	generateOneEntry := func() error {
-		entry, err := t.GenerateSchema(name, tag, itemSchema, db, level)
+	generateOneEntry := func(example interface{}) error {
+		entry, err := t.GenerateSchema(name, tag, itemSchema, example, db, level)
 		if err != nil {
 			return err
 		}
@@ -1580,13 +1808,13 @@ func (t *Test) generateArray(name string, parentTag *mqswag.MeqaTag, schema mqsw
 	}
 
 	// we only print one entry
-	err := generateOneEntry()
+	err := generateOneEntry(itemExample(0))
 	if err != nil {
 		return nil, err
 	}
 	level = 0 // this will supress prints
 	for i := 0; i < numItems; i++ {
-		err = generateOneEntry()
+		err = generateOneEntry(itemExample(i + 1))
 		if err != nil {
 			return nil, err
 		}
@@ -1594,8 +1822,9 @@ func (t *Test) generateArray(name string, parentTag *mqswag.MeqaTag, schema mqsw
 	return ar, nil
 }
 
-func (t *Test) generateObject(name string, parentTag *mqswag.MeqaTag, schema mqswag.SchemaRef, db *mqswag.DB, level int) (interface{}, error) {
+func (t *Test) generateObject(name string, parentTag *mqswag.MeqaTag, schema mqswag.SchemaRef, example interface{}, db *mqswag.DB, level int) (interface{}, error) {
 	obj := make(map[string]interface{})
+	exampleMap, _ := example.(map[string]interface{})
 	var spaces string
 	var nextLevel int
 	if level > 0 {
@@ -1603,31 +1832,45 @@ func (t *Test) generateObject(name string, parentTag *mqswag.MeqaTag, schema mqs
 		nextLevel = level + 1
 	}
 	if level != 0 {
-		fmt.Println("")
+		fmt.Fprintln(t.out(), "")
 	}
 	for k, v := range schema.Value.Properties {
 		if level != 0 {
-			fmt.Printf("%s%s . ", spaces, k)
+			fmt.Fprintf(t.out(), "%s%s . ", spaces, k)
 		}
 		if t.suite.BodyParams != nil {
 			if o, ok := t.suite.BodyParams.(map[string]interface{})[k]; ok {
 				if o != nil {
 					obj[k] = o
-					fmt.Println("found")
+					fmt.Fprintln(t.out(), "found")
 				} else {
-					fmt.Println("skipping")
+					fmt.Fprintln(t.out(), "skipping")
 				}
 				continue
 			}
 		}
-		o, err := t.GenerateSchema(k+"_", nil, (mqswag.SchemaRef)(*v), db, nextLevel)
+		if v.Value != nil && v.Value.ReadOnly {
+			// The server sets these, they don't belong in requests.
+			if level != 0 {
+				fmt.Fprintln(t.out(), "readOnly")
+			}
+			continue
+		}
+		if v.Value != nil && v.Value.Nullable && rand.Intn(NullChance) == 0 {
+			if level != 0 {
+				fmt.Fprintln(t.out(), "null")
+			}
+			obj[k] = nil
+			continue
+		}
+		o, err := t.GenerateSchema(k+"_", nil, (mqswag.SchemaRef)(*v), exampleMap[k], db, nextLevel)
 		if err != nil {
 			return nil, err
 		}
 		obj[k] = o
 	}
 
-	tag := mqswag.GetMeqaTag(schema.Value.Description)
+	tag := mqswag.GetSchemaTag(schema.Value, t.ctx.Logger)
 	if tag == nil {
 		tag = parentTag
 	}
@@ -1638,12 +1881,16 @@ func (t *Test) generateObject(name string, parentTag *mqswag.MeqaTag, schema mqs
 	return obj, nil
 }
 
-// The parentTag passed in is what the higher level thinks this schema object should be.
-func (t *Test) GenerateSchema(name string, parentTag *mqswag.MeqaTag, schema mqswag.SchemaRef, db *mqswag.DB, level int) (interface{}, error) {
+// The parentTag passed in is what the higher level thinks this schema object should be. The example is the
+// documented example for the value from higher up, if any. It takes precedence over the schema's example.
+func (t *Test) GenerateSchema(name string, parentTag *mqswag.MeqaTag, schema mqswag.SchemaRef, example interface{}, db *mqswag.DB, level int) (interface{}, error) {
 	swagger := db.Swagger
+	if example == nil {
+		example = schema.Value.Example
+	}
 
 	// The tag that's closest to the object takes priority, much like child class can override parent class.
-	tag := mqswag.GetMeqaTag(schema.Value.Description)
+	tag := mqswag.GetSchemaTag(schema.Value, t.ctx.Logger)
 	if tag == nil {
 		tag = parentTag
 	}
@@ -1663,26 +1910,39 @@ func (t *Test) GenerateSchema(name string, parentTag *mqswag.MeqaTag, schema mqs
 			}
 			if len(found) > 0 {
 				if level != 0 {
-					fmt.Printf("found %s\n", referenceName)
+					fmt.Fprintf(t.out(), "found %s\n", referenceName)
 				}
 				return found[0], nil
 			}
 		}
-		return t.GenerateSchema(name, &mqswag.MeqaTag{referenceName, "", "", 0}, referredSchema, db, level)
+		return t.GenerateSchema(name, &mqswag.MeqaTag{Class: referenceName}, referredSchema, example, db, level)
 	}
 
 	if len(schema.Value.Enum) != 0 {
+		if example != nil && t.useExamples() {
+			if level != 0 {
+				fmt.Fprint(t.out(), "example\n")
+			}
+			return example, nil
+		}
 		if level != 0 {
-			fmt.Print("enum\n")
+			fmt.Fprint(t.out(), "enum\n")
 		}
 		return generateEnum(schema.Value.Enum)
 	}
 
+	if schema.Value.Not != nil {
+		return t.generateNot(name, tag, schema, example, db, level)
+	}
+	if len(schema.Value.OneOf) > 0 || len(schema.Value.AnyOf) > 0 {
+		return t.generateOneOf(name, tag, schema, example, db, level)
+	}
+
 	if len(schema.Value.AllOf) > 0 {
 		combined := make(map[string]interface{})
 		discriminator := ""
 		for _, s := range schema.Value.AllOf {
-			m, err := t.GenerateSchema(name, nil, (mqswag.SchemaRef)(*s), db, level)
+			m, err := t.GenerateSchema(name, nil, (mqswag.SchemaRef)(*s), example, db, level)
 			if err != nil {
 				return nil, err
 			}
@@ -1713,16 +1973,89 @@ func (t *Test) GenerateSchema(name string, parentTag *mqswag.MeqaTag, schema mqs
 
 	if len(schema.Value.Type) == 0 {
 		// return nil, mqutil.NewError(mqutil.ErrInvalid, "Parameter doesn't have type")
-		return t.generateObject(name, tag, schema, db, level)
+		return t.generateObject(name, tag, schema, example, db, level)
 	}
 	if schema.Value.Type == gojsonschema.TYPE_OBJECT {
-		return t.generateObject(name, tag, schema, db, level)
+		return t.generateObject(name, tag, schema, example, db, level)
 	}
 	if schema.Value.Type == gojsonschema.TYPE_ARRAY {
-		return t.generateArray(name, tag, schema, db, level)
+		return t.generateArray(name, tag, schema, example, db, level)
+	}
+
+	return t.generateByType(schema, name, tag, nil, example, level != 0)
+}
+
+// generateOneOf generates a value for one of the oneOf or anyOf schemas, picked at random. With a
+// discriminator the schema is picked from its mapping and the discriminator property is set. An
+// example is only passed on to the schema it matches.
+func (t *Test) generateOneOf(name string, parentTag *mqswag.MeqaTag, schema mqswag.SchemaRef, example interface{}, db *mqswag.DB, level int) (interface{}, error) {
+	if d := schema.Value.Discriminator; d != nil && len(d.PropertyName) > 0 {
+		mapping := db.Swagger.DiscriminatorMapping(schema)
+		var values []string
+		for value := range mapping {
+			values = append(values, value)
+		}
+		if len(values) > 0 {
+			sort.Strings(values)
+			value := values[rand.Intn(len(values))]
+			exampleMap, _ := example.(map[string]interface{})
+			if v, ok := exampleMap[d.PropertyName].(string); ok && mapping[v].Value != nil && t.useExamples() {
+				value = v
+			} else if exampleMap[d.PropertyName] != value {
+				example = nil
+			}
+			o, err := t.GenerateSchema(name, parentTag, mapping[value], example, db, level)
+			if obj, isMap := o.(map[string]interface{}); isMap && obj[d.PropertyName] != value {
+				// Don't change the objects we got from the DB.
+				obj = mqutil.MapCopy(obj)
+				obj[d.PropertyName] = value
+				o = obj
+			}
+			return o, err
+		}
+	}
+	alternatives := schema.Value.OneOf
+	if len(alternatives) == 0 {
+		alternatives = schema.Value.AnyOf
+	}
+	alternative := (mqswag.SchemaRef)(*alternatives[rand.Intn(len(alternatives))])
+	if example != nil {
+		for _, s := range alternatives {
+			if t.useExamples() && (mqswag.SchemaRef)(*s).Matches(example, db.Swagger, t.ctx.Logger) {
+				alternative = (mqswag.SchemaRef)(*s)
+				break
+			}
+		}
+		if !alternative.Matches(example, db.Swagger, t.ctx.Logger) {
+			example = nil
+		}
 	}
+	return t.GenerateSchema(name, parentTag, alternative, example, db, level)
+}
 
-	return t.generateByType(schema, name, tag, nil, level != 0)
+// generateNot generates values for the schema without its not until one doesn't match the not. If the
+// schema has nothing but the not, values of random types are tried.
+func (t *Test) generateNot(name string, parentTag *mqswag.MeqaTag, schema mqswag.SchemaRef, example interface{}, db *mqswag.DB, level int) (interface{}, error) {
+	not := (mqswag.SchemaRef)(*schema.Value.Not)
+	for i := 0; i < MaxRetries; i++ {
+		s := *schema.Value
+		s.Not = nil
+		if i > 0 {
+			// The example didn't do.
+			example, s.Example = nil, nil
+		}
+		if len(s.Type) == 0 && len(s.Properties) == 0 && len(s.AllOf) == 0 && len(s.OneOf) == 0 && len(s.AnyOf) == 0 && len(s.Enum) == 0 {
+			s.Type = dataTypes[rand.Intn(len(dataTypes))]
+		}
+		o, err := t.GenerateSchema(name, parentTag, mqswag.SchemaRef{Value: &s}, example, db, level)
+		if err != nil {
+			return nil, err
+		}
+		if !not.Matches(o, db.Swagger, t.ctx.Logger) {
+			return o, nil
+		}
+	}
+	return nil, mqutil.NewError(mqutil.ErrInvalid, fmt.Sprintf("can't generate a value for %s that doesn't match its not schema", name))
 }
 
 func generateEnum(e []interface{}) (interface{}, error) {
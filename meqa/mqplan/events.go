@@ -0,0 +1,93 @@
+package mqplan
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+)
+
+// testEvent is the JSON object written to the events stream for each executed test.
+type testEvent struct {
+	Time           string      `json:"time"`
+	Suite          string      `json:"suite"`
+	Test           string      `json:"test"`
+	Path           string      `json:"path"`
+	Method         string      `json:"method"`
+	Identity       string      `json:"identity,omitempty"`
+	Params         *TestParams `json:"params"`
+	ExpectedStatus interface{} `json:"expectedStatus"`
+	ActualStatus   int         `json:"actualStatus,omitempty"`
+	DurationMs     float64     `json:"durationMs"`
+	Result         string      `json:"result"`
+	ErrorType      *int        `json:"errorType,omitempty"`
+	ErrorClass     string      `json:"errorClass,omitempty"`
+	Error          string      `json:"error,omitempty"`
+	SchemaError    string      `json:"schemaError,omitempty"`
+	ContentError   string      `json:"contentError,omitempty"`
+	HeaderError    string      `json:"headerError,omitempty"`
+	FuzzFailures   int         `json:"fuzzFailures,omitempty"`
+	EventError     string      `json:"eventError,omitempty"`
+}
+
+func (t *Test) event(suiteName string) *testEvent {
+	e := &testEvent{
+		Time:           time.Now().Format(time.RFC3339Nano),
+		Suite:          suiteName,
+		Test:           t.Name,
+		Path:           t.Path,
+		Method:         t.Method,
+		Identity:       t.As,
+		Params:         &t.TestParams,
+		ExpectedStatus: t.expectedStatus,
+		Result:         mqutil.Passed,
+		FuzzFailures:   len(t.fuzzFailures),
+	}
+	if t.resp != nil {
+		e.ActualStatus = t.resp.StatusCode()
+		e.DurationMs = float64(t.stopTime.Sub(t.startTime)) / float64(time.Millisecond)
+	}
+	if t.err != nil {
+		e.Result = mqutil.Failed
+		e.Error = errorMessage(t.err)
+		if typedErr, ok := t.err.(*mqutil.TypedError); ok {
+			errType := typedErr.Type()
+			e.ErrorType = &errType
+			e.ErrorClass = mqutil.ErrTypeName(errType)
+		}
+	}
+	if t.schemaError != nil {
+		e.SchemaError = errorMessage(t.schemaError)
+	}
+	if t.contentError != nil {
+		e.ContentError = errorMessage(t.contentError)
+	}
+	if t.headerError != nil {
+		e.HeaderError = errorMessage(t.headerError)
+	}
+	return e
+}
+
+// writeEvent writes the event for the finished test to plan.Events, if it's set. Each event is
+// written out right away so the stream is usable even if the run doesn't finish.
+func (plan *TestPlan) writeEvent(suiteName string, t *Test) {
+	if plan.Events == nil {
+		return
+	}
+	e := t.event(suiteName)
+	b, err := json.Marshal(e)
+	if err != nil {
+		plan.ctx.Logger.Printf("can't marshal the event for test %s: %s", t.Name, err.Error())
+		// The parameters and the expected status are what can't be marshaled, e.g. a NaN. The event
+		// is written without them.
+		e.Params, e.ExpectedStatus = nil, nil
+		e.EventError = err.Error()
+		b, _ = json.Marshal(e)
+	}
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	_, err = plan.Events.Write(append(b, '\n'))
+	if err != nil {
+		plan.ctx.Logger.Printf("can't write the event for test %s: %s", t.Name, err.Error())
+	}
+}
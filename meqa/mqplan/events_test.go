@@ -0,0 +1,104 @@
+package mqplan
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+)
+
+func TestWriteEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := reportServer()
+	defer server.Close()
+	plan := loadPlan(t, dir, thingsSpec, reportPlan, server.URL)
+	events := &bytes.Buffer{}
+	plan.Events = events
+	plan.RunAll([]string{"get suite", "post suite"}, 2)
+
+	// An event per executed test, the skipped one has none.
+	lines := strings.Split(strings.TrimSpace(events.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 events, got %s", events.String())
+	}
+	got := make(map[string]map[string]interface{})
+	for _, line := range lines {
+		var e map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("expected a JSON event, got %s", line)
+		}
+		got[e["suite"].(string)+"/"+e["test"].(string)] = e
+	}
+	for name, want := range map[string]struct {
+		method, result string
+		status         float64
+	}{
+		"get suite/get_thing":   {"get", mqutil.Passed, 200},
+		"get suite/get_broken":  {"get", mqutil.Failed, 500},
+		"post suite/post_thing": {"post", mqutil.Failed, 400},
+	} {
+		e := got[name]
+		if e == nil || e["method"] != want.method || e["result"] != want.result || e["actualStatus"] != want.status {
+			t.Errorf("expected %s to be %s with status %v, got %v", name, want.result, want.status, e)
+			continue
+		}
+		if _, ok := e["durationMs"].(float64); !ok || e["time"] == nil || e["params"] == nil {
+			t.Errorf("expected %s to have its time, duration and params, got %v", name, e)
+		}
+		if msg, _ := e["error"].(string); (want.result == mqutil.Failed) != (len(msg) > 0) {
+			t.Errorf("expected only the failed tests to have an error, got %v", e)
+		}
+	}
+}
+
+// Every test has an event, without the backtrace of its errors, even when it can't be marshaled.
+func TestWriteEventsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The name of the thing isn't a string.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 1, "name": true}`))
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, mismatchSpec, "things suite:\n- name: post_thing\n  path: /things\n  method: post\n"+
+		"- name: post_nan\n  path: /things\n  method: post\n  expect:\n    status: .nan\n", server.URL)
+	events := &bytes.Buffer{}
+	plan.Events = events
+	plan.RunAll([]string{"things suite"}, 1)
+
+	lines := strings.Split(strings.TrimSpace(events.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 events, got %s", events.String())
+	}
+	for i, name := range []string{"post_thing", "post_nan"} {
+		var e map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &e); err != nil {
+			t.Fatalf("expected a JSON event, got %s", lines[i])
+		}
+		if e["test"] != name || e["actualStatus"] != float64(201) {
+			t.Errorf("expected the event of %s, got %s", name, lines[i])
+		}
+		schemaError, _ := e["schemaError"].(string)
+		if name == "post_thing" && (!strings.Contains(schemaError, "don't match") || strings.Contains(schemaError, "Backtrace")) {
+			t.Errorf("expected the schema error without the backtrace, got %q", schemaError)
+		}
+		if eventError, _ := e["eventError"].(string); (name == "post_nan") != strings.Contains(eventError, "NaN") {
+			t.Errorf("expected only the NaN to fail the marshaling, got %q for %s", eventError, name)
+		}
+	}
+}
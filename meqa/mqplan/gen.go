@@ -2,7 +2,9 @@ package mqplan
 
 import (
 	"fmt"
+	"log"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
@@ -46,7 +48,7 @@ func GetLastPathParam(name string) string {
 			break
 		}
 	}
-	if last[0] == '{' && last[len(last)-1] == '}' {
+	if len(last) > 1 && last[0] == '{' && last[len(last)-1] == '}' {
 		return last[1 : len(last)-1]
 	}
 	return ""
@@ -66,10 +68,10 @@ func CreateTestFromOp(opNode *mqswag.DAGNode, testId int) *Test {
 	return t
 }
 
-func OperationMatches(node *mqswag.DAGNode, method string) bool {
+func OperationMatches(node *mqswag.DAGNode, method string, logger *log.Logger) bool {
 	op, ok := node.Data.(*spec.Operation)
 	if ok && op != nil {
-		tag := mqswag.GetMeqaTag(op.Description)
+		tag := mqswag.GetOperationTag(op, logger)
 		if (tag != nil && tag.Operation == method) || ((tag == nil || len(tag.Operation) == 0) && node.GetMethod() == method) {
 			return true
 		}
@@ -99,7 +101,7 @@ func GenerateTestsForObject(create *mqswag.DAGNode, obj *mqswag.DAGNode, plan *T
 		}
 		testId++
 		testSuite.Tests = append(testSuite.Tests, CreateTestFromOp(child, testId))
-		if OperationMatches(child, mqswag.MethodDelete) {
+		if OperationMatches(child, mqswag.MethodDelete, plan.ctx.Logger) {
 			testId++
 			testSuite.Tests = append(testSuite.Tests, CreateTestFromOp(create, testId))
 		}
@@ -117,7 +119,7 @@ func GenerateTestsForObject(create *mqswag.DAGNode, obj *mqswag.DAGNode, plan *T
 		j := rand.Intn(len(obj.Children))
 		child := obj.Children[j]
 		if child.GetType() != mqswag.TypeOp {
-			mqutil.Logger.Printf("unexpected: (%s) has a child (%s) that's not an operation", obj.Name, child.Name)
+			plan.ctx.Logger.Printf("unexpected: (%s) has a child (%s) that's not an operation", obj.Name, child.Name)
 			continue
 		}
 		testId++
@@ -133,9 +135,9 @@ func GenerateTestsForObject(create *mqswag.DAGNode, obj *mqswag.DAGNode, plan *T
 	return nil
 }
 
-func GenerateTestPlan(swagger *mqswag.Swagger, dag *mqswag.DAG) (*TestPlan, error) {
+func GenerateTestPlan(ctx *Context, dag *mqswag.DAG) (*TestPlan, error) {
 	testPlan := &TestPlan{}
-	testPlan.Init(swagger, nil)
+	testPlan.Init(ctx)
 	testPlan.comment = `
 This test plan has test suites that are about objects. Each test suite create an object,
 then exercise REST calls that use that object as an input.
@@ -188,7 +190,7 @@ func GeneratePathTestSuite(operations mqswag.NodeList, plan *TestPlan) {
 		testId++
 		currentTest := CreateTestFromOp(o, testId)
 		testSuite.Tests = append(testSuite.Tests, currentTest)
-		if OperationMatches(o, mqswag.MethodPost) && !strings.Contains(o.GetName(), fmt.Sprintf("{%s}", idTag)) {
+		if OperationMatches(o, mqswag.MethodPost, plan.ctx.Logger) && !strings.Contains(o.GetName(), fmt.Sprintf("{%s}", idTag)) {
 			// Store the creation test to use the object id later
 			createTest = currentTest
 		} else if strings.Contains(o.GetName(), fmt.Sprintf("{%s}", idTag)) {
@@ -197,14 +199,14 @@ func GeneratePathTestSuite(operations mqswag.NodeList, plan *TestPlan) {
 			currentTest.PathParams[idTag] = fmt.Sprintf("{{%s.outputs.%s}}", createTest.Name, idTag)
 		}
 		// Add a negative test case after deleting the object to confirm delete worked
-		if OperationMatches(o, mqswag.MethodDelete) {
+		if OperationMatches(o, mqswag.MethodDelete, plan.ctx.Logger) {
 			lastTest := testSuite.Tests[len(testSuite.Tests)-1]
 			// Find an operation that takes the same last path param.
 			lastParam := GetLastPathParam(o.GetName())
 			if len(lastParam) > 0 {
 				for _, repeatOp := range operations {
 					// Try to GET the object we just deleted to confirm it no longer exists
-					if lastParam == GetLastPathParam(repeatOp.GetName()) && OperationMatches(repeatOp, mqswag.MethodGet) {
+					if lastParam == GetLastPathParam(repeatOp.GetName()) && OperationMatches(repeatOp, mqswag.MethodGet, plan.ctx.Logger) {
 						testId++
 						repeatTest := CreateTestFromOp(repeatOp, testId)
 						repeatTest.PathParams = make(map[string]interface{})
@@ -242,18 +244,9 @@ func (n PathWeightList) Less(i, j int) bool {
 	return n[i].weight < n[j].weight || (n[i].weight == n[j].weight && n[i].path < n[j].path)
 }
 
-// Go through all the paths in swagger, and generate the tests for all the operations under
-// the path.
-func GeneratePathTestPlan(swagger *mqswag.Swagger, dag *mqswag.DAG, allowedAPIs, ignoredPaths map[string]bool) (*TestPlan, error) {
-	testPlan := &TestPlan{}
-	testPlan.Init(swagger, nil)
-	testPlan.comment = `
-In this test plan, the test suites are the REST paths, and the tests are the different
-operations under the path. The tests under the same suite will share each others'
-parameters by default.
-	`
-	addInitTestSuite(testPlan)
-
+// groupOperationsByPath groups the operations in the dag by their path without the path params
+// suffix, and sorts the paths by weight.
+func groupOperationsByPath(dag *mqswag.DAG, ignoredPaths map[string]bool) (map[string]mqswag.NodeList, PathWeightList) {
 	pathMap := make(map[string]mqswag.NodeList)
 	pathWeight := make(map[string]int)
 
@@ -297,7 +290,22 @@ parameters by default.
 		pathWeightList = append(pathWeightList, p)
 	}
 	sort.Sort(pathWeightList)
+	return pathMap, pathWeightList
+}
 
+// Go through all the paths in swagger, and generate the tests for all the operations under
+// the path.
+func GeneratePathTestPlan(ctx *Context, dag *mqswag.DAG, allowedAPIs, ignoredPaths map[string]bool) (*TestPlan, error) {
+	testPlan := &TestPlan{}
+	testPlan.Init(ctx)
+	testPlan.comment = `
+In this test plan, the test suites are the REST paths, and the tests are the different
+operations under the path. The tests under the same suite will share each others'
+parameters by default.
+	`
+	addInitTestSuite(testPlan)
+
+	pathMap, pathWeightList := groupOperationsByPath(dag, ignoredPaths)
 	for _, p := range pathWeightList {
 		if allowedAPIs == nil || allowedAPIs[p.path] {
 			GeneratePathTestSuite(pathMap[p.path], testPlan)
@@ -306,11 +314,203 @@ parameters by default.
 	return testPlan, nil
 }
 
+// The identities of the BOLA test plan by default.
+const (
+	BolaOwner    = "owner"
+	BolaAttacker = "attacker"
+)
+
+// createdIdField returns the field of the objects created by create that goes in the param of
+// their path: the param itself if the successful responses have such a property, id otherwise.
+func createdIdField(create *mqswag.DAGNode, param string, swagger *mqswag.Swagger) string {
+	op := create.Data.(*spec.Operation)
+	for code, resp := range op.Responses {
+		if c, err := strconv.Atoi(code); code != "default" && (err != nil || c < 200 || c >= 300) {
+			continue
+		}
+		if resp == nil || resp.Value == nil {
+			continue
+		}
+		for _, mediaType := range resp.Value.Content {
+			if mediaType.Schema == nil || mediaType.Schema.Value == nil {
+				continue
+			}
+			if _, ok := (mqswag.SchemaRef)(*mediaType.Schema).GetProperties(swagger)[param]; ok {
+				return param
+			}
+		}
+	}
+	return "id"
+}
+
+// GenerateBolaTestSuite generates the broken object level authorization test suites for the
+// operations of a path, one for each create. The owner creates an object with the POST, then the
+// attacker tries the GET, PUT and DELETE on the path of the object, expecting 403 or 404. Finally
+// the owner reads the object to check it's intact, and deletes it. The objects of a nested create,
+// like the POST on /users/{userId}/posts, are created in a user the owner creates first. No suite
+// is generated for a create without object operations.
+func GenerateBolaTestSuite(operations mqswag.NodeList, plan *TestPlan, owner, attacker string) {
+	sort.Sort(mqswag.ByMethodPriority(operations))
+	for _, o := range operations {
+		if OperationMatches(o, mqswag.MethodPost, plan.ctx.Logger) && len(GetLastPathParam(o.GetName())) == 0 {
+			generateBolaTestSuite(o, operations, plan, owner, attacker)
+		}
+	}
+}
+
+// bolaObjectOps returns the operations on the objects the create creates, i.e. the ones on the
+// path of the create with an id param, with the given methods.
+func bolaObjectOps(create *mqswag.DAGNode, operations mqswag.NodeList, methods ...string) mqswag.NodeList {
+	var objectOps mqswag.NodeList
+	for _, o := range operations {
+		lastParam := GetLastPathParam(o.GetName())
+		if len(lastParam) == 0 || o.GetName() != strings.TrimSuffix(create.GetName(), "/")+"/{"+lastParam+"}" {
+			continue
+		}
+		for _, m := range methods {
+			if o.GetMethod() == m {
+				objectOps = append(objectOps, o)
+			}
+		}
+	}
+	return objectOps
+}
+
+// bolaParents returns the creates of the objects the objects of the create are nested in, the
+// outermost first. If one of them isn't found, it returns the path param of its objects.
+func bolaParents(create *mqswag.DAGNode, operations mqswag.NodeList, logger *log.Logger) (mqswag.NodeList, string) {
+	var parents mqswag.NodeList
+	segments := strings.Split(strings.TrimSuffix(create.GetName(), "/"), "/")
+	for i, segment := range segments {
+		param := GetLastPathParam(segment)
+		if len(param) == 0 {
+			continue
+		}
+		var parent *mqswag.DAGNode
+		for _, o := range operations {
+			if OperationMatches(o, mqswag.MethodPost, logger) && strings.TrimSuffix(o.GetName(), "/") == strings.Join(segments[:i], "/") {
+				parent = o
+				break
+			}
+		}
+		if parent == nil {
+			return nil, param
+		}
+		parents = append(parents, parent)
+	}
+	return parents, ""
+}
+
+// bolaPathParams returns the path params of a path on or in the objects createTest creates. The
+// params on the path of the create are the ones createTest was sent with, the next one is the id
+// of the object it created.
+func bolaPathParams(createTest *Test, create *mqswag.DAGNode, path string, swagger *mqswag.Swagger) map[string]interface{} {
+	params := make(map[string]interface{})
+	createSegments := len(strings.Split(strings.TrimSuffix(create.GetName(), "/"), "/"))
+	for i, segment := range strings.Split(path, "/") {
+		param := GetLastPathParam(segment)
+		switch {
+		case len(param) == 0:
+		case i < createSegments:
+			params[param] = fmt.Sprintf("{{%s.pathParams.%s}}", createTest.Name, param)
+		case i == createSegments:
+			params[param] = fmt.Sprintf("{{%s.outputs.%s}}", createTest.Name, createdIdField(create, param, swagger))
+		}
+	}
+	return params
+}
+
+// generateBolaTestSuite generates the BOLA test suite of a create, see GenerateBolaTestSuite.
+func generateBolaTestSuite(create *mqswag.DAGNode, operations mqswag.NodeList, plan *TestPlan, owner, attacker string) {
+	objectOps := bolaObjectOps(create, operations, mqswag.MethodGet, mqswag.MethodPut, mqswag.MethodDelete)
+	if len(objectOps) == 0 {
+		return
+	}
+	parents, param := bolaParents(create, operations, plan.ctx.Logger)
+	if len(param) > 0 {
+		plan.ctx.Logger.Printf("No BOLA test suite for %s, no operation creates the %s it's in", create.GetName(), param)
+		return
+	}
+
+	testId := 0
+	testSuite := CreateTestSuite(fmt.Sprintf("%s -- bola", create.GetName()), nil, plan)
+	addTest := func(o *mqswag.DAGNode, as string, pathParams map[string]interface{}) *Test {
+		testId++
+		t := CreateTestFromOp(o, testId)
+		t.As = as
+		t.PathParams = pathParams
+		testSuite.Tests = append(testSuite.Tests, t)
+		return t
+	}
+	// The owner creates the objects the object is in, each in the one created before.
+	var parentTests []*Test
+	var createTest *Test
+	for i, c := range append(parents, create) {
+		var pathParams map[string]interface{}
+		if i > 0 {
+			pathParams = bolaPathParams(parentTests[i-1], parents[i-1], c.GetName(), plan.ctx.Swagger)
+		}
+		createTest = addTest(c, owner, pathParams)
+		if i < len(parents) {
+			parentTests = append(parentTests, createTest)
+		}
+	}
+	objectTest := func(o *mqswag.DAGNode, as string, status interface{}) {
+		t := addTest(o, as, bolaPathParams(createTest, create, o.GetName(), plan.ctx.Swagger))
+		if status != nil {
+			t.Expect = map[string]interface{}{ExpectStatus: status}
+		}
+	}
+	for _, o := range objectOps {
+		objectTest(o, attacker, []interface{}{403, 404})
+	}
+	// The attacker's attempts must not have changed or deleted the object.
+	for _, o := range objectOps {
+		if o.GetMethod() == mqswag.MethodGet {
+			objectTest(o, owner, nil)
+		}
+	}
+	for _, o := range objectOps {
+		if o.GetMethod() == mqswag.MethodDelete {
+			objectTest(o, owner, nil)
+		}
+	}
+	// Then the objects it was in, the innermost first.
+	for i := len(parents) - 1; i >= 0; i-- {
+		for _, o := range bolaObjectOps(parents[i], operations, mqswag.MethodDelete) {
+			addTest(o, owner, bolaPathParams(parentTests[i], parents[i], o.GetName(), plan.ctx.Swagger))
+		}
+	}
+	plan.Add(testSuite)
+}
+
+// GenerateBolaTestPlan generates a broken object level authorization (BOLA/IDOR) test suite for
+// every path, in which an attacker tries to access the objects an owner created. The plan is run
+// with the owner and attacker identities declared, see Identities.
+func GenerateBolaTestPlan(ctx *Context, dag *mqswag.DAG, allowedAPIs, ignoredPaths map[string]bool, owner, attacker string) (*TestPlan, error) {
+	testPlan := &TestPlan{}
+	testPlan.Init(ctx)
+	testPlan.comment = fmt.Sprintf(`
+In this test plan, %s creates an object in each test suite and %s tries to read, update
+and delete it, which should fail with 403 or 404. The plan must be run with the identities
+%s and %s declared, e.g. with mqgo run -identities.
+`, owner, attacker, owner, attacker)
+	addInitTestSuite(testPlan)
+
+	pathMap, pathWeightList := groupOperationsByPath(dag, ignoredPaths)
+	for _, p := range pathWeightList {
+		if allowedAPIs == nil || allowedAPIs[p.path] {
+			GenerateBolaTestSuite(pathMap[p.path], testPlan, owner, attacker)
+		}
+	}
+	return testPlan, nil
+}
+
 // Go through all the paths in swagger, and generate the tests for all the operations under
 // the path.
-func GenerateSimpleTestPlan(swagger *mqswag.Swagger, dag *mqswag.DAG) (*TestPlan, error) {
+func GenerateSimpleTestPlan(ctx *Context, dag *mqswag.DAG) (*TestPlan, error) {
 	testPlan := &TestPlan{}
-	testPlan.Init(swagger, nil)
+	testPlan.Init(ctx)
 	addInitTestSuite(testPlan)
 
 	testId := 0
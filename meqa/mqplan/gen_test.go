@@ -0,0 +1,371 @@
+package mqplan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+)
+
+const bolaSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths:
+  /things:
+    post:
+      operationId: addThing
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Thing'
+      responses:
+        '200':
+          description: the thing created
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Thing'
+  /things/{thingId}:
+    parameters:
+    - name: thingId
+      in: path
+      required: true
+      schema:
+        type: integer
+    get:
+      operationId: getThing
+      responses:
+        '200':
+          description: the thing
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Thing'
+    put:
+      operationId: updateThing
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Thing'
+      responses:
+        '200':
+          description: the thing updated
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Thing'
+    delete:
+      operationId: deleteThing
+      responses:
+        '204':
+          description: deleted
+  /things/{thingId}/parts:
+    post:
+      operationId: addPart
+      parameters:
+      - name: thingId
+        in: path
+        required: true
+        schema:
+          type: integer
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Thing'
+      responses:
+        '200':
+          description: the part created
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Thing'
+  /things/{thingId}/parts/{partId}:
+    parameters:
+    - name: thingId
+      in: path
+      required: true
+      schema:
+        type: integer
+    - name: partId
+      in: path
+      required: true
+      schema:
+        type: integer
+    get:
+      operationId: getPart
+      responses:
+        '200':
+          description: the part
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Thing'
+    delete:
+      operationId: deletePart
+      responses:
+        '204':
+          description: deleted
+  /boxes/{boxId}/items:
+    post:
+      operationId: addItem
+      parameters:
+      - name: boxId
+        in: path
+        required: true
+        schema:
+          type: integer
+      responses:
+        '201':
+          description: the item created
+  /boxes/{boxId}/items/{itemId}:
+    get:
+      operationId: getItem
+      parameters:
+      - name: boxId
+        in: path
+        required: true
+        schema:
+          type: integer
+      - name: itemId
+        in: path
+        required: true
+        schema:
+          type: integer
+      responses:
+        '200':
+          description: the item
+  /widgets:
+    post:
+      operationId: addWidget
+      responses:
+        '201':
+          description: the widget created
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Widget'
+  /widgets/{widgetId}:
+    get:
+      operationId: getWidget
+      parameters:
+      - name: widgetId
+        in: path
+        required: true
+        schema:
+          type: integer
+      responses:
+        '200':
+          description: the widget
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Widget'
+  /gadgets:
+    post:
+      operationId: addGadget
+      responses:
+        '201':
+          description: the gadget created
+  /status:
+    get:
+      operationId: getStatus
+      responses:
+        '200':
+          description: the service is up
+components:
+  schemas:
+    Thing:
+      type: object
+      properties:
+        id:
+          type: integer
+          readOnly: true
+        name:
+          type: string
+    Widget:
+      type: object
+      properties:
+        widgetId:
+          type: integer
+          readOnly: true
+`
+
+// generateBola generates the BOLA test plan of bolaSpec.
+func generateBola(t *testing.T, dir string, logger *log.Logger) *TestPlan {
+	swagger := loadPlan(t, dir, bolaSpec, "", "").ctx.Swagger
+	dag := mqswag.NewDAG()
+	if err := swagger.AddToDAG(dag, mqutil.NewLogger(ioutil.Discard)); err != nil {
+		t.Fatal(err)
+	}
+	dag.Sort()
+	dag.CheckWeight()
+	plan, err := GenerateBolaTestPlan(NewContext(swagger, logger), dag, nil, nil, BolaOwner, BolaAttacker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+func TestGenerateBolaTestPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var logs bytes.Buffer
+	plan := generateBola(t, dir, mqutil.NewLogger(&logs))
+
+	// The paths without a create or without operations on the objects created get no suite.
+	var names []string
+	for _, suite := range plan.SuiteList {
+		names = append(names, suite.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"/things -- bola", "/things/{thingId}/parts -- bola", "/widgets -- bola", MeqaInit}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected the suites %v, got %v", want, names)
+	}
+	var tests []string
+	for _, test := range plan.SuiteMap["/things -- bola"].Tests {
+		tests = append(tests, fmt.Sprintf("%s %s %s %v %v", test.As, test.Method, test.Path, test.PathParams, test.Expect))
+	}
+	want := []string{
+		"owner post /things map[] map[]",
+		"attacker get /things/{thingId} map[thingId:{{post_addThing_1.outputs.id}}] map[status:[403 404]]",
+		"attacker put /things/{thingId} map[thingId:{{post_addThing_1.outputs.id}}] map[status:[403 404]]",
+		"attacker delete /things/{thingId} map[thingId:{{post_addThing_1.outputs.id}}] map[status:[403 404]]",
+		"owner get /things/{thingId} map[thingId:{{post_addThing_1.outputs.id}}] map[]",
+		"owner delete /things/{thingId} map[thingId:{{post_addThing_1.outputs.id}}] map[]",
+	}
+	if !reflect.DeepEqual(tests, want) {
+		t.Errorf("expected the tests\n%v\ngot\n%v", want, tests)
+	}
+	// A nested object is created in an object the owner creates first, which is deleted last.
+	tests = nil
+	for _, test := range plan.SuiteMap["/things/{thingId}/parts -- bola"].Tests {
+		tests = append(tests, fmt.Sprintf("%s %s %s %v %v", test.As, test.Method, test.Path, test.PathParams, test.Expect))
+	}
+	partParams := "map[partId:{{post_addPart_2.outputs.id}} thingId:{{post_addPart_2.pathParams.thingId}}]"
+	want = []string{
+		"owner post /things map[] map[]",
+		"owner post /things/{thingId}/parts map[thingId:{{post_addThing_1.outputs.id}}] map[]",
+		"attacker get /things/{thingId}/parts/{partId} " + partParams + " map[status:[403 404]]",
+		"attacker delete /things/{thingId}/parts/{partId} " + partParams + " map[status:[403 404]]",
+		"owner get /things/{thingId}/parts/{partId} " + partParams + " map[]",
+		"owner delete /things/{thingId}/parts/{partId} " + partParams + " map[]",
+		"owner delete /things/{thingId} map[thingId:{{post_addThing_1.outputs.id}}] map[]",
+	}
+	if !reflect.DeepEqual(tests, want) {
+		t.Errorf("expected the tests\n%v\ngot\n%v", want, tests)
+	}
+	// Without a create for the box the items are in there's no suite, and the log says why.
+	if !strings.Contains(logs.String(), "No BOLA test suite for /boxes/{boxId}/items, no operation creates the boxId it's in") {
+		t.Errorf("expected the log to tell why /boxes/{boxId}/items has no suite, got\n%s", logs.String())
+	}
+	// The id of the created object is the property named like the path param if there is one.
+	attack := plan.SuiteMap["/widgets -- bola"].Tests[1]
+	if id := attack.PathParams["widgetId"]; id != "{{post_addWidget_1.outputs.widgetId}}" {
+		t.Errorf("expected the widget id to be the widgetId of the created widget, got %v", id)
+	}
+}
+
+// thingServer serves the things of bolaSpec and their parts. Unless it's vulnerable, only the
+// owner of an object can access it.
+func thingServer(vulnerable bool) http.Handler {
+	var mutex sync.Mutex
+	lastId := 0
+	owners := make(map[string]string) // by the path of the object
+	names := make(map[string]string)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		user := r.Header.Get("Authorization")
+		var thing map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&thing)
+		object := r.URL.Path
+		if r.Method == http.MethodPost {
+			if parent := path.Dir(object); parent != "/" && owners[parent] != user {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			lastId++
+			object = fmt.Sprintf("%s/%d", object, lastId)
+			owners[object] = user
+			names[object], _ = thing["name"].(string)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": lastId, "name": names[object]})
+			return
+		}
+		if owner, ok := owners[object]; !ok || (owner != user && !vulnerable) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodDelete:
+			delete(owners, object)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case http.MethodPut:
+			names[object], _ = thing["name"].(string)
+		}
+		id, _ := strconv.Atoi(path.Base(object))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "name": names[object]})
+	})
+}
+
+func TestRunBola(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	planPath := filepath.Join(dir, "bola.yml")
+	if err := generateBola(t, dir, nil).DumpToFile(planPath); err != nil {
+		t.Fatal(err)
+	}
+	bola, err := ioutil.ReadFile(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, vulnerable := range []bool{false, true} {
+		server := httptest.NewServer(thingServer(vulnerable))
+		plan := loadPlan(t, dir, bolaSpec, string(bola), server.URL)
+		plan.Identities = Identities{BolaOwner: {ApiToken: "alice"}, BolaAttacker: {ApiToken: "mallory"}}
+		plan.RunAll([]string{"/things -- bola", "/things/{thingId}/parts -- bola"}, 1)
+		server.Close()
+
+		if len(plan.resultList) != 13 {
+			t.Fatalf("expected 13 tests, got %v", plan.ResultCounts)
+		}
+		// Against a vulnerable server the attacker gets to delete the thing, so the owner's checks
+		// after it fail too.
+		for _, test := range plan.resultList {
+			if !vulnerable && test.err != nil {
+				t.Errorf("expected %s as %s to pass, got %v", test.Name, test.As, test.err)
+			}
+			if vulnerable && test.As == BolaAttacker && test.err == nil {
+				t.Errorf("expected the attacker's %s to fail against a vulnerable server", test.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package mqplan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	spec "github.com/getkin/kin-openapi/openapi3"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// checkDocumentedHeaders checks the response headers against the headers documented for the response.
+// Required headers must be present, and the ones present must match their schema.
+func checkDocumentedHeaders(header http.Header, headers map[string]*spec.HeaderRef, validator *mqswag.JSONValidator) error {
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var msgs []string
+	for _, name := range names {
+		h := headers[name].Value
+		if h == nil {
+			continue
+		}
+		values, present := header[http.CanonicalHeaderKey(name)]
+		if !present {
+			if mqswag.IsHeaderRequired(h) {
+				msgs = append(msgs, fmt.Sprintf("required header %s is missing", name))
+			}
+			continue
+		}
+		if h.Schema == nil || h.Schema.Value == nil {
+			continue
+		}
+		value := headerValue(strings.Join(values, ","), h.Schema.Value)
+		if err := validator.Validate((mqswag.SchemaRef)(*h.Schema), value); err != nil {
+			msgs = append(msgs, fmt.Sprintf("header %s: %s doesn't match the schema", name, strings.Join(values, ",")))
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("%s", strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+// headerValue converts the header's value to the type of its schema. Values that don't convert are
+// left as strings for the validator to report.
+func headerValue(value string, schema *spec.Schema) interface{} {
+	switch schema.Type {
+	case gojsonschema.TYPE_INTEGER, gojsonschema.TYPE_NUMBER:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case gojsonschema.TYPE_BOOLEAN:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case gojsonschema.TYPE_ARRAY:
+		// The simple style, comma separated.
+		var items []interface{}
+		for _, v := range strings.Split(value, ",") {
+			if schema.Items != nil && schema.Items.Value != nil {
+				items = append(items, headerValue(strings.TrimSpace(v), schema.Items.Value))
+			} else {
+				items = append(items, strings.TrimSpace(v))
+			}
+		}
+		return items
+	}
+	return value
+}
+
+// checkExpectedHeaders checks the response headers against the headers section of a test's expect. A
+// value is either the exact value of the header, {regex: pattern} to match the value against, or null
+// if the header must not be present.
+func checkExpectedHeaders(header http.Header, expected interface{}) error {
+	expectMap, ok := expected.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expect.headers must be a map of header names to values")
+	}
+	var names []string
+	for name := range expectMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var msgs []string
+	for _, name := range names {
+		values, present := header[http.CanonicalHeaderKey(name)]
+		value := strings.Join(values, ",")
+		switch e := expectMap[name].(type) {
+		case nil:
+			if present {
+				msgs = append(msgs, fmt.Sprintf("header %s: expecting none, got %s", name, value))
+			}
+		case map[string]interface{}:
+			pattern, ok := e[ExpectRegex].(string)
+			if !ok {
+				return fmt.Errorf("expect.headers.%s: only %s is supported in a map", name, ExpectRegex)
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("expect.headers.%s: invalid regex %s: %s", name, pattern, err.Error())
+			}
+			if !present || !re.MatchString(value) {
+				msgs = append(msgs, fmt.Sprintf("header %s: expecting a match for %s, got %q", name, pattern, value))
+			}
+		case float64:
+			// Numbers come from yaml as floats.
+			if !present || value != strconv.FormatFloat(e, 'f', -1, 64) {
+				msgs = append(msgs, fmt.Sprintf("header %s: expecting %v, got %q", name, e, value))
+			}
+		default:
+			if !present || value != fmt.Sprint(e) {
+				msgs = append(msgs, fmt.Sprintf("header %s: expecting %v, got %q", name, e, value))
+			}
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("%s", strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+// actualHeaders returns the values of the named headers in the response, nil for the missing ones.
+func actualHeaders(header http.Header, expected interface{}) map[string]interface{} {
+	expectMap, _ := expected.(map[string]interface{})
+	actual := make(map[string]interface{})
+	for name := range expectMap {
+		if values, present := header[http.CanonicalHeaderKey(name)]; present {
+			actual[name] = strings.Join(values, ",")
+		} else {
+			actual[name] = nil
+		}
+	}
+	return actual
+}
@@ -0,0 +1,136 @@
+package mqplan
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+func TestHeaderValue(t *testing.T) {
+	integers := &spec.Schema{Type: "array", Items: &spec.SchemaRef{Value: &spec.Schema{Type: "integer"}}}
+	for _, c := range []struct {
+		value    string
+		schema   *spec.Schema
+		expected interface{}
+	}{
+		{"abc", &spec.Schema{Type: "string"}, "abc"},
+		{"12", &spec.Schema{Type: "string"}, "12"},
+		{"12", &spec.Schema{Type: "integer"}, json.Number("12")},
+		{"1.5", &spec.Schema{Type: "number"}, json.Number("1.5")},
+		{"abc", &spec.Schema{Type: "integer"}, "abc"},
+		{"true", &spec.Schema{Type: "boolean"}, true},
+		{"0", &spec.Schema{Type: "boolean"}, false},
+		{"yes", &spec.Schema{Type: "boolean"}, "yes"},
+		{"a, b,c", &spec.Schema{Type: "array"}, []interface{}{"a", "b", "c"}},
+		{"1, 2,x", integers, []interface{}{json.Number("1"), json.Number("2"), "x"}},
+		{"", integers, []interface{}{""}},
+		{"abc", &spec.Schema{}, "abc"},
+	} {
+		if got := headerValue(c.value, c.schema); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%q as %s: expected %#v, got %#v", c.value, c.schema.Type, c.expected, got)
+		}
+	}
+}
+
+const headerSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths:
+  /things:
+    post:
+      responses:
+        '201':
+          description: the created thing
+          headers:
+            X-Rate-Limit:
+              required: true
+              schema:
+                type: integer
+            ETag:
+              schema:
+                type: string
+                pattern: '^"v[0-9]+"$'
+`
+
+func TestCheckDocumentedHeaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	plan := loadPlan(t, dir, headerSpec, "", "")
+	headers := plan.ctx.Swagger.Paths["/things"].Post.Responses["201"].Value.Headers
+	for _, c := range []struct {
+		header   http.Header
+		expected []string
+	}{
+		{http.Header{"X-Rate-Limit": {"100"}, "Etag": {`"v1"`}}, nil},
+		{http.Header{"X-Rate-Limit": {"100"}}, nil},
+		{http.Header{"Etag": {`"v1"`}}, []string{"required header X-Rate-Limit is missing"}},
+		{http.Header{"X-Rate-Limit": {"many"}, "Etag": {"v1"}}, []string{
+			"header ETag: v1 doesn't match the schema",
+			"header X-Rate-Limit: many doesn't match the schema",
+		}},
+	} {
+		err := checkDocumentedHeaders(c.header, headers, plan.ctx.Validator)
+		if len(c.expected) == 0 {
+			if err != nil {
+				t.Errorf("expected %v to match, got %s", c.header, err.Error())
+			}
+			continue
+		}
+		if err == nil || err.Error() != strings.Join(c.expected, "\n") {
+			t.Errorf("expected %v for %v, got %v", c.expected, c.header, err)
+		}
+	}
+}
+
+func TestCheckExpectedHeaders(t *testing.T) {
+	header := http.Header{"Etag": {`"v1"`}, "X-Rate-Limit": {"100"}, "Vary": {"Accept", "Origin"}}
+	for _, c := range []struct {
+		expected interface{}
+		mismatch string
+	}{
+		{map[string]interface{}{"ETag": `"v1"`, "x-rate-limit": 100.0, "Vary": "Accept,Origin"}, ""},
+		{map[string]interface{}{"ETag": map[string]interface{}{ExpectRegex: `^"v[0-9]+"$`}, "X-Debug": nil}, ""},
+		{map[string]interface{}{"ETag": "v1"}, `header ETag: expecting v1, got "\"v1\""`},
+		{map[string]interface{}{"X-Rate-Limit": 99.0}, `header X-Rate-Limit: expecting 99, got "100"`},
+		{map[string]interface{}{"X-Debug": map[string]interface{}{ExpectRegex: "."}}, `header X-Debug: expecting a match for ., got ""`},
+		{map[string]interface{}{"ETag": nil}, `header ETag: expecting none, got "v1"`},
+	} {
+		err := checkExpectedHeaders(header, c.expected)
+		if len(c.mismatch) == 0 {
+			if err != nil {
+				t.Errorf("expected %v to match, got %s", c.expected, err.Error())
+			}
+		} else if err == nil || err.Error() != c.mismatch {
+			t.Errorf("expected %s for %v, got %v", c.mismatch, c.expected, err)
+		}
+	}
+	// Mistakes in the plan.
+	for _, expected := range []interface{}{
+		"ETag",
+		map[string]interface{}{"ETag": map[string]interface{}{"prefix": "v"}},
+		map[string]interface{}{"ETag": map[string]interface{}{ExpectRegex: "("}},
+	} {
+		if err := checkExpectedHeaders(header, expected); err == nil || !strings.Contains(err.Error(), "expect.headers") {
+			t.Errorf("expected an error in the expect for %v, got %v", expected, err)
+		}
+	}
+}
+
+func TestActualHeaders(t *testing.T) {
+	header := http.Header{"Etag": {`"v1"`}, "Vary": {"Accept", "Origin"}}
+	actual := actualHeaders(header, map[string]interface{}{"ETag": "v2", "vary": nil, "X-Debug": nil})
+	if expected := map[string]interface{}{"ETag": `"v1"`, "vary": "Accept,Origin", "X-Debug": nil}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
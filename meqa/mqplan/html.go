@@ -0,0 +1,335 @@
+package mqplan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+)
+
+// The data passed to htmlTemplate.
+type htmlReport struct {
+	Generated    string
+	Counts       []htmlCount
+	Suites       []*htmlSuite
+	FuzzFailures []*mqswag.Payload
+}
+
+type htmlCount struct {
+	Name  string
+	Value int
+	Class string
+}
+
+type htmlSuite struct {
+	Name                                                                     string
+	Passed, Failed, Skipped, SchemaMismatch, ContentMismatch, HeaderMismatch int
+	Duration                                                                 string
+	Tests                                                                    []*htmlTest
+}
+
+type htmlTest struct {
+	Name            string
+	Method          string
+	Path            string
+	Identity        string
+	URL             string
+	Result          string // passed, failed or skipped
+	Status          int
+	Latency         string
+	RequestHeaders  []string
+	RequestBody     string
+	ResponseHeaders []string
+	ResponseBody    string
+	Error           string
+	SchemaError     string
+	ContentError    string
+	HeaderError     string
+	FuzzFailures    int
+}
+
+// prettyBody indents body if it's JSON, otherwise returns it as is.
+func prettyBody(body []byte) string {
+	var out bytes.Buffer
+	if json.Indent(&out, body, "", "    ") == nil {
+		return out.String()
+	}
+	return string(body)
+}
+
+// headerLines flattens the header into sorted "Key: Value" lines.
+func headerLines(header http.Header) []string {
+	var lines []string
+	for k, values := range header {
+		for _, v := range values {
+			lines = append(lines, k+": "+v)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// The request headers that carry credentials. Their values are left out of the report, like the
+// ones of the apiKey security schemes.
+var credentialHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+const redacted = "REDACTED"
+
+// apiKeyNames returns the names of the apiKey security schemes of the spec sent in the location in.
+func (t *Test) apiKeyNames(in string) []string {
+	if t.ctx == nil || t.ctx.Swagger == nil {
+		return nil
+	}
+	var names []string
+	for _, ref := range t.ctx.Swagger.Components.SecuritySchemes {
+		if s := ref.Value; s != nil && s.Type == SecurityApiKey && s.In == in {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+// redactHeader returns the header without the values of the headers that carry credentials.
+func (t *Test) redactHeader(header http.Header) http.Header {
+	redactedHeader := make(http.Header, len(header))
+	for k, v := range header {
+		redactedHeader[k] = v
+	}
+	for _, k := range append(t.apiKeyNames(SecurityInHeader), credentialHeaders...) {
+		k = http.CanonicalHeaderKey(k)
+		if _, ok := redactedHeader[k]; ok {
+			redactedHeader[k] = []string{redacted}
+		}
+	}
+	return redactedHeader
+}
+
+// redactURL returns the URL without the values of the apiKeys sent in the query.
+func (t *Test) redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	changed := false
+	for _, k := range t.apiKeyNames(SecurityInQuery) {
+		if _, ok := query[k]; ok {
+			query.Set(k, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return rawURL
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (t *Test) htmlTest() *htmlTest {
+	h := &htmlTest{
+		Name:         t.Name,
+		Method:       strings.ToUpper(t.Method),
+		Path:         t.Path,
+		Identity:     t.As,
+		Result:       "passed",
+		FuzzFailures: len(t.fuzzFailures),
+	}
+	if t.err != nil {
+		h.Result = "failed"
+		h.Error = errorMessage(t.err)
+		if t.responseError != nil {
+			h.Error = h.Error + "\n" + responseErrorString(t.responseError)
+		}
+	}
+	if t.schemaError != nil {
+		h.SchemaError = errorMessage(t.schemaError)
+	}
+	if t.contentError != nil {
+		h.ContentError = errorMessage(t.contentError)
+	}
+	if t.headerError != nil {
+		h.HeaderError = errorMessage(t.headerError)
+	}
+	if t.resp == nil {
+		return h
+	}
+	h.Status = t.resp.StatusCode()
+	h.Latency = t.stopTime.Sub(t.startTime).Round(time.Microsecond).String()
+	h.ResponseHeaders = headerLines(t.resp.Header())
+	h.ResponseBody = prettyBody(t.resp.Body())
+	if req := t.resp.Request; req != nil {
+		header := req.Header
+		h.URL = req.URL
+		if req.RawRequest != nil {
+			header = req.RawRequest.Header
+			h.URL = req.RawRequest.URL.String()
+		}
+		h.URL = t.redactURL(h.URL)
+		h.RequestHeaders = headerLines(t.redactHeader(header))
+		if body, ok := req.Body.([]byte); ok {
+			// The multipart, XML and raw bodies.
+			h.RequestBody = prettyBody(body)
+		} else if req.Body != nil {
+			body, _ := mqutil.MarshalJsonIndentNoEscape(req.Body)
+			h.RequestBody = string(body)
+		} else if len(req.FormData) > 0 {
+			h.RequestBody = req.FormData.Encode()
+		}
+	}
+	return h
+}
+
+// htmlReport collects the run results for the HTML report.
+func (plan *TestPlan) htmlReport() *htmlReport {
+	report := &htmlReport{Generated: time.Now().Format(time.RFC1123)}
+	for _, name := range []string{mqutil.Passed, mqutil.Failed, mqutil.Skipped, mqutil.SchemaMismatch, mqutil.ContentMismatch, mqutil.HeaderMismatch, mqutil.Total, mqutil.FuzzTotal} {
+		report.Counts = append(report.Counts, htmlCount{name, plan.ResultCounts[name], strings.ToLower(strings.ReplaceAll(name, " ", ""))})
+	}
+	report.Counts = append(report.Counts, htmlCount{mqutil.FuzzFails, len(plan.ctx.NewFailures), "failed"})
+	for _, tc := range plan.suiteResults {
+		suite := &htmlSuite{
+			Name:     tc.Name,
+			Skipped:  len(tc.skipped),
+			Duration: tc.stopTime.Sub(tc.startTime).Round(time.Millisecond).String(),
+		}
+		for _, t := range tc.results {
+			h := t.htmlTest()
+			if h.Result == "failed" {
+				suite.Failed++
+			} else {
+				suite.Passed++
+			}
+			if len(h.SchemaError) > 0 {
+				suite.SchemaMismatch++
+			}
+			if len(h.ContentError) > 0 {
+				suite.ContentMismatch++
+			}
+			if len(h.HeaderError) > 0 {
+				suite.HeaderMismatch++
+			}
+			suite.Tests = append(suite.Tests, h)
+		}
+		for _, t := range tc.skipped {
+			suite.Tests = append(suite.Tests, &htmlTest{
+				Name:   t.Name,
+				Method: strings.ToUpper(t.Method),
+				Path:   t.Path,
+				Result: "skipped",
+			})
+		}
+		report.Suites = append(report.Suites, suite)
+	}
+	report.FuzzFailures = plan.ctx.NewFailures
+	return report
+}
+
+// WriteHTML writes the run results to path as a self-contained HTML page.
+func (plan *TestPlan) WriteHTML(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return htmlTemplate.Execute(f, plan.htmlReport())
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"lines": func(lines []string) string { return strings.Join(lines, "\n") },
+	"value": func(v interface{}) string { return fmt.Sprint(v) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>meqa test report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.3em; margin-top: 2em; }
+.counts { display: flex; flex-wrap: wrap; gap: 1em; }
+.count { border: 1px solid #ddd; border-radius: 6px; padding: 0.8em 1.2em; min-width: 7em; }
+.count .value { font-size: 1.8em; font-weight: bold; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 1em; }
+th, td { border-bottom: 1px solid #eee; padding: 0.4em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f6f6f6; }
+pre { background: #f8f8f8; padding: 0.6em; overflow-x: auto; white-space: pre-wrap; word-break: break-all; margin: 0.3em 0; }
+details > summary { cursor: pointer; }
+.panes { display: flex; gap: 1em; }
+.panes > div { flex: 1; min-width: 0; }
+.passed { color: #1a7f37; }
+.failed { color: #cf222e; }
+.skipped, .schemamismatch, .contentmismatch, .headermismatch { color: #9a6700; }
+.method { font-family: monospace; font-weight: bold; }
+.identity { color: #57606a; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>meqa test report</h1>
+<p>Generated {{.Generated}}</p>
+
+<div class="counts">
+{{range .Counts}}<div class="count"><div class="value {{.Class}}">{{.Value}}</div><div>{{.Name}}</div></div>
+{{end}}</div>
+
+<h2>Test suites</h2>
+<table>
+<tr><th>Suite</th><th>Passed</th><th>Failed</th><th>Skipped</th><th>Schema mismatches</th><th>Content mismatches</th><th>Header mismatches</th><th>Duration</th></tr>
+{{range .Suites}}<tr><td><a href="#{{.Name}}">{{.Name}}</a></td><td class="passed">{{.Passed}}</td><td class="failed">{{.Failed}}</td><td class="skipped">{{.Skipped}}</td><td class="schemamismatch">{{.SchemaMismatch}}</td><td class="contentmismatch">{{.ContentMismatch}}</td><td class="headermismatch">{{.HeaderMismatch}}</td><td>{{.Duration}}</td></tr>
+{{end}}</table>
+
+{{range .Suites}}
+<h2 id="{{.Name}}">{{.Name}}</h2>
+<table>
+<tr><th>Test</th><th>Request</th><th>Status</th><th>Latency</th><th>Result</th></tr>
+{{range .Tests}}<tr>
+<td>{{.Name}}</td>
+<td><span class="method">{{.Method}}</span> {{.Path}}{{if .Identity}} <span class="identity">as {{.Identity}}</span>{{end}}</td>
+<td>{{if .Status}}{{.Status}}{{end}}</td>
+<td>{{.Latency}}</td>
+<td class="{{.Result}}">{{.Result}}{{if .SchemaError}} <span class="schemamismatch">(schema mismatch)</span>{{end}}{{if .ContentError}} <span class="contentmismatch">(content mismatch)</span>{{end}}{{if .HeaderError}} <span class="headermismatch">(header mismatch)</span>{{end}}{{if .FuzzFailures}} <span class="failed">({{.FuzzFailures}} fuzz failures)</span>{{end}}</td>
+</tr>
+{{if ne .Result "skipped"}}<tr><td colspan="5">
+<details>
+<summary>Details</summary>
+{{if .Error}}<h4 class="failed">Error</h4><pre>{{.Error}}</pre>{{end}}
+{{if .SchemaError}}<h4 class="schemamismatch">Schema mismatch</h4><pre>{{.SchemaError}}</pre>{{end}}
+{{if .ContentError}}<h4 class="contentmismatch">Content mismatch</h4><pre>{{.ContentError}}</pre>{{end}}
+{{if .HeaderError}}<h4 class="headermismatch">Header mismatch</h4><pre>{{.HeaderError}}</pre>{{end}}
+<div class="panes">
+<div>
+<h4>Request</h4>
+<pre>{{.Method}} {{.URL}}</pre>
+{{if .RequestHeaders}}<pre>{{lines .RequestHeaders}}</pre>{{end}}
+{{if .RequestBody}}<pre>{{.RequestBody}}</pre>{{end}}
+</div>
+<div>
+<h4>Response</h4>
+<pre>{{if .Status}}{{.Status}}{{else}}no response{{end}} {{.Latency}}</pre>
+{{if .ResponseHeaders}}<pre>{{lines .ResponseHeaders}}</pre>{{end}}
+{{if .ResponseBody}}<pre>{{.ResponseBody}}</pre>{{end}}
+</div>
+</div>
+</details>
+</td></tr>{{end}}
+{{end}}</table>
+{{end}}
+
+{{if .FuzzFailures}}
+<h2>Fuzz failures</h2>
+<table>
+<tr><th>Endpoint</th><th>Field</th><th>Value</th><th>Fuzz type</th><th>Expected</th><th>Actual</th><th>Message</th></tr>
+{{range .FuzzFailures}}<tr><td><span class="method">{{.Method}}</span> {{.Endpoint}}</td><td>{{.Field}}</td><td><pre>{{value .Value}}</pre></td><td>{{.FuzzType}}</td><td>{{.Expected}}</td><td>{{.Actual}}</td><td><pre>{{.Message}}</pre></td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
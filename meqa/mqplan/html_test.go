@@ -0,0 +1,132 @@
+package mqplan
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+	spec "github.com/getkin/kin-openapi/openapi3"
+	"gopkg.in/resty.v1"
+)
+
+func TestWriteHTML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := reportServer()
+	defer server.Close()
+	plan := loadPlan(t, dir, thingsSpec, reportPlan, server.URL)
+	plan.RunAll([]string{"get suite", "post suite"}, 2)
+	htmlPath := filepath.Join(dir, "report.html")
+	if err := plan.WriteHTML(htmlPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(htmlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(data)
+	for _, want := range []string{
+		`<a href="#get%20suite">get suite</a></td><td class="passed">1</td><td class="failed">1</td><td class="skipped">0</td>`,
+		`<a href="#post%20suite">post suite</a></td><td class="passed">0</td><td class="failed">1</td><td class="skipped">1</td>`,
+		`<h2 id="get suite">get suite</h2>`,
+		"<td>get_broken</td>",
+		`<td class="failed">failed</td>`,
+		`<td class="skipped">skipped</td>`,
+		"<pre>GET " + server.URL + "/things/1</pre>",
+		"Response Status Code: 500",
+		"Content-Type: application/json",
+		// The response body is indented and escaped.
+		`&#34;name&#34;: &#34;&lt;b&gt;thing&lt;/b&gt;&#34;`,
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("expected the report to contain %q", want)
+		}
+	}
+	if strings.Contains(report, "<b>thing") {
+		t.Errorf("expected the response body to be escaped")
+	}
+}
+
+func TestHTMLTestErrors(t *testing.T) {
+	test := &Test{Name: "get_thing", Method: "get", Path: "/things/{id}"}
+	test.err = mqutil.NewError(mqutil.ErrHttp, "expecting status 200")
+	test.schemaError = mqutil.NewError(mqutil.ErrInvalid, "name is required")
+	test.contentError = mqutil.NewError(mqutil.ErrInvalid, "expecting a JSON body")
+	test.headerError = mqutil.NewError(mqutil.ErrInvalid, "header X-Rate-Limit is missing")
+	h := test.htmlTest()
+	if h.Result != "failed" {
+		t.Errorf("expected the test to fail, got %s", h.Result)
+	}
+	// The mismatches are shown without the backtrace.
+	for msg, want := range map[string]string{
+		h.Error:        "expecting status 200",
+		h.SchemaError:  "name is required",
+		h.ContentError: "expecting a JSON body",
+		h.HeaderError:  "header X-Rate-Limit is missing",
+	} {
+		if !strings.Contains(msg, want) || strings.Contains(msg, "Backtrace") {
+			t.Errorf("expected %q without the backtrace, got %q", want, msg)
+		}
+	}
+}
+
+func TestHTMLTestRequest(t *testing.T) {
+	swagger := &mqswag.Swagger{}
+	swagger.Components.SecuritySchemes = map[string]*spec.SecuritySchemeRef{
+		"header": {Value: &spec.SecurityScheme{Type: SecurityApiKey, In: SecurityInHeader, Name: "X-Api-Key"}},
+		"query":  {Value: &spec.SecurityScheme{Type: SecurityApiKey, In: SecurityInQuery, Name: "api_key"}},
+	}
+	raw, err := http.NewRequest(http.MethodPost, "http://localhost/things?api_key=secret&page=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		"Authorization":       "Bearer secret",
+		"Cookie":              "session=secret",
+		"Proxy-Authorization": "Basic secret",
+		"X-Api-Key":           "secret",
+		"Content-Type":        "application/xml",
+	} {
+		raw.Header.Set(k, v)
+	}
+	test := &Test{Name: "post_thing", Method: "post", Path: "/things", ctx: &Context{Swagger: swagger}}
+	test.resp = &resty.Response{
+		Request:     &resty.Request{Body: []byte("<thing><name>a</name></thing>"), RawRequest: raw},
+		RawResponse: &http.Response{StatusCode: http.StatusCreated},
+	}
+	h := test.htmlTest()
+
+	report := h.URL + "\n" + strings.Join(h.RequestHeaders, "\n")
+	if strings.Contains(report, "secret") {
+		t.Errorf("expected the credentials to be redacted, got\n%s", report)
+	}
+	for _, want := range []string{
+		"api_key=REDACTED",
+		"page=2",
+		"Authorization: REDACTED",
+		"Cookie: REDACTED",
+		"Proxy-Authorization: REDACTED",
+		"X-Api-Key: REDACTED",
+		"Content-Type: application/xml",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("expected the request to contain %q, got\n%s", want, report)
+		}
+	}
+	// The raw bodies are shown as they are sent, not base64 encoded.
+	if h.RequestBody != "<thing><name>a</name></thing>" {
+		t.Errorf("expected the XML body, got %q", h.RequestBody)
+	}
+	// The request itself is left as it was sent.
+	if raw.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("expected the request headers to be left unchanged, got %v", raw.Header)
+	}
+}
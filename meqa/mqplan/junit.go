@@ -0,0 +1,182 @@
+package mqplan
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/resty.v1"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+)
+
+// The JUnit XML format understood by most CI systems.
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Skipped  int               `xml:"skipped,attr"`
+	Time     float64           `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	Time      float64          `xml:"time,attr"`
+	Timestamp string           `xml:"timestamp,attr,omitempty"`
+	Cases     []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// JUnit failure types.
+const (
+	junitError         = "Error"
+	junitResponseError = "ResponseError"
+	junitSchemaError   = "SchemaMismatch"
+	junitContentError  = "ContentMismatch"
+	junitHeaderError   = "HeaderMismatch"
+	junitFuzzFailure   = "FuzzFailure"
+)
+
+// errorMessage returns the message of err without the backtrace.
+func errorMessage(err error) string {
+	if typedErr, ok := err.(*mqutil.TypedError); ok {
+		return strings.TrimSpace(typedErr.Message())
+	}
+	return err.Error()
+}
+
+// responseErrorString formats the responseError of a test.
+func responseErrorString(responseError interface{}) string {
+	if resp, ok := responseError.(*resty.Response); ok {
+		return fmt.Sprintf("Response Status Code: %d\n%s", resp.StatusCode(), resp.String())
+	}
+	return fmt.Sprint(responseError)
+}
+
+func (t *Test) junitTestCase(className string) *junitTestCase {
+	name := fmt.Sprintf("%s (%s %s)", t.Name, strings.ToUpper(t.Method), t.Path)
+	if len(t.As) > 0 {
+		name = fmt.Sprintf("%s (%s %s as %s)", t.Name, strings.ToUpper(t.Method), t.Path, t.As)
+	}
+	c := &junitTestCase{
+		Name:      name,
+		ClassName: className,
+		Time:      t.stopTime.Sub(t.startTime).Seconds(),
+	}
+	if c.Time < 0 {
+		c.Time = 0
+	}
+	var failures []*junitFailure
+	if t.err != nil {
+		failure := &junitFailure{errorMessage(t.err), junitError, errorMessage(t.err)}
+		if t.responseError != nil {
+			failure.Type = junitResponseError
+			failure.Text = responseErrorString(t.responseError)
+		}
+		failures = append(failures, failure)
+	}
+	if t.schemaError != nil {
+		failures = append(failures, &junitFailure{"response doesn't match the openapi schema", junitSchemaError, errorMessage(t.schemaError)})
+	}
+	if t.contentError != nil {
+		failures = append(failures, &junitFailure{"response isn't in a documented media type", junitContentError, errorMessage(t.contentError)})
+	}
+	if t.headerError != nil {
+		failures = append(failures, &junitFailure{"response headers don't match", junitHeaderError, errorMessage(t.headerError)})
+	}
+	if len(failures) > 0 {
+		// A testcase has a single failure, the other mismatches are added to its text.
+		c.Failure = failures[0]
+		for _, f := range failures[1:] {
+			c.Failure.Text += fmt.Sprintf("\n\n%s: %s\n%s", f.Type, f.Message, f.Text)
+		}
+	}
+	return c
+}
+
+// junitReport converts the run results to JUnit test suites.
+func (plan *TestPlan) junitReport() *junitTestSuites {
+	report := &junitTestSuites{}
+	for _, tc := range plan.suiteResults {
+		suite := &junitTestSuite{
+			Name:      tc.Name,
+			Time:      tc.stopTime.Sub(tc.startTime).Seconds(),
+			Timestamp: tc.startTime.Format(time.RFC3339),
+		}
+		for _, t := range tc.results {
+			suite.Cases = append(suite.Cases, t.junitTestCase(tc.Name))
+			for _, p := range t.fuzzFailures {
+				text := fmt.Sprintf("Expected: %s\nActual: %s\n%s", p.Expected, p.Actual, p.Message)
+				suite.Cases = append(suite.Cases, &junitTestCase{
+					Name:      fmt.Sprintf("%s fuzz %s %s=%v", t.Name, p.FuzzType, p.Field, p.Value),
+					ClassName: tc.Name,
+					Failure:   &junitFailure{fmt.Sprintf("expected %s got %s", p.Expected, p.Actual), junitFuzzFailure, text},
+				})
+			}
+		}
+		for _, t := range tc.skipped {
+			suite.Cases = append(suite.Cases, &junitTestCase{
+				Name:      fmt.Sprintf("%s (%s %s)", t.Name, strings.ToUpper(t.Method), t.Path),
+				ClassName: tc.Name,
+				Skipped:   &junitSkipped{"skipped because an earlier create failed"},
+			})
+		}
+		for _, c := range suite.Cases {
+			suite.Tests++
+			if c.Failure != nil {
+				suite.Failures++
+			}
+			if c.Skipped != nil {
+				suite.Skipped++
+			}
+		}
+		report.Suites = append(report.Suites, suite)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Skipped += suite.Skipped
+		report.Time += suite.Time
+	}
+	return report
+}
+
+// WriteJUnit writes the run results to path in the JUnit XML format.
+func (plan *TestPlan) WriteJUnit(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	f.WriteString(xml.Header)
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	err = enc.Encode(plan.junitReport())
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString("\n")
+	return err
+}
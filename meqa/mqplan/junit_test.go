@@ -0,0 +1,205 @@
+package mqplan
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const reportPlan = `
+get suite:
+- name: get_thing
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 1
+- name: get_broken
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 500
+---
+post suite:
+- name: post_thing
+  path: /things
+  method: post
+- name: get_thing
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 1
+`
+
+// reportServer fails the creates, and responds to a get with the status in its id if there is one
+// or with a thing otherwise.
+func reportServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if status, err := strconv.Atoi(path.Base(r.URL.Path)); err == nil && status > 100 {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 1, "name": "<b>thing</b>"}`))
+	}))
+}
+
+func TestWriteJUnit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := reportServer()
+	defer server.Close()
+	plan := loadPlan(t, dir, thingsSpec, reportPlan, server.URL)
+	plan.RunAll([]string{"get suite", "post suite"}, 2)
+	junitPath := filepath.Join(dir, "junit.xml")
+	if err := plan.WriteJUnit(junitPath); err != nil {
+		t.Fatal(err)
+	}
+
+	var report struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+		Skipped  int `xml:"skipped,attr"`
+		Suites   []struct {
+			Name     string `xml:"name,attr"`
+			Tests    int    `xml:"tests,attr"`
+			Failures int    `xml:"failures,attr"`
+			Cases    []struct {
+				Name      string `xml:"name,attr"`
+				ClassName string `xml:"classname,attr"`
+				Failures  []struct {
+					Message string `xml:"message,attr"`
+					Type    string `xml:"type,attr"`
+					Text    string `xml:",chardata"`
+				} `xml:"failure"`
+				Skipped *struct {
+					Message string `xml:"message,attr"`
+				} `xml:"skipped"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	data, err := ioutil.ReadFile(junitPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(data, &report); err != nil {
+		t.Fatalf("expected valid XML, got %s", err.Error())
+	}
+	if report.Tests != 4 || report.Failures != 2 || report.Skipped != 1 || len(report.Suites) != 2 {
+		t.Fatalf("expected 2 suites with 4 testcases, 2 failures and 1 skipped, got %s", data)
+	}
+	get, post := report.Suites[0], report.Suites[1]
+	if get.Name != "get suite" || get.Tests != 2 || get.Failures != 1 || post.Name != "post suite" || post.Tests != 2 || post.Failures != 1 {
+		t.Fatalf("expected the suites in order with a failure each, got %s", data)
+	}
+	if c := get.Cases[0]; c.Name != "get_thing (GET /things/{id})" || c.ClassName != "get suite" || len(c.Failures) != 0 || c.Skipped != nil {
+		t.Errorf("expected get_thing to pass, got %+v", c)
+	}
+	if c := get.Cases[1]; len(c.Failures) != 1 || c.Failures[0].Type != junitResponseError ||
+		!strings.Contains(c.Failures[0].Text, "Response Status Code: 500") {
+		t.Errorf("expected get_broken to fail with its response, got %+v", c)
+	}
+	if c := post.Cases[0]; c.Name != "post_thing (POST /things)" || len(c.Failures) != 1 {
+		t.Errorf("expected post_thing to fail, got %+v", c)
+	}
+	if c := post.Cases[1]; c.ClassName != "post suite" || c.Skipped == nil || len(c.Failures) != 0 {
+		t.Errorf("expected get_thing to be skipped after the failed create, got %+v", c)
+	}
+}
+
+const mismatchSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths:
+  /things:
+    post:
+      responses:
+        '201':
+          description: the created thing
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  id:
+                    type: integer
+                  name:
+                    type: string
+`
+
+// A response that doesn't match its schema fails the testcase once, with the mismatch and without
+// the backtrace.
+func TestWriteJUnitSchemaMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The name of the second thing isn't a string.
+	var mutex sync.Mutex
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		posts++
+		name := `"thing"`
+		if posts > 1 {
+			name = "true"
+		}
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 1, "name": ` + name + `}`))
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, mismatchSpec, "things suite:\n- name: post_thing\n  path: /things\n  method: post\n"+
+		"- name: post_mismatch\n  path: /things\n  method: post\n", server.URL)
+	plan.RunAll([]string{"things suite"}, 1)
+	junitPath := filepath.Join(dir, "junit.xml")
+	if err := plan.WriteJUnit(junitPath); err != nil {
+		t.Fatal(err)
+	}
+
+	var report struct {
+		Failures int `xml:"failures,attr"`
+		Suites   []struct {
+			Cases []struct {
+				Failures []struct {
+					Text string `xml:",chardata"`
+				} `xml:"failure"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	data, err := ioutil.ReadFile(junitPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(data, &report); err != nil {
+		t.Fatalf("expected valid XML, got %s", err.Error())
+	}
+	if report.Failures != 1 || len(report.Suites) != 1 || len(report.Suites[0].Cases) != 2 {
+		t.Fatalf("expected 2 testcases with 1 failure, got %s", data)
+	}
+	cases := report.Suites[0].Cases
+	if len(cases[0].Failures) != 0 || len(cases[1].Failures) != 1 {
+		t.Fatalf("expected a single failure of post_mismatch, got %s", data)
+	}
+	if text := cases[1].Failures[0].Text; !strings.Contains(text, "schema and object don't match") || strings.Contains(text, "Backtrace") {
+		t.Errorf("expected the schema mismatch without the backtrace, got %s", text)
+	}
+}
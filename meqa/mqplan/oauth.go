@@ -0,0 +1,202 @@
+package mqplan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+// The OAuth2 grants a token can be acquired with.
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantPassword          = "password"
+	GrantRefreshToken      = "refresh_token"
+)
+
+// A token is considered expired this long before it actually expires, so it doesn't expire in flight.
+const tokenExpiryMargin = 10 * time.Second
+
+// oauthToken is a token acquired from a token endpoint.
+type oauthToken struct {
+	accessToken  string
+	refreshToken string
+	expiry       time.Time // zero if the token doesn't expire
+}
+
+func (t *oauthToken) expired() bool {
+	return !t.expiry.IsZero() && time.Now().Add(tokenExpiryMargin).After(t.expiry)
+}
+
+// cachedToken is the token of one credential and scopes. Its mutex is held while the token is
+// acquired, so the tests that need it wait for the first one to get it instead of all getting one.
+type cachedToken struct {
+	token *oauthToken
+	mutex sync.Mutex
+}
+
+// tokenKey identifies a cached token. A token is only good for the scopes it was asked for, so
+// each set of scopes has its own, the scopes sorted and joined.
+type tokenKey struct {
+	credential *Credential
+	scopes     string
+}
+
+// tokenCache holds the tokens of a plan, one per credential and scopes.
+type tokenCache struct {
+	tokens map[tokenKey]*cachedToken
+	mutex  sync.Mutex // guards the map, not the tokens
+}
+
+// get returns the cached token of the credential for the scopes, adding an empty one if there is
+// none.
+func (cache *tokenCache) get(c *Credential, scopes []string) *cachedToken {
+	sorted := append([]string(nil), scopes...)
+	sort.Strings(sorted)
+	key := tokenKey{c, strings.Join(sorted, " ")}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.tokens == nil {
+		cache.tokens = make(map[tokenKey]*cachedToken)
+	}
+	cached := cache.tokens[key]
+	if cached == nil {
+		cached = &cachedToken{}
+		cache.tokens[key] = cached
+	}
+	return cached
+}
+
+// acquiresToken returns whether the credential gets its token from a token endpoint.
+func (c *Credential) acquiresToken(scheme *spec.SecurityScheme) bool {
+	return len(c.Token) == 0 && (scheme.Type == SecurityOAuth2 || scheme.Type == SecurityOpenIdConnect) &&
+		(len(c.ClientId) > 0 || len(c.Username) > 0) && len(c.tokenURL(scheme)) > 0
+}
+
+// grant returns the grant the credential acquires its token with, the password grant if it has a
+// username and the client credentials grant otherwise.
+func (c *Credential) grant() string {
+	if len(c.Grant) > 0 {
+		return c.Grant
+	}
+	if len(c.Username) > 0 {
+		return GrantPassword
+	}
+	return GrantClientCredentials
+}
+
+// flow returns the flow of the scheme for the credential's grant, nil if the scheme has none.
+func (c *Credential) flow(scheme *spec.SecurityScheme) *spec.OAuthFlow {
+	if scheme.Flows == nil {
+		return nil
+	}
+	if c.grant() == GrantPassword {
+		return scheme.Flows.Password
+	}
+	return scheme.Flows.ClientCredentials
+}
+
+// tokenURL returns the token endpoint of the credential, the one of the scheme's flow unless the
+// credential has its own.
+func (c *Credential) tokenURL(scheme *spec.SecurityScheme) string {
+	if len(c.TokenURL) > 0 {
+		return c.TokenURL
+	}
+	if flow := c.flow(scheme); flow != nil {
+		return flow.TokenURL
+	}
+	return ""
+}
+
+// refreshURL returns the endpoint to refresh the credential's token at.
+func (c *Credential) refreshURL(scheme *spec.SecurityScheme) string {
+	if flow := c.flow(scheme); flow != nil && len(flow.RefreshURL) > 0 && len(c.TokenURL) == 0 {
+		return flow.RefreshURL
+	}
+	return c.tokenURL(scheme)
+}
+
+// oauthToken returns the access token of the credential for the scopes, acquiring one from the
+// token endpoint if there is no cached token, it expired or it's the rejected one. The token is
+// cached in the plan, so when several tests have the same token rejected only the first one gets a
+// new token. The tests of other credentials and scopes don't wait for it.
+func (plan *TestPlan) oauthToken(scheme *spec.SecurityScheme, c *Credential, scopes []string, rejected string) (string, error) {
+	if len(c.Scopes) > 0 {
+		scopes = c.Scopes
+	}
+	cached := plan.tokens.get(c, scopes)
+	cached.mutex.Lock()
+	defer cached.mutex.Unlock()
+	token := cached.token
+	if token != nil && token.accessToken != rejected && !token.expired() {
+		return token.accessToken, nil
+	}
+	var newToken *oauthToken
+	var err error
+	if token != nil && len(token.refreshToken) > 0 {
+		newToken, err = plan.requestToken(c.refreshURL(scheme), c, url.Values{
+			"grant_type":    {GrantRefreshToken},
+			"refresh_token": {token.refreshToken},
+		})
+	}
+	if newToken == nil {
+		form := url.Values{"grant_type": {c.grant()}}
+		if c.grant() == GrantPassword {
+			form.Set("username", c.Username)
+			form.Set("password", c.Password)
+		}
+		if len(scopes) > 0 {
+			form.Set("scope", strings.Join(scopes, " "))
+		}
+		newToken, err = plan.requestToken(c.tokenURL(scheme), c, form)
+	}
+	if err != nil {
+		cached.token = nil
+		return "", err
+	}
+	cached.token = newToken
+	return newToken.accessToken, nil
+}
+
+// requestToken posts the form to the token endpoint. The client authenticates with HTTP basic
+// authentication if it has a secret. A relative endpoint is relative to the plan's base URL.
+func (plan *TestPlan) requestToken(endpoint string, c *Credential, form url.Values) (*oauthToken, error) {
+	if u, err := url.Parse(endpoint); err == nil && !u.IsAbs() {
+		if base, err := url.Parse(plan.BaseURL); err == nil {
+			endpoint = base.ResolveReference(u).String()
+		}
+	}
+	req := plan.Client().R()
+	if len(c.ClientSecret) > 0 {
+		req.SetBasicAuth(c.ClientId, c.ClientSecret)
+	} else if len(c.ClientId) > 0 {
+		form.Set("client_id", c.ClientId)
+	}
+	req.SetHeader("Accept", "application/json")
+	req.SetMultiValueFormData(form)
+	resp, err := req.Post(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("can't get a token from %s: %s", endpoint, err.Error())
+	}
+	if !resp.IsSuccess() {
+		return nil, fmt.Errorf("can't get a token from %s: %s %s", endpoint, resp.Status(), string(resp.Body()))
+	}
+	var body struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(resp.Body(), &body); err != nil || len(body.AccessToken) == 0 {
+		return nil, fmt.Errorf("can't get a token from %s: no access_token in %s", endpoint, string(resp.Body()))
+	}
+	token := &oauthToken{accessToken: body.AccessToken, refreshToken: body.RefreshToken}
+	if body.ExpiresIn > 0 {
+		token.expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
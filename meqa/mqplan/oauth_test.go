@@ -0,0 +1,336 @@
+package mqplan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+func TestOAuthTokenConcurrent(t *testing.T) {
+	slowStarted, fastIssued := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := r.FormValue("client_id")
+		mutex.Lock()
+		requests[client]++
+		mutex.Unlock()
+		if client == "slow" {
+			// The slow token is only issued after the fast one.
+			once.Do(func() { close(slowStarted) })
+			select {
+			case <-fastIssued:
+			case <-time.After(5 * time.Second):
+				http.Error(w, "the fast token wasn't issued", http.StatusInternalServerError)
+				return
+			}
+		}
+		fmt.Fprintf(w, `{"access_token": "%s-token", "expires_in": 3600}`, client)
+	}))
+	defer server.Close()
+
+	plan := &TestPlan{BaseURL: server.URL}
+	scheme := &spec.SecurityScheme{Type: SecurityOAuth2}
+	slow := &Credential{ClientId: "slow", TokenURL: "/token"}
+	fast := &Credential{ClientId: "fast", TokenURL: "/token"}
+	var wg sync.WaitGroup
+	tokens, errs := make([]string, 3), make([]error, 3)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = plan.oauthToken(scheme, slow, nil, "")
+		}(i)
+	}
+	<-slowStarted
+	// The fast credential doesn't wait for the slow one's token.
+	token, err := plan.oauthToken(scheme, fast, nil, "")
+	close(fastIssued)
+	wg.Wait()
+
+	if err != nil || token != "fast-token" {
+		t.Errorf("expected the fast token, got %q %v", token, err)
+	}
+	for i := range tokens {
+		if errs[i] != nil || tokens[i] != "slow-token" {
+			t.Errorf("expected the slow token, got %q %v", tokens[i], errs[i])
+		}
+	}
+	// The tests of the slow credential wait for the first one to get the token.
+	if requests["slow"] != 1 || requests["fast"] != 1 {
+		t.Errorf("expected one token request per credential, got %v", requests)
+	}
+}
+
+// A token is only used for the scopes it was asked for.
+func TestOAuthTokenScopes(t *testing.T) {
+	var scopes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := r.FormValue("scope")
+		scopes = append(scopes, scope)
+		fmt.Fprintf(w, `{"access_token": "%s-token", "expires_in": 3600}`, strings.Replace(scope, " ", "+", -1))
+	}))
+	defer server.Close()
+
+	plan := &TestPlan{BaseURL: server.URL}
+	scheme := &spec.SecurityScheme{Type: SecurityOAuth2}
+	c := &Credential{ClientId: "meqa", TokenURL: "/token"}
+	for _, s := range []struct {
+		scopes []string
+		token  string
+	}{
+		{[]string{"read"}, "read-token"},
+		{[]string{"write"}, "write-token"},
+		{[]string{"read", "write"}, "read+write-token"},
+		{[]string{"write", "read"}, "read+write-token"},
+		{[]string{"read"}, "read-token"},
+	} {
+		token, err := plan.oauthToken(scheme, c, s.scopes, "")
+		if err != nil || token != s.token {
+			t.Errorf("expected %s for %v, got %q %v", s.token, s.scopes, token, err)
+		}
+	}
+	if want := []string{"read", "write", "read write"}; !reflect.DeepEqual(scopes, want) {
+		t.Errorf("expected a token per set of scopes, got %v", scopes)
+	}
+
+	// The scopes of the credential replace the ones of the requirement.
+	c = &Credential{ClientId: "meqa", TokenURL: "/token", Scopes: []string{"admin"}}
+	for _, s := range [][]string{{"read"}, {"write"}} {
+		if token, err := plan.oauthToken(scheme, c, s, ""); err != nil || token != "admin-token" {
+			t.Errorf("expected the admin token for %v, got %q %v", s, token, err)
+		}
+	}
+	if len(scopes) != 4 {
+		t.Errorf("expected a single admin token, got %v", scopes)
+	}
+}
+
+const oauthSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+security:
+- oauth: [read]
+paths:
+  /things/{id}:
+    get:
+      parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+      responses:
+        '200':
+          description: the thing
+components:
+  securitySchemes:
+    oauth:
+      type: oauth2
+      flows:
+        clientCredentials:
+          tokenUrl: /oauth/token
+          scopes:
+            read: read things
+        password:
+          tokenUrl: /oauth/password
+          refreshUrl: /oauth/refresh
+          scopes:
+            read: read things
+`
+
+func TestCredentialTokenURL(t *testing.T) {
+	scheme := &spec.SecurityScheme{Type: SecurityOAuth2, Flows: &spec.OAuthFlows{
+		ClientCredentials: &spec.OAuthFlow{TokenURL: "/oauth/token"},
+		Password:          &spec.OAuthFlow{TokenURL: "/oauth/password", RefreshURL: "/oauth/refresh"},
+	}}
+	for _, c := range []struct {
+		credential *Credential
+		scheme     *spec.SecurityScheme
+		grant      string
+		tokenURL   string
+		refreshURL string
+		acquires   bool
+	}{
+		{&Credential{ClientId: "meqa"}, scheme, GrantClientCredentials, "/oauth/token", "/oauth/token", true},
+		{&Credential{Username: "alice"}, scheme, GrantPassword, "/oauth/password", "/oauth/refresh", true},
+		{&Credential{Username: "alice", Grant: GrantClientCredentials}, scheme, GrantClientCredentials, "/oauth/token", "/oauth/token", true},
+		{&Credential{Username: "alice", TokenURL: "/token"}, scheme, GrantPassword, "/token", "/token", true},
+		{&Credential{ClientId: "meqa", Token: "t"}, scheme, GrantClientCredentials, "/oauth/token", "/oauth/token", false},
+		{&Credential{ClientSecret: "s"}, scheme, GrantClientCredentials, "/oauth/token", "/oauth/token", false},
+		{&Credential{ClientId: "meqa"}, &spec.SecurityScheme{Type: SecurityOAuth2}, GrantClientCredentials, "", "", false},
+		{&Credential{ClientId: "meqa", TokenURL: "/token"}, &spec.SecurityScheme{Type: SecurityOpenIdConnect}, GrantClientCredentials, "/token", "/token", true},
+		{&Credential{ClientId: "meqa", TokenURL: "/token"}, &spec.SecurityScheme{Type: SecurityHttp, Scheme: "bearer"}, GrantClientCredentials, "/token", "/token", false},
+	} {
+		grant, tokenURL, refreshURL, acquires := c.credential.grant(), c.credential.tokenURL(c.scheme), c.credential.refreshURL(c.scheme), c.credential.acquiresToken(c.scheme)
+		if grant != c.grant || tokenURL != c.tokenURL || refreshURL != c.refreshURL || acquires != c.acquires {
+			t.Errorf("expected %+v to use %s at %q refreshed at %q acquiring a token %v, got %s %q %q %v",
+				c.credential, c.grant, c.tokenURL, c.refreshURL, c.acquires, grant, tokenURL, refreshURL, acquires)
+		}
+		if acquires && !c.credential.canApply(c.scheme) {
+			t.Errorf("expected %+v to apply to %s", c.credential, c.scheme.Type)
+		}
+	}
+}
+
+const oauthPlan = `
+things suite:
+- name: get_1
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 1
+- name: get_2
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 2
+- name: get_3
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 3
+`
+
+func TestRunOAuth2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A stub token server: every grant issues a new token, and the API accepts only the latest.
+	var grants []string
+	var expiresIn int
+	var valid string
+	revokeAt := 0
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/oauth/") {
+			r.ParseForm()
+			grant := r.Form.Get("grant_type")
+			switch grant {
+			case GrantClientCredentials:
+				if id, secret, _ := r.BasicAuth(); r.URL.Path != "/oauth/token" || id != "meqa" || secret != "s3cret" || r.Form.Get("scope") != "read" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+			case GrantPassword:
+				if r.URL.Path != "/oauth/password" || r.Form.Get("username") != "alice" || r.Form.Get("password") != "pw" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+			}
+			grants = append(grants, grant+" "+r.URL.Path)
+			valid = fmt.Sprintf("token%d", len(grants))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  valid,
+				"token_type":    "Bearer",
+				"expires_in":    expiresIn,
+				"refresh_token": "refresh",
+			})
+			return
+		}
+		calls++
+		if calls == revokeAt {
+			valid = ""
+		}
+		if r.Header.Get("Authorization") != "Bearer "+valid {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	clientCredentials := &Credential{ClientId: "meqa", ClientSecret: "s3cret"}
+	for _, c := range []struct {
+		credential *Credential
+		expiresIn  int
+		revokeAt   int
+		expect401  bool
+		grants     []string
+		calls      int
+	}{
+		// The token is cached, and replaced when it's rejected.
+		{clientCredentials, 3600, 2, false, []string{"client_credentials /oauth/token", "refresh_token /oauth/token"}, 4},
+		// An expired token is refreshed before the request.
+		{&Credential{Username: "alice", Password: "pw"}, 5, 0, false,
+			[]string{"password /oauth/password", "refresh_token /oauth/refresh", "refresh_token /oauth/refresh"}, 3},
+		// A 401 the test expects isn't retried with a new token.
+		{clientCredentials, 3600, 2, true, []string{"client_credentials /oauth/token", "refresh_token /oauth/token"}, 4},
+	} {
+		grants, calls, valid = nil, 0, ""
+		expiresIn, revokeAt = c.expiresIn, c.revokeAt
+		plan := oauthPlan
+		if c.expect401 {
+			plan = strings.Replace(oauthPlan, "    id: 2\n", "    id: 2\n  expect:\n    status: 401\n", 1)
+		}
+		p := loadPlan(t, dir, oauthSpec, plan, server.URL)
+		p.Credentials = Credentials{"oauth": c.credential}
+		p.RunAll([]string{"things suite"}, 1)
+		if p.ResultCounts[mqutil.Passed] != 3 {
+			t.Errorf("expected 3 passed tests, got %v", p.ResultCounts)
+		}
+		if !reflect.DeepEqual(grants, c.grants) || calls != c.calls {
+			t.Errorf("expected the grants %v and %d calls, got %v and %d calls", c.grants, c.calls, grants, calls)
+		}
+	}
+}
+
+// Under -j the tests that had the same token rejected get a single new token.
+func TestRunOAuth2Rejected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var mutex sync.Mutex
+	var rejected sync.WaitGroup
+	rejected.Add(2)
+	var grants []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/oauth/") {
+			mutex.Lock()
+			grants = append(grants, r.FormValue("grant_type"))
+			token := fmt.Sprintf("token%d", len(grants))
+			mutex.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": token, "refresh_token": "refresh"})
+			return
+		}
+		switch r.Header.Get("Authorization") {
+		case "Bearer token1":
+			// Both suites have the first token rejected before either gets a new one.
+			rejected.Done()
+			rejected.Wait()
+			w.WriteHeader(http.StatusUnauthorized)
+		case "Bearer token2":
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, oauthSpec, "a suite:\n- name: get_1\n  path: /things/{id}\n  method: get\n  pathParams:\n    id: 1\n"+
+		"---\nb suite:\n- name: get_2\n  path: /things/{id}\n  method: get\n  pathParams:\n    id: 2\n", server.URL)
+	plan.Credentials = Credentials{"oauth": {ClientId: "meqa"}}
+	plan.RunAll([]string{"a suite", "b suite"}, 2)
+
+	if plan.ResultCounts[mqutil.Passed] != 2 {
+		t.Errorf("expected 2 passed tests, got %v", plan.ResultCounts)
+	}
+	if want := []string{GrantClientCredentials, GrantRefreshToken}; !reflect.DeepEqual(grants, want) {
+		t.Errorf("expected a single refresh, got %v", grants)
+	}
+}
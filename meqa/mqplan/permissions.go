@@ -0,0 +1,300 @@
+package mqplan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+// The outcomes of the calls of an operation by a role, and what they mean for the policy.
+const (
+	PermissionAllow   = "allow"   // a 2xx
+	PermissionDeny    = "deny"    // a 401 or 403
+	PermissionUnknown = "unknown" // any other status, the call didn't tell
+
+	PermissionOk              = "ok"
+	PermissionOverPermissive  = "over-permissive"  // allowed but the policy denies it
+	PermissionUnderPermissive = "under-permissive" // denied but the policy allows it
+)
+
+// Permissions is the policy of which roles may call which operations. It maps the roles, which are
+// the names of identities, to the operations they may call. An operation is given by its
+// operationId, its method and path (e.g. "GET /pet/{petId}"), or just its path for all of its
+// methods. "*" allows every operation.
+type Permissions map[string][]string
+
+// ReadPermissions reads a permissions file.
+func ReadPermissions(path string) (Permissions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var permissions Permissions
+	err = yaml.Unmarshal(data, &permissions)
+	if err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// Roles returns the roles of the policy in order.
+func (p Permissions) Roles() []string {
+	var roles []string
+	for role := range p {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Allows returns whether the policy lets role call the operation at path and method.
+func (p Permissions) Allows(role string, path string, method string, op *spec.Operation) bool {
+	for _, entry := range p[role] {
+		entry = strings.TrimSpace(entry)
+		if entry == "*" || entry == path || (op != nil && len(op.OperationID) > 0 && entry == op.OperationID) {
+			return true
+		}
+		if fields := strings.Fields(entry); len(fields) == 2 && strings.EqualFold(fields[0], method) && fields[1] == path {
+			return true
+		}
+	}
+	return false
+}
+
+// RunPermissions runs the named test suites once for every role of the policy, with the tests made
+// as the role. Tests with their own as: are setup steps and keep their identity, e.g. to create the
+// objects a role that may not create them calls. The roles must be identities of the plan. Every
+// role starts from a clean state, so what a role could do doesn't depend on the roles that ran
+// before it. See PermissionMatrix for the result.
+func (plan *TestPlan) RunPermissions(names []string, workers int, permissions Permissions) error {
+	roles := permissions.Roles()
+	for _, role := range roles {
+		if plan.Identities[role] == nil {
+			return fmt.Errorf("role %s of the permissions isn't an identity of the plan", role)
+		}
+	}
+	defer func() {
+		plan.runAs = ""
+	}()
+	for _, role := range roles {
+		fmt.Fprintf(plan.out(), "\n---\nRunning as role: %s\n", role)
+		plan.startOver()
+		plan.runAs = role
+		plan.RunAll(names, workers)
+	}
+	return nil
+}
+
+// startOver forgets the tests run so far, their variables and the objects they created, and gets
+// a new client. The results are kept.
+func (plan *TestPlan) startOver() {
+	plan.ctx.History = TestHistory{}
+	plan.ctx.DB = plan.ctx.DB.CloneSchema()
+	plan.newClient()
+}
+
+// PermissionMatrix compares the outcome of the calls each role made to each operation with the
+// policy.
+type PermissionMatrix struct {
+	Roles           []string                `json:"roles"`
+	Operations      []*OperationPermissions `json:"operations"`
+	OverPermissive  int                     `json:"overPermissive"`
+	UnderPermissive int                     `json:"underPermissive"`
+	Unknown         int                     `json:"unknown"` // the cells the calls didn't verify
+}
+
+// Violations returns the number of cells that don't show the role gets what the policy says.
+func (m *PermissionMatrix) Violations() int {
+	return m.OverPermissive + m.UnderPermissive + m.Unknown
+}
+
+// OperationPermissions is a row of the matrix, the cells are in the order of the roles.
+type OperationPermissions struct {
+	Path        string            `json:"path"`
+	Method      string            `json:"method"`
+	OperationId string            `json:"operationId,omitempty"`
+	Cells       []*PermissionCell `json:"cells"`
+}
+
+// PermissionCell is the outcome of the calls a role made to an operation.
+type PermissionCell struct {
+	Role     string `json:"role"`
+	Expected string `json:"expected"` // PermissionAllow or PermissionDeny
+	Actual   string `json:"actual"`   // PermissionAllow, PermissionDeny or PermissionUnknown
+	Statuses []int  `json:"statuses,omitempty"`
+	Verdict  string `json:"verdict"`
+}
+
+// record adds a call that got status to the cell. One allowed call is enough to allow the
+// operation.
+func (c *PermissionCell) record(status int) {
+	found := false
+	for _, s := range c.Statuses {
+		found = found || s == status
+	}
+	if !found {
+		c.Statuses = append(c.Statuses, status)
+		sort.Ints(c.Statuses)
+	}
+	switch {
+	case status >= 200 && status < 300:
+		c.Actual = PermissionAllow
+	case (status == http.StatusUnauthorized || status == http.StatusForbidden) && c.Actual != PermissionAllow:
+		c.Actual = PermissionDeny
+	}
+}
+
+// PermissionMatrix computes the matrix of the operations called by the roles of the policy so far.
+func (plan *TestPlan) PermissionMatrix(permissions Permissions) *PermissionMatrix {
+	m := &PermissionMatrix{Roles: permissions.Roles()}
+	if plan.ctx == nil || plan.ctx.Swagger == nil {
+		return m
+	}
+	swagger := plan.ctx.Swagger
+	rows := make(map[string]*OperationPermissions)
+	for _, t := range plan.resultList {
+		if _, ok := permissions[t.As]; !ok {
+			continue
+		}
+		key := mqswag.GetDAGName(mqswag.TypeOp, t.Path, t.Method)
+		row := rows[key]
+		if row == nil {
+			row = &OperationPermissions{Path: t.Path, Method: t.Method}
+			op := GetOperationByMethod(swagger.Paths[t.Path], t.Method)
+			if op != nil {
+				row.OperationId = op.OperationID
+			}
+			for _, role := range m.Roles {
+				cell := &PermissionCell{Role: role, Expected: PermissionDeny, Actual: PermissionUnknown}
+				if permissions.Allows(role, t.Path, t.Method, op) {
+					cell.Expected = PermissionAllow
+				}
+				row.Cells = append(row.Cells, cell)
+			}
+			rows[key] = row
+			m.Operations = append(m.Operations, row)
+		}
+		if t.resp == nil {
+			continue
+		}
+		for _, cell := range row.Cells {
+			if cell.Role == t.As {
+				cell.record(t.resp.StatusCode())
+			}
+		}
+	}
+	sort.Slice(m.Operations, func(i, j int) bool {
+		a, b := m.Operations[i], m.Operations[j]
+		return a.Path < b.Path || (a.Path == b.Path && methodIndex(a.Method) < methodIndex(b.Method))
+	})
+	for _, row := range m.Operations {
+		for _, cell := range row.Cells {
+			switch {
+			case cell.Actual == PermissionUnknown:
+				cell.Verdict = PermissionUnknown
+				m.Unknown++
+			case cell.Actual == cell.Expected:
+				cell.Verdict = PermissionOk
+			case cell.Actual == PermissionAllow:
+				cell.Verdict = PermissionOverPermissive
+				m.OverPermissive++
+			default:
+				cell.Verdict = PermissionUnderPermissive
+				m.UnderPermissive++
+			}
+		}
+	}
+	return m
+}
+
+func methodIndex(method string) int {
+	for i, m := range mqswag.MethodAll {
+		if m == method {
+			return i
+		}
+	}
+	return len(mqswag.MethodAll)
+}
+
+// text returns the text of the cell in the printed matrix, e.g. "allow (200)".
+func (c *PermissionCell) text() string {
+	if len(c.Statuses) == 0 {
+		return "not called"
+	}
+	var statuses []string
+	for _, s := range c.Statuses {
+		statuses = append(statuses, fmt.Sprint(s))
+	}
+	return fmt.Sprintf("%s (%s)", c.Actual, strings.Join(statuses, ","))
+}
+
+// Print writes the matrix in text to out. Over-permissive cells are red, under-permissive ones
+// yellow and unknown ones blue.
+func (m *PermissionMatrix) Print(out io.Writer) {
+	fmt.Fprint(out, mqutil.AQUA)
+	fmt.Fprintf(out, "-----------------------------Permissions-----------------------------\n")
+	fmt.Fprint(out, mqutil.END)
+	opWidth := len("operation")
+	widths := make([]int, len(m.Roles))
+	for i, role := range m.Roles {
+		widths[i] = len(role)
+	}
+	for _, row := range m.Operations {
+		if w := len(row.Method) + 1 + len(row.Path); w > opWidth {
+			opWidth = w
+		}
+		for i, cell := range row.Cells {
+			if w := len(cell.text()); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	fmt.Fprintf(out, "%-*s", opWidth, "operation")
+	for i, role := range m.Roles {
+		fmt.Fprintf(out, "  %-*s", widths[i], role)
+	}
+	fmt.Fprintln(out)
+	for _, row := range m.Operations {
+		fmt.Fprintf(out, "%-*s", opWidth, strings.ToUpper(row.Method)+" "+row.Path)
+		for i, cell := range row.Cells {
+			text := fmt.Sprintf("%-*s", widths[i], cell.text())
+			switch cell.Verdict {
+			case PermissionOverPermissive:
+				text = mqutil.RED + text + mqutil.END
+			case PermissionUnderPermissive:
+				text = mqutil.YELLOW + text + mqutil.END
+			case PermissionUnknown:
+				text = mqutil.BLUE + text + mqutil.END
+			}
+			fmt.Fprintf(out, "  %s", text)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprint(out, mqutil.RED)
+	fmt.Fprintf(out, "Over-permissive: %v\n", m.OverPermissive)
+	fmt.Fprint(out, mqutil.YELLOW)
+	fmt.Fprintf(out, "Under-permissive: %v\n", m.UnderPermissive)
+	fmt.Fprint(out, mqutil.BLUE)
+	fmt.Fprintf(out, "Unknown (not verified): %v\n", m.Unknown)
+	fmt.Fprint(out, mqutil.END)
+}
+
+// WriteToFile writes the matrix in JSON to path.
+func (m *PermissionMatrix) WriteToFile(path string) error {
+	b, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
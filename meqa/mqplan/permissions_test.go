@@ -0,0 +1,310 @@
+package mqplan
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+func TestAllows(t *testing.T) {
+	op := &spec.Operation{OperationID: "getThing"}
+	permissions := Permissions{
+		"admin": {"*"},
+		"user":  {"getThing", " post /things ", "/widgets"},
+		"guest": {},
+	}
+	for _, c := range []struct {
+		role     string
+		method   string
+		path     string
+		op       *spec.Operation
+		expected bool
+	}{
+		{"admin", "delete", "/things/{thingId}", nil, true},
+		{"user", "get", "/things/{thingId}", op, true},
+		{"user", "get", "/things/{thingId}", nil, false},
+		{"user", "post", "/things", nil, true},
+		{"user", "get", "/things", nil, false},
+		{"user", "delete", "/widgets", nil, true},
+		{"guest", "get", "/things/{thingId}", op, false},
+		{"nobody", "get", "/things/{thingId}", op, false},
+	} {
+		if got := permissions.Allows(c.role, c.path, c.method, c.op); got != c.expected {
+			t.Errorf("expected %s to be allowed %s %s %v, got %v", c.role, c.method, c.path, c.expected, got)
+		}
+	}
+}
+
+func TestPermissionCellRecord(t *testing.T) {
+	for _, c := range []struct {
+		statuses []int
+		actual   string
+		text     string
+	}{
+		{nil, PermissionUnknown, "not called"},
+		{[]int{200}, PermissionAllow, "allow (200)"},
+		{[]int{403, 401, 403}, PermissionDeny, "deny (401,403)"},
+		// One allowed call is enough.
+		{[]int{403, 204}, PermissionAllow, "allow (204,403)"},
+		{[]int{201, 403}, PermissionAllow, "allow (201,403)"},
+		{[]int{404, 500}, PermissionUnknown, "unknown (404,500)"},
+	} {
+		cell := &PermissionCell{Actual: PermissionUnknown}
+		for _, status := range c.statuses {
+			cell.record(status)
+		}
+		if cell.Actual != c.actual || cell.text() != c.text {
+			t.Errorf("expected %v to be %s, got %s", c.statuses, c.text, cell.text())
+		}
+	}
+}
+
+const permissionsPlan = `
+things suite:
+- name: post_thing
+  path: /things
+  method: post
+- name: get_thing
+  path: /things/{thingId}
+  method: get
+  pathParams:
+    thingId: 1
+- name: delete_thing
+  path: /things/{thingId}
+  method: delete
+  pathParams:
+    thingId: 1
+`
+
+func TestRunPermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The server lets users delete things but not read them, and fails the guest's deletes.
+	allowed := map[string]map[string]bool{
+		"Bearer admin": {http.MethodPost: true, http.MethodGet: true, http.MethodDelete: true},
+		"Bearer user":  {http.MethodPost: true, http.MethodDelete: true},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get("Authorization")
+		methods, ok := allowed[user]
+		switch {
+		case user == "Bearer guest" && r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusInternalServerError)
+		case !ok:
+			w.WriteHeader(http.StatusUnauthorized)
+		case !methods[r.Method]:
+			w.WriteHeader(http.StatusForbidden)
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": 1, "name": "thing"}`))
+		}
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, bolaSpec, permissionsPlan, server.URL)
+	plan.Identities = Identities{"admin": {ApiToken: "admin"}, "user": {ApiToken: "user"}, "guest": {ApiToken: "guest"}}
+	permissions := Permissions{
+		"admin": {"*"},
+		"user":  {"addThing", "GET /things/{thingId}"},
+		"guest": {},
+	}
+	if err := plan.RunPermissions([]string{"things suite"}, 1, permissions); err != nil {
+		t.Fatal(err)
+	}
+
+	m := plan.PermissionMatrix(permissions)
+	if want := []string{"admin", "guest", "user"}; !reflect.DeepEqual(m.Roles, want) {
+		t.Fatalf("expected the roles %v, got %v", want, m.Roles)
+	}
+	var rows []string
+	for _, row := range m.Operations {
+		var verdicts []string
+		for _, cell := range row.Cells {
+			verdicts = append(verdicts, cell.Verdict)
+		}
+		rows = append(rows, row.Method+" "+row.Path+" "+row.OperationId+": "+strings.Join(verdicts, " "))
+	}
+	want := []string{
+		"post /things addThing: ok ok ok",
+		"get /things/{thingId} getThing: ok ok under-permissive",
+		"delete /things/{thingId} deleteThing: ok unknown over-permissive",
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("expected the rows\n%v\ngot\n%v", want, rows)
+	}
+	if m.OverPermissive != 1 || m.UnderPermissive != 1 || m.Unknown != 1 || m.Violations() != 3 {
+		t.Errorf("expected one over-permissive, under-permissive and unknown cell, got %d, %d and %d", m.OverPermissive, m.UnderPermissive, m.Unknown)
+	}
+	var out bytes.Buffer
+	m.Print(&out)
+	for _, text := range []string{"GET /things/{thingId}", "deny (403)", "unknown (500)", "Unknown (not verified): 1"} {
+		if !strings.Contains(out.String(), text) {
+			t.Errorf("expected the matrix to contain %s, got\n%s", text, out.String())
+		}
+	}
+
+	// A role without an identity can't be run.
+	permissions["nobody"] = []string{"*"}
+	if err := plan.RunPermissions([]string{"things suite"}, 1, permissions); err == nil || !strings.Contains(err.Error(), "role nobody") {
+		t.Errorf("expected an error for a role that isn't an identity, got %v", err)
+	}
+}
+
+const setupPlan = `
+things suite:
+- name: create_thing
+  path: /things
+  method: post
+  as: admin
+- name: post_thing
+  path: /things
+  method: post
+- name: get_thing
+  path: /things/{thingId}
+  method: get
+  pathParams:
+    thingId: '{{create_thing.outputs.id}}'
+- name: delete_thing
+  path: /things/{thingId}
+  method: delete
+  pathParams:
+    thingId: '{{create_thing.outputs.id}}'
+`
+
+// A test with its own as: creates the thing the role calls, even if the role may not create it.
+func TestPermissionsSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Users may only read things.
+	var mutex sync.Mutex
+	var calls []string
+	things := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		user := r.Header.Get("Authorization")
+		calls = append(calls, user+" "+r.Method)
+		switch {
+		case user != "Bearer admin" && r.Method != http.MethodGet:
+			w.WriteHeader(http.StatusForbidden)
+		case r.Method == http.MethodPost:
+			id := len(things) + 1
+			things[fmt.Sprintf("/things/%d", id)] = true
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"id": %d, "name": "thing"}`, id)
+		case !things[r.URL.Path]:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodDelete:
+			delete(things, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": 1, "name": "thing"}`))
+		}
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, bolaSpec, setupPlan, server.URL)
+	plan.Identities = Identities{"admin": {ApiToken: "admin"}, "user": {ApiToken: "user"}}
+	permissions := Permissions{"admin": {"*"}, "user": {"GET /things/{thingId}"}}
+	if err := plan.RunPermissions([]string{"things suite"}, 1, permissions); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"Bearer admin POST", "Bearer admin POST", "Bearer admin GET", "Bearer admin DELETE",
+		"Bearer admin POST", "Bearer user POST", "Bearer user GET", "Bearer user DELETE",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("expected the calls\n%v\ngot\n%v", want, calls)
+	}
+	m := plan.PermissionMatrix(permissions)
+	for _, row := range m.Operations {
+		for _, cell := range row.Cells {
+			if cell.Verdict != PermissionOk {
+				t.Errorf("expected %s %s to be ok for %s, got %s %v", row.Method, row.Path, cell.Role, cell.Verdict, cell.Statuses)
+			}
+		}
+	}
+	if len(m.Operations) != 3 || m.Violations() != 0 {
+		t.Errorf("expected 3 operations and no violations, got %d and %d", len(m.Operations), m.Violations())
+	}
+}
+
+const cleanStatePlan = `
+things suite:
+- name: post_thing
+  path: /things
+  method: post
+  capture:
+    id: responseHeaders.X-Thing-Id
+- name: get_thing
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: '{{vars.id}}'
+`
+
+// A role doesn't get to use what the roles before it created, nor their connections.
+func TestPermissionsCleanState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var mutex sync.Mutex
+	var paths []string
+	addrs := make(map[string]map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		user := r.Header.Get("Authorization")
+		if addrs[r.RemoteAddr] == nil {
+			addrs[r.RemoteAddr] = make(map[string]bool)
+		}
+		addrs[r.RemoteAddr][user] = true
+		if user != "Bearer admin" {
+			paths = append(paths, r.Method+" "+r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodPost {
+			w.Header().Set("X-Thing-Id", "7")
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+	plan := loadPlan(t, dir, thingsSpec, cleanStatePlan, server.URL)
+	plan.SetClientConfig(&ClientConfig{})
+	plan.Identities = Identities{"admin": {ApiToken: "admin"}, "user": {ApiToken: "user"}}
+	err = plan.RunPermissions([]string{"things suite"}, 1, Permissions{"admin": {"*"}, "user": {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range paths {
+		if strings.HasSuffix(p, "/7") {
+			t.Errorf("expected the user not to get the admin's thing, got %v", paths)
+		}
+	}
+	for addr, users := range addrs {
+		if len(users) > 1 {
+			t.Errorf("expected the roles not to share a connection, got %v on %s", users, addr)
+		}
+	}
+}
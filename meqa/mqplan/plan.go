@@ -1,11 +1,12 @@
 package mqplan
 
 import (
-	"encoding/json"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -29,11 +30,11 @@ const (
 )
 
 type TestParams struct {
-	QueryParams  map[string]interface{} `yaml:"queryParams,omitempty"`
-	FormParams   map[string]interface{} `yaml:"formParams,omitempty"`
-	PathParams   map[string]interface{} `yaml:"pathParams,omitempty"`
-	HeaderParams map[string]interface{} `yaml:"headerParams,omitempty"`
-	BodyParams   interface{}            `yaml:"bodyParams,omitempty"`
+	QueryParams  map[string]interface{} `yaml:"queryParams,omitempty" json:"queryParams,omitempty"`
+	FormParams   map[string]interface{} `yaml:"formParams,omitempty" json:"formParams,omitempty"`
+	PathParams   map[string]interface{} `yaml:"pathParams,omitempty" json:"pathParams,omitempty"`
+	HeaderParams map[string]interface{} `yaml:"headerParams,omitempty" json:"headerParams,omitempty"`
+	BodyParams   interface{}            `yaml:"bodyParams,omitempty" json:"bodyParams,omitempty"`
 }
 
 // Copy the parameters from src. If there is a conflict dst will be overwritten.
@@ -80,15 +81,28 @@ type TestSuite struct {
 	// test suite parameters
 	TestParams `yaml:",inline,omitempty" json:",inline,omitempty"`
 	Strict     bool
+	Examples   bool
+	FuzzStatus interface{}
 
 	// Authentication
 	Username string
 	Password string
 	ApiToken string
+	As       string // the identity the tests run as, the suite's credentials if empty
 
 	plan *TestPlan
+	ctx  *Context
 	db   *mqswag.DB // objects generated/obtained as part of this suite
 
+	// The following are only set on the copy of the suite that is being run.
+	out       io.Writer         // console output
+	history   *TestHistory      // tests run by this suite, falls back to the plan's history
+	results   []*Test           // tests executed, in order
+	skipped   []*Test           // tests not executed because an earlier create failed
+	failures  []*mqswag.Payload // new fuzz failures
+	startTime time.Time
+	stopTime  time.Time
+
 	comment string
 }
 
@@ -98,12 +112,33 @@ func CreateTestSuite(name string, tests []*Test, plan *TestPlan) *TestSuite {
 	c.Tests = tests
 	(&c.TestParams).Copy(&plan.TestParams)
 	c.Strict = plan.Strict
+	c.Examples = plan.Examples
+	c.FuzzStatus = plan.FuzzStatus
 
 	c.Username = plan.Username
 	c.Password = plan.Password
 	c.ApiToken = plan.ApiToken
+	c.As = plan.As
 
 	c.plan = plan
+	c.ctx = plan.ctx
+	return &c
+}
+
+// clone returns a copy of the suite to hold the state of a single run. The parameter maps are
+// copied because meqa_init tests update them while the suite runs.
+func (tc *TestSuite) clone() *TestSuite {
+	c := *tc
+	c.QueryParams = mqutil.MapCopy(tc.QueryParams)
+	c.FormParams = mqutil.MapCopy(tc.FormParams)
+	c.PathParams = mqutil.MapCopy(tc.PathParams)
+	c.HeaderParams = mqutil.MapCopy(tc.HeaderParams)
+	if m, ok := tc.BodyParams.(map[string]interface{}); ok {
+		c.BodyParams = mqutil.MapCopy(m)
+	}
+	c.results = nil
+	c.skipped = nil
+	c.failures = nil
 	return &c
 }
 
@@ -111,26 +146,37 @@ func CreateTestSuite(name string, tests []*Test, plan *TestPlan) *TestSuite {
 type TestPlan struct {
 	SuiteMap  map[string](*TestSuite)
 	SuiteList [](*TestSuite)
-	db        *mqswag.DB
-	swagger   *mqswag.Swagger
+	ctx       *Context
 
 	// global parameters
-	TestParams `yaml:",inline,omitempty" json:",inline,omitempty"`
-	Strict     bool
-	BaseURL    string
+	TestParams   `yaml:",inline,omitempty" json:",inline,omitempty"`
+	Strict       bool
+	Examples     bool        // prefer the examples in the spec to random values
+	StrictSchema bool        // validate responses with a JSON Schema validator, a mismatch fails the test
+	FuzzStatus   interface{} // what negative fuzz requests expect, 400 if not set
+	BaseURL      string
 
 	// Authentication
-	Username string
-	Password string
-	ApiToken string
+	Username    string
+	Password    string
+	ApiToken    string
+	Credentials Credentials // the credentials of the security schemes in the spec
+	Identities  Identities  // the identities tests can run as
+	As          string      // the identity the tests run as by default
+	runAs       string      // if set, the identity all the tests run as, see RunPermissions
+	tokens      tokenCache  // the OAuth2 tokens acquired for the credentials
+
+	client       *resty.Client            // see Client()
+	clients      map[string]*resty.Client // the clients of the identities, see clientAs
+	clientConfig *ClientConfig            // what client was created from, nil if it was given to SetClient
+	Out          io.Writer                // the console output of the run, os.Stdout if nil
 
 	// Run result.
 	resultList   []*Test
+	suiteResults []*TestSuite // the runs of the top level suites, in order
 	ResultCounts map[string]int
-
-	OldFailuresMap map[string]map[string]map[string]map[mqutil.FuzzValue]bool // endpoint->method->field->(value,fuzzType)->bool
-	NewFailures    []*mqswag.Payload
-	OtherFailures  []*mqswag.Payload // Failures where fuzzType != currFuzzType
+	Events       io.Writer  // if set, a JSON event is written for every test as it finishes
+	mutex        sync.Mutex // protects the run results when suites run in parallel
 
 	comment  string
 	FuzzType string
@@ -141,7 +187,7 @@ type TestPlan struct {
 func (plan *TestPlan) Add(testSuite *TestSuite) error {
 	if _, exist := plan.SuiteMap[testSuite.Name]; exist {
 		str := fmt.Sprintf("Duplicate name %s found in test plan", testSuite.Name)
-		mqutil.Logger.Println(str)
+		plan.ctx.Logger.Println(str)
 		return errors.New(str)
 	}
 	plan.SuiteMap[testSuite.Name] = testSuite
@@ -153,24 +199,36 @@ func (plan *TestPlan) AddFromString(data string) error {
 	var suiteMap map[string]([]*Test)
 	err := yaml.Unmarshal([]byte(data), &suiteMap)
 	if err != nil {
-		mqutil.Logger.Printf("The following is not a valud TestSuite:\n%s", data)
+		plan.ctx.Logger.Printf("The following is not a valud TestSuite:\n%s", data)
 		return err
 	}
 
+	// The global parameters go first, the suites copy them when they are created.
+	for _, t := range suiteMap[MeqaInit] {
+		t.Init(plan.ctx, nil)
+		(&plan.TestParams).Copy(&t.TestParams)
+		plan.Strict = t.Strict
+		plan.Examples = plan.Examples || t.Examples
+		if t.FuzzStatus != nil {
+			plan.FuzzStatus = t.FuzzStatus
+		}
+		if len(t.As) > 0 {
+			plan.As = t.As
+		}
+		for name, id := range t.Identities {
+			if plan.Identities == nil {
+				plan.Identities = make(Identities)
+			}
+			plan.Identities[name] = id
+		}
+	}
 	for suiteName, testList := range suiteMap {
 		if suiteName == MeqaInit {
-			// global parameters
-			for _, t := range testList {
-				t.Init(nil)
-				(&plan.TestParams).Copy(&t.TestParams)
-				plan.Strict = t.Strict
-			}
-
 			continue
 		}
 		testSuite := CreateTestSuite(suiteName, testList, plan)
 		for _, t := range testList {
-			t.Init(testSuite)
+			t.Init(plan.ctx, testSuite)
 		}
 		err = plan.Add(testSuite)
 		if err != nil {
@@ -180,13 +238,13 @@ func (plan *TestPlan) AddFromString(data string) error {
 	return nil
 }
 
-func (plan *TestPlan) InitFromFile(path string, db *mqswag.DB) error {
-	plan.Init(db.Swagger, db)
+func (plan *TestPlan) InitFromFile(path string, ctx *Context) error {
+	plan.Init(ctx)
 
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		mqutil.Logger.Printf("Can't open the following file: %s", path)
-		mqutil.Logger.Println(err.Error())
+		plan.ctx.Logger.Printf("Can't open the following file: %s", path)
+		plan.ctx.Logger.Println(err.Error())
 		return err
 	}
 	chunks := strings.Split(string(data), "---")
@@ -196,44 +254,6 @@ func (plan *TestPlan) InitFromFile(path string, db *mqswag.DB) error {
 	return nil
 }
 
-// ReadFails reads the .mqfails file and stores previous failures in OldFailuresMap and OtherFailures
-func (plan *TestPlan) ReadFails(path string) error {
-	f, err := os.Open(filepath.Join(path, MeqaFails))
-	defer f.Close()
-	if err != nil {
-		return err
-	}
-	failures := make(map[string]map[string]map[string]map[mqutil.FuzzValue]bool)
-	d := json.NewDecoder(f)
-	for {
-		var v mqswag.Payload
-		if err := d.Decode(&v); err == io.EOF {
-			break
-		} else if err != nil {
-			return err
-		}
-		// Initialize the maps if they don't exist
-		if failures[v.Endpoint] == nil {
-			failures[v.Endpoint] = make(map[string]map[string]map[mqutil.FuzzValue]bool)
-		}
-		if failures[v.Endpoint][v.Method] == nil {
-			failures[v.Endpoint][v.Method] = make(map[string]map[mqutil.FuzzValue]bool)
-		}
-		if failures[v.Endpoint][v.Method][v.Field] == nil {
-			failures[v.Endpoint][v.Method][v.Field] = make(map[mqutil.FuzzValue]bool)
-		}
-		// Add failures matching current fuzzType to OldFailuresMap and rest to OtherFailuresMap
-		if plan.FuzzType == v.FuzzType || plan.FuzzType == mqutil.FuzzAll {
-			fuzzValue := mqutil.FuzzValue{Value: v.Value, FuzzType: v.FuzzType}
-			failures[v.Endpoint][v.Method][v.Field][fuzzValue] = true
-		} else {
-			plan.OtherFailures = append(plan.OtherFailures, &v)
-		}
-	}
-	plan.OldFailuresMap = failures
-	return nil
-}
-
 func WriteComment(comment string, f *os.File) {
 	ar := strings.Split(comment, "\n")
 	for _, line := range ar {
@@ -288,7 +308,7 @@ func (plan *TestPlan) WriteResultToFile(path string) error {
 	return p.DumpToFile(path)
 }
 
-func ReadMetadata(path string) map[string]interface{} {
+func ReadMetadata(path string, logger *log.Logger) map[string]interface{} {
 	var meta map[string]interface{}
 	data, err := ioutil.ReadFile(filepath.Join(path, MetaFile))
 	if err != nil {
@@ -296,59 +316,11 @@ func ReadMetadata(path string) map[string]interface{} {
 	}
 	err = yaml.Unmarshal([]byte(data), &meta)
 	if err != nil {
-		mqutil.Logger.Printf("error: %v", err)
+		logger.Printf("error: %v", err)
 	}
 	return meta
 }
 
-// WriteFailures writes new failures to mqfails file
-func (plan *TestPlan) WriteFailures(path string) error {
-	flags := os.O_CREATE | os.O_WRONLY
-	var perms os.FileMode
-	if plan.Repro {
-		// Overwrite the file
-		flags |= os.O_TRUNC
-		perms = 0755
-	} else {
-		// Append new failures at the end
-		flags |= os.O_APPEND
-		perms = 0644
-	}
-	// Write/Append to mqfails
-	mqFails, err := os.OpenFile(filepath.Join(path, MeqaFails), flags, perms)
-	defer mqFails.Close()
-	if err != nil {
-		return err
-	}
-	// Write only new failures to new newFails
-	newFails, err := os.OpenFile(filepath.Join(path, NewFails), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
-	defer newFails.Close()
-	if err != nil {
-		return err
-	}
-	d1 := json.NewEncoder(mqFails)
-	d2 := json.NewEncoder(newFails)
-	meta := ReadMetadata(path)
-	if plan.Repro {
-		// Write the previous failures which we haven't tested (in this run) as it is
-		for _, v := range plan.OtherFailures {
-			if err := d1.Encode(v); err != nil {
-				return err
-			}
-		}
-	}
-	for _, v := range plan.NewFailures {
-		v.Meta = meta
-		if err := d1.Encode(v); err != nil {
-			return err
-		}
-		if err := d2.Encode(v); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 func (plan *TestPlan) LogErrors() {
 	fmt.Print(mqutil.AQUA)
 	fmt.Printf("------------------------SchemaMismatches-----------------------------\n")
@@ -365,6 +337,34 @@ func (plan *TestPlan) LogErrors() {
 		}
 	}
 	fmt.Print(mqutil.AQUA)
+	fmt.Printf("-----------------------ContentMismatches-----------------------------\n")
+	fmt.Print(mqutil.END)
+	for _, t := range plan.resultList {
+		if t.contentError != nil {
+			fmt.Print(mqutil.AQUA)
+			fmt.Println("--------")
+			fmt.Printf("%v: %v\n", t.Path, t.Name)
+			fmt.Print(mqutil.END)
+			fmt.Print(mqutil.YELLOW)
+			fmt.Println(t.contentError.Error())
+			fmt.Print(mqutil.END)
+		}
+	}
+	fmt.Print(mqutil.AQUA)
+	fmt.Printf("-----------------------HeaderMismatches------------------------------\n")
+	fmt.Print(mqutil.END)
+	for _, t := range plan.resultList {
+		if t.headerError != nil {
+			fmt.Print(mqutil.AQUA)
+			fmt.Println("--------")
+			fmt.Printf("%v: %v\n", t.Path, t.Name)
+			fmt.Print(mqutil.END)
+			fmt.Print(mqutil.YELLOW)
+			fmt.Println(t.headerError.Error())
+			fmt.Print(mqutil.END)
+		}
+	}
+	fmt.Print(mqutil.AQUA)
 	fmt.Printf("-----------------------------Errors----------------------------------\n")
 	fmt.Print(mqutil.END)
 	for _, t := range plan.resultList {
@@ -392,45 +392,188 @@ func (plan *TestPlan) PrintSummary() {
 	fmt.Print(mqutil.YELLOW)
 	fmt.Printf("%v: %v\n", mqutil.Skipped, plan.ResultCounts[mqutil.Skipped])
 	fmt.Printf("%v: %v\n", mqutil.SchemaMismatch, plan.ResultCounts[mqutil.SchemaMismatch])
+	fmt.Printf("%v: %v\n", mqutil.ContentMismatch, plan.ResultCounts[mqutil.ContentMismatch])
+	fmt.Printf("%v: %v\n", mqutil.HeaderMismatch, plan.ResultCounts[mqutil.HeaderMismatch])
 	fmt.Print(mqutil.AQUA)
 	fmt.Printf("%v: %v\n", mqutil.Total, plan.ResultCounts[mqutil.Total])
 	fmt.Print(mqutil.RED)
-	fmt.Printf("%v: %v\n", mqutil.FuzzFails, len(plan.NewFailures))
+	fmt.Printf("%v: %v\n", mqutil.FuzzFails, len(plan.ctx.NewFailures))
 	fmt.Print(mqutil.AQUA)
 	fmt.Printf("%v: %v\n", mqutil.FuzzTotal, plan.ResultCounts[mqutil.FuzzTotal])
 	fmt.Print(mqutil.END)
 }
 
-func (plan *TestPlan) Init(swagger *mqswag.Swagger, db *mqswag.DB) {
-	plan.db = db
-	plan.swagger = swagger
+func (plan *TestPlan) Init(ctx *Context) {
+	plan.ctx = ctx
 	plan.SuiteMap = make(map[string]*TestSuite)
 	plan.SuiteList = nil
 	plan.resultList = nil
+	plan.suiteResults = nil
+}
+
+// Context returns the context the plan runs in.
+func (plan *TestPlan) Context() *Context {
+	return plan.ctx
+}
+
+// out returns the writer the console output of the run goes to.
+func (plan *TestPlan) out() io.Writer {
+	if plan.Out != nil {
+		return plan.Out
+	}
+	return os.Stdout
+}
+
+// AddResultCounts adds counts to the plan's ResultCounts.
+func (plan *TestPlan) AddResultCounts(counts map[string]int) {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	if plan.ResultCounts == nil {
+		plan.ResultCounts = make(map[string]int)
+	}
+	for k := range counts {
+		plan.ResultCounts[k] += counts[k]
+	}
+}
+
+// addResults records the tests and fuzz failures of a finished suite run.
+func (plan *TestPlan) addResults(tc *TestSuite) {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	plan.resultList = append(plan.resultList, tc.results...)
+	plan.suiteResults = append(plan.suiteResults, tc)
+	plan.ctx.NewFailures = append(plan.ctx.NewFailures, tc.failures...)
 }
 
 // Run a named TestSuite in the test plan.
 func (plan *TestPlan) Run(name string, parentTest *Test) (map[string]int, error) {
-	tc, ok := plan.SuiteMap[name]
+	tc, resultCounts, err := plan.runSuite(name, parentTest, plan.out(), nil)
+	if tc != nil {
+		plan.addResults(tc)
+		plan.ctx.History.merge(tc.history)
+	}
+	return resultCounts, err
+}
+
+// RunAll runs the named test suites with up to workers suites running at the same time, and adds
+// up their result counts. The console output of each suite is held back until the suites before
+// it have finished, and the results are recorded in the order of names, so neither depends on
+// how the suites were scheduled.
+func (plan *TestPlan) RunAll(names []string, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	type suiteRun struct {
+		out   bytes.Buffer
+		suite *TestSuite
+		done  chan struct{}
+	}
+	runs := make([]*suiteRun, len(names))
+	for i := range runs {
+		runs[i] = &suiteRun{done: make(chan struct{})}
+	}
+
+	next := make(chan int)
+	go func() {
+		for i := range names {
+			next <- i
+		}
+		close(next)
+	}()
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range next {
+				r := runs[i]
+				var out io.Writer = &r.out
+				if workers == 1 {
+					// Nothing to interleave with, print as we go.
+					out = plan.out()
+				}
+				plan.ctx.Logger.Printf("\n---\nTest suite: %s\n", names[i])
+				fmt.Fprintf(out, "\n---\nTest suite: %s\n", names[i])
+				tc, counts, err := plan.runSuite(names[i], nil, out, nil)
+				plan.ctx.Logger.Printf("err:\n%v", err)
+				plan.AddResultCounts(counts)
+				if workers == 1 && tc != nil {
+					// The next suite sees the tests of this one, as if they were a single run.
+					plan.ctx.History.merge(tc.history)
+				}
+				r.suite = tc
+				close(r.done)
+			}
+		}()
+	}
+
+	for _, r := range runs {
+		<-r.done
+		if workers > 1 {
+			plan.out().Write(r.out.Bytes())
+		}
+		if r.suite != nil {
+			plan.addResults(r.suite)
+		}
+	}
+	if workers > 1 {
+		// The suites only saw their own tests while running. Once they are all done later suites
+		// can see them, in the order of names.
+		for _, r := range runs {
+			if r.suite != nil {
+				plan.ctx.History.merge(r.suite.history)
+			}
+		}
+	}
+}
+
+// runSuite runs a copy of the named suite, writing its console output to out. Tests are looked up
+// in h when resolving parameters. If h is nil a new history is created for the suite, which falls
+// back to the plan's history but isn't added to it until the caller merges it.
+func (plan *TestPlan) runSuite(name string, parentTest *Test, out io.Writer, h *TestHistory) (*TestSuite, map[string]int, error) {
+	suite, ok := plan.SuiteMap[name]
 	resultCounts := make(map[string]int)
-	if !ok || len(tc.Tests) == 0 {
+	if !ok || len(suite.Tests) == 0 {
 		str := fmt.Sprintf("The following test suite is not found: %s", name)
-		mqutil.Logger.Println(str)
-		return resultCounts, errors.New(str)
+		plan.ctx.Logger.Println(str)
+		return nil, resultCounts, errors.New(str)
+	}
+	if h == nil {
+		h = &TestHistory{parent: &plan.ctx.History}
 	}
-	tc.db = plan.db.CloneSchema()
+	tc := suite.clone()
+	tc.db = plan.ctx.DB.CloneSchema()
+	tc.out = &lockedWriter{w: out}
+	tc.history = h
+	// Templates in the suite parameters, e.g. {{uuid()}} in meqa_init, are resolved once per run.
+	tc.TestParams.ResolveWithHistory(h, plan.ctx.Logger)
+	tc.startTime = time.Now()
 	defer func() {
-		tc.db = nil
+		tc.stopTime = time.Now()
 	}()
+
 	resultCounts[mqutil.Total] = len(tc.Tests)
 	resultCounts[mqutil.Failed] = 0
 	var tcErr error
 	for i, test := range tc.Tests {
 		if len(test.Ref) != 0 {
 			test.Strict = tc.Strict
-			resultCounts, err := plan.Run(test.Ref, test)
+			test.Examples = tc.Examples
+			if test.FuzzStatus == nil {
+				test.FuzzStatus = tc.FuzzStatus
+			}
+			// The identity is set on a copy: the suite's can change from run to run.
+			ref := *test
+			if len(ref.As) == 0 {
+				ref.As = plan.runAs
+			}
+			if len(ref.As) == 0 {
+				ref.As = tc.As
+			}
+			refSuite, resultCounts, err := plan.runSuite(test.Ref, &ref, out, h)
+			if refSuite != nil {
+				tc.results = append(tc.results, refSuite.results...)
+				tc.failures = append(tc.failures, refSuite.failures...)
+			}
 			if err != nil {
-				return resultCounts, err
+				return tc, resultCounts, err
 			}
 			continue
 		}
@@ -438,35 +581,54 @@ func (plan *TestPlan) Run(name string, parentTest *Test) (map[string]int, error)
 		if test.Name == MeqaInit {
 			// Apply the parameters to the test suite.
 			(&tc.TestParams).Copy(&test.TestParams)
+			tc.TestParams.ResolveWithHistory(h, plan.ctx.Logger)
 			tc.Strict = test.Strict
+			tc.Examples = tc.Examples || test.Examples
+			if test.FuzzStatus != nil {
+				tc.FuzzStatus = test.FuzzStatus
+			}
+			if len(test.As) > 0 {
+				tc.As = test.As
+			}
 			continue
 		}
 
 		dup := test.SchemaDuplicate()
+		dup.suite, dup.db = tc, tc.db
 		dup.Strict = tc.Strict
 		if parentTest != nil {
 			dup.CopyParent(parentTest)
 		}
-		dup.ResolveHistoryParameters(&History)
-		History.Append(dup)
+		// A test with its own as: keeps it when the tests run as a role, it's a setup step.
+		if len(dup.As) == 0 {
+			dup.As = plan.runAs
+		}
+		if len(dup.As) == 0 {
+			dup.As = tc.As
+		}
+		dup.ResolveHistoryParameters(h)
+		h.Append(dup)
 		if parentTest != nil {
 			dup.Name = parentTest.Name // always inherit the name
 		}
 		payloads, err := dup.Run(tc) // Run the test case
 		// Store new failures with their payloads
-		if payloads != nil && len(payloads) > 0 {
-			if plan.NewFailures == nil {
-				plan.NewFailures = make([]*mqswag.Payload, 0, len(payloads))
-			}
-			plan.NewFailures = append(plan.NewFailures, payloads...)
-		}
+		dup.fuzzFailures = payloads
+		tc.failures = append(tc.failures, payloads...)
 		dup.err = err
-		plan.resultList = append(plan.resultList, dup)
+		tc.results = append(tc.results, dup)
+		plan.writeEvent(tc.Name, dup)
 		if dup.schemaError != nil {
 			resultCounts[mqutil.SchemaMismatch]++
 		}
+		if dup.contentError != nil {
+			resultCounts[mqutil.ContentMismatch]++
+		}
+		if dup.headerError != nil {
+			resultCounts[mqutil.HeaderMismatch]++
+		}
 		if err != nil {
-			mqutil.Logger.Println(err.Error())
+			plan.ctx.Logger.Println(err.Error())
 			resultCounts[mqutil.Failed]++
 			if tcErr == nil {
 				tcErr = err
@@ -474,45 +636,96 @@ func (plan *TestPlan) Run(name string, parentTest *Test) (map[string]int, error)
 		} else {
 			resultCounts[mqutil.Passed]++
 		}
-		// If creation (POST) of an object fails, subsequent GET, PUT, DELETE tests will fail too, so just skip them
-		if dup.Method == mqswag.MethodPost && len(dup.PathParams) == 0 && dup.resp.RawResponse.StatusCode >= 300 {
-			fmt.Printf("Skipping %v tests...\n", len(tc.Tests)-i-1)
+		// If creation (POST) of an object fails, subsequent GET, PUT, DELETE tests will fail too, so just skip them.
+		// When checking permissions every operation is called whatever the create got.
+		if len(plan.runAs) == 0 && dup.Method == mqswag.MethodPost && len(dup.PathParams) == 0 && dup.resp != nil && dup.resp.StatusCode() >= 300 {
+			fmt.Fprintf(tc.out, "Skipping %v tests...\n", len(tc.Tests)-i-1)
 			resultCounts[mqutil.Skipped] += len(tc.Tests) - i - 1
+			tc.skipped = append(tc.skipped, tc.Tests[i+1:]...)
 			break
 		}
 	}
-	return resultCounts, tcErr
+	return tc, resultCounts, tcErr
 }
 
-// The current global TestPlan
-var Current TestPlan
+// lockedWriter serializes the writes of the concurrent fuzz requests within a suite.
+type lockedWriter struct {
+	w     io.Writer
+	mutex sync.Mutex
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	return lw.w.Write(p)
+}
 
 // TestHistory records the execution result of all the tests
 type TestHistory struct {
-	tests []*Test
-	mutex sync.Mutex
+	tests  []*Test
+	vars   map[string]interface{} // the values captured by the tests
+	parent *TestHistory           // searched when a test is not found in this history
+	mutex  sync.Mutex
 }
 
 // GetTest gets a test by its name
 func (h *TestHistory) GetTest(name string) *Test {
 	h.mutex.Lock()
-	defer h.mutex.Unlock()
 	for i := len(h.tests) - 1; i >= 0; i-- {
 		if h.tests[i].Name == name {
+			h.mutex.Unlock()
 			return h.tests[i]
 		}
 	}
+	h.mutex.Unlock()
+	if h.parent != nil {
+		return h.parent.GetTest(name)
+	}
 	return nil
 }
+
+// Append adds the test to this history. Its parents don't see it until the history is merged into
+// them, so suites running at the same time don't see each other's tests.
 func (h *TestHistory) Append(t *Test) {
 	h.mutex.Lock()
-	defer h.mutex.Unlock()
 	h.tests = append(h.tests, t)
+	h.mutex.Unlock()
+}
+
+// merge adds the tests and variables of other, which ran after the ones already in h, to h.
+func (h *TestHistory) merge(other *TestHistory) {
+	other.mutex.Lock()
+	tests := other.tests
+	vars := mqutil.MapCopy(other.vars)
+	other.mutex.Unlock()
+	h.mutex.Lock()
+	h.tests = append(h.tests, tests...)
+	h.vars = mqutil.MapCombine(h.vars, vars)
+	h.mutex.Unlock()
+}
+
+// GetVar gets a variable captured by a test.
+func (h *TestHistory) GetVar(name string) (interface{}, bool) {
+	h.mutex.Lock()
+	value, ok := h.vars[name]
+	h.mutex.Unlock()
+	if !ok && h.parent != nil {
+		return h.parent.GetVar(name)
+	}
+	return value, ok
 }
 
-var History TestHistory
+// SetVar sets the variable in this history. Like the tests, its parents see it once the history
+// is merged into them.
+func (h *TestHistory) SetVar(name string, value interface{}) {
+	h.mutex.Lock()
+	if h.vars == nil {
+		h.vars = make(map[string]interface{})
+	}
+	h.vars[name] = value
+	h.mutex.Unlock()
+}
 
 func init() {
 	rand.Seed(int64(time.Now().Second()))
-	resty.SetRedirectPolicy(resty.FlexibleRedirectPolicy(15))
 }
@@ -0,0 +1,382 @@
+package mqplan
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+)
+
+const thingsSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths:
+  /things:
+    post:
+      responses:
+        '201':
+          description: the thing is created
+  /things/{id}:
+    get:
+      parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+      responses:
+        '200':
+          description: the thing
+`
+
+// loadPlan writes the spec and the plan to dir and loads the plan to run against baseURL.
+func loadPlan(t *testing.T, dir, spec, plan, baseURL string) *TestPlan {
+	specPath := filepath.Join(dir, "spec.yml")
+	planPath := filepath.Join(dir, "plan.yml")
+	for p, data := range map[string]string{specPath: spec, planPath: plan} {
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	swagger, err := mqswag.CreateSwaggerFromURL(specPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &TestPlan{}
+	if err := p.InitFromFile(planPath, NewContext(swagger, nil)); err != nil {
+		t.Fatal(err)
+	}
+	p.BaseURL = baseURL
+	p.Out = ioutil.Discard
+	p.ResultCounts = make(map[string]int)
+	return p
+}
+
+const parallelPlan = `
+a suite:
+- name: get_slow
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 300
+---
+b suite:
+- name: get_fast
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 100
+---
+c suite:
+- name: get_fast
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: 100
+`
+
+func TestRunAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The id is how long the request takes in milliseconds.
+	var mutex sync.Mutex
+	var inFlight, maxInFlight int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+		ms, _ := strconv.Atoi(path.Base(r.URL.Path))
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	for _, workers := range []int{1, 3} {
+		plan := loadPlan(t, dir, thingsSpec, parallelPlan, server.URL)
+		maxInFlight = 0
+		var out bytes.Buffer
+		plan.Out = &out
+		plan.RunAll([]string{"a suite", "b suite", "c suite"}, workers)
+		if maxInFlight != workers {
+			t.Errorf("expected %d suites to run at the same time, got %d", workers, maxInFlight)
+		}
+		// Suite a finishes last, but its results and output come first.
+		var names []string
+		for _, test := range plan.resultList {
+			names = append(names, test.suite.Name+"/"+test.Name)
+		}
+		if want := []string{"a suite/get_slow", "b suite/get_fast", "c suite/get_fast"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("expected the results in the order of the suites with %d workers, got %v", workers, names)
+		}
+		console := out.String()
+		a, b, c := strings.Index(console, "Test suite: a suite"), strings.Index(console, "Test suite: b suite"), strings.Index(console, "Test suite: c suite")
+		if a < 0 || b < a || c < b {
+			t.Errorf("expected the output in the order of the suites with %d workers, got\n%s", workers, console)
+		}
+		if plan.ResultCounts[mqutil.Total] != 3 || plan.ResultCounts[mqutil.Passed] != 3 {
+			t.Errorf("expected 3 passed tests with %d workers, got %v", workers, plan.ResultCounts)
+		}
+	}
+}
+
+const historyPlan = `
+a suite:
+- name: create
+  path: /things
+  method: post
+- name: get_slow
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: '{{create.outputs.id}}'
+  headerParams:
+    X-Delay: 200ms
+---
+b suite:
+- name: get_other
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: '{{create.outputs.id}}'
+`
+
+// A suite sees the tests of the suites before it only once they are done, which they aren't yet
+// when they run in parallel.
+func TestRunAllHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if delay, err := time.ParseDuration(r.Header.Get("X-Delay")); err == nil {
+			time.Sleep(delay)
+		}
+		switch {
+		case r.Method == http.MethodPost:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": 7}`))
+		case r.URL.Path != "/things/7":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for _, workers := range []int{1, 2} {
+		plan := loadPlan(t, dir, thingsSpec, historyPlan, server.URL)
+		plan.RunAll([]string{"a suite", "b suite"}, workers)
+		if len(plan.resultList) != 3 {
+			t.Fatalf("expected 3 tests with %d workers, got %v", workers, plan.ResultCounts)
+		}
+		if found := plan.resultList[2].err == nil; found != (workers == 1) {
+			t.Errorf("expected suite b to see the create of suite a only when they run one after the other, got %v with %d workers", found, workers)
+		}
+	}
+}
+
+const strictSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths:
+  /things:
+    post:
+      responses:
+        '201':
+          description: the created thing
+          content:
+            application/json:
+              schema:
+                type: object
+                additionalProperties: false
+                required: [name]
+                properties:
+                  id:
+                    type: integer
+                    format: int32
+                  name:
+                    type: string
+                  size:
+                    type: integer
+                  color:
+                    type: string
+`
+
+// The lenient check lets what the JSON Schema validator catches through.
+func TestRunStrictSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	for _, c := range []struct {
+		body     string
+		strict   bool
+		mismatch string
+	}{
+		{`{"id": 1, "name": "a"}`, true, ""},
+		{`{"id": 1, "name": "a", "size": 2, "color": "red", "extra": true}`, false, ""},
+		{`{"id": 1, "name": "a", "size": 2, "color": "red", "extra": true}`, true, "extra"},
+		{`{"id": 10000000000, "name": "a"}`, false, ""},
+		{`{"id": 10000000000, "name": "a"}`, true, "id"},
+	} {
+		body = c.body
+		plan := loadPlan(t, dir, strictSpec, "things suite:\n- name: post_thing\n  path: /things\n  method: post\n", server.URL)
+		plan.StrictSchema = c.strict
+		plan.RunAll([]string{"things suite"}, 1)
+
+		test := plan.resultList[0]
+		if len(c.mismatch) == 0 {
+			if test.err != nil || test.schemaError != nil {
+				t.Errorf("expected %s to match with strict %v, got %v %v", c.body, c.strict, test.err, test.schemaError)
+			}
+			continue
+		}
+		if test.err == nil || test.schemaError == nil || !strings.Contains(test.schemaError.Error(), c.mismatch) {
+			t.Errorf("expected %s to fail on %s with strict %v, got %v %v", c.body, c.mismatch, c.strict, test.err, test.schemaError)
+		}
+	}
+}
+
+const sharedSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths:
+  /things/{id}:
+    parameters:
+    - name: X-Owner
+      in: header
+      schema:
+        type: string
+        format: email
+    get:
+      parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: integer
+      responses:
+        '200':
+          description: the thing
+`
+
+// The suites running in parallel share the spec, so generating their requests must not change it.
+func TestRunAllSharedSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var mutex sync.Mutex
+	var owners []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		owners = append(owners, r.Header.Get("X-Owner"))
+	}))
+	defer server.Close()
+	var plan, names string
+	for _, name := range []string{"a", "b", "c", "d"} {
+		plan += name + " suite:\n- name: get_thing\n  path: /things/{id}\n  method: get\n---\n"
+		names += name + " suite,"
+	}
+	p := loadPlan(t, dir, sharedSpec, plan, server.URL)
+	p.RunAll(strings.Split(strings.TrimSuffix(names, ","), ","), 4)
+
+	if p.ResultCounts[mqutil.Passed] != 4 || len(owners) != 4 {
+		t.Fatalf("expected 4 passed tests, got %v", p.ResultCounts)
+	}
+	for _, owner := range owners {
+		if !strings.Contains(owner, "@") {
+			t.Errorf("expected the path level header to be sent as an email, got %q", owner)
+		}
+	}
+	op := p.ctx.Swagger.Paths["/things/{id}"].Get
+	if len(op.Parameters) != 1 {
+		t.Errorf("expected the path level parameters to be left out of the operation, got %d parameters", len(op.Parameters))
+	}
+	if id := op.Parameters[0].Value.Schema.Value; id.Max != nil {
+		t.Errorf("expected the id schema to be left without a maximum, got %v", *id.Max)
+	}
+	if owner := p.ctx.Swagger.Paths["/things/{id}"].Parameters[0].Value.Schema.Value; len(owner.Pattern) > 0 {
+		t.Errorf("expected the owner schema to be left without a pattern, got %s", owner.Pattern)
+	}
+}
+
+func TestRunAllVerbose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	// The verbose mode is per plan.
+	for _, verbose := range []bool{false, true} {
+		plan := loadPlan(t, dir, thingsSpec, "c suite:\n- name: get_thing\n  path: /things/{id}\n  method: get\n  pathParams:\n    id: 1\n", server.URL)
+		plan.ctx.Verbose = verbose
+		var out bytes.Buffer
+		plan.Out = &out
+		plan.RunAll([]string{"c suite"}, 1)
+		if got := strings.Contains(out.String(), "pathParams:"); got != verbose {
+			t.Errorf("expected the path parameters to be printed only in verbose mode, verbose %v got\n%s", verbose, out.String())
+		}
+	}
+}
+
+func TestAddFromStringInit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Enough suites that some of them come before meqa_init in the map order.
+	plan := "meqa_init:\n- pathParams:\n    id: 7\n  as: alice\n"
+	for i := 0; i < 20; i++ {
+		plan += "suite " + strconv.Itoa(i) + ":\n- name: get_thing\n  path: /things/{id}\n  method: get\n"
+	}
+	p := loadPlan(t, dir, thingsSpec, plan, "")
+	if len(p.SuiteList) != 20 {
+		t.Fatalf("expected 20 suites, got %d", len(p.SuiteList))
+	}
+	for _, s := range p.SuiteList {
+		if s.PathParams["id"] != 7 || s.As != "alice" {
+			t.Errorf("suite %s: expected the meqa_init parameters, got %v as %q", s.Name, s.PathParams, s.As)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package mqplan
+
+import (
+	"time"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqswag"
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+)
+
+// TestResult is the outcome of a test run by the plan.
+type TestResult struct {
+	Suite        string
+	Name         string
+	Path         string
+	Method       string
+	Identity     string // the identity the request was made as, empty for the default credentials
+	Result       string // mqutil.Passed, mqutil.Failed or mqutil.Skipped
+	Status       int    // the response status, 0 if no response was received
+	Duration     time.Duration
+	Err          error // why the test failed
+	SchemaError  error // set if the response doesn't match the schema in the spec
+	ContentError error // set if the response isn't in a media type documented in the spec
+	HeaderError  error // set if the response headers don't match the spec or the expect
+	FuzzFailures []*mqswag.Payload
+}
+
+// Error returns the failure message of the test without the backtrace, or "" if it passed.
+func (r *TestResult) Error() string {
+	if r.Err == nil {
+		return ""
+	}
+	return errorMessage(r.Err)
+}
+
+func (t *Test) result(suiteName string) *TestResult {
+	r := &TestResult{
+		Suite:        suiteName,
+		Name:         t.Name,
+		Path:         t.Path,
+		Method:       t.Method,
+		Identity:     t.As,
+		Result:       mqutil.Passed,
+		Err:          t.err,
+		SchemaError:  t.schemaError,
+		ContentError: t.contentError,
+		HeaderError:  t.headerError,
+		FuzzFailures: t.fuzzFailures,
+	}
+	if t.err != nil {
+		r.Result = mqutil.Failed
+	}
+	if t.resp != nil {
+		r.Status = t.resp.StatusCode()
+		r.Duration = t.stopTime.Sub(t.startTime)
+	}
+	return r
+}
+
+// Results returns the outcome of every test run so far, in the order of the suites.
+func (plan *TestPlan) Results() []*TestResult {
+	plan.mutex.Lock()
+	defer plan.mutex.Unlock()
+	var results []*TestResult
+	for _, tc := range plan.suiteResults {
+		for _, t := range tc.results {
+			results = append(results, t.result(tc.Name))
+		}
+		for _, t := range tc.skipped {
+			results = append(results, &TestResult{
+				Suite:  tc.Name,
+				Name:   t.Name,
+				Path:   t.Path,
+				Method: t.Method,
+				Result: mqutil.Skipped,
+			})
+		}
+	}
+	return results
+}
@@ -0,0 +1,460 @@
+package mqplan
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+	uuid "github.com/gofrs/uuid"
+)
+
+// This file resolves the templates in the test parameters. A template is an expression between {{ and }}:
+// a reference to a parameter of an earlier test like {{post_addPet_1.outputs.tags[0].name}}, a function
+// call like {{randInt(1, 10)}}, or a string or number literal. Function arguments are expressions too.
+
+// templateFunc is a function that can be called in a template.
+type templateFunc func(args []interface{}) (interface{}, error)
+
+var templateFuncs = map[string]templateFunc{
+	// uuid() returns a random uuid.
+	"uuid": func(args []interface{}) (interface{}, error) {
+		if err := checkArgs(args, 0, 0); err != nil {
+			return nil, err
+		}
+		u, err := uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+		return u.String(), nil
+	},
+	// now(layout) returns the current time in the Go time layout, RFC 3339 if not given.
+	"now": func(args []interface{}) (interface{}, error) {
+		if err := checkArgs(args, 0, 1); err != nil {
+			return nil, err
+		}
+		layout := time.RFC3339
+		if len(args) > 0 {
+			layout = templateString(args[0])
+		}
+		return time.Now().Format(layout), nil
+	},
+	// randInt(min, max) returns a random int between min and max, both included.
+	"randInt": func(args []interface{}) (interface{}, error) {
+		if err := checkArgs(args, 2, 2); err != nil {
+			return nil, err
+		}
+		min, err := strconv.Atoi(templateString(args[0]))
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an int", templateString(args[0]))
+		}
+		max, err := strconv.Atoi(templateString(args[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an int", templateString(args[1]))
+		}
+		if max < min {
+			return nil, fmt.Errorf("max %d is less than min %d", max, min)
+		}
+		return min + rand.Intn(max-min+1), nil
+	},
+	// env(name, default) returns the environment variable, or default if it isn't set.
+	"env": func(args []interface{}) (interface{}, error) {
+		if err := checkArgs(args, 1, 2); err != nil {
+			return nil, err
+		}
+		if value, ok := os.LookupEnv(templateString(args[0])); ok {
+			return value, nil
+		}
+		if len(args) > 1 {
+			return args[1], nil
+		}
+		return nil, fmt.Errorf("%s is not set", templateString(args[0]))
+	},
+	// base64(value) returns the standard base64 encoding of the value.
+	"base64": func(args []interface{}) (interface{}, error) {
+		if err := checkArgs(args, 1, 1); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString([]byte(templateString(args[0]))), nil
+	},
+}
+
+func checkArgs(args []interface{}, min, max int) error {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return fmt.Errorf("expecting %d arguments, got %d", min, len(args))
+		}
+		return fmt.Errorf("expecting %d to %d arguments, got %d", min, max, len(args))
+	}
+	return nil
+}
+
+// templateString formats a template value for a string.
+func templateString(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(value)
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// templateParser evaluates the expression of a template.
+type templateParser struct {
+	str string
+	pos int
+	h   *TestHistory
+}
+
+func (p *templateParser) skipSpaces() {
+	for p.pos < len(p.str) && (p.str[p.pos] == ' ' || p.str[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *templateParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%s at offset %d of %s", fmt.Sprintf(format, a...), p.pos, p.str)
+}
+
+// name reads the characters up to the next delimiter.
+func (p *templateParser) name() string {
+	start := p.pos
+	for p.pos < len(p.str) && !strings.ContainsRune(".[](),'\" \t}", rune(p.str[p.pos])) {
+		p.pos++
+	}
+	return p.str[start:p.pos]
+}
+
+// expr evaluates the expression that starts at the current position.
+func (p *templateParser) expr() (interface{}, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.str) {
+		return nil, p.errorf("missing expression")
+	}
+	switch c := p.str[p.pos]; {
+	case c == '"' || c == '\'':
+		return p.stringLiteral(c)
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.str) && strings.ContainsRune("0123456789.", rune(p.str[p.pos])) {
+			p.pos++
+		}
+		if _, err := strconv.ParseFloat(p.str[start:p.pos], 64); err != nil {
+			return nil, p.errorf("invalid number %s", p.str[start:p.pos])
+		}
+		return json.Number(p.str[start:p.pos]), nil
+	}
+	start := p.pos
+	first := p.name()
+	if len(first) == 0 {
+		return nil, p.errorf("unexpected %q", p.str[p.pos])
+	}
+	if p.pos < len(p.str) && p.str[p.pos] == '(' {
+		return p.call(first)
+	}
+	// A reference, testName.section.name...
+	path := []string{first}
+	for p.pos < len(p.str) {
+		if p.str[p.pos] == '.' {
+			p.pos++
+			name := p.name()
+			if len(name) == 0 {
+				return nil, p.errorf("missing name after .")
+			}
+			path = append(path, name)
+		} else if p.str[p.pos] == '[' {
+			end := strings.IndexByte(p.str[p.pos:], ']')
+			if end < 0 {
+				return nil, p.errorf("missing ]")
+			}
+			if _, err := strconv.Atoi(strings.TrimSpace(p.str[p.pos+1 : p.pos+end])); err != nil {
+				return nil, p.errorf("invalid index %s", p.str[p.pos:p.pos+end+1])
+			}
+			path = append(path, "["+strings.TrimSpace(p.str[p.pos+1:p.pos+end])+"]")
+			p.pos += end + 1
+		} else {
+			break
+		}
+	}
+	ref := p.str[start:p.pos]
+	if path[0] == ParamVars && len(path) > 1 {
+		var value interface{}
+		var ok bool
+		if p.h != nil {
+			value, ok = p.h.GetVar(path[1])
+		}
+		if !ok {
+			return nil, fmt.Errorf("variable %s of {{%s}} not found", path[1], ref)
+		}
+		for _, field := range path[2:] {
+			value = lookupField(value, field)
+		}
+		if value == nil {
+			return nil, fmt.Errorf("{{%s}} not found", ref)
+		}
+		return value, nil
+	}
+	if (len(path) < 3 && !(len(path) == 2 && path[1] == ParamStatus)) || strings.HasPrefix(path[1], "[") {
+		return nil, fmt.Errorf("invalid parameter: {{%s}}, the format is {{testName.paramSection.paramName}}, e.g. {{test1.outputs.id}}", ref)
+	}
+	var t *Test
+	if p.h != nil {
+		t = p.h.GetTest(path[0])
+	}
+	if t == nil {
+		return nil, fmt.Errorf("test %s of {{%s}} not found", path[0], ref)
+	}
+	value := t.GetParam(path[1:])
+	if value == nil {
+		return nil, fmt.Errorf("{{%s}} not found", ref)
+	}
+	return value, nil
+}
+
+func (p *templateParser) stringLiteral(quote byte) (interface{}, error) {
+	var b strings.Builder
+	for p.pos++; p.pos < len(p.str); p.pos++ {
+		c := p.str[p.pos]
+		if c == '\\' && p.pos+1 < len(p.str) {
+			p.pos++
+			b.WriteByte(p.str[p.pos])
+		} else if c == quote {
+			p.pos++
+			return b.String(), nil
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return nil, p.errorf("unterminated string")
+}
+
+func (p *templateParser) call(name string) (interface{}, error) {
+	f := templateFuncs[name]
+	if f == nil {
+		return nil, p.errorf("unknown function %s", name)
+	}
+	p.pos++ // (
+	var args []interface{}
+	p.skipSpaces()
+	if p.pos < len(p.str) && p.str[p.pos] == ')' {
+		p.pos++
+		return callTemplateFunc(name, f, args)
+	}
+	for {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		p.skipSpaces()
+		if p.pos >= len(p.str) {
+			return nil, p.errorf("missing )")
+		}
+		if p.str[p.pos] == ')' {
+			p.pos++
+			return callTemplateFunc(name, f, args)
+		}
+		if p.str[p.pos] != ',' {
+			return nil, p.errorf("expecting , or )")
+		}
+		p.pos++
+	}
+}
+
+func callTemplateFunc(name string, f templateFunc, args []interface{}) (interface{}, error) {
+	result, err := f(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %s", name, err.Error())
+	}
+	return result, nil
+}
+
+// StringParamsResolveWithHistory resolves the templates in str. If str is a single template the value
+// keeps its type, so an int stays an int, otherwise the values are interpolated into the string. It
+// returns nil if str has no templates or one of them can't be resolved.
+func StringParamsResolveWithHistory(str string, h *TestHistory, logger *log.Logger) interface{} {
+	if !strings.Contains(str, "{{") {
+		return nil
+	}
+	var values []interface{}
+	var literals []string
+	rest := str
+	for {
+		begin := strings.Index(rest, "{{")
+		if begin < 0 {
+			break
+		}
+		p := &templateParser{str: rest, pos: begin + 2, h: h}
+		value, err := p.expr()
+		if err == nil {
+			p.skipSpaces()
+			if !strings.HasPrefix(rest[p.pos:], "}}") {
+				err = p.errorf("expecting }}")
+			}
+		}
+		if err != nil {
+			logger.Printf("can't resolve %s: %s", str, err.Error())
+			return nil
+		}
+		literals = append(literals, rest[:begin])
+		values = append(values, value)
+		rest = rest[p.pos+2:]
+	}
+	if len(values) == 1 && len(literals[0]) == 0 && len(rest) == 0 {
+		return values[0]
+	}
+	var b strings.Builder
+	for i, value := range values {
+		b.WriteString(literals[i])
+		b.WriteString(templateString(value))
+	}
+	b.WriteString(rest)
+	return b.String()
+}
+
+// ParamsResolveWithHistory returns obj with the templates in it resolved at any depth. The maps and
+// arrays in obj are copied, not changed.
+func ParamsResolveWithHistory(obj interface{}, h *TestHistory, logger *log.Logger) interface{} {
+	switch o := obj.(type) {
+	case string:
+		if result := StringParamsResolveWithHistory(o, h, logger); result != nil {
+			return result
+		}
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(o))
+		for k, v := range o {
+			m[k] = ParamsResolveWithHistory(v, h, logger)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(o))
+		for i, v := range o {
+			a[i] = ParamsResolveWithHistory(v, h, logger)
+		}
+		return a
+	}
+	return obj
+}
+
+func MapParamsResolveWithHistory(paramMap map[string]interface{}, h *TestHistory, logger *log.Logger) {
+	for k, v := range paramMap {
+		paramMap[k] = ParamsResolveWithHistory(v, h, logger)
+	}
+}
+
+func ArrayParamsResolveWithHistory(paramArray []interface{}, h *TestHistory, logger *log.Logger) {
+	for i, param := range paramArray {
+		paramArray[i] = ParamsResolveWithHistory(param, h, logger)
+	}
+}
+
+// ResolveWithHistory resolves the templates in the parameters.
+func (p *TestParams) ResolveWithHistory(h *TestHistory, logger *log.Logger) {
+	MapParamsResolveWithHistory(p.PathParams, h, logger)
+	MapParamsResolveWithHistory(p.FormParams, h, logger)
+	MapParamsResolveWithHistory(p.HeaderParams, h, logger)
+	MapParamsResolveWithHistory(p.QueryParams, h, logger)
+	p.BodyParams = ParamsResolveWithHistory(p.BodyParams, h, logger)
+}
+
+func (t *Test) ResolveHistoryParameters(h *TestHistory) {
+	t.TestParams.ResolveWithHistory(h, t.ctx.Logger)
+	for _, section := range []string{ExpectJsonPath, ExpectBodyMatches} {
+		if t.Expect[section] != nil {
+			t.Expect[section] = ParamsResolveWithHistory(t.Expect[section], h, t.ctx.Logger)
+		}
+	}
+}
+
+// capture sets the variables of the capture block. A value is either a template, or the path of what
+// to capture in the results of this test, as in outputs.items[0].id or responseHeaders.Location.
+func (t *Test) capture(h *TestHistory) error {
+	var names []string
+	for name := range t.Capture {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		expr := t.Capture[name]
+		var value interface{}
+		if strings.Contains(expr, "{{") {
+			value = StringParamsResolveWithHistory(expr, h, t.ctx.Logger)
+		} else {
+			value = t.GetParam(splitParamPath(expr))
+		}
+		if value == nil {
+			fmt.Fprintf(t.out(), "... capturing %s from %s. Fail\n", name, expr)
+			return mqutil.NewError(mqutil.ErrNotFound, fmt.Sprintf("can't capture %s from %s", name, expr))
+		}
+		h.SetVar(name, value)
+		fmt.Fprintf(t.out(), "... captured %s = %v\n", name, templateString(value))
+	}
+	return nil
+}
+
+// splitParamPath splits a path like outputs.items[0].id into outputs, items, [0] and id.
+func splitParamPath(path string) []string {
+	var fields []string
+	for _, f := range strings.Split(path, ".") {
+		for {
+			i := strings.IndexByte(f, '[')
+			if i < 0 {
+				break
+			}
+			if i > 0 {
+				fields = append(fields, f[:i])
+			}
+			end := strings.IndexByte(f, ']')
+			if end < i {
+				break
+			}
+			fields = append(fields, f[i:end+1])
+			f = f[end+1:]
+		}
+		if len(f) > 0 {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// lookupField returns the named field of a map, or the item of an array for a field like [0].
+func lookupField(section interface{}, field string) interface{} {
+	if strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]") {
+		ar, ok := section.([]interface{})
+		if !ok {
+			return nil
+		}
+		i, err := strconv.Atoi(field[1 : len(field)-1])
+		if err != nil {
+			return nil
+		}
+		if i < 0 {
+			i += len(ar)
+		}
+		if i < 0 || i >= len(ar) {
+			return nil
+		}
+		return ar[i]
+	}
+	if paramMap, ok := section.(map[string]interface{}); ok {
+		return paramMap[field]
+	}
+	return nil
+}
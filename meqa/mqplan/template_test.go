@@ -0,0 +1,213 @@
+package mqplan
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func templateHistory() *TestHistory {
+	test := &Test{Name: "post_1"}
+	test.PathParams = map[string]interface{}{"petId": 3}
+	test.Expect = map[string]interface{}{
+		ExpectBody: map[string]interface{}{
+			"id":   7.0,
+			"tags": []interface{}{map[string]interface{}{"name": "a"}},
+		},
+	}
+	h := &TestHistory{}
+	h.Append(test)
+	h.SetVar("token", "abc")
+	h.SetVar("obj", map[string]interface{}{"k": "v"})
+	return h
+}
+
+func TestStringParamsResolveWithHistory(t *testing.T) {
+	os.Setenv("MEQA_TEST_SET", "set")
+	defer os.Unsetenv("MEQA_TEST_SET")
+	h := templateHistory()
+	for _, c := range []struct {
+		str      string
+		expected interface{}
+	}{
+		{"plain", nil},
+		{"{{post_1.outputs.id}}", 7.0},
+		{"pet-{{post_1.outputs.id}}", "pet-7"},
+		{"{{post_1.outputs.tags[0].name}}", "a"},
+		{"{{post_1.outputs.tags[-1].name}}", "a"},
+		{"{{ post_1.pathParams.petId }}", 3},
+		{"{{post_1.outputs.tags}}", []interface{}{map[string]interface{}{"name": "a"}}},
+		{"tags={{post_1.outputs.tags}}", `tags=[{"name":"a"}]`},
+		{"{{vars.token}}/{{vars.obj.k}}", "abc/v"},
+		{"{{'a}}b'}}", "a}}b"},
+		{`{{"it\"s"}}`, `it"s`},
+		{"{{-1.5}}", json.Number("-1.5")},
+		{"{{base64('user:pw')}}", "dXNlcjpwdw=="},
+		{"{{base64(vars.token)}}", "YWJj"},
+		{"{{randInt(5, 5)}}", 5},
+		{"{{env('MEQA_TEST_UNSET', 'default')}}", "default"},
+		{"{{env('MEQA_TEST_SET', 'default')}}", "set"},
+	} {
+		if got := StringParamsResolveWithHistory(c.str, h, log.New(&bytes.Buffer{}, "", 0)); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: expected %#v, got %#v", c.str, c.expected, got)
+		}
+	}
+}
+
+// The functions that return something different each time.
+func TestStringParamsResolveWithHistoryRandom(t *testing.T) {
+	h := templateHistory()
+	logger := log.New(&bytes.Buffer{}, "", 0)
+	if id, ok := StringParamsResolveWithHistory("{{randInt(1000, 2000)}}", h, logger).(int); !ok || id < 1000 || id > 2000 {
+		t.Errorf("expected an int between 1000 and 2000, got %#v", id)
+	}
+	if name, _ := StringParamsResolveWithHistory("pet-{{uuid()}}", h, logger).(string); !strings.HasPrefix(name, "pet-") || len(name) != len("pet-")+36 {
+		t.Errorf("expected a name with a uuid, got %s", name)
+	}
+	year := strconv.Itoa(time.Now().Year())
+	if got := StringParamsResolveWithHistory("{{now('2006')}}", h, logger); got != year {
+		t.Errorf("expected %s, got %#v", year, got)
+	}
+	if _, err := time.Parse(time.RFC3339, StringParamsResolveWithHistory("{{now()}}", h, logger).(string)); err != nil {
+		t.Errorf("expected the time in RFC 3339, got %v", err)
+	}
+}
+
+func TestStringParamsResolveWithHistoryErrors(t *testing.T) {
+	h := templateHistory()
+	for _, c := range []struct {
+		str      string
+		expected string
+	}{
+		{"{{post_1.outputs.missing}}", "{{post_1.outputs.missing}} not found"},
+		{"{{nope.outputs.id}}", "test nope of {{nope.outputs.id}} not found"},
+		{"{{vars.nope}}", "variable nope of {{vars.nope}} not found"},
+		{"{{post_1.id}}", "invalid parameter: {{post_1.id}}"},
+		{"{{post_1.outputs.tags[x]}}", "invalid index [x]"},
+		{"{{post_1.outputs.}}", "missing name after ."},
+		{"{{unknown()}}", "unknown function unknown"},
+		{"{{randInt(1)}}", "randInt(): expecting 2 arguments, got 1"},
+		{"{{randInt(2, 1)}}", "randInt(): max 1 is less than min 2"},
+		{"{{base64('a' 'b')}}", "expecting , or )"},
+		{"{{base64('a'", "missing )"},
+		{"{{'abc}}", "unterminated string"},
+		{"{{1.2.3}}", "invalid number 1.2.3"},
+		{"{{vars.token extra}}", "expecting }}"},
+		{"{{}}", "unexpected '}'"},
+	} {
+		var buf bytes.Buffer
+		if got := StringParamsResolveWithHistory(c.str, h, log.New(&buf, "", 0)); got != nil {
+			t.Errorf("%s: expected nil, got %#v", c.str, got)
+		}
+		if !strings.Contains(buf.String(), c.expected) {
+			t.Errorf("%s: expected the log to contain %q, got %q", c.str, c.expected, buf.String())
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	client := NewClient(&ClientConfig{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/things/7")
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		w.WriteHeader(http.StatusCreated)
+	})})
+	resp, err := client.R().Post("http://example.com/things")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		expr     string
+		expected interface{}
+	}{
+		{"responseHeaders.location", "/things/7"},
+		{"cookies.session", "abc"},
+		{"outputs.tags[0].name", "a"},
+		{"{{post_1.status}}", 201},
+		{"{{vars.token}}-{{post_1.outputs.id}}", "abc-7"},
+		{"cookies.missing", nil},
+		{"{{vars.missing}}", nil},
+	} {
+		h := templateHistory()
+		test := h.GetTest("post_1")
+		test.ctx = NewContext(nil, nil)
+		test.suite = &TestSuite{out: ioutil.Discard}
+		test.resp = resp
+		test.Capture = map[string]string{"captured": c.expr}
+		err := test.capture(h)
+		value, ok := h.GetVar("captured")
+		if c.expected == nil {
+			if err == nil || ok || !strings.Contains(err.Error(), "can't capture captured from "+c.expr) {
+				t.Errorf("expected %s not to be captured, got %v %v", c.expr, value, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(value, c.expected) {
+			t.Errorf("expected %#v from %s, got %#v %v", c.expected, c.expr, value, err)
+		}
+	}
+}
+
+const parallelCapturePlan = `
+a suite:
+- name: post_thing
+  path: /things
+  method: post
+  capture:
+    id: responseHeaders.X-Thing-Id
+- name: get_slow
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: '{{vars.id}}'
+  headerParams:
+    X-Delay: 200ms
+---
+b suite:
+- name: get_other
+  path: /things/{id}
+  method: get
+  pathParams:
+    id: '{{vars.id}}'
+`
+
+// Like the tests, the variables of a suite are seen by the suites after it once it is done.
+func TestRunAllCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if delay, err := time.ParseDuration(r.Header.Get("X-Delay")); err == nil {
+			time.Sleep(delay)
+		}
+		switch {
+		case r.Method == http.MethodPost:
+			w.Header().Set("X-Thing-Id", "7")
+			w.WriteHeader(http.StatusCreated)
+		case r.URL.Path != "/things/7":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for _, workers := range []int{1, 2} {
+		plan := loadPlan(t, dir, thingsSpec, parallelCapturePlan, server.URL)
+		plan.RunAll([]string{"a suite", "b suite"}, workers)
+		if len(plan.resultList) != 3 {
+			t.Fatalf("expected 3 tests with %d workers, got %v", workers, plan.ResultCounts)
+		}
+		if found := plan.resultList[2].err == nil; found != (workers == 1) {
+			t.Errorf("expected suite b to see the id captured by suite a only when they run one after the other, got %v with %d workers", found, workers)
+		}
+	}
+}
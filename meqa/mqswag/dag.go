@@ -73,7 +73,7 @@ func (node *DAGNode) CheckChildrenWeight() bool {
 		if c.Weight <= node.Weight {
 			return false
 		}
-		if mqutil.Verbose {
+		if node.dag.Verbose {
 			fmt.Printf("       -  %s, weight: %d priority: %d\n", c.Name, c.Weight, c.Priority)
 		}
 	}
@@ -181,6 +181,7 @@ func (n ByMethodPriority) Less(i, j int) bool {
 type DAG struct {
 	NameMap    map[string]*DAGNode // DAGNode name to node mapping.
 	WeightList [DAGDepth]NodeList  // List ordered by DAGNodes' weights. Max of 1000 levels in DAG depth.
+	Verbose    bool                // print the weights when checking them
 }
 
 func (dag *DAG) Init() {
@@ -231,7 +232,7 @@ type DAGIterFunc func(previous *DAGNode, current *DAGNode) error
 
 func (dag *DAG) IterateWeight(weight int, f DAGIterFunc) error {
 	if weight >= DAGDepth {
-		return mqutil.NewError(mqutil.ErrInvalid, fmt.Sprintf("invalid weight to iterate", weight))
+		return mqutil.NewError(mqutil.ErrInvalid, fmt.Sprintf("invalid weight to iterate: %d", weight))
 	}
 	l := dag.WeightList[weight]
 	for _, n := range l {
@@ -268,7 +269,7 @@ func (dag *DAG) Sort() {
 
 func (dag *DAG) CheckWeight() {
 	checkChildren := func(previous *DAGNode, current *DAGNode) error {
-		if mqutil.Verbose {
+		if dag.Verbose {
 			fmt.Printf("\nname: %s weight: %d priority: %d, children: \n", current.Name, current.Weight, current.Priority)
 		}
 		ok := current.CheckChildrenWeight()
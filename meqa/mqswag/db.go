@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"reflect"
 	"regexp"
 	"strings"
@@ -52,7 +53,16 @@ func (schema SchemaRef) GetProperties(swagger *Swagger) map[string]*spec.SchemaR
 // Prases the object against this schema. If the obj and schema doesn't match
 // return an error. Otherwise parse all the objects identified by the schema
 // into the map indexed by the object class name.
-func (schema SchemaRef) Parses(name string, object interface{}, collection map[string][]interface{}, followRef bool, swagger *Swagger) error {
+func (schema SchemaRef) Parses(name string, object interface{}, collection map[string][]interface{}, followRef bool, swagger *Swagger, logger *log.Logger) error {
+	return schema.parses(name, object, collection, followRef, false, swagger, logger)
+}
+
+// ParsesResponse is Parses for an object the server returned, which must not have writeOnly properties.
+func (schema SchemaRef) ParsesResponse(name string, object interface{}, collection map[string][]interface{}, followRef bool, swagger *Swagger, logger *log.Logger) error {
+	return schema.parses(name, object, collection, followRef, true, swagger, logger)
+}
+
+func (schema SchemaRef) parses(name string, object interface{}, collection map[string][]interface{}, followRef bool, response bool, swagger *Swagger, logger *log.Logger) error {
 	raiseError := func(msg string) error {
 		schemaBytes, _ := json.MarshalIndent(schema.Value, "", "    ")
 		objectBytes, _ := json.MarshalIndent(object, "", "    ")
@@ -71,7 +81,19 @@ func (schema SchemaRef) Parses(name string, object interface{}, collection map[s
 		if !followRef {
 			return nil
 		}
-		return referredSchema.Parses(refName, object, collection, followRef, swagger)
+		return referredSchema.parses(refName, object, collection, followRef, response, swagger, logger)
+	}
+
+	if len(schema.Value.OneOf) > 0 || len(schema.Value.AnyOf) > 0 {
+		return schema.parsesAlternatives(name, object, collection, followRef, response, swagger, logger, raiseError)
+	}
+	if schema.Value.Not != nil {
+		if ((SchemaRef)(*schema.Value.Not)).Matches(object, swagger, logger) {
+			return raiseError("object matches the not schema")
+		}
+		if len(schema.Value.Type) == 0 && len(schema.Value.Properties) == 0 && len(schema.Value.AllOf) == 0 {
+			return nil
+		}
 	}
 
 	if len(schema.Value.AllOf) > 0 {
@@ -95,7 +117,7 @@ func (schema SchemaRef) Parses(name string, object interface{}, collection map[s
 				}
 			}
 			// The name doesn't get passed down. The name is handled at the current level.
-			err = ((SchemaRef)(*s)).Parses("", m, collection, followRef, swagger)
+			err = ((SchemaRef)(*s)).parses("", m, collection, followRef, response, swagger, logger)
 			if err != nil {
 				return err
 			}
@@ -153,6 +175,10 @@ func (schema SchemaRef) Parses(name string, object interface{}, collection map[s
 		}
 		for _, requiredName := range schema.Value.Required {
 			if _, exist := objMap[requiredName]; !exist {
+				// writeOnly properties are only required in requests.
+				if p := schema.Value.Properties[requiredName]; p != nil && p.Value != nil && p.Value.WriteOnly {
+					continue
+				}
 				return raiseError(fmt.Sprintf("required field not present: %s", requiredName))
 			}
 		}
@@ -162,7 +188,13 @@ func (schema SchemaRef) Parses(name string, object interface{}, collection map[s
 			propertySchema, exist := schema.Value.Properties[propertyName]
 			if exist {
 				count++
-				err = ((SchemaRef)(*propertySchema)).Parses("", objProperty, collection, followRef, swagger)
+				if response && propertySchema.Value != nil && propertySchema.Value.WriteOnly {
+					return raiseError(fmt.Sprintf("writeOnly field returned: %s", propertyName))
+				}
+				if objProperty == nil && propertySchema.Value != nil && !propertySchema.Value.Nullable && swagger.checksNullable() {
+					return raiseError(fmt.Sprintf("field is null but not nullable: %s", propertyName))
+				}
+				err = ((SchemaRef)(*propertySchema)).parses("", objProperty, collection, followRef, response, swagger, logger)
 				if err != nil {
 					return err
 				}
@@ -188,7 +220,10 @@ func (schema SchemaRef) Parses(name string, object interface{}, collection map[s
 		}
 		ar := object.([]interface{})
 		for _, item := range ar {
-			err = itemsSchema.Parses("", item, collection, followRef, swagger)
+			if item == nil && !itemsSchema.Value.Nullable && swagger.checksNullable() {
+				return raiseError("array item is null but not nullable")
+			}
+			err = itemsSchema.parses("", item, collection, followRef, response, swagger, logger)
 			if err != nil {
 				return err
 			}
@@ -197,7 +232,7 @@ func (schema SchemaRef) Parses(name string, object interface{}, collection map[s
 		return raiseError(fmt.Sprintf("unknown type: %v", k))
 	}
 	if isProperty && !followRef {
-		tag := GetMeqaTag(schema.Value.Description)
+		tag := GetSchemaTag(schema.Value, logger)
 		if tag != nil && len(tag.Class) > 0 && len(tag.Property) > 0 {
 			key := fmt.Sprintf("%s.%s", tag.Class, tag.Property)
 			collection[key] = append(collection[key], object)
@@ -206,15 +241,50 @@ func (schema SchemaRef) Parses(name string, object interface{}, collection map[s
 	return nil
 }
 
+// parsesAlternatives parses the object against the oneOf or anyOf schemas of this schema. With a
+// discriminator, its value in the object picks the schema. Otherwise the object must match exactly
+// one of the oneOf schemas, or at least one of the anyOf schemas.
+func (schema SchemaRef) parsesAlternatives(name string, object interface{}, collection map[string][]interface{}, followRef bool, response bool, swagger *Swagger, logger *log.Logger, raiseError func(string) error) error {
+	if d := schema.Value.Discriminator; d != nil && len(d.PropertyName) > 0 {
+		objMap, _ := object.(map[string]interface{})
+		value, ok := objMap[d.PropertyName].(string)
+		if !ok {
+			return raiseError(fmt.Sprintf("discriminator not present: %s", d.PropertyName))
+		}
+		s, ok := swagger.DiscriminatorMapping(schema)[value]
+		if !ok {
+			return raiseError(fmt.Sprintf("unknown discriminator value %s: %s", d.PropertyName, value))
+		}
+		return s.parses(name, object, collection, followRef, response, swagger, logger)
+	}
+	alternatives, keyword := schema.Value.OneOf, "oneOf"
+	if len(alternatives) == 0 {
+		alternatives, keyword = schema.Value.AnyOf, "anyOf"
+	}
+	var matched []SchemaRef
+	for _, s := range alternatives {
+		if ((SchemaRef)(*s)).Matches(object, swagger, logger) {
+			matched = append(matched, (SchemaRef)(*s))
+		}
+	}
+	if len(matched) == 0 {
+		return raiseError(fmt.Sprintf("object matches none of the %s schemas", keyword))
+	}
+	if keyword == "oneOf" && len(matched) > 1 {
+		return raiseError(fmt.Sprintf("object matches %d of the oneOf schemas", len(matched)))
+	}
+	return matched[0].parses(name, object, collection, followRef, response, swagger, logger)
+}
+
 // Matches checks if the Schema matches the input interface. In proper swagger.json
 // Enums should have types as well. So we don't check for untyped enums.
-// TODO check format, handle AllOf, AnyOf, OneOf
-func (schema SchemaRef) Matches(object interface{}, swagger *Swagger) bool {
-	err := schema.Parses("", object, make(map[string][]interface{}), true, swagger)
+// TODO check format
+func (schema SchemaRef) Matches(object interface{}, swagger *Swagger, logger *log.Logger) bool {
+	err := schema.Parses("", object, make(map[string][]interface{}), true, swagger, logger)
 	return err == nil
 }
 
-func (schema SchemaRef) Contains(name string, swagger *Swagger) bool {
+func (schema SchemaRef) Contains(name string, swagger *Swagger, logger *log.Logger) bool {
 	iterFunc := func(swagger *Swagger, schemaName string, schema SchemaRef, context interface{}) error {
 		// The only way we have to abort is through an error.
 		if schemaName == name {
@@ -223,7 +293,7 @@ func (schema SchemaRef) Contains(name string, swagger *Swagger) bool {
 		return nil
 	}
 
-	err := schema.Iterate(iterFunc, nil, swagger, true)
+	err := schema.Iterate(iterFunc, nil, swagger, true, logger)
 	if err != nil && err.Error() == "found" {
 		return true
 	}
@@ -235,8 +305,8 @@ type SchemaIterator func(swagger *Swagger, schemaName string, schema SchemaRef,
 // IterateSchema descends down the starting schema and call the iterator function for all the child schemas.
 // The iteration order is parent first then children. It will abort on error. The followWeak flag indicates whether
 // we should follow weak references when iterating.
-func (schema SchemaRef) Iterate(iterFunc SchemaIterator, context interface{}, swagger *Swagger, followWeak bool) error {
-	tag := GetMeqaTag(schema.Value.Description)
+func (schema SchemaRef) Iterate(iterFunc SchemaIterator, context interface{}, swagger *Swagger, followWeak bool, logger *log.Logger) error {
+	tag := GetSchemaTag(schema.Value, logger)
 	if tag != nil && (tag.Flags&FlagWeak) != 0 && !followWeak {
 		return nil
 	}
@@ -246,9 +316,13 @@ func (schema SchemaRef) Iterate(iterFunc SchemaIterator, context interface{}, sw
 		return err
 	}
 
-	if len(schema.Value.AllOf) > 0 {
-		for _, s := range schema.Value.AllOf {
-			err = ((SchemaRef)(*s)).Iterate(iterFunc, context, swagger, followWeak)
+	var combined []*spec.SchemaRef
+	combined = append(combined, schema.Value.AllOf...)
+	combined = append(combined, schema.Value.OneOf...)
+	combined = append(combined, schema.Value.AnyOf...)
+	if len(combined) > 0 {
+		for _, s := range combined {
+			err = ((SchemaRef)(*s)).Iterate(iterFunc, context, swagger, followWeak, logger)
 			if err != nil {
 				return err
 			}
@@ -262,7 +336,7 @@ func (schema SchemaRef) Iterate(iterFunc SchemaIterator, context interface{}, sw
 		return err
 	}
 	if referredSchema.Value != nil {
-		tag := GetMeqaTag(referredSchema.Value.Description)
+		tag := GetSchemaTag(referredSchema.Value, logger)
 		if tag != nil && (tag.Flags&FlagWeak) != 0 && !followWeak {
 			return nil
 		}
@@ -274,7 +348,7 @@ func (schema SchemaRef) Iterate(iterFunc SchemaIterator, context interface{}, sw
 
 	if strings.Contains(schema.Value.Type, gojsonschema.TYPE_OBJECT) {
 		for _, v := range schema.Value.Properties {
-			err = (SchemaRef)(*v).Iterate(iterFunc, context, swagger, followWeak)
+			err = (SchemaRef)(*v).Iterate(iterFunc, context, swagger, followWeak, logger)
 			if err != nil {
 				return err
 			}
@@ -282,7 +356,7 @@ func (schema SchemaRef) Iterate(iterFunc SchemaIterator, context interface{}, sw
 	}
 	if strings.Contains(schema.Value.Type, gojsonschema.TYPE_ARRAY) {
 		itemSchema := (*schema.Value.Items)
-		err = (SchemaRef)(itemSchema).Iterate(iterFunc, context, swagger, followWeak)
+		err = (SchemaRef)(itemSchema).Iterate(iterFunc, context, swagger, followWeak, logger)
 		if err != nil {
 			return err
 		}
@@ -292,12 +366,20 @@ func (schema SchemaRef) Iterate(iterFunc SchemaIterator, context interface{}, sw
 
 func Validate(s SchemaRef, c interface{}) bool {
 	if s.Value.Type == gojsonschema.TYPE_STRING {
-		length := uint64(utf8.RuneCountInString(c.(string)))
+		str, ok := c.(string)
+		if !ok {
+			return false
+		}
+		length := uint64(utf8.RuneCountInString(str))
 		if s.Value.MinLength > length || (s.Value.MaxLength != nil && length > *s.Value.MaxLength) {
 			return false
 		}
 	} else if s.Value.Type == gojsonschema.TYPE_NUMBER || s.Value.Type == gojsonschema.TYPE_INTEGER {
-		if (s.Value.Min != nil && *s.Value.Min > c.(float64)) || (s.Value.Max != nil && c.(float64) > *s.Value.Max) {
+		f, ok := toFloat(c)
+		if !ok {
+			return false
+		}
+		if (s.Value.Min != nil && *s.Value.Min > f) || (s.Value.Max != nil && f > *s.Value.Max) {
 			return false
 		}
 	}
@@ -309,6 +391,22 @@ func Validate(s SchemaRef, c interface{}) bool {
 	return true
 }
 
+// toFloat converts the numbers we get from json, yaml and the generator to float64.
+func toFloat(c interface{}) (float64, bool) {
+	if n, ok := c.(json.Number); ok {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	v := reflect.ValueOf(c)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return v.Convert(reflect.TypeOf(float64(0))).Float(), true
+	}
+	return 0, false
+}
+
 type DBEntry struct {
 	Data         map[string]interface{}            // The object itself.
 	Associations map[string]map[string]interface{} // The objects associated with this object. Class to object map.
@@ -408,6 +506,7 @@ func (db *SchemaDB) Update(criteria interface{}, associations map[string]map[str
 type DB struct {
 	schemas map[string](*SchemaDB)
 	Swagger *Swagger
+	logger  *log.Logger
 	mutex   sync.Mutex // We don't expect much contention, as such mutex will be fast
 }
 
@@ -415,12 +514,13 @@ type DB struct {
 // need to track it in DB. This will save some resources. We can do this by adding swagger to
 // a dag, then iterate through all the objects, and find those that doesn't have any oepration
 // as a child.
-func (db *DB) Init(s *Swagger) {
+func (db *DB) Init(s *Swagger, logger *log.Logger) {
 	db.Swagger = s
+	db.logger = logger
 	db.schemas = make(map[string](*SchemaDB))
 	for schemaName, schema := range s.Components.Schemas {
 		if _, ok := db.schemas[schemaName]; ok {
-			mqutil.Logger.Printf("warning - schema %s already exists", schemaName)
+			logger.Printf("warning - schema %s already exists", schemaName)
 		}
 		// Note that schema variable is reused in the loop
 		schemaCopy := (SchemaRef)(*schema)
@@ -434,7 +534,7 @@ func (db *DB) CloneSchema() *DB {
 	for k, v := range db.schemas {
 		schemas[k] = v.CloneSchema()
 	}
-	return &DB{schemas, db.Swagger, sync.Mutex{}}
+	return &DB{schemas, db.Swagger, db.logger, sync.Mutex{}}
 }
 
 func (db *DB) GetSchema(name string) SchemaRef {
@@ -506,13 +606,10 @@ func (db *DB) Update(name string, criteria interface{}, associations map[string]
 func (db *DB) FindMatchingSchema(obj interface{}) (string, SchemaRef) {
 	for name, schemaDB := range db.schemas {
 		schema := schemaDB.Schema
-		if schema.Matches(obj, db.Swagger) {
-			mqutil.Logger.Printf("found matching schema: %s", name)
+		if schema.Matches(obj, db.Swagger, db.logger) {
+			db.logger.Printf("found matching schema: %s", name)
 			return name, (SchemaRef)(schema)
 		}
 	}
 	return "", SchemaRef{}
 }
-
-// DB holds schema name to Schema mapping.
-var ObjDB DB
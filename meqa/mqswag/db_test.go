@@ -0,0 +1,128 @@
+package mqswag
+
+import (
+	"testing"
+
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+const petSpec = `
+openapi: 3.0.0
+info:
+  title: pets
+  version: "1"
+paths: {}
+components:
+  schemas:
+    Pet:
+      oneOf:
+      - $ref: '#/components/schemas/Cat'
+      - $ref: '#/components/schemas/Dog'
+      discriminator:
+        propertyName: petType
+        mapping:
+          cat: '#/components/schemas/Cat'
+          dog: Dog
+    Cat:
+      type: object
+      required: [petType, name, secret]
+      properties:
+        id:
+          type: integer
+          readOnly: true
+        petType:
+          type: string
+        name:
+          type: string
+        secret:
+          type: string
+          writeOnly: true
+        nickname:
+          type: string
+          nullable: true
+    Dog:
+      type: object
+      required: [petType, name]
+      properties:
+        petType:
+          type: string
+        name:
+          type: string
+        tag:
+          not:
+            type: string
+    Named:
+      type: object
+      required: [name]
+      properties:
+        name:
+          type: string
+        id:
+          type: integer
+    Numbered:
+      type: object
+      required: [id]
+      properties:
+        name:
+          type: string
+        id:
+          type: integer
+    AnyOf:
+      anyOf:
+      - $ref: '#/components/schemas/Named'
+      - $ref: '#/components/schemas/Numbered'
+    OneOf:
+      oneOf:
+      - $ref: '#/components/schemas/Named'
+      - $ref: '#/components/schemas/Numbered'
+`
+
+func TestParsesAlternatives(t *testing.T) {
+	s, err := spec.NewSwaggerLoader().LoadSwaggerFromData([]byte(petSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	swagger := (*Swagger)(s)
+	schema := func(name string) SchemaRef {
+		return SchemaRef{Ref: "#/components/schemas/" + name, Value: s.Components.Schemas[name].Value}
+	}
+
+	cat := map[string]interface{}{"petType": "cat", "name": "tom", "secret": "s"}
+	tests := []struct {
+		name     string
+		schema   string
+		object   interface{}
+		response bool
+		valid    bool
+	}{
+		{"cat", "Pet", cat, false, true},
+		{"dog by its bare name", "Pet", map[string]interface{}{"petType": "dog", "name": "rex"}, false, true},
+		{"no discriminator", "Pet", map[string]interface{}{"name": "tom"}, false, false},
+		{"unknown discriminator", "Pet", map[string]interface{}{"petType": "bird", "name": "tweety"}, false, false},
+		{"write only returned", "Pet", cat, true, false},
+		{"write only left out of the response", "Pet", map[string]interface{}{"petType": "cat", "name": "tom", "id": 1.0}, true, true},
+		{"nullable", "Pet", map[string]interface{}{"petType": "cat", "name": "tom", "secret": "s", "nickname": nil}, false, true},
+		{"null but not nullable", "Pet", map[string]interface{}{"petType": "cat", "name": nil, "secret": "s"}, false, false},
+		{"not", "Pet", map[string]interface{}{"petType": "dog", "name": "rex", "tag": "a"}, false, false},
+		{"not matched", "Pet", map[string]interface{}{"petType": "dog", "name": "rex", "tag": 1.0}, false, true},
+		{"any of both", "AnyOf", map[string]interface{}{"name": "a", "id": 1.0}, false, true},
+		{"any of none", "AnyOf", map[string]interface{}{"size": 1.0}, false, false},
+		{"one of", "OneOf", map[string]interface{}{"name": "a"}, false, true},
+		{"one of both", "OneOf", map[string]interface{}{"name": "a", "id": 1.0}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parses := schema(tt.schema).Parses
+			if tt.response {
+				parses = schema(tt.schema).ParsesResponse
+			}
+			err := parses("", tt.object, make(map[string][]interface{}), true, swagger, nil)
+			if tt.valid && err != nil {
+				t.Errorf("expected %v to be valid, got %v", tt.object, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("expected %v to be invalid", tt.object)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package mqswag
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+
+	spec "github.com/getkin/kin-openapi/openapi3"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// This file validates objects against the OpenAPI schemas with a JSON Schema validator. Unlike
+// SchemaRef.Parses it doesn't tolerate anything the schema doesn't allow.
+
+const definitionsPrefix = "#/definitions/"
+
+func init() {
+	// The formats OpenAPI adds to JSON Schema.
+	gojsonschema.FormatCheckers.Add("int32", intFormatChecker{math.MinInt32, math.MaxInt32})
+	gojsonschema.FormatCheckers.Add("int64", intFormatChecker{math.MinInt64, math.MaxInt64})
+	gojsonschema.FormatCheckers.Add("byte", byteFormatChecker{})
+}
+
+type intFormatChecker struct {
+	min, max float64
+}
+
+func (f intFormatChecker) IsFormat(input interface{}) bool {
+	// The validator passes numbers as rationals.
+	if r, ok := input.(*big.Rat); ok {
+		input, _ = r.Float64()
+	}
+	n, ok := toFloat(input)
+	if !ok {
+		// Not a number, the type check reports it.
+		return true
+	}
+	return n == math.Trunc(n) && n >= f.min && n <= f.max
+}
+
+type byteFormatChecker struct{}
+
+func (f byteFormatChecker) IsFormat(input interface{}) bool {
+	str, ok := input.(string)
+	if !ok {
+		return true
+	}
+	_, err := base64.StdEncoding.DecodeString(str)
+	return err == nil
+}
+
+// JSONValidator validates objects against the schemas of a swagger with a JSON Schema validator.
+type JSONValidator struct {
+	swagger *Swagger
+
+	// The component schemas converted to JSON Schema definitions, the first time an object is
+	// validated.
+	once        sync.Once
+	definitions map[string]interface{}
+	err         error
+}
+
+// NewJSONValidator creates a validator for the schemas of swagger.
+func NewJSONValidator(swagger *Swagger) *JSONValidator {
+	return &JSONValidator{swagger: swagger}
+}
+
+// jsonDefinitions returns the component schemas converted to JSON Schema definitions.
+func (v *JSONValidator) jsonDefinitions() (map[string]interface{}, error) {
+	v.once.Do(func() {
+		v.definitions = make(map[string]interface{})
+		for name, s := range v.swagger.Components.Schemas {
+			v.definitions[name], v.err = v.swagger.toJsonSchema(&spec.SchemaRef{Value: s.Value})
+			if v.err != nil {
+				return
+			}
+		}
+	})
+	return v.definitions, v.err
+}
+
+// Validate validates the object against the schema. The schema is converted from OpenAPI first:
+// nullable allows null, and writeOnly properties must not be present.
+func (v *JSONValidator) Validate(schema SchemaRef, object interface{}) error {
+	doc, err := v.swagger.toJsonSchema((*spec.SchemaRef)(&schema))
+	if err != nil {
+		return err
+	}
+	definitions, err := v.jsonDefinitions()
+	if err != nil {
+		return err
+	}
+	root, _ := doc.(map[string]interface{})
+	if root == nil {
+		// A bare $ref.
+		root = map[string]interface{}{"allOf": []interface{}{doc}}
+	}
+	root["definitions"] = definitions
+
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(root), gojsonschema.NewGoLoader(object))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+	var msgs []string
+	for _, e := range result.Errors() {
+		msgs = append(msgs, e.String())
+	}
+	objectBytes, _ := json.MarshalIndent(object, "", "    ")
+	return errors.New(fmt.Sprintf("object doesn't match the JSON schema:\n%s\nObject:\n%s\n",
+		strings.Join(msgs, "\n"), string(objectBytes)))
+}
+
+// toJsonSchema converts the OpenAPI schema to a JSON Schema map. References are turned into references
+// to the definitions.
+func (swagger *Swagger) toJsonSchema(schema *spec.SchemaRef) (interface{}, error) {
+	if schema == nil {
+		return nil, nil
+	}
+	if len(schema.Ref) > 0 {
+		name, _, err := swagger.GetReferredSchema((SchemaRef)(*schema))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"$ref": definitionsPrefix + name}, nil
+	}
+	if schema.Value == nil {
+		return map[string]interface{}{}, nil
+	}
+	s := *schema.Value
+	// Children are converted below.
+	s.Properties, s.Items, s.AllOf, s.OneOf, s.AnyOf, s.Not, s.AdditionalProperties = nil, nil, nil, nil, nil, nil, nil
+	b, err := json.Marshal(&s)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(string(b)))
+	d.UseNumber()
+	if err = d.Decode(&m); err != nil {
+		return nil, err
+	}
+	for _, k := range []string{"nullable", "readOnly", "writeOnly", "discriminator", "xml", "example", "externalDocs", "patternProperties"} {
+		delete(m, k)
+	}
+	if schema.Value.Nullable {
+		if len(schema.Value.Type) > 0 {
+			m["type"] = []interface{}{schema.Value.Type, "null"}
+		}
+		if len(schema.Value.Enum) > 0 {
+			m["enum"] = append(m["enum"].([]interface{}), nil)
+		}
+	}
+
+	convert := func(key string, s *spec.SchemaRef) error {
+		if s == nil {
+			return nil
+		}
+		c, err := swagger.toJsonSchema(s)
+		if err == nil {
+			m[key] = c
+		}
+		return err
+	}
+	convertList := func(key string, list []*spec.SchemaRef) error {
+		if len(list) == 0 {
+			return nil
+		}
+		var l []interface{}
+		for _, s := range list {
+			c, err := swagger.toJsonSchema(s)
+			if err != nil {
+				return err
+			}
+			l = append(l, c)
+		}
+		m[key] = l
+		return nil
+	}
+	if err = convert("items", schema.Value.Items); err != nil {
+		return nil, err
+	}
+	if err = convert("not", schema.Value.Not); err != nil {
+		return nil, err
+	}
+	if err = convert("additionalProperties", schema.Value.AdditionalProperties); err != nil {
+		return nil, err
+	}
+	if schema.Value.AdditionalPropertiesAllowed != nil {
+		m["additionalProperties"] = *schema.Value.AdditionalPropertiesAllowed
+	}
+	if err = convertList("oneOf", schema.Value.OneOf); err != nil {
+		return nil, err
+	}
+	if err = convertList("anyOf", schema.Value.AnyOf); err != nil {
+		return nil, err
+	}
+	if err = convertList("allOf", schema.Value.AllOf); err != nil {
+		return nil, err
+	}
+
+	if len(schema.Value.Properties) > 0 {
+		properties := make(map[string]interface{})
+		var writeOnly []interface{}
+		for name, p := range schema.Value.Properties {
+			if properties[name], err = swagger.toJsonSchema(p); err != nil {
+				return nil, err
+			}
+			if p.Value != nil && p.Value.WriteOnly {
+				writeOnly = append(writeOnly, map[string]interface{}{"required": []interface{}{name}})
+			}
+		}
+		m["properties"] = properties
+		if len(writeOnly) > 0 {
+			var required []interface{}
+			for _, r := range schema.Value.Required {
+				if p := schema.Value.Properties[r]; p == nil || p.Value == nil || !p.Value.WriteOnly {
+					required = append(required, r)
+				}
+			}
+			if len(required) > 0 {
+				m["required"] = required
+			} else {
+				delete(m, "required")
+			}
+			// None of the writeOnly properties may be present.
+			l, _ := m["allOf"].([]interface{})
+			m["allOf"] = append(l, map[string]interface{}{"not": map[string]interface{}{"anyOf": writeOnly}})
+		}
+	}
+	return m, nil
+}
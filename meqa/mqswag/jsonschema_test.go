@@ -0,0 +1,65 @@
+package mqswag
+
+import (
+	"testing"
+
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+const thingSpec = `
+openapi: 3.0.0
+info:
+  title: things
+  version: "1"
+paths: {}
+components:
+  schemas:
+    Thing:
+      type: object
+      required: [id]
+      additionalProperties: false
+      properties:
+        id:
+          type: integer
+          format: int32
+        name:
+          type: string
+          nullable: true
+        password:
+          type: string
+          writeOnly: true
+`
+
+func TestJSONValidator(t *testing.T) {
+	s, err := spec.NewSwaggerLoader().LoadSwaggerFromData([]byte(thingSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator := NewJSONValidator((*Swagger)(s))
+	thing := SchemaRef{Ref: "#/components/schemas/Thing", Value: s.Components.Schemas["Thing"].Value}
+
+	tests := []struct {
+		name   string
+		object interface{}
+		valid  bool
+	}{
+		{"valid", map[string]interface{}{"id": 1.0, "name": "a"}, true},
+		{"nullable", map[string]interface{}{"id": 1.0, "name": nil}, true},
+		{"missing required", map[string]interface{}{"name": "a"}, false},
+		{"additional property", map[string]interface{}{"id": 1.0, "color": "red"}, false},
+		{"int32 overflow", map[string]interface{}{"id": 1e10}, false},
+		{"write only", map[string]interface{}{"id": 1.0, "password": "secret"}, false},
+		{"wrong type", "thing", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.Validate(thing, tt.object)
+			if tt.valid && err != nil {
+				t.Errorf("expected %v to be valid, got %v", tt.object, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("expected %v to be invalid", tt.object)
+			}
+		})
+	}
+}
@@ -2,11 +2,14 @@
 package mqswag
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -38,7 +41,10 @@ const (
 )
 
 const (
-	JsonResponse = "application/json"
+	JsonResponse   = "application/json"
+	FormMedia      = "application/x-www-form-urlencoded"
+	MultipartMedia = "multipart/form-data"
+	XmlMedia       = "application/xml"
 )
 
 var MethodAll []string = []string{MethodGet, MethodPut, MethodPost, MethodDelete, MethodHead, MethodPatch, MethodOptions}
@@ -88,7 +94,7 @@ func (t *MeqaTag) Equals(o *MeqaTag) bool {
 
 func (t *MeqaTag) ToString() string {
 	str := "<meqa " + t.Class
-	if len(t.Property) > 0 {
+	if len(t.Property) > 0 || len(t.Operation) > 0 {
 		str = str + "." + t.Property
 	}
 	if len(t.Operation) > 0 {
@@ -98,14 +104,15 @@ func (t *MeqaTag) ToString() string {
 	return str
 }
 
+var meqaTagRegexp = regexp.MustCompile("<meqa *[/-~\\-]+\\.?[/-~\\-]*\\.?[a-zA-Z]* *[a-zA-Z,]* *>")
+
 // GetMeqaTag extracts the <meqa > tags.
 // Example. for  <meqa Pet.Name.update>, return Pet, Name, update
-func GetMeqaTag(desc string) *MeqaTag {
+func GetMeqaTag(desc string, logger *log.Logger) *MeqaTag {
 	if len(desc) == 0 {
 		return nil
 	}
-	re := regexp.MustCompile("<meqa *[/-~\\-]+\\.?[/-~\\-]*\\.?[a-zA-Z]* *[a-zA-Z,]* *>")
-	ar := re.FindAllString(desc, -1)
+	ar := meqaTagRegexp.FindAllString(desc, -1)
 
 	// TODO it's possible that we have multiple choices because the server can't be
 	// certain. However, we only process one right now.
@@ -116,7 +123,7 @@ func GetMeqaTag(desc string) *MeqaTag {
 	right := strings.IndexRune(meqa, '>')
 
 	if right < 0 {
-		mqutil.Logger.Printf("invalid meqa tag in description: %s", desc)
+		logger.Printf("invalid meqa tag in description: %s", desc)
 		return nil
 	}
 	meqa = strings.Trim(meqa[:right], " ")
@@ -146,34 +153,138 @@ func GetMeqaTag(desc string) *MeqaTag {
 	case 3:
 		return &MeqaTag{contents[0], contents[1], contents[2], flags}
 	default:
-		mqutil.Logger.Printf("invalid meqa tag in description: %s", desc)
+		logger.Printf("invalid meqa tag in description: %s", desc)
 		return nil
 	}
 }
 
-type Swagger spec.Swagger
+// MeqaExtension is the vendor extension that holds the meqa tag of an operation, parameter, schema
+// or response, e.g. x-meqa: {class: Pet, property: id, operation: get, flags: [weak]}. The short form
+// x-meqa: Pet.id.get is the same as <meqa Pet.id.get>.
+const MeqaExtension = "x-meqa"
 
-var UniqueKeys map[string]bool
+type meqaExtension struct {
+	Class     string      `json:"class"`
+	Property  string      `json:"property"`
+	Operation string      `json:"operation"`
+	Flags     interface{} `json:"flags"` // a list or a comma separated string
+}
 
-func ReadUniqueKeys(meqaPath string) error {
+// GetMeqaTagFromExtensions extracts the tag from the x-meqa extension. It returns nil if there is
+// no valid extension.
+func GetMeqaTagFromExtensions(props spec.ExtensionProps, logger *log.Logger) *MeqaTag {
+	value, ok := props.Extensions[MeqaExtension]
+	if !ok {
+		return nil
+	}
+	raw, ok := value.(json.RawMessage)
+	if !ok {
+		var err error
+		raw, err = json.Marshal(value)
+		if err != nil {
+			return nil
+		}
+	}
+	var short string
+	if json.Unmarshal(raw, &short) == nil {
+		return GetMeqaTag("<meqa "+short+">", logger)
+	}
+	var ext meqaExtension
+	if json.Unmarshal(raw, &ext) != nil || len(ext.Class) == 0 {
+		return nil
+	}
+	tag := &MeqaTag{ext.Class, ext.Property, ext.Operation, 0}
+	var flags []string
+	switch f := ext.Flags.(type) {
+	case string:
+		flags = strings.Split(f, ",")
+	case []interface{}:
+		for _, v := range f {
+			flags = append(flags, fmt.Sprint(v))
+		}
+	}
+	for _, f := range flags {
+		switch strings.TrimSpace(f) {
+		case "success":
+			tag.Flags |= FlagSuccess
+		case "fail":
+			tag.Flags |= FlagFail
+		case "weak":
+			tag.Flags |= FlagWeak
+		}
+	}
+	return tag
+}
+
+// GetTag returns the meqa tag from the x-meqa extension, or from the <meqa> tag in the description
+// if there is no extension.
+func GetTag(props spec.ExtensionProps, desc string, logger *log.Logger) *MeqaTag {
+	if tag := GetMeqaTagFromExtensions(props, logger); tag != nil {
+		return tag
+	}
+	return GetMeqaTag(desc, logger)
+}
+
+// GetOperationTag returns the meqa tag of the operation.
+func GetOperationTag(op *spec.Operation, logger *log.Logger) *MeqaTag {
+	return GetTag(op.ExtensionProps, op.Description, logger)
+}
+
+// GetParameterTag returns the meqa tag of the parameter.
+func GetParameterTag(param *spec.Parameter, logger *log.Logger) *MeqaTag {
+	return GetTag(param.ExtensionProps, param.Description, logger)
+}
+
+// GetSchemaTag returns the meqa tag of the schema.
+func GetSchemaTag(schema *spec.Schema, logger *log.Logger) *MeqaTag {
+	return GetTag(schema.ExtensionProps, schema.Description, logger)
+}
+
+// GetResponseTag returns the meqa tag of the response.
+func GetResponseTag(resp *spec.Response, logger *log.Logger) *MeqaTag {
+	return GetTag(resp.ExtensionProps, resp.Description, logger)
+}
+
+// GetExamples returns the example and the values of the named examples of a parameter or media type,
+// the named ones sorted by name. Examples with only an externalValue are skipped.
+func GetExamples(example interface{}, examples map[string]*spec.ExampleRef) []interface{} {
+	var result []interface{}
+	if example != nil {
+		result = append(result, example)
+	}
+	var names []string
+	for name, e := range examples {
+		if e != nil && e.Value != nil && e.Value.Value != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result = append(result, examples[name].Value.Value)
+	}
+	return result
+}
+
+type Swagger spec.Swagger
+
+// ReadUniqueKeys reads the fields that must have unique values from the unique keys file.
+func ReadUniqueKeys(meqaPath string) (map[string]bool, error) {
 	var uniqueKeysStruct UniqueKeysStruct
 	data, err := ioutil.ReadFile(filepath.Join(meqaPath, UniqueKeysFile))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = yaml.Unmarshal([]byte(data), &uniqueKeysStruct)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	UniqueKeys = make(map[string]bool)
+	uniqueKeys := make(map[string]bool)
 	for _, key := range uniqueKeysStruct.Keys {
-		UniqueKeys[key] = true
+		uniqueKeys[key] = true
 	}
-	return nil
+	return uniqueKeys, nil
 }
 
-var Dataset, DoneData DatasetType
-
 func filter(doneData, allData, dataset *map[string][]interface{}, batchSize int) {
 	doneMap := make(map[string]map[interface{}]bool)
 	for k, v := range *doneData {
@@ -214,7 +325,9 @@ func filter(doneData, allData, dataset *map[string][]interface{}, batchSize int)
 	}
 }
 
-func ReadDataset(datasetPath, meqaPath, fuzzMode string, batchSize int) error {
+// ReadDataset picks the next batch of fuzz values from the dataset that haven't been used yet.
+// It returns the batch and the values used so far, including the batch.
+func ReadDataset(datasetPath, meqaPath, fuzzMode string, batchSize int) (dataset, doneData DatasetType, err error) {
 	readLocalDataset := func(datasetPath string) (DatasetType, error) {
 		var dataset DatasetType
 		data, err := ioutil.ReadFile(datasetPath)
@@ -225,7 +338,6 @@ func ReadDataset(datasetPath, meqaPath, fuzzMode string, batchSize int) error {
 		return dataset, err
 	}
 	var AllData DatasetType
-	var err error
 	if datasetPath == "" {
 		stringsList := blns.Unencoded()
 		interfacesList := make([]interface{}, len(stringsList))
@@ -243,24 +355,25 @@ func ReadDataset(datasetPath, meqaPath, fuzzMode string, batchSize int) error {
 	} else {
 		AllData, err = readLocalDataset(datasetPath)
 		if err != nil {
-			return err
+			return dataset, doneData, err
 		}
 	}
-	DoneData, err = readLocalDataset(filepath.Join(meqaPath, DoneDataFile))
+	doneData, err = readLocalDataset(filepath.Join(meqaPath, DoneDataFile))
 	if err != nil {
-		return err
+		return dataset, doneData, err
 	}
 	if fuzzMode == mqutil.FuzzPositive || fuzzMode == mqutil.FuzzAll {
-		filter(&DoneData.Positive, &AllData.Positive, &Dataset.Positive, batchSize)
+		filter(&doneData.Positive, &AllData.Positive, &dataset.Positive, batchSize)
 	}
 	if fuzzMode == mqutil.FuzzNegative || fuzzMode == mqutil.FuzzAll {
-		filter(&DoneData.Negative, &AllData.Negative, &Dataset.Negative, batchSize)
+		filter(&doneData.Negative, &AllData.Negative, &dataset.Negative, batchSize)
 	}
-	return nil
+	return dataset, doneData, nil
 }
 
-func WriteDoneData(meqaPath string) error {
-	data, err := yaml.Marshal(DoneData)
+// WriteDoneData writes the fuzz values used so far to the done data file.
+func WriteDoneData(meqaPath string, doneData DatasetType) error {
+	data, err := yaml.Marshal(doneData)
 	if err != nil {
 		return err
 	}
@@ -273,8 +386,7 @@ func CreateSwaggerFromURL(path string, meqaPath string) (*Swagger, error) {
 	os.Remove(tmpPath)
 	tmpFile, err := os.Create(tmpPath)
 	if err != nil {
-		mqutil.Logger.Printf("can't access tmp file %s", tmpPath)
-		return nil, err
+		return nil, fmt.Errorf("can't access tmp file %s: %s", tmpPath, err.Error())
 	}
 	defer os.Remove(tmpPath)
 
@@ -286,18 +398,15 @@ func CreateSwaggerFromURL(path string, meqaPath string) (*Swagger, error) {
 	} else {
 		yamlBytes, err := ioutil.ReadFile(path)
 		if err != nil {
-			mqutil.Logger.Printf("can't read file %s", path)
-			return nil, err
+			return nil, fmt.Errorf("can't read file %s: %s", path, err.Error())
 		}
 		jsonBytes, err := mqutil.YamlToJson(yamlBytes)
 		if err != nil {
-			mqutil.Logger.Printf("invalid yaml in file %s %v", path, err)
-			return nil, err
+			return nil, fmt.Errorf("invalid yaml in file %s: %s", path, err.Error())
 		}
 		_, err = tmpFile.Write(jsonBytes)
 		if err != nil {
-			mqutil.Logger.Printf("can't access tmp file %s", tmpPath)
-			return nil, err
+			return nil, fmt.Errorf("can't access tmp file %s: %s", tmpPath, err.Error())
 		}
 		swaggerJsonPath = tmpPath
 	}
@@ -305,9 +414,10 @@ func CreateSwaggerFromURL(path string, meqaPath string) (*Swagger, error) {
 	// specDoc, err := loads.Spec(swaggerJsonPath)
 	spec, err := spec.NewSwaggerLoader().LoadSwaggerFromFile(swaggerJsonPath)
 	if err != nil {
-		mqutil.Logger.Printf("Can't open the following file: %s", path)
-		mqutil.Logger.Println(err.Error())
-		return nil, err
+		return nil, fmt.Errorf("can't open the following file: %s: %s", path, err.Error())
+	}
+	if jsonBytes, err := ioutil.ReadFile(swaggerJsonPath); err == nil {
+		markRequiredHeaders(spec, jsonBytes)
 	}
 
 	// log.Println("Would be serving:", specDoc.Spec().Info.Title)
@@ -316,6 +426,71 @@ func CreateSwaggerFromURL(path string, meqaPath string) (*Swagger, error) {
 	return (*Swagger)(spec), nil
 }
 
+// SwaggerVersionExtension marks the specs converted from Swagger 2.0, which has no null. Only the
+// specs written as OpenAPI 3 have their null values checked against nullable.
+const SwaggerVersionExtension = "x-meqa-swagger-version"
+
+// checksNullable reports whether a null value must be declared nullable in the swagger.
+func (swagger *Swagger) checksNullable() bool {
+	if swagger == nil {
+		return true
+	}
+	_, converted := swagger.Extensions[SwaggerVersionExtension]
+	return !converted
+}
+
+// The loader drops the required field of headers. markRequiredHeaders recovers it from the json
+// document into this extension.
+const headerRequiredExtension = "x-meqa-required"
+
+func markRequiredHeaders(swagger *spec.Swagger, data []byte) {
+	var doc interface{}
+	if json.Unmarshal(data, &doc) != nil {
+		return
+	}
+	lookup := func(keys ...string) map[string]interface{} {
+		node := doc
+		for _, k := range keys {
+			m, _ := node.(map[string]interface{})
+			node = m[k]
+		}
+		m, _ := node.(map[string]interface{})
+		return m
+	}
+	mark := func(headers map[string]*spec.HeaderRef, raw map[string]interface{}) {
+		for name, h := range headers {
+			rawHeader, _ := raw[name].(map[string]interface{})
+			if required, _ := rawHeader["required"].(bool); required && h.Value != nil {
+				if h.Value.Extensions == nil {
+					h.Value.Extensions = make(map[string]interface{})
+				}
+				h.Value.Extensions[headerRequiredExtension] = true
+			}
+		}
+	}
+	for path, item := range swagger.Paths {
+		for method, op := range item.Operations() {
+			for code, resp := range op.Responses {
+				if resp.Value != nil {
+					mark(resp.Value.Headers, lookup("paths", path, strings.ToLower(method), "responses", code, "headers"))
+				}
+			}
+		}
+	}
+	for name, resp := range swagger.Components.Responses {
+		if resp.Value != nil {
+			mark(resp.Value.Headers, lookup("components", "responses", name, "headers"))
+		}
+	}
+	mark(swagger.Components.Headers, lookup("components", "headers"))
+}
+
+// IsHeaderRequired returns whether the response header is documented as required.
+func IsHeaderRequired(header *spec.Header) bool {
+	required, _ := header.Extensions[headerRequiredExtension].(bool)
+	return required
+}
+
 func GetListFromFile(path string) (map[string]bool, error) {
 	bytes, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -354,24 +529,58 @@ func (swagger *Swagger) GetReferredSchema(schema SchemaRef) (string, SchemaRef,
 	return tokens[3], referredSchema, nil
 }
 
+// DiscriminatorMapping returns the oneOf and anyOf schemas of the schema indexed by the value its
+// discriminator property takes for them. Schemas that aren't in the discriminator's mapping use the
+// name they are referred by.
+func (swagger *Swagger) DiscriminatorMapping(schema SchemaRef) map[string]SchemaRef {
+	mapping := make(map[string]SchemaRef)
+	if schema.Value.Discriminator == nil {
+		return mapping
+	}
+	mapped := make(map[string]bool)
+	for value, ref := range schema.Value.Discriminator.Mapping {
+		if !strings.Contains(ref, "/") {
+			ref = "#/components/schemas/" + ref
+		}
+		_, referredSchema, err := swagger.GetReferredSchema(SchemaRef{Ref: ref})
+		if err != nil {
+			continue
+		}
+		mapping[value] = SchemaRef{Ref: ref, Value: referredSchema.Value}
+		mapped[ref] = true
+	}
+	var alternatives []*spec.SchemaRef
+	alternatives = append(alternatives, schema.Value.OneOf...)
+	alternatives = append(alternatives, schema.Value.AnyOf...)
+	for _, s := range alternatives {
+		if len(s.Ref) == 0 || mapped[s.Ref] {
+			continue
+		}
+		if name, _, err := swagger.GetReferredSchema((SchemaRef)(*s)); err == nil {
+			mapping[name] = (SchemaRef)(*s)
+		}
+	}
+	return mapping
+}
+
 // GetSchemaRootType gets the real object type fo the specified schema. It only returns meaningful
 // data for object and array of object type of parameters. If the parameter is a basic type it returns
 // nil
-func (swagger *Swagger) GetSchemaRootType(schema SchemaRef, parentTag *MeqaTag) (*MeqaTag, SchemaRef) {
-	tag := GetMeqaTag(schema.Value.Description)
+func (swagger *Swagger) GetSchemaRootType(schema SchemaRef, parentTag *MeqaTag, logger *log.Logger) (*MeqaTag, SchemaRef) {
+	tag := GetSchemaTag(schema.Value, logger)
 	if tag == nil {
 		tag = parentTag
 	}
 	referenceName, referredSchema, err := swagger.GetReferredSchema(schema)
 	if err != nil {
-		mqutil.Logger.Print(err)
+		logger.Print(err)
 		return nil, SchemaRef{}
 	}
 	if referredSchema.Value != nil {
 		if tag == nil {
 			tag = &MeqaTag{referenceName, "", "", 0}
 		}
-		return swagger.GetSchemaRootType(referredSchema, tag)
+		return swagger.GetSchemaRootType(referredSchema, tag, logger)
 	}
 	if len(schema.Value.Enum) != 0 {
 		return nil, SchemaRef{}
@@ -381,7 +590,7 @@ func (swagger *Swagger) GetSchemaRootType(schema SchemaRef, parentTag *MeqaTag)
 	}
 	if strings.Contains(schema.Value.Type, gojsonschema.TYPE_ARRAY) {
 		itemSchema := (SchemaRef)(*schema.Value.Items)
-		return swagger.GetSchemaRootType(itemSchema, tag)
+		return swagger.GetSchemaRootType(itemSchema, tag, logger)
 	} else if strings.Contains(schema.Value.Type, gojsonschema.TYPE_OBJECT) {
 		return tag, schema
 	}
@@ -432,9 +641,9 @@ func (dep *Dependencies) CollectFromTag(tag *MeqaTag) string {
 
 // collects all the objects referred to by the schema. All the object names are put into
 // the specified map.
-func CollectSchemaDependencies(schema SchemaRef, swagger *Swagger, dag *DAG, dep *Dependencies) error {
+func CollectSchemaDependencies(schema SchemaRef, swagger *Swagger, dag *DAG, dep *Dependencies, logger *log.Logger) error {
 	iterFunc := func(swagger *Swagger, schemaName string, schema SchemaRef, context interface{}) error {
-		collected := dep.CollectFromTag(GetMeqaTag(schema.Value.Description))
+		collected := dep.CollectFromTag(GetSchemaTag(schema.Value, logger))
 		if len(collected) == 0 && len(schemaName) > 0 {
 			dep.Default[schemaName] = 1
 		}
@@ -442,10 +651,10 @@ func CollectSchemaDependencies(schema SchemaRef, swagger *Swagger, dag *DAG, dep
 		return nil
 	}
 
-	return schema.Iterate(iterFunc, dep, swagger, false)
+	return schema.Iterate(iterFunc, dep, swagger, false, logger)
 }
 
-func CollectParamDependencies(params spec.Parameters, swagger *Swagger, dag *DAG, dep *Dependencies) error {
+func CollectParamDependencies(params spec.Parameters, swagger *Swagger, dag *DAG, dep *Dependencies, logger *log.Logger) error {
 	defer func() { dep.Default = nil }()
 
 	// the list of objects this method is producing that are specified through refs. We need to go through
@@ -457,12 +666,12 @@ func CollectParamDependencies(params spec.Parameters, swagger *Swagger, dag *DAG
 		} else {
 			dep.Default = dep.Consumes
 		}
-		collected := dep.CollectFromTag(GetMeqaTag(param.Value.Description))
+		collected := dep.CollectFromTag(GetParameterTag(param.Value, logger))
 
 		if param.Value.Schema.Value != nil {
 			schema := (SchemaRef)(*param.Value.Schema)
 			if len(collected) == 0 {
-				collected = dep.CollectFromTag(GetMeqaTag(schema.Value.Description))
+				collected = dep.CollectFromTag(GetSchemaTag(schema.Value, logger))
 			}
 			if len(collected) > 0 {
 				// Only try to collect addition info from the object schema if the object is not
@@ -474,7 +683,7 @@ func CollectParamDependencies(params spec.Parameters, swagger *Swagger, dag *DAG
 				}
 			} else {
 				// Getting root type covers refs and arrays
-				t, _ := swagger.GetSchemaRootType(schema, nil)
+				t, _ := swagger.GetSchemaRootType(schema, nil, logger)
 				if t != nil && len(t.Class) > 0 {
 					dep.Default[t.Class] = 1
 					inputsNeeded = append(inputsNeeded, t.Class)
@@ -482,7 +691,7 @@ func CollectParamDependencies(params spec.Parameters, swagger *Swagger, dag *DAG
 				}
 			}
 			dep.Default = dep.Consumes
-			err := CollectSchemaDependencies(schema, swagger, dag, dep)
+			err := CollectSchemaDependencies(schema, swagger, dag, dep, logger)
 			if err != nil {
 				return err
 			}
@@ -494,7 +703,7 @@ func CollectParamDependencies(params spec.Parameters, swagger *Swagger, dag *DAG
 	for _, name := range inputsNeeded {
 		schema := swagger.FindSchemaByName(name)
 		dep.Default = dep.Consumes
-		err := CollectSchemaDependencies(schema, swagger, dag, dep)
+		err := CollectSchemaDependencies(schema, swagger, dag, dep, logger)
 		if err != nil {
 			return err
 		}
@@ -503,7 +712,7 @@ func CollectParamDependencies(params spec.Parameters, swagger *Swagger, dag *DAG
 	return nil
 }
 
-func CollectResponseDependencies(responses *spec.Responses, swagger *Swagger, dag *DAG, dep *Dependencies) error {
+func CollectResponseDependencies(responses *spec.Responses, swagger *Swagger, dag *DAG, dep *Dependencies, logger *log.Logger) error {
 	if responses == nil {
 		return nil
 	}
@@ -511,13 +720,13 @@ func CollectResponseDependencies(responses *spec.Responses, swagger *Swagger, da
 	defer func() { dep.Default = nil }()
 	for respCodeS, respSpec := range *responses {
 		respCode, _ := strconv.Atoi(respCodeS)
-		collected := dep.CollectFromTag(GetMeqaTag(respSpec.Value.Description))
+		collected := dep.CollectFromTag(GetResponseTag(respSpec.Value, logger))
 		if len(collected) > 0 {
 			continue
 		}
 		if respSpec.Value != nil && respCode >= 200 && respCode < 300 {
 			if respSpec.Value.Content != nil {
-				err := CollectSchemaDependencies((SchemaRef)(*respSpec.Value.Content[JsonResponse].Schema), swagger, dag, dep)
+				err := CollectSchemaDependencies((SchemaRef)(*respSpec.Value.Content[JsonResponse].Schema), swagger, dag, dep, logger)
 				if err != nil {
 					return err
 				}
@@ -537,7 +746,7 @@ var methodWeight = map[string]int{
 	MethodDelete:  4,
 }
 
-func AddOperation(pathName string, pathItem *spec.PathItem, method string, swagger *Swagger, dag *DAG, setPriority bool) error {
+func AddOperation(pathName string, pathItem *spec.PathItem, method string, swagger *Swagger, dag *DAG, setPriority bool, logger *log.Logger) error {
 	op := pathItem.GetOperation(strings.ToUpper(method))
 	if op == nil {
 		return nil
@@ -557,7 +766,7 @@ func AddOperation(pathName string, pathItem *spec.PathItem, method string, swagg
 	// The nodes that are part of outputs depends on this operation. The outputs are children.
 	// We have to be careful here. Get operations will also return objects. For gets, the outputs
 	// are children only if they are not part of input parameters.
-	tag := GetMeqaTag(op.Description)
+	tag := GetOperationTag(op, logger)
 	dep := &Dependencies{}
 	dep.Produces = make(map[string]interface{})
 	dep.Consumes = make(map[string]interface{})
@@ -573,17 +782,17 @@ func AddOperation(pathName string, pathItem *spec.PathItem, method string, swagg
 
 	// The order matters. At the end of CollectParamDependencies we collect the parameters
 	// referred by the object we produce.
-	err = CollectParamDependencies(op.Parameters, swagger, dag, dep)
+	err = CollectParamDependencies(op.Parameters, swagger, dag, dep, logger)
 	if err != nil {
 		return err
 	}
 
-	err = CollectParamDependencies(pathItem.Parameters, swagger, dag, dep)
+	err = CollectParamDependencies(pathItem.Parameters, swagger, dag, dep, logger)
 	if err != nil {
 		return err
 	}
 
-	err = CollectResponseDependencies(&op.Responses, swagger, dag, dep)
+	err = CollectResponseDependencies(&op.Responses, swagger, dag, dep, logger)
 	if err != nil {
 		return err
 	}
@@ -635,7 +844,7 @@ func AddOperation(pathName string, pathItem *spec.PathItem, method string, swagg
 	return node.AddDependencies(dag, dep.Consumes, false)
 }
 
-func (swagger *Swagger) AddToDAG(dag *DAG) error {
+func (swagger *Swagger) AddToDAG(dag *DAG, logger *log.Logger) error {
 	// Add all definitions
 	for name, schema := range swagger.Components.Schemas {
 		schemaCopy := Schema(*schema.Value) // must make a copy first, the schema variable is reused in the loop scope
@@ -654,7 +863,7 @@ func (swagger *Swagger) AddToDAG(dag *DAG) error {
 			}
 			return nil
 		}
-		((SchemaRef)(*schema)).Iterate(collectInner, nil, swagger, false)
+		((SchemaRef)(*schema)).Iterate(collectInner, nil, swagger, false, logger)
 		// The inner fields are the parents. The child depends on parents.
 		node.AddDependencies(dag, collections, false)
 	}
@@ -662,7 +871,7 @@ func (swagger *Swagger) AddToDAG(dag *DAG) error {
 	// Add all operations
 	for pathName, pathItem := range swagger.Paths {
 		for _, method := range MethodAll {
-			err := AddOperation(pathName, pathItem, method, swagger, dag, false)
+			err := AddOperation(pathName, pathItem, method, swagger, dag, false, logger)
 			if err != nil {
 				return err
 			}
@@ -671,7 +880,7 @@ func (swagger *Swagger) AddToDAG(dag *DAG) error {
 	// set priorities. This can only be done after the above, where all weights for all operations are set.
 	for pathName, pathItem := range swagger.Paths {
 		for _, method := range MethodAll {
-			err := AddOperation(pathName, pathItem, method, swagger, dag, true)
+			err := AddOperation(pathName, pathItem, method, swagger, dag, true, logger)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,462 @@
+package mqswag
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AdityaVallabh/swagger_meqa/meqa/mqutil"
+	"gopkg.in/yaml.v2"
+
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+	spec "github.com/getkin/kin-openapi/openapi3"
+)
+
+// ReadSpecDoc parses an OpenAPI 3 or Swagger 2.0 spec in json or yaml. Swagger 2.0 specs are
+// converted to OpenAPI 3. The key order of OpenAPI 3 input is kept.
+func ReadSpecDoc(data []byte) (yaml.MapSlice, error) {
+	var doc yaml.MapSlice
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	if v := docValue(doc, "swagger"); v == nil {
+		if docValue(doc, "openapi") == nil {
+			return nil, fmt.Errorf("neither an OpenAPI 3 nor a Swagger 2.0 spec")
+		}
+		return doc, nil
+	} else if fmt.Sprint(v) != "2.0" {
+		return nil, fmt.Errorf("unsupported swagger version %v", v)
+	}
+
+	jsonBytes, err := mqutil.YamlToJson(data)
+	if err != nil {
+		return nil, err
+	}
+	var swagger2 openapi2.Swagger
+	err = json.Unmarshal(jsonBytes, &swagger2)
+	if err != nil {
+		return nil, err
+	}
+	swagger3, err := openapi2conv.ToV3Swagger(&swagger2)
+	if err != nil {
+		return nil, err
+	}
+	// Fix up what the conversion gets wrong when schemes aren't given.
+	swagger3.OpenAPI = "3.0.0"
+	swagger3.Servers = nil
+	schemes := swagger2.Schemes
+	if len(schemes) == 0 {
+		schemes = []string{"http"}
+	}
+	for _, scheme := range schemes {
+		if len(swagger2.Host) > 0 {
+			swagger3.AddServer(&spec.Server{URL: scheme + "://" + swagger2.Host + swagger2.BasePath})
+		} else if len(swagger2.BasePath) > 0 {
+			swagger3.AddServer(&spec.Server{URL: swagger2.BasePath})
+			break
+		}
+	}
+	jsonBytes, err = json.Marshal(swagger3)
+	if err != nil {
+		return nil, err
+	}
+	// The conversion keeps the 2.0 references.
+	jsonBytes = []byte(strings.NewReplacer(
+		`"#/definitions/`, `"#/components/schemas/`,
+		`"#/parameters/`, `"#/components/parameters/`,
+		`"#/responses/`, `"#/components/responses/`,
+	).Replace(string(jsonBytes)))
+	doc = nil
+	err = yaml.Unmarshal(jsonBytes, &doc)
+	if err != nil {
+		return nil, err
+	}
+	mapNullable(doc)
+	return append(doc, yaml.MapItem{Key: SwaggerVersionExtension, Value: "2.0"}), nil
+}
+
+// mapNullable turns the x-nullable extensions of Swagger 2.0 into the nullable of OpenAPI 3, which
+// the conversion leaves out.
+func mapNullable(node interface{}) {
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		for i := range n {
+			if n[i].Key == "x-nullable" {
+				n[i].Key = "nullable"
+			}
+			mapNullable(n[i].Value)
+		}
+	case []interface{}:
+		for _, item := range n {
+			mapNullable(item)
+		}
+	}
+}
+
+// TagSpecDoc adds the <meqa> tags that can be inferred from the structure of an OpenAPI 3 spec
+// to the descriptions in it. The classes come from the schemas referred to by the responses and
+// request bodies, the object properties from the path parameters (e.g. {petId} is Pet.id) and the
+// operations from the methods and the shape of the paths. Descriptions that already have a tag
+// are left alone. With extension the tags are added as x-meqa extensions instead of to the
+// descriptions. It returns the tagged spec and the number of tags added.
+func TagSpecDoc(doc yaml.MapSlice, extension bool, logger *log.Logger) (yaml.MapSlice, int, error) {
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	jsonBytes, err := mqutil.YamlToJson(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	s, err := spec.NewSwaggerLoader().LoadSwaggerFromData(jsonBytes)
+	if err != nil {
+		return nil, 0, err
+	}
+	swagger := (*Swagger)(s)
+
+	count := 0
+	var node interface{} = doc
+	addTag := func(tag *MeqaTag, loc ...interface{}) {
+		var added bool
+		node, added = addTagAt(node, loc, tag, extension, logger)
+		if added {
+			count++
+		}
+	}
+	var pathNames []string
+	for pathName := range swagger.Paths {
+		pathNames = append(pathNames, pathName)
+	}
+	sort.Strings(pathNames)
+	for _, pathName := range pathNames {
+		pathItem := swagger.Paths[pathName]
+		for i, param := range pathItem.Parameters {
+			if tag := swagger.inferParamTag(pathName, param, "", logger); tag != nil {
+				addTag(tag, "paths", pathName, "parameters", i)
+			}
+		}
+		for _, method := range MethodAll {
+			op := pathItem.GetOperation(strings.ToUpper(method))
+			if op == nil {
+				continue
+			}
+			tag := swagger.inferOperationTag(pathName, method, op, logger)
+			if tag != nil {
+				addTag(tag, "paths", pathName, method)
+			}
+			var class string
+			if tag != nil {
+				class = tag.Class
+			}
+			for i, param := range op.Parameters {
+				if tag := swagger.inferParamTag(pathName, param, class, logger); tag != nil {
+					addTag(tag, "paths", pathName, method, "parameters", i)
+				}
+			}
+		}
+	}
+	return node.(yaml.MapSlice), count, nil
+}
+
+// inferOperationTag guesses the class and the operation of op. Actions on an object such as
+// POST /pet/{petId}/uploadImage aren't tagged.
+func (swagger *Swagger) inferOperationTag(pathName string, method string, op *spec.Operation, logger *log.Logger) *MeqaTag {
+	if GetOperationTag(op, logger) != nil {
+		return nil
+	}
+	segments := strings.Split(strings.Trim(pathName, "/"), "/")
+	last := segments[len(segments)-1]
+	isItem := isPathParam(last)
+
+	class := swagger.responseClass(op, logger)
+	if len(class) == 0 && op.RequestBody != nil && op.RequestBody.Value != nil {
+		for _, mediaType := range sortedMediaTypes(op.RequestBody.Value.Content) {
+			class = swagger.schemaClass(op.RequestBody.Value.Content[mediaType].Schema, logger)
+			if len(class) > 0 {
+				break
+			}
+		}
+	}
+	if len(class) == 0 {
+		collection := last
+		if isItem && len(segments) > 1 {
+			collection = segments[len(segments)-2]
+		}
+		class = swagger.findClass(singular(collection))
+	}
+	if len(class) == 0 {
+		return nil
+	}
+	if !isItem && len(segments) > 1 && isPathParam(segments[len(segments)-2]) && swagger.findClass(singular(last)) != class {
+		return nil
+	}
+
+	operation := method
+	switch method {
+	case MethodPost:
+		if isItem {
+			operation = MethodPut
+		}
+	case MethodPatch:
+		operation = MethodPut
+	case MethodHead, MethodOptions:
+		return nil
+	}
+	return &MeqaTag{class, "", operation, 0}
+}
+
+// inferParamTag guesses the class and property of a path parameter, first from its name (petId is
+// Pet.id), then from the path segment before it (/user/{username} is User.username) and last from
+// the class of the operation.
+func (swagger *Swagger) inferParamTag(pathName string, param *spec.ParameterRef, opClass string, logger *log.Logger) *MeqaTag {
+	if param == nil || len(param.Ref) > 0 || param.Value == nil || param.Value.In != "path" {
+		return nil
+	}
+	if GetParameterTag(param.Value, logger) != nil {
+		return nil
+	}
+	name := param.Value.Name
+
+	var candidates []string
+	var classes []string
+	for className := range swagger.Components.Schemas {
+		classes = append(classes, className)
+	}
+	// Longer names first so that {petTagId} is PetTag rather than Pet.
+	sort.Slice(classes, func(i, j int) bool {
+		if len(classes[i]) != len(classes[j]) {
+			return len(classes[i]) > len(classes[j])
+		}
+		return classes[i] < classes[j]
+	})
+	for _, className := range classes {
+		if _, ok := trimClassPrefix(name, className); ok {
+			candidates = append(candidates, className)
+			break
+		}
+	}
+	segments := strings.Split(strings.Trim(pathName, "/"), "/")
+	for i, s := range segments {
+		if s == "{"+name+"}" && i > 0 && !isPathParam(segments[i-1]) {
+			if class := swagger.findClass(singular(segments[i-1])); len(class) > 0 {
+				candidates = append(candidates, class)
+			}
+		}
+	}
+	if len(opClass) > 0 {
+		candidates = append(candidates, opClass)
+	}
+
+	for _, class := range candidates {
+		schema := swagger.FindSchemaByName(class)
+		properties := []string{name}
+		if rest, ok := trimClassPrefix(name, class); ok && len(rest) > 0 {
+			properties = append(properties, strings.ToLower(rest[:1])+rest[1:])
+		}
+		for _, property := range properties {
+			if p := findProperty(schema, property); len(p) > 0 {
+				return &MeqaTag{class, p, "", 0}
+			}
+		}
+	}
+	return nil
+}
+
+// responseClass returns the class of the objects in the first successful response of op.
+func (swagger *Swagger) responseClass(op *spec.Operation, logger *log.Logger) string {
+	var codes []string
+	for code := range op.Responses {
+		if c, err := strconv.Atoi(code); err == nil && c >= 200 && c < 300 {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		resp := op.Responses[code]
+		if resp == nil || resp.Value == nil {
+			continue
+		}
+		for _, mediaType := range sortedMediaTypes(resp.Value.Content) {
+			if class := swagger.schemaClass(resp.Value.Content[mediaType].Schema, logger); len(class) > 0 {
+				return class
+			}
+		}
+	}
+	return ""
+}
+
+// schemaClass returns the name of the object schema that schema refers to directly or through
+// an array.
+func (swagger *Swagger) schemaClass(schema *spec.SchemaRef, logger *log.Logger) string {
+	if schema == nil || schema.Value == nil {
+		return ""
+	}
+	tag, _ := swagger.GetSchemaRootType((SchemaRef)(*schema), nil, logger)
+	if tag == nil {
+		return ""
+	}
+	return tag.Class
+}
+
+// findClass returns the name of the schema that matches name ignoring case, "-" and "_". An exact
+// match is preferred, otherwise the first of the matching names in order is taken.
+func (swagger *Swagger) findClass(name string) string {
+	if _, ok := swagger.Components.Schemas[name]; ok {
+		return name
+	}
+	var classes []string
+	for className := range swagger.Components.Schemas {
+		classes = append(classes, className)
+	}
+	sort.Strings(classes)
+	for _, className := range classes {
+		if normalizeName(className) == normalizeName(name) {
+			return className
+		}
+	}
+	return ""
+}
+
+// findProperty returns the name of the property of schema that matches name ignoring case.
+func findProperty(schema SchemaRef, name string) string {
+	if schema.Value == nil {
+		return ""
+	}
+	for p := range schema.Value.Properties {
+		if p == name {
+			return p
+		}
+	}
+	for p := range schema.Value.Properties {
+		if normalizeName(p) == normalizeName(name) {
+			return p
+		}
+	}
+	for _, s := range schema.Value.AllOf {
+		if s != nil {
+			if p := findProperty((SchemaRef)(*s), name); len(p) > 0 {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// addTagAt adds tag to the object at loc in node, either to its description or as the x-meqa
+// extension. It returns the updated node and whether the tag was added.
+func addTagAt(node interface{}, loc []interface{}, tag *MeqaTag, extension bool, logger *log.Logger) (interface{}, bool) {
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		if len(loc) == 0 {
+			if docValue(n, "$ref") != nil || docValue(n, MeqaExtension) != nil {
+				return n, false
+			}
+			if extension {
+				ext := yaml.MapSlice{{Key: "class", Value: tag.Class}}
+				if len(tag.Property) > 0 {
+					ext = append(ext, yaml.MapItem{Key: "property", Value: tag.Property})
+				}
+				if len(tag.Operation) > 0 {
+					ext = append(ext, yaml.MapItem{Key: "operation", Value: tag.Operation})
+				}
+				return append(n, yaml.MapItem{Key: MeqaExtension, Value: ext}), true
+			}
+			for i := range n {
+				if n[i].Key == "description" {
+					desc := fmt.Sprint(n[i].Value)
+					if GetMeqaTag(desc, logger) != nil {
+						return n, false
+					}
+					n[i].Value = strings.TrimSpace(desc + " " + tag.ToString())
+					return n, true
+				}
+			}
+			return append(n, yaml.MapItem{Key: "description", Value: tag.ToString()}), true
+		}
+		for i := range n {
+			if fmt.Sprint(n[i].Key) == fmt.Sprint(loc[0]) {
+				var added bool
+				n[i].Value, added = addTagAt(n[i].Value, loc[1:], tag, extension, logger)
+				return n, added
+			}
+		}
+	case []interface{}:
+		if len(loc) > 0 {
+			if i, ok := loc[0].(int); ok && i < len(n) {
+				var added bool
+				n[i], added = addTagAt(n[i], loc[1:], tag, extension, logger)
+				return n, added
+			}
+		}
+	}
+	return node, false
+}
+
+func docValue(doc yaml.MapSlice, key string) interface{} {
+	for _, item := range doc {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+func sortedMediaTypes(content spec.Content) []string {
+	var mediaTypes []string
+	for mediaType := range content {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	// json first
+	sort.Slice(mediaTypes, func(i, j int) bool {
+		ji, jj := strings.Contains(mediaTypes[i], "json"), strings.Contains(mediaTypes[j], "json")
+		if ji != jj {
+			return ji
+		}
+		return mediaTypes[i] < mediaTypes[j]
+	})
+	return mediaTypes
+}
+
+func isPathParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+}
+
+// singular makes a best effort to turn a plural path segment like pets into the class name.
+func singular(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies"):
+		return strings.TrimSuffix(name, "ies") + "y"
+	case strings.HasSuffix(name, "sses"), strings.HasSuffix(name, "xes"):
+		return strings.TrimSuffix(name, "es")
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss"):
+		return strings.TrimSuffix(name, "s")
+	}
+	return name
+}
+
+// trimClassPrefix removes the class name from the start of name, ignoring case, "-" and "_".
+func trimClassPrefix(name string, class string) (string, bool) {
+	class = normalizeName(class)
+	for i, c := range name {
+		if len(class) == 0 {
+			return strings.TrimLeft(name[i:], "_-"), true
+		}
+		if c == '_' || c == '-' {
+			continue
+		}
+		if !strings.EqualFold(string(c), class[:1]) {
+			return name, false
+		}
+		class = class[1:]
+	}
+	return "", len(class) == 0
+}
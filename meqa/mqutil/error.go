@@ -15,6 +15,16 @@ const (
 	ErrInternal          // unexpected internal error (meqa error)
 )
 
+var errTypeNames = []string{"OK", "Invalid", "NotFound", "Expect", "Http", "ServerResp", "Internal"}
+
+// ErrTypeName returns a readable name for the error type.
+func ErrTypeName(errType int) string {
+	if errType >= 0 && errType < len(errTypeNames) {
+		return errTypeNames[errType]
+	}
+	return fmt.Sprintf("%d", errType)
+}
+
 // Error implements MQ specific error type.
 type Error interface {
 	error
@@ -25,6 +35,7 @@ type Error interface {
 type TypedError struct {
 	errType int
 	errMsg  string
+	msg     string // errMsg without the type and backtrace
 }
 
 func (e *TypedError) Error() string {
@@ -35,9 +46,14 @@ func (e *TypedError) Type() int {
 	return e.errType
 }
 
+// Message returns the error message without the backtrace.
+func (e *TypedError) Message() string {
+	return e.msg
+}
+
 func NewError(errType int, str string) error {
 	buf := string(debug.Stack())
-	err := TypedError{errType, ""}
+	err := TypedError{errType, "", str}
 	err.errMsg = fmt.Sprintf("==== %v ====\nError message:\n%s\nBacktrace:%v", errType, str, buf)
 	return &err
 }
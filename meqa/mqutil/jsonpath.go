@@ -0,0 +1,151 @@
+package mqutil
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is one step of a parsed JSONPath.
+type jsonPathStep struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+	length   bool
+}
+
+// parseJsonPath parses the subset of JSONPath that JsonPath supports.
+func parseJsonPath(path string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid path %s: must start with $", path)
+	}
+	var steps []jsonPathStep
+	rest := path[1:]
+	for len(rest) > 0 {
+		if len(steps) > 0 && steps[len(steps)-1].length {
+			return nil, fmt.Errorf("invalid path %s: length() must be the last step", path)
+		}
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			switch name {
+			case "":
+				return nil, fmt.Errorf("invalid path %s: empty name", path)
+			case "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case "length()":
+				steps = append(steps, jsonPathStep{length: true})
+			default:
+				steps = append(steps, jsonPathStep{name: name})
+			}
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %s: missing ]", path)
+			}
+			selector := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			if selector == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+			} else if len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0] {
+				steps = append(steps, jsonPathStep{name: selector[1 : len(selector)-1]})
+			} else if i, err := strconv.Atoi(selector); err == nil {
+				steps = append(steps, jsonPathStep{index: i, isIndex: true})
+			} else {
+				return nil, fmt.Errorf("invalid path %s: unsupported selector [%s]", path, selector)
+			}
+		default:
+			return nil, fmt.Errorf("invalid path %s: unexpected %q", path, rest[0])
+		}
+	}
+	return steps, nil
+}
+
+// JsonPathValue is a value found by JsonPath, with its definite path.
+type JsonPathValue struct {
+	Path  string
+	Value interface{}
+}
+
+// JsonPathChild returns the JSONPath of the named child of path.
+func JsonPathChild(path, name string) string {
+	if len(name) > 0 && !strings.ContainsAny(name, ".[]'\" ()") {
+		return path + "." + name
+	}
+	return path + "['" + name + "']"
+}
+
+// JsonPath evaluates a JSONPath against obj, which is in the form json decodes to. The supported
+// subset is the root $, .name and ['name'] children, [n] indexes (negative ones count from the end),
+// the .* and [*] wildcards, and a trailing .length() giving the length of an array, object or string.
+// It returns the values found, each with the definite path it was found at, and whether the path is
+// definite, i.e. has no wildcards and so finds at most one value.
+func JsonPath(obj interface{}, path string) ([]JsonPathValue, bool, error) {
+	steps, err := parseJsonPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	definite := true
+	values := []JsonPathValue{{"$", obj}}
+	for _, step := range steps {
+		var next []JsonPathValue
+		for _, pv := range values {
+			switch v := pv.Value; {
+			case step.length:
+				if v == nil {
+					continue
+				}
+				switch rv := reflect.ValueOf(v); rv.Kind() {
+				case reflect.Map, reflect.Slice, reflect.Array:
+					next = append(next, JsonPathValue{pv.Path + ".length()", rv.Len()})
+				case reflect.String:
+					next = append(next, JsonPathValue{pv.Path + ".length()", len([]rune(rv.String()))})
+				}
+			case step.wildcard:
+				definite = false
+				switch c := v.(type) {
+				case []interface{}:
+					for i, item := range c {
+						next = append(next, JsonPathValue{fmt.Sprintf("%s[%d]", pv.Path, i), item})
+					}
+				case map[string]interface{}:
+					var keys []string
+					for k := range c {
+						keys = append(keys, k)
+					}
+					sort.Strings(keys)
+					for _, k := range keys {
+						next = append(next, JsonPathValue{JsonPathChild(pv.Path, k), c[k]})
+					}
+				}
+			case step.isIndex:
+				if a, ok := v.([]interface{}); ok {
+					i := step.index
+					if i < 0 {
+						i += len(a)
+					}
+					if i >= 0 && i < len(a) {
+						next = append(next, JsonPathValue{fmt.Sprintf("%s[%d]", pv.Path, i), a[i]})
+					}
+				}
+			default:
+				if m, ok := v.(map[string]interface{}); ok {
+					if child, ok := m[step.name]; ok {
+						next = append(next, JsonPathValue{JsonPathChild(pv.Path, step.name), child})
+					}
+				}
+			}
+		}
+		values = next
+	}
+	return values, definite, nil
+}
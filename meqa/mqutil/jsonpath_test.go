@@ -0,0 +1,90 @@
+package mqutil
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJsonPath(t *testing.T) {
+	var obj interface{}
+	err := json.Unmarshal([]byte(`{
+		"id": 1,
+		"name": "doggie",
+		"tags": [{"name": "a"}, {"name": "b"}, {"id": 3}],
+		"category": {"id": 2, "name": "Dogs"},
+		"odd.key": "x",
+		"empty": null
+	}`), &obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path     string
+		expected []JsonPathValue
+		definite bool
+	}{
+		{"$", []JsonPathValue{{"$", obj}}, true},
+		{"$.id", []JsonPathValue{{"$.id", 1.0}}, true},
+		{"$.category.name", []JsonPathValue{{"$.category.name", "Dogs"}}, true},
+		{"$['category']['id']", []JsonPathValue{{"$.category.id", 2.0}}, true},
+		{`$["odd.key"]`, []JsonPathValue{{"$['odd.key']", "x"}}, true},
+		{"$.tags[1].name", []JsonPathValue{{"$.tags[1].name", "b"}}, true},
+		{"$.tags[-1].id", []JsonPathValue{{"$.tags[2].id", 3.0}}, true},
+		{"$.tags[3]", nil, true},
+		{"$.missing", nil, true},
+		{"$.empty", []JsonPathValue{{"$.empty", nil}}, true},
+		{"$.tags[*].name", []JsonPathValue{{"$.tags[0].name", "a"}, {"$.tags[1].name", "b"}}, false},
+		{"$.category.*", []JsonPathValue{{"$.category.id", 2.0}, {"$.category.name", "Dogs"}}, false},
+		{"$.tags.length()", []JsonPathValue{{"$.tags.length()", 3}}, true},
+		{"$.name.length()", []JsonPathValue{{"$.name.length()", 6}}, true},
+		{"$.category.length()", []JsonPathValue{{"$.category.length()", 2}}, true},
+		{"$.id.length()", nil, true},
+		{"$.empty.length()", nil, true},
+	} {
+		values, definite, err := JsonPath(obj, c.path)
+		if err != nil {
+			t.Errorf("JsonPath(%s): %s", c.path, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(values, c.expected) || definite != c.definite {
+			t.Errorf("JsonPath(%s): expected %v (definite %t), got %v (definite %t)", c.path, c.expected, c.definite, values, definite)
+		}
+	}
+}
+
+func TestJsonPathInvalid(t *testing.T) {
+	for _, c := range []struct {
+		path     string
+		expected string
+	}{
+		{"id", "must start with $"},
+		{"$.", "empty name"},
+		{"$..id", "empty name"},
+		{"$.tags[0", "missing ]"},
+		{"$.tags[?(@.id)]", "unsupported selector"},
+		{"$.tags.length().name", "length() must be the last step"},
+		{"$id", "unexpected 'i'"},
+	} {
+		if _, _, err := JsonPath(nil, c.path); err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("JsonPath(%s): expected an error with %q, got %v", c.path, c.expected, err)
+		}
+	}
+}
+
+func TestJsonPathChild(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		expected string
+	}{
+		{"id", "$.id"},
+		{"odd.key", "$['odd.key']"},
+		{"a b", "$['a b']"},
+		{"", "$['']"},
+	} {
+		if got := JsonPathChild("$", c.name); got != c.expected {
+			t.Errorf("JsonPathChild(%q): expected %s, got %s", c.name, c.expected, got)
+		}
+	}
+}
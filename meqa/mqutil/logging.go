@@ -9,13 +9,15 @@ import (
 
 // Test results constants
 const (
-	Passed         = "Passed"
-	Failed         = "Failed"
-	Skipped        = "Skipped"
-	SchemaMismatch = "SchemaMismatch"
-	Total          = "Total"
-	FuzzTotal      = "Fuzz Total"
-	FuzzFails      = "Fuzz Fails"
+	Passed          = "Passed"
+	Failed          = "Failed"
+	Skipped         = "Skipped"
+	SchemaMismatch  = "SchemaMismatch"
+	ContentMismatch = "ContentMismatch"
+	HeaderMismatch  = "HeaderMismatch"
+	Total           = "Total"
+	FuzzTotal       = "Fuzz Total"
+	FuzzFails       = "Fuzz Fails"
 )
 
 // Colors for better logging
@@ -29,8 +31,7 @@ const (
 )
 
 func NewLogger(out io.Writer) *log.Logger {
-	Logger = log.New(out, "", (log.Ldate | log.Lmicroseconds | log.Lshortfile))
-	return Logger
+	return log.New(out, "", (log.Ldate | log.Lmicroseconds | log.Lshortfile))
 }
 
 func NewStdLogger() *log.Logger {
@@ -45,9 +46,3 @@ func NewFileLogger(path string) *log.Logger {
 	}
 	return NewLogger(f)
 }
-
-// There is only one logger per process.
-var Logger *log.Logger
-
-// Whether verbose mose is on
-var Verbose bool
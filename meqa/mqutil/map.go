@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
+	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -161,11 +164,16 @@ func ArrayCopy(src []interface{}) (dst []interface{}) {
 	return dst
 }
 
-func InterfacePrint(m interface{}, printToConsole bool) {
+func InterfacePrint(logger *log.Logger, m interface{}, printToConsole bool) {
+	InterfaceFprint(logger, os.Stdout, m, printToConsole)
+}
+
+// InterfaceFprint is like InterfacePrint but writes the console copy to out.
+func InterfaceFprint(logger *log.Logger, out io.Writer, m interface{}, printToConsole bool) {
 	yamlBytes, _ := yaml.Marshal(m)
-	Logger.Print(string(yamlBytes))
+	logger.Print(string(yamlBytes))
 	if printToConsole {
-		fmt.Println(string(yamlBytes))
+		fmt.Fprintln(out, string(yamlBytes))
 	}
 }
 